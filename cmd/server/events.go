@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/naventro/payment-service/internal/api/dto"
+	"github.com/naventro/payment-service/internal/api/handlers"
+	"github.com/naventro/payment-service/internal/catchup"
+	"github.com/naventro/payment-service/internal/models"
+	"github.com/naventro/payment-service/internal/repository"
+	"github.com/stripe/stripe-go/v84"
+)
+
+// runEvents implements the "events" subcommand
+func runEvents(args []string) {
+	if len(args) == 0 {
+		log.Fatalf("Usage: events list|replay|catchup [flags]")
+	}
+
+	switch args[0] {
+	case "list":
+		runEventsList(args[1:])
+	case "replay":
+		runEventsReplay(args[1:])
+	case "catchup":
+		runEventsCatchup(args[1:])
+	default:
+		log.Fatalf("Unknown events command %q (available: list, replay, catchup)", args[0])
+	}
+}
+
+func runEventsList(args []string) {
+	fs := flag.NewFlagSet("events list", flag.ExitOnError)
+	eventType := fs.String("type", "", "only events of this type, e.g. customer.subscription.created")
+	status := fs.String("status", "", "only events with this status (received, processed, failed)")
+	from := fs.String("from", "", "only events received at or after this RFC 3339 time")
+	to := fs.String("to", "", "only events received before this RFC 3339 time")
+	limit := fs.Int("limit", 100, "maximum number of events")
+	asJSON := fs.Bool("json", false, "print the events as JSON")
+	fs.Parse(args)
+
+	filter := repository.EventFilter{
+		Type:   *eventType,
+		Status: models.EventStatus(*status),
+		From:   parseTimeFlag("from", *from),
+		To:     parseTimeFlag("to", *to),
+		Limit:  *limit,
+	}
+
+	deps := setup()
+	defer deps.DB.Close()
+
+	events, err := deps.EventRepo.List(filter)
+	if err != nil {
+		log.Fatalf("Error listing events: %v", err)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(events)
+		return
+	}
+
+	for _, event := range events {
+		fmt.Printf("%s  %-9s  %s  %s", event.ReceivedAt.Format(time.RFC3339), event.Status, event.StripeEventID, event.Type)
+		if event.Error != nil {
+			fmt.Printf("  error: %s", *event.Error)
+		}
+		fmt.Println()
+	}
+	fmt.Printf("%d events\n", len(events))
+}
+
+func runEventsReplay(args []string) {
+	fs := flag.NewFlagSet("events replay", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "roll back the changes and print what would have been written")
+	asJSON := fs.Bool("json", false, "print the results as JSON")
+	fs.Parse(args)
+
+	ids := fs.Args()
+	if len(ids) == 0 {
+		log.Fatalf("Usage: events replay [--dry-run] [--json] <event ID>...")
+	}
+
+	deps := setup()
+	defer deps.DB.Close()
+
+	results := make([]dto.EventReplayResult, 0, len(ids))
+	failed := 0
+	for _, id := range ids {
+		stored, err := deps.EventRepo.GetByStripeEventID(id)
+		if err != nil {
+			log.Fatalf("Error fetching event %s: %v", id, err)
+		}
+
+		result := dto.EventReplayResult{EventID: id, DryRun: *dryRun, Error: "event not found"}
+		if stored != nil {
+			result = handlers.ReplayEvent(deps, stored, *dryRun)
+		}
+		if result.Error != "" {
+			failed++
+		}
+		results = append(results, result)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(results)
+	} else {
+		for _, result := range results {
+			printReplayResult(result)
+		}
+	}
+
+	if failed > 0 {
+		deps.DB.Close()
+		os.Exit(1)
+	}
+}
+
+func runEventsCatchup(args []string) {
+	fs := flag.NewFlagSet("events catchup", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print the result as JSON")
+	fs.Parse(args)
+
+	deps := setup()
+	defer deps.DB.Close()
+
+	result, err := newCatchupPoller(deps).Run()
+	if err != nil {
+		log.Fatalf("Error catching up on events: %v", err)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(result)
+	} else {
+		printCatchupResult(result)
+	}
+
+	if result.Failed > 0 {
+		deps.DB.Close()
+		os.Exit(1)
+	}
+}
+
+func newCatchupPoller(deps *handlers.Dependencies) *catchup.Poller {
+	receive := func(event *stripe.Event) (bool, error) {
+		// Only keep the event types the webhook endpoint handles
+		if !handlers.IsHandledEvent(event.Type) {
+			return false, nil
+		}
+
+		payload, err := json.Marshal(event)
+		if err != nil {
+			return false, fmt.Errorf("error encoding event: %w", err)
+		}
+
+		return handlers.ReceiveEvent(deps, *event, payload, false)
+	}
+
+	return catchup.New(deps.StripeClient, deps.SyncStateRepo, receive, handlers.HandledEventTypes(), deps.Config.EventCatchupLookback)
+}
+
+// scheduledCatchup returns the job run by the server on EVENT_CATCHUP_INTERVAL
+func scheduledCatchup(deps *handlers.Dependencies) func() error {
+	poller := newCatchupPoller(deps)
+
+	return func() error {
+		result, err := poller.Run()
+		if err != nil {
+			return err
+		}
+
+		if result.Processed > 0 || result.Failed > 0 {
+			log.Printf(
+				"Event catch-up recovered %d missed events (%d failed) out of %d listed",
+				result.Processed, result.Failed, result.Listed,
+			)
+		}
+		return nil
+	}
+}
+
+func printCatchupResult(result *catchup.Result) {
+	fmt.Printf(
+		"Listed %d events from %s to %s: %d processed, %d failed, %d already seen or not handled\n",
+		result.Listed,
+		result.From.Format(time.RFC3339),
+		result.To.Format(time.RFC3339),
+		result.Processed,
+		result.Failed,
+		result.Skipped,
+	)
+}
+
+func printReplayResult(result dto.EventReplayResult) {
+	fmt.Printf("%s %s: %s\n", result.EventID, result.Type, result.Status)
+	if result.Error != "" {
+		fmt.Printf("  error: %s\n", result.Error)
+	}
+
+	for _, change := range result.Changes {
+		fmt.Printf("  %s %s id=%v\n", change.Op, change.Table, change.ID)
+		switch change.Op {
+		case "update":
+			for _, field := range change.Fields {
+				fmt.Printf("    %s: %v -> %v\n", field, change.Before[field], change.After[field])
+			}
+		case "insert":
+			printRow(change.After)
+		case "delete":
+			printRow(change.Before)
+		}
+	}
+
+	if result.DryRun && result.Error == "" && len(result.Changes) == 0 {
+		fmt.Println("  no changes")
+	}
+}
+
+func printRow(row map[string]interface{}) {
+	data, _ := json.Marshal(row)
+	fmt.Printf("    %s\n", data)
+}
+
+func parseTimeFlag(name, value string) *time.Time {
+	if value == "" {
+		return nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		log.Fatalf("--%s must be an RFC 3339 timestamp: %v", name, err)
+	}
+	return &t
+}
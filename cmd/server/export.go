@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"log"
+	"os"
+	"time"
+
+	"github.com/naventro/payment-service/internal/export"
+	"github.com/naventro/payment-service/internal/repository"
+)
+
+// runExport implements the "export" subcommand
+func runExport(args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	dataset := fs.String("dataset", "journal", "what to export: invoices, refunds, disputes or journal")
+	format := fs.String("format", "csv", "output format: csv or json")
+	tenant := fs.String("tenant", "", "only this tenant (default: every tenant)")
+	month := fs.String("month", "", "calendar month to export, as YYYY-MM (default: last month)")
+	from := fs.String("from", "", "start of the period, as an RFC 3339 time; requires --to")
+	to := fs.String("to", "", "end of the period (exclusive), as an RFC 3339 time; requires --from")
+	out := fs.String("out", "", "file to write (default: stdout)")
+	fetchFees := fs.Bool("fetch-fees", false, "fetch missing Stripe fees of paid invoices before exporting them")
+	fs.Parse(args)
+
+	opts := export.Options{}
+
+	var err error
+	if opts.Dataset, err = export.ParseDataset(*dataset); err != nil {
+		log.Fatalf("%v", err)
+	}
+	if opts.Format, err = export.ParseFormat(*format); err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	start, end, err := export.ResolvePeriod(*month, *from, *to, time.Now())
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	opts.Filter = repository.ExportFilter{Tenant: *tenant, From: start, To: end}
+
+	w := os.Stdout
+	if *out != "" {
+		if w, err = os.Create(*out); err != nil {
+			log.Fatalf("Error creating %s: %v", *out, err)
+		}
+		defer w.Close()
+	}
+
+	deps := setup()
+	defer deps.DB.Close()
+
+	if *fetchFees {
+		stored, err := deps.Exporter.BackfillFees(opts.Filter)
+		if err != nil {
+			log.Fatalf("Error fetching fees: %v", err)
+		}
+		log.Printf("Stored %d Stripe fees", stored)
+	}
+
+	buf := bufio.NewWriter(w)
+	count, err := deps.Exporter.Export(buf, opts)
+	if flushErr := buf.Flush(); err == nil {
+		err = flushErr
+	}
+	if err != nil {
+		log.Fatalf("Error exporting %s after %d records: %v", opts.Dataset, count, err)
+	}
+
+	log.Printf("Exported %d %s records from %s to %s", count, opts.Dataset, start.Format(time.RFC3339), end.Format(time.RFC3339))
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/naventro/payment-service/internal/importer"
+)
+
+// runImport implements the "import" subcommand
+func runImport(args []string) {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	file := fs.String("file", "", "CSV file with the columns stripe_id,user_id,tenant[,plan]")
+	dryRun := fs.Bool("dry-run", false, "report the actions without writing to Stripe or the database")
+	asJSON := fs.Bool("json", false, "print the results as JSON")
+	fs.Parse(args)
+
+	if *file == "" {
+		log.Fatalf("--file is required")
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		log.Fatalf("Error opening %s: %v", *file, err)
+	}
+	rows, err := importer.ParseCSV(f)
+	f.Close()
+	if err != nil {
+		log.Fatalf("Error parsing %s: %v", *file, err)
+	}
+
+	deps := setup()
+	defer deps.DB.Close()
+
+	imp := importer.New(deps.StripeClient, deps.Catalog, deps.SubRepo, deps.InvoiceRepo, deps.CustomerRepo, deps.SubscriptionHistoryRepo)
+	results := imp.Import(rows, *dryRun)
+
+	failed := 0
+	for _, result := range results {
+		if result.Error != "" {
+			failed++
+		}
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(results)
+	} else {
+		for _, result := range results {
+			fmt.Printf("line %d %s -> %s/%s\n", result.Row.Line, result.Row.StripeID, result.Row.Tenant, result.Row.UserID)
+			for _, action := range result.Actions {
+				fmt.Printf("  %s\n", action)
+			}
+			if result.Error != "" {
+				fmt.Printf("  error: %s\n", result.Error)
+			} else if len(result.Actions) == 0 {
+				fmt.Println("  already imported")
+			}
+		}
+
+		mode := "Imported"
+		if *dryRun {
+			mode = "Dry run:"
+		}
+		fmt.Printf("%s %d rows, %d failed\n", mode, len(results)-failed, failed)
+	}
+
+	if failed > 0 {
+		deps.DB.Close()
+		os.Exit(1)
+	}
+}
+
+// splitList splits a comma-separated flag value, ignoring empty entries
+func splitList(value string) []string {
+	var values []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
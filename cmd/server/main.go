@@ -1,21 +1,65 @@
 package main
 
 import (
+	"context"
 	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/logger"
 	"github.com/gofiber/fiber/v2/middleware/recover"
+	"github.com/naventro/payment-service/internal/analytics"
 	"github.com/naventro/payment-service/internal/api/handlers"
 	"github.com/naventro/payment-service/internal/api/routes"
+	"github.com/naventro/payment-service/internal/catalog"
 	"github.com/naventro/payment-service/internal/config"
 	"github.com/naventro/payment-service/internal/database"
+	"github.com/naventro/payment-service/internal/export"
+	"github.com/naventro/payment-service/internal/invoicepdf"
+	"github.com/naventro/payment-service/internal/mailer"
+	"github.com/naventro/payment-service/internal/metrics"
+	"github.com/naventro/payment-service/internal/notify"
 	"github.com/naventro/payment-service/internal/repository"
+	"github.com/naventro/payment-service/internal/scheduler"
 	"github.com/naventro/payment-service/internal/stripe"
+	"github.com/naventro/payment-service/internal/tenant"
 	"github.com/naventro/payment-service/internal/webhook"
 )
 
 func main() {
+	// The first non-flag argument selects a subcommand; serving is the default
+	command := "serve"
+	args := os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	switch command {
+	case "serve":
+		runServer()
+	case "reconcile":
+		runReconcile(args)
+	case "import":
+		runImport(args)
+	case "events":
+		runEvents(args)
+	case "export":
+		runExport(args)
+	case "recognize":
+		runRecognize(args)
+	case "number":
+		runNumber(args)
+	default:
+		log.Fatalf("Unknown command %q (available: serve, reconcile, import, events, export, recognize, number)", command)
+	}
+}
+
+// setup loads configuration, connects to the database, runs migrations and
+// builds the dependencies shared by the server and the CLI commands
+func setup() *handlers.Dependencies {
 	// Load configuration
 	cfg, err := config.Load()
 	if err != nil {
@@ -27,7 +71,6 @@ func main() {
 	if err != nil {
 		log.Fatalf("Error connecting to database: %v", err)
 	}
-	defer db.Close()
 
 	// Run migrations
 	if err := db.RunMigrations(); err != nil {
@@ -37,21 +80,86 @@ func main() {
 	// Initialize repositories
 	subRepo := repository.NewSubscriptionRepository(db.DB)
 	invoiceRepo := repository.NewInvoiceRepository(db.DB)
+	customerRepo := repository.NewCustomerRepository(db.DB)
+	checkoutSessionRepo := repository.NewCheckoutSessionRepository(db.DB)
+	eventRepo := repository.NewEventRepository(db.DB)
+	syncStateRepo := repository.NewSyncStateRepository(db.DB)
+	disputeRepo := repository.NewDisputeRepository(db.DB)
+	refundRepo := repository.NewRefundRepository(db.DB)
+	orderRepo := repository.NewOrderRepository(db.DB)
+	usageRepo := repository.NewUsageRepository(db.DB)
+	subscriptionItemRepo := repository.NewSubscriptionItemRepository(db.DB)
+	revenueRepo := repository.NewRevenueRepository(db.DB)
+	subscriptionHistoryRepo := repository.NewSubscriptionHistoryRepository(db.DB)
+	exportRepo := repository.NewExportRepository(db.DB)
+	revenueRecognitionRepo := repository.NewRevenueRecognitionRepository(db.DB)
+
+	// Load per-tenant settings
+	tenants, err := tenant.Load(cfg.TenantConfigFile)
+	if err != nil {
+		log.Fatalf("Error loading tenant settings: %v", err)
+	}
+
+	// Load the product catalog
+	products, err := catalog.Load(cfg.CatalogFile)
+	if err != nil {
+		log.Fatalf("Error loading catalog: %v", err)
+	}
 
 	// Initialize Stripe client
 	stripeClient := stripe.NewClient(cfg.StripeSecretKey)
 
 	// Initialize webhook client
-	webhookClient := webhook.NewClient(cfg.BackendWebhookURL)
+	webhookClient := webhook.NewClient(cfg.BackendWebhookURL, cfg.BackendWebhookFormat)
 
 	// Create dependencies container
-	deps := &handlers.Dependencies{
-		Config:        cfg,
-		DB:            db,
-		SubRepo:       subRepo,
-		InvoiceRepo:   invoiceRepo,
-		StripeClient:  stripeClient,
-		WebhookClient: webhookClient,
+	return &handlers.Dependencies{
+		Config:                  cfg,
+		DB:                      db,
+		SubRepo:                 subRepo,
+		InvoiceRepo:             invoiceRepo,
+		CustomerRepo:            customerRepo,
+		CheckoutSessionRepo:     checkoutSessionRepo,
+		EventRepo:               eventRepo,
+		SyncStateRepo:           syncStateRepo,
+		DisputeRepo:             disputeRepo,
+		RefundRepo:              refundRepo,
+		OrderRepo:               orderRepo,
+		UsageRepo:               usageRepo,
+		SubscriptionItemRepo:    subscriptionItemRepo,
+		RevenueRepo:             revenueRepo,
+		SubscriptionHistoryRepo: subscriptionHistoryRepo,
+		ExportRepo:              exportRepo,
+		RevenueRecognitionRepo:  revenueRecognitionRepo,
+		StripeClient:            stripeClient,
+		WebhookClient:           webhookClient,
+		Metrics:                 metrics.NewRegistry(),
+		Tenants:                 tenants,
+		Catalog:                 products,
+		Analytics:               analytics.New(subscriptionHistoryRepo, cfg.ReportingCurrency, cfg.ExchangeRates),
+		Exporter:                export.New(exportRepo, revenueRecognitionRepo, stripeClient),
+		InvoicePDFs:             invoicepdf.NewStore(cfg.InvoicePDFDir),
+		Notifier:                notify.New(mailer.New(cfg), tenants, cfg.MailFrom),
+	}
+}
+
+func runServer() {
+	deps := setup()
+	defer deps.DB.Close()
+	cfg := deps.Config
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Start background jobs
+	if cfg.ReconcileInterval > 0 {
+		go scheduler.Every(ctx, "reconciliation", cfg.ReconcileInterval, scheduledReconcile(deps))
+	}
+	if cfg.EventCatchupInterval > 0 {
+		go scheduler.Every(ctx, "event catch-up", cfg.EventCatchupInterval, scheduledCatchup(deps))
+	}
+	if cfg.UsageReportInterval > 0 {
+		go scheduler.Every(ctx, "usage reporting", cfg.UsageReportInterval, scheduledUsageReport(deps))
 	}
 
 	// Create Fiber app
@@ -76,6 +184,14 @@ func main() {
 	// Setup routes
 	routes.Setup(app, deps)
 
+	// Shut down gracefully on SIGINT/SIGTERM
+	go func() {
+		<-ctx.Done()
+		if err := app.Shutdown(); err != nil {
+			log.Printf("Error shutting down server: %v", err)
+		}
+	}()
+
 	// Start server
 	addr := ":" + cfg.Port
 	log.Printf("Starting payment service on port %s", cfg.Port)
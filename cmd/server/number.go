@@ -0,0 +1,83 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/naventro/payment-service/internal/api/handlers"
+	"github.com/naventro/payment-service/internal/models"
+)
+
+// runNumber implements the "number" subcommand, which numbers the paid
+// invoices that have no number, e.g. those paid before numbering existed, in
+// the order they were paid
+func runNumber(args []string) {
+	fs := flag.NewFlagSet("number", flag.ExitOnError)
+	tenant := fs.String("tenant", "", "only this tenant (default: every tenant)")
+	offline := fs.Bool("offline", false, "order invoices by their stored payment date instead of fetching it from Stripe")
+	fs.Parse(args)
+
+	deps := setup()
+	defer deps.DB.Close()
+
+	invoices, err := deps.InvoiceRepo.ListUnnumberedPaid(*tenant)
+	if err != nil {
+		log.Fatalf("Error listing invoices: %v", err)
+	}
+
+	// Without its payment date an invoice cannot be placed in the sequence,
+	// so nothing is numbered unless every date is known
+	paid := make(map[int]time.Time, len(invoices))
+	for _, invoice := range invoices {
+		paidAt, err := invoicePaidAt(deps, invoice, *offline)
+		if err != nil {
+			log.Fatalf("Error fetching invoice %s: %v", invoice.StripeInvoiceID, err)
+		}
+		paid[invoice.ID] = paidAt
+	}
+
+	sort.SliceStable(invoices, func(i, j int) bool {
+		return paid[invoices[i].ID].Before(paid[invoices[j].ID])
+	})
+
+	numbered := 0
+	for _, invoice := range invoices {
+		settings := deps.Tenants.Get(invoice.Tenant).Invoice
+		series := settings.Series(paid[invoice.ID].UTC())
+
+		number, err := deps.InvoiceRepo.AssignNumber(invoice.ID, invoice.Tenant, series, func(n int64) string {
+			return settings.FormatNumber(series, n)
+		})
+		if err != nil {
+			log.Fatalf("Error numbering invoice %s after %d invoices: %v", invoice.StripeInvoiceID, numbered, err)
+		}
+
+		log.Printf("Invoice %s numbered %s", invoice.StripeInvoiceID, number)
+		numbered++
+	}
+
+	log.Printf("Numbered %d invoices", numbered)
+}
+
+// invoicePaidAt returns when a stored invoice was paid according to Stripe,
+// or as stored if offline is set
+func invoicePaidAt(deps *handlers.Dependencies, invoice *models.Invoice, offline bool) (time.Time, error) {
+	if offline {
+		if invoice.PaidAt != nil {
+			return *invoice.PaidAt, nil
+		}
+		return invoice.CreatedAt, nil
+	}
+
+	inv, err := deps.StripeClient.GetInvoice(invoice.StripeInvoiceID)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if inv.StatusTransitions != nil && inv.StatusTransitions.PaidAt > 0 {
+		return time.Unix(inv.StatusTransitions.PaidAt, 0), nil
+	}
+
+	return invoice.CreatedAt, nil
+}
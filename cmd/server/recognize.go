@@ -0,0 +1,102 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"time"
+
+	"github.com/naventro/payment-service/internal/api/handlers"
+	"github.com/naventro/payment-service/internal/models"
+	"github.com/naventro/payment-service/internal/recognition"
+	"github.com/naventro/payment-service/internal/repository"
+)
+
+// runRecognize implements the "recognize" subcommand, which rebuilds the
+// revenue recognition schedules of paid invoices and their refunds, e.g. for
+// invoices paid before recognition existed
+func runRecognize(args []string) {
+	fs := flag.NewFlagSet("recognize", flag.ExitOnError)
+	tenant := fs.String("tenant", "", "only this tenant (default: every tenant)")
+	from := fs.String("from", "", "only invoices created at or after this RFC 3339 time")
+	to := fs.String("to", "", "only invoices created before this RFC 3339 time")
+	offline := fs.Bool("offline", false, "use the stored invoice periods instead of fetching the invoice lines from Stripe")
+	fs.Parse(args)
+
+	filter := repository.ExportFilter{Tenant: *tenant, To: time.Now()}
+	if t := parseTimeFlag("from", *from); t != nil {
+		filter.From = *t
+	}
+	if t := parseTimeFlag("to", *to); t != nil {
+		filter.To = *t
+	}
+
+	deps := setup()
+	defer deps.DB.Close()
+
+	scheduled, failed := 0, 0
+	err := deps.ExportRepo.StreamInvoices(filter, func(invoice *models.InvoiceExport) error {
+		if invoice.Status != models.InvoiceStatusPaid {
+			return nil
+		}
+
+		if err := recognizeInvoice(deps, &invoice.Invoice, *offline); err != nil {
+			log.Printf("Error scheduling revenue of invoice %s: %v", invoice.StripeInvoiceID, err)
+			failed++
+			return nil
+		}
+		scheduled++
+		return nil
+	})
+	if err != nil {
+		log.Fatalf("Error listing invoices: %v", err)
+	}
+
+	log.Printf("Scheduled revenue of %d invoices, %d failed", scheduled, failed)
+	if failed > 0 {
+		deps.DB.Close()
+		os.Exit(1)
+	}
+}
+
+// recognizeInvoice rebuilds the schedule of a paid invoice and then applies
+// its refunds in the order they were made
+func recognizeInvoice(deps *handlers.Dependencies, invoice *models.Invoice, offline bool) error {
+	granularity := recognition.Granularity(deps.Config.RevenueRecognition)
+	lines := recognition.InvoiceLines(invoice)
+	paidAt := invoice.CreatedAt
+
+	if !offline {
+		inv, err := deps.StripeClient.GetInvoice(invoice.StripeInvoiceID)
+		if err != nil {
+			return err
+		}
+		items, err := deps.StripeClient.InvoiceLines(inv)
+		if err != nil {
+			return err
+		}
+		if len(items) > 0 {
+			lines = recognition.StripeLines(items)
+		}
+		if inv.StatusTransitions != nil && inv.StatusTransitions.PaidAt > 0 {
+			paidAt = time.Unix(inv.StatusTransitions.PaidAt, 0)
+		}
+	}
+
+	if err := recognition.SaveSchedule(deps.RevenueRecognitionRepo, invoice, lines, paidAt, granularity); err != nil {
+		return err
+	}
+
+	refunds, err := deps.RefundRepo.GetByInvoiceID(invoice.ID)
+	if err != nil {
+		return err
+	}
+
+	for _, refund := range refunds {
+		if err := recognition.SaveRefund(deps.RevenueRecognitionRepo, refund, invoice, granularity); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
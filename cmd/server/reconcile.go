@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/naventro/payment-service/internal/api/handlers"
+	"github.com/naventro/payment-service/internal/reconcile"
+)
+
+// runReconcile implements the "reconcile" subcommand
+func runReconcile(args []string) {
+	fs := flag.NewFlagSet("reconcile", flag.ExitOnError)
+	tenants := fs.String("tenant", "", "comma-separated tenants to reconcile (default: all tenants with subscriptions)")
+	repair := fs.Bool("repair", false, "update the database to match Stripe")
+	notify := fs.Bool("notify", false, "notify the backend about repaired subscriptions")
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	fs.Parse(args)
+
+	deps := setup()
+	defer deps.DB.Close()
+
+	opts := reconcile.Options{
+		Tenants: splitList(*tenants),
+		Repair:  *repair,
+		Notify:  *notify,
+	}
+
+	report, err := newReconciler(deps).Run(opts)
+	if err != nil {
+		log.Fatalf("Error running reconciliation: %v", err)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	} else {
+		printReconcileReport(report)
+	}
+
+	// A non-zero exit lets cron jobs alert on drift that still needs attention
+	if report.Unresolved() > 0 || len(report.Errors) > 0 {
+		deps.DB.Close()
+		os.Exit(1)
+	}
+}
+
+func newReconciler(deps *handlers.Dependencies) *reconcile.Reconciler {
+	return reconcile.New(
+		reconcile.NewStripeProvider(deps.StripeClient, deps.Catalog.IsPlanPrice),
+		deps.SubRepo,
+		deps.InvoiceRepo,
+		deps.CustomerRepo,
+		deps.SubscriptionHistoryRepo,
+		deps.WebhookClient,
+	)
+}
+
+// scheduledReconcile returns the job run by the server on RECONCILE_INTERVAL
+func scheduledReconcile(deps *handlers.Dependencies) func() error {
+	reconciler := newReconciler(deps)
+	opts := reconcile.Options{
+		Tenants: deps.Config.ReconcileTenants,
+		Repair:  deps.Config.ReconcileRepair,
+		Notify:  deps.Config.ReconcileNotify,
+	}
+
+	return func() error {
+		report, err := reconciler.Run(opts)
+		if err != nil {
+			return err
+		}
+
+		for _, m := range report.Mismatches {
+			log.Printf("Reconciliation mismatch: %s", formatMismatch(m))
+		}
+		log.Printf(
+			"Reconciliation finished: %d subscriptions, %d invoices, %d mismatches (%d unresolved), %d errors",
+			report.Subscriptions, report.Invoices, len(report.Mismatches), report.Unresolved(), len(report.Errors),
+		)
+		return nil
+	}
+}
+
+func printReconcileReport(report *reconcile.Report) {
+	for _, m := range report.Mismatches {
+		fmt.Println(formatMismatch(m))
+	}
+	for _, e := range report.Errors {
+		fmt.Printf("error: %s\n", e)
+	}
+
+	fmt.Printf(
+		"Checked %d subscriptions and %d invoices across %d tenants: %d mismatches, %d unresolved\n",
+		report.Subscriptions, report.Invoices, len(report.Tenants), len(report.Mismatches), report.Unresolved(),
+	)
+}
+
+func formatMismatch(m reconcile.Mismatch) string {
+	line := fmt.Sprintf("[%s] %s %s %s", m.Tenant, m.Object, m.StripeID, m.Kind)
+	if m.Field != "" {
+		line += fmt.Sprintf(" %s: local=%q stripe=%q", m.Field, m.Local, m.Remote)
+	}
+	if m.Repaired {
+		line += " (repaired)"
+	}
+	if m.Error != "" {
+		line += " (repair failed: " + m.Error + ")"
+	}
+	return line
+}
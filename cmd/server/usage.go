@@ -0,0 +1,28 @@
+package main
+
+import (
+	"log"
+
+	"github.com/naventro/payment-service/internal/api/handlers"
+	"github.com/naventro/payment-service/internal/usage"
+)
+
+// scheduledUsageReport returns the job run by the server on USAGE_REPORT_INTERVAL
+func scheduledUsageReport(deps *handlers.Dependencies) func() error {
+	reporter := usage.New(deps.UsageRepo, deps.StripeClient, deps.Catalog, deps.Config.UsageReportMaxAttempts)
+
+	return func() error {
+		result, err := reporter.Run()
+		if err != nil {
+			return err
+		}
+
+		if result.Reported > 0 || result.Retrying > 0 || result.Failed > 0 {
+			log.Printf(
+				"Usage reporting finished: %d reported, %d to retry, %d failed",
+				result.Reported, result.Retrying, result.Failed,
+			)
+		}
+		return nil
+	}
+}
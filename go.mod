@@ -3,6 +3,7 @@ module github.com/naventro/payment-service
 go 1.25.3
 
 require (
+	github.com/go-pdf/fpdf v0.9.0
 	github.com/gofiber/fiber/v2 v2.52.10
 	github.com/lib/pq v1.10.9
 	github.com/stripe/stripe-go/v84 v84.2.0
@@ -0,0 +1,446 @@
+package analytics
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/naventro/payment-service/internal/models"
+)
+
+// Query selects the subscriptions and period of a report. Empty Tenant and
+// Plan include every tenant and plan; an empty Currency reports in the
+// configured reporting currency.
+type Query struct {
+	Tenant   string
+	Plan     models.Plan
+	From     time.Time
+	To       time.Time
+	Currency string
+}
+
+// Metrics are the revenue and subscriber figures of a group of subscriptions.
+// Amounts are in the smallest unit of the report currency.
+type Metrics struct {
+	// StartingMRR is the MRR at From and MRR the MRR at To
+	StartingMRR int64 `json:"starting_mrr"`
+	MRR         int64 `json:"mrr"`
+	ARR         int64 `json:"arr"`
+	// Movements between From and To: NewMRR from subscriptions that started
+	// paying, ExpansionMRR and ContractionMRR from price or seat changes and
+	// ChurnedMRR from subscriptions that stopped paying
+	NewMRR         int64 `json:"new_mrr"`
+	ExpansionMRR   int64 `json:"expansion_mrr"`
+	ContractionMRR int64 `json:"contraction_mrr"`
+	ChurnedMRR     int64 `json:"churned_mrr"`
+	NetNewMRR      int64 `json:"net_new_mrr"`
+	// Subscribers counts subscriptions by status at To. StartingPaying and
+	// Paying count the subscriptions counted in MRR at From and To, and
+	// Churned the ones that stopped paying in between.
+	Subscribers    map[models.SubscriptionStatus]int64 `json:"subscribers"`
+	StartingPaying int64                               `json:"starting_paying"`
+	Paying         int64                               `json:"paying"`
+	Churned        int64                               `json:"churned"`
+	// Monthly churn rates: Churned over StartingPaying and ChurnedMRR over
+	// StartingMRR, divided by the months in the period
+	CustomerChurnRate float64 `json:"customer_churn_rate"`
+	RevenueChurnRate  float64 `json:"revenue_churn_rate"`
+	// ARPU is MRR per paying subscription at To and LTV the ARPU over the
+	// monthly customer churn rate, zero when nobody churned
+	ARPU int64 `json:"arpu"`
+	LTV  int64 `json:"ltv"`
+	// Trials that ended between From and To, and how many of them became active
+	TrialsEnded         int64   `json:"trials_ended"`
+	TrialsConverted     int64   `json:"trials_converted"`
+	TrialConversionRate float64 `json:"trial_conversion_rate"`
+}
+
+// Breakdown holds the metrics of one tenant and plan
+type Breakdown struct {
+	Tenant string      `json:"tenant"`
+	Plan   models.Plan `json:"plan"`
+	Metrics
+}
+
+// Cohort follows the subscriptions that started paying in a month.
+// Retention[k] is the share still paying at the end of the k-th month after
+// it, or at To for the current month.
+type Cohort struct {
+	Month       string    `json:"month"`
+	Subscribers int64     `json:"subscribers"`
+	Retention   []float64 `json:"retention"`
+}
+
+// Report is the result of a Query
+type Report struct {
+	Currency  string       `json:"currency"`
+	Tenant    string       `json:"tenant,omitempty"`
+	Plan      models.Plan  `json:"plan,omitempty"`
+	From      time.Time    `json:"from"`
+	To        time.Time    `json:"to"`
+	Totals    Metrics      `json:"totals"`
+	Breakdown []*Breakdown `json:"breakdown"`
+	Cohorts   []*Cohort    `json:"cohorts"`
+	// MissingRates lists currencies without an exchange rate, whose
+	// subscriptions are left out of the amounts
+	MissingRates []string `json:"missing_rates,omitempty"`
+}
+
+// HistorySource lists subscription snapshots, implemented by
+// repository.SubscriptionHistoryRepository
+type HistorySource interface {
+	// List returns the snapshots recorded before the given time, grouped by
+	// subscription and oldest first. An empty tenant returns every tenant.
+	List(tenant string, before time.Time) ([]*models.SubscriptionHistory, error)
+}
+
+// Analytics computes MRR, churn and retention from the subscription history
+type Analytics struct {
+	historyRepo HistorySource
+	currency    string
+	rates       map[string]float64
+}
+
+// New creates an Analytics reporting in currency. rates hold the units of
+// currency per unit of every other currency.
+func New(historyRepo HistorySource, currency string, rates map[string]float64) *Analytics {
+	return &Analytics{
+		historyRepo: historyRepo,
+		currency:    strings.ToLower(currency),
+		rates:       rates,
+	}
+}
+
+type groupKey struct {
+	tenant string
+	plan   models.Plan
+}
+
+// Report computes the metrics of q from the subscription history
+func (a *Analytics) Report(q Query) (*Report, error) {
+	if !q.From.Before(q.To) {
+		return nil, fmt.Errorf("from must be before to")
+	}
+
+	currency := strings.ToLower(q.Currency)
+	if currency == "" {
+		currency = a.currency
+	}
+	if _, ok := a.rate(currency); !ok {
+		return nil, fmt.Errorf("no exchange rate for %s", currency)
+	}
+
+	entries, err := a.historyRepo.List(q.Tenant, q.To)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &reportBuilder{
+		analytics: a,
+		query:     q,
+		currency:  currency,
+		groups:    make(map[groupKey]*Breakdown),
+		cohorts:   make(map[string]*cohortCounts),
+		missing:   make(map[string]bool),
+	}
+
+	for start := 0; start < len(entries); {
+		end := start + 1
+		for end < len(entries) && entries[end].SubscriptionID == entries[start].SubscriptionID {
+			end++
+		}
+		r.addSubscription(entries[start:end])
+		start = end
+	}
+
+	return r.build(), nil
+}
+
+// HasRate reports whether reports can be made in currency. An empty
+// currency is the reporting currency.
+func (a *Analytics) HasRate(currency string) bool {
+	if currency == "" {
+		return true
+	}
+	_, ok := a.rate(strings.ToLower(currency))
+	return ok
+}
+
+// rate returns the units of the reporting currency per unit of currency
+func (a *Analytics) rate(currency string) (float64, bool) {
+	if currency == a.currency {
+		return 1, true
+	}
+	rate, ok := a.rates[currency]
+	return rate, ok
+}
+
+// convert converts an amount in the smallest unit of from into the smallest
+// unit of to
+func (a *Analytics) convert(amount int64, from, to string) (int64, bool) {
+	if from == to {
+		return amount, true
+	}
+
+	fromRate, ok := a.rate(from)
+	if !ok {
+		return 0, false
+	}
+	toRate, ok := a.rate(to)
+	if !ok {
+		return 0, false
+	}
+
+	units := float64(amount) / minorUnits(from) * fromRate / toRate
+	return int64(math.Round(units * minorUnits(to))), true
+}
+
+func minorUnits(currency string) float64 {
+	return float64(models.MinorUnits(currency))
+}
+
+// paying reports whether a subscription in status counts towards MRR
+func paying(status models.SubscriptionStatus) bool {
+	return status == models.StatusActive || status == models.StatusPastDue
+}
+
+type cohortCounts struct {
+	month       time.Time
+	subscribers int64
+	retained    []int64
+}
+
+type reportBuilder struct {
+	analytics *Analytics
+	query     Query
+	currency  string
+	groups    map[groupKey]*Breakdown
+	cohorts   map[string]*cohortCounts
+	missing   map[string]bool
+}
+
+func (r *reportBuilder) group(entry *models.SubscriptionHistory) *Breakdown {
+	key := groupKey{tenant: entry.Tenant, plan: entry.Plan}
+	group, ok := r.groups[key]
+	if !ok {
+		group = &Breakdown{
+			Tenant:  entry.Tenant,
+			Plan:    entry.Plan,
+			Metrics: Metrics{Subscribers: make(map[models.SubscriptionStatus]int64)},
+		}
+		r.groups[key] = group
+	}
+	return group
+}
+
+// mrr returns the MRR an entry contributes in the report currency
+func (r *reportBuilder) mrr(entry *models.SubscriptionHistory) int64 {
+	if entry == nil || !paying(entry.Status) {
+		return 0
+	}
+
+	amount, ok := r.analytics.convert(entry.MRR, entry.Currency, r.currency)
+	if !ok {
+		r.missing[entry.Currency] = true
+		return 0
+	}
+	return amount
+}
+
+// addSubscription adds the snapshots of one subscription, oldest first
+func (r *reportBuilder) addSubscription(entries []*models.SubscriptionHistory) {
+	from, to := r.query.From, r.query.To
+
+	var previous *models.SubscriptionHistory
+	var firstPaying *models.SubscriptionHistory
+	for _, entry := range entries {
+		if firstPaying == nil && paying(entry.Status) {
+			firstPaying = entry
+		}
+
+		if entry.RecordedAt.Before(from) {
+			previous = entry
+			continue
+		}
+
+		before, after := r.mrr(previous), r.mrr(entry)
+		switch {
+		case before == 0 && after > 0:
+			r.group(entry).NewMRR += after
+		case before > 0 && after == 0:
+			r.group(previous).ChurnedMRR += before
+			r.group(previous).Churned++
+		case after > before:
+			r.group(entry).ExpansionMRR += after - before
+		case after < before:
+			r.group(entry).ContractionMRR += before - after
+		}
+
+		if previous != nil && previous.Status == models.StatusTrialing && entry.Status != models.StatusTrialing {
+			group := r.group(entry)
+			group.TrialsEnded++
+			if entry.Status == models.StatusActive {
+				group.TrialsConverted++
+			}
+		}
+
+		previous = entry
+	}
+
+	if starting := stateAt(entries, from); starting != nil {
+		group := r.group(starting)
+		group.StartingMRR += r.mrr(starting)
+		if paying(starting.Status) {
+			group.StartingPaying++
+		}
+	}
+
+	if current := stateAt(entries, to); current != nil {
+		group := r.group(current)
+		group.MRR += r.mrr(current)
+		group.Subscribers[current.Status]++
+		if paying(current.Status) {
+			group.Paying++
+		}
+	}
+
+	if firstPaying != nil && !firstPaying.RecordedAt.Before(from) && r.included(firstPaying) {
+		r.addToCohort(entries, firstPaying)
+	}
+}
+
+// addToCohort counts a subscription in the cohort of the month it started paying
+func (r *reportBuilder) addToCohort(entries []*models.SubscriptionHistory, first *models.SubscriptionHistory) {
+	month := time.Date(first.RecordedAt.Year(), first.RecordedAt.Month(), 1, 0, 0, 0, 0, time.UTC)
+	key := month.Format("2006-01")
+
+	cohort, ok := r.cohorts[key]
+	if !ok {
+		cohort = &cohortCounts{month: month}
+		for start := month; start.Before(r.query.To); start = start.AddDate(0, 1, 0) {
+			cohort.retained = append(cohort.retained, 0)
+		}
+		r.cohorts[key] = cohort
+	}
+	cohort.subscribers++
+
+	for k := range cohort.retained {
+		at := month.AddDate(0, k+1, 0)
+		if at.After(r.query.To) {
+			at = r.query.To
+		}
+		if state := stateAt(entries, at); state != nil && paying(state.Status) {
+			cohort.retained[k]++
+		}
+	}
+}
+
+// included reports whether an entry belongs to the queried plan
+func (r *reportBuilder) included(entry *models.SubscriptionHistory) bool {
+	return r.query.Plan == "" || entry.Plan == r.query.Plan
+}
+
+func (r *reportBuilder) build() *Report {
+	report := &Report{
+		Currency:  r.currency,
+		Tenant:    r.query.Tenant,
+		Plan:      r.query.Plan,
+		From:      r.query.From,
+		To:        r.query.To,
+		Totals:    Metrics{Subscribers: make(map[models.SubscriptionStatus]int64)},
+		Breakdown: []*Breakdown{},
+		Cohorts:   []*Cohort{},
+	}
+
+	// Churn rates are monthly whatever the length of the period
+	months := r.query.To.Sub(r.query.From).Hours() / 24 / (365.25 / 12)
+
+	for _, group := range r.groups {
+		if r.query.Plan != "" && group.Plan != r.query.Plan {
+			continue
+		}
+
+		group.finish(months)
+		report.Breakdown = append(report.Breakdown, group)
+
+		totals := &report.Totals
+		totals.StartingMRR += group.StartingMRR
+		totals.MRR += group.MRR
+		totals.NewMRR += group.NewMRR
+		totals.ExpansionMRR += group.ExpansionMRR
+		totals.ContractionMRR += group.ContractionMRR
+		totals.ChurnedMRR += group.ChurnedMRR
+		totals.StartingPaying += group.StartingPaying
+		totals.Paying += group.Paying
+		totals.Churned += group.Churned
+		totals.TrialsEnded += group.TrialsEnded
+		totals.TrialsConverted += group.TrialsConverted
+		for status, count := range group.Subscribers {
+			totals.Subscribers[status] += count
+		}
+	}
+	report.Totals.finish(months)
+
+	sort.Slice(report.Breakdown, func(i, j int) bool {
+		a, b := report.Breakdown[i], report.Breakdown[j]
+		if a.Tenant != b.Tenant {
+			return a.Tenant < b.Tenant
+		}
+		return a.Plan < b.Plan
+	})
+
+	for key, counts := range r.cohorts {
+		cohort := &Cohort{Month: key, Subscribers: counts.subscribers, Retention: make([]float64, len(counts.retained))}
+		for k, retained := range counts.retained {
+			cohort.Retention[k] = float64(retained) / float64(counts.subscribers)
+		}
+		report.Cohorts = append(report.Cohorts, cohort)
+	}
+	sort.Slice(report.Cohorts, func(i, j int) bool { return report.Cohorts[i].Month < report.Cohorts[j].Month })
+
+	for currency := range r.missing {
+		report.MissingRates = append(report.MissingRates, currency)
+	}
+	sort.Strings(report.MissingRates)
+
+	return report
+}
+
+// finish derives the metrics computed from the others over a period of the
+// given number of months
+func (m *Metrics) finish(months float64) {
+	m.ARR = m.MRR * 12
+	m.NetNewMRR = m.NewMRR + m.ExpansionMRR - m.ContractionMRR - m.ChurnedMRR
+	if m.TrialsEnded > 0 {
+		m.TrialConversionRate = float64(m.TrialsConverted) / float64(m.TrialsEnded)
+	}
+
+	if m.StartingPaying > 0 && months > 0 {
+		m.CustomerChurnRate = float64(m.Churned) / float64(m.StartingPaying) / months
+	}
+	if m.StartingMRR > 0 && months > 0 {
+		m.RevenueChurnRate = float64(m.ChurnedMRR) / float64(m.StartingMRR) / months
+	}
+
+	if m.Paying > 0 {
+		m.ARPU = m.MRR / m.Paying
+	}
+	if m.CustomerChurnRate > 0 {
+		m.LTV = int64(math.Round(float64(m.ARPU) / m.CustomerChurnRate))
+	}
+}
+
+// stateAt returns the last snapshot recorded before t, or nil if the
+// subscription did not exist yet
+func stateAt(entries []*models.SubscriptionHistory, t time.Time) *models.SubscriptionHistory {
+	var state *models.SubscriptionHistory
+	for _, entry := range entries {
+		if !entry.RecordedAt.Before(t) {
+			break
+		}
+		state = entry
+	}
+	return state
+}
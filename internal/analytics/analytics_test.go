@@ -0,0 +1,164 @@
+package analytics
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/naventro/payment-service/internal/models"
+)
+
+// fakeHistory serves snapshots from memory the way the repository lists them
+type fakeHistory []*models.SubscriptionHistory
+
+func (h fakeHistory) List(tenant string, before time.Time) ([]*models.SubscriptionHistory, error) {
+	var entries []*models.SubscriptionHistory
+	for _, entry := range h {
+		if (tenant == "" || entry.Tenant == tenant) && entry.RecordedAt.Before(before) {
+			entries = append(entries, entry)
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].SubscriptionID != entries[j].SubscriptionID {
+			return entries[i].SubscriptionID < entries[j].SubscriptionID
+		}
+		return entries[i].RecordedAt.Before(entries[j].RecordedAt)
+	})
+	return entries, nil
+}
+
+func day(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+func snapshot(id int, plan models.Plan, status models.SubscriptionStatus, currency string, mrr int64, at time.Time) *models.SubscriptionHistory {
+	return &models.SubscriptionHistory{
+		SubscriptionID: id,
+		Tenant:         "menuum",
+		Plan:           plan,
+		Status:         status,
+		Quantity:       1,
+		Currency:       currency,
+		MRR:            mrr,
+		RecordedAt:     at,
+	}
+}
+
+// newHistory returns five subscriptions: 1 pays since before 2026 and doubles
+// its seats in February, 2 pays since before 2026 and cancels in February,
+// 3 starts paying in January, 4 converts from a trial in January and cancels
+// in March, and 5 starts paying in euros on a yearly plan in February
+func newHistory() fakeHistory {
+	monthly, yearly := models.Plan("premium_monthly"), models.Plan("premium_yearly")
+	return fakeHistory{
+		snapshot(1, monthly, models.StatusActive, "usd", 1000, day(2025, time.December, 1)),
+		snapshot(1, monthly, models.StatusActive, "usd", 2000, day(2026, time.February, 10)),
+		snapshot(2, monthly, models.StatusActive, "usd", 1000, day(2025, time.November, 15)),
+		snapshot(2, monthly, models.StatusCanceled, "usd", 1000, day(2026, time.February, 20)),
+		snapshot(3, monthly, models.StatusActive, "usd", 1500, day(2026, time.January, 10)),
+		snapshot(4, monthly, models.StatusTrialing, "usd", 1000, day(2026, time.January, 5)),
+		snapshot(4, monthly, models.StatusActive, "usd", 1000, day(2026, time.January, 19)),
+		snapshot(4, monthly, models.StatusCanceled, "usd", 1000, day(2026, time.March, 5)),
+		snapshot(5, yearly, models.StatusActive, "eur", 1000, day(2026, time.February, 1)),
+	}
+}
+
+func TestReport(t *testing.T) {
+	type figures struct {
+		StartingMRR, MRR, New, Expansion, Contraction, ChurnedMRR, Churned int64
+	}
+
+	tests := []struct {
+		name    string
+		query   Query
+		want    figures
+		cohorts []Cohort
+	}{
+		{
+			name:  "quarter",
+			query: Query{From: day(2026, time.January, 1), To: day(2026, time.April, 1)},
+			// 5 is converted at 1.1 USD per EUR
+			want: figures{StartingMRR: 2000, MRR: 4600, New: 3600, Expansion: 1000, ChurnedMRR: 2000, Churned: 2},
+			cohorts: []Cohort{
+				{Month: "2026-01", Subscribers: 2, Retention: []float64{1, 1, 0.5}},
+				{Month: "2026-02", Subscribers: 1, Retention: []float64{1, 1}},
+			},
+		},
+		{
+			name:    "one plan",
+			query:   Query{Plan: "premium_yearly", From: day(2026, time.January, 1), To: day(2026, time.April, 1)},
+			want:    figures{MRR: 1100, New: 1100},
+			cohorts: []Cohort{{Month: "2026-02", Subscribers: 1, Retention: []float64{1, 1}}},
+		},
+		{
+			name:  "last month",
+			query: Query{From: day(2026, time.March, 1), To: day(2026, time.April, 1)},
+			// Everyone started paying before the period, so there are no cohorts
+			want: figures{StartingMRR: 5600, MRR: 4600, ChurnedMRR: 1000, Churned: 1},
+		},
+		{
+			name:  "other tenant",
+			query: Query{Tenant: "other", From: day(2026, time.January, 1), To: day(2026, time.April, 1)},
+		},
+	}
+
+	analytics := New(newHistory(), "USD", map[string]float64{"eur": 1.1})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report, err := analytics.Report(tt.query)
+			if err != nil {
+				t.Fatalf("Report: %v", err)
+			}
+
+			totals := report.Totals
+			got := figures{
+				StartingMRR: totals.StartingMRR,
+				MRR:         totals.MRR,
+				New:         totals.NewMRR,
+				Expansion:   totals.ExpansionMRR,
+				Contraction: totals.ContractionMRR,
+				ChurnedMRR:  totals.ChurnedMRR,
+				Churned:     totals.Churned,
+			}
+			if got != tt.want {
+				t.Errorf("totals = %+v, want %+v", got, tt.want)
+			}
+			if net := tt.want.New + tt.want.Expansion - tt.want.Contraction - tt.want.ChurnedMRR; totals.NetNewMRR != net {
+				t.Errorf("net new MRR = %d, want %d", totals.NetNewMRR, net)
+			}
+
+			var cohorts []Cohort
+			for _, cohort := range report.Cohorts {
+				cohorts = append(cohorts, *cohort)
+			}
+			if !reflect.DeepEqual(cohorts, tt.cohorts) {
+				t.Errorf("cohorts = %+v, want %+v", cohorts, tt.cohorts)
+			}
+			if len(report.MissingRates) != 0 {
+				t.Errorf("missing rates = %v, want none", report.MissingRates)
+			}
+		})
+	}
+}
+
+func TestReportTrials(t *testing.T) {
+	analytics := New(newHistory(), "usd", map[string]float64{"eur": 1.1})
+
+	report, err := analytics.Report(Query{From: day(2026, time.January, 1), To: day(2026, time.April, 1)})
+	if err != nil {
+		t.Fatalf("Report: %v", err)
+	}
+
+	totals := report.Totals
+	if totals.TrialsEnded != 1 || totals.TrialsConverted != 1 || totals.TrialConversionRate != 1 {
+		t.Errorf("trials = %d ended, %d converted, rate %v; want 1, 1, 1", totals.TrialsEnded, totals.TrialsConverted, totals.TrialConversionRate)
+	}
+	if totals.StartingPaying != 2 || totals.Paying != 3 {
+		t.Errorf("paying = %d at start, %d at end; want 2 and 3", totals.StartingPaying, totals.Paying)
+	}
+	if totals.Subscribers[models.StatusActive] != 3 || totals.Subscribers[models.StatusCanceled] != 2 {
+		t.Errorf("subscribers = %v, want 3 active and 2 canceled", totals.Subscribers)
+	}
+}
@@ -0,0 +1,25 @@
+package dto
+
+import (
+	"github.com/naventro/payment-service/internal/catalog"
+	"github.com/naventro/payment-service/internal/models"
+)
+
+// AddonListResponse represents the add-ons that can be attached to subscriptions
+type AddonListResponse struct {
+	Addons []catalog.Addon `json:"addons"`
+}
+
+// AttachAddonRequest represents the request body for attaching an add-on to a subscription
+type AttachAddonRequest struct {
+	Addon    string `json:"addon"`
+	Quantity int64  `json:"quantity,omitempty"`
+	// ProrationBehavior is create_prorations (default), always_invoice or none
+	ProrationBehavior string `json:"proration_behavior,omitempty"`
+}
+
+// SubscriptionItemsResponse represents the items billed on a subscription
+type SubscriptionItemsResponse struct {
+	SubscriptionID string                     `json:"subscription_id"`
+	Items          []*models.SubscriptionItem `json:"items"`
+}
@@ -1,19 +1,54 @@
 package dto
 
 import (
+	"time"
+
 	"github.com/naventro/payment-service/internal/models"
 )
 
 // CheckoutRequest represents the request body for creating a checkout session
 type CheckoutRequest struct {
-	UserID     string      `json:"user_id"`
-	Plan       models.Plan `json:"plan"`
-	SuccessURL string      `json:"success_url"`
-	CancelURL  string      `json:"cancel_url"`
+	UserID string      `json:"user_id"`
+	Plan   models.Plan `json:"plan,omitempty"`
+	// Product is a catalog product bought with a one-time payment, instead of a plan
+	Product string `json:"product,omitempty"`
+	// Quantity is the number of seats (e.g. locations) of the plan, 1 if unset
+	Quantity int64 `json:"quantity,omitempty"`
+	// Currency is the ISO 4217 code the plan is charged in. If unset it is the
+	// local currency of Country when the plan is sold in it, otherwise the
+	// catalog currency.
+	Currency string `json:"currency,omitempty"`
+	// Country is the customer's ISO 3166 alpha-2 country code
+	Country string `json:"country,omitempty"`
+	// Locale is the language of the Checkout page, e.g. "es" or "pt-BR"
+	Locale     string `json:"locale,omitempty"`
+	SuccessURL string `json:"success_url"`
+	CancelURL  string `json:"cancel_url"`
+	// Optional profile used to prefill newly created Stripe customers
+	Email string `json:"email,omitempty"`
+	Name  string `json:"name,omitempty"`
 }
 
 // CheckoutResponse represents the response body for a successful checkout session creation
 type CheckoutResponse struct {
 	SessionID  string `json:"session_id"`
 	SessionURL string `json:"session_url"`
+	// Currency is the currency a plan is charged in
+	Currency string `json:"currency,omitempty"`
+}
+
+// CheckoutSessionResponse represents the provisioning status of a checkout session
+type CheckoutSessionResponse struct {
+	SessionID          string                       `json:"session_id"`
+	UserID             string                       `json:"user_id"`
+	Plan               models.Plan                  `json:"plan,omitempty"`
+	Product            string                       `json:"product,omitempty"`
+	Status             models.CheckoutSessionStatus `json:"status"`
+	Provisioned        bool                         `json:"provisioned"`
+	SubscriptionID     string                       `json:"subscription_id,omitempty"`
+	SubscriptionStatus models.SubscriptionStatus    `json:"subscription_status,omitempty"`
+	OrderStatus        models.OrderStatus           `json:"order_status,omitempty"`
+	CreatedAt          time.Time                    `json:"created_at"`
+	CompletedAt        *time.Time                   `json:"completed_at,omitempty"`
+	ExpiredAt          *time.Time                   `json:"expired_at,omitempty"`
 }
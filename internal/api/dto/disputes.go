@@ -0,0 +1,8 @@
+package dto
+
+import "github.com/naventro/payment-service/internal/models"
+
+// DisputeListResponse represents a page of disputes
+type DisputeListResponse struct {
+	Disputes []*models.Dispute `json:"disputes"`
+}
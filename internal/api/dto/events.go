@@ -0,0 +1,41 @@
+package dto
+
+import (
+	"time"
+
+	"github.com/naventro/payment-service/internal/models"
+	"github.com/naventro/payment-service/internal/repository"
+)
+
+// EventListResponse represents a page of stored Stripe events
+type EventListResponse struct {
+	Events []*models.StripeEvent `json:"events"`
+}
+
+// EventReplayRequest selects stored events to replay, either by Stripe event
+// ID or by filter
+type EventReplayRequest struct {
+	EventIDs []string           `json:"event_ids,omitempty"`
+	Type     string             `json:"type,omitempty"`
+	Status   models.EventStatus `json:"status,omitempty"`
+	From     *time.Time         `json:"from,omitempty"`
+	To       *time.Time         `json:"to,omitempty"`
+	Limit    int                `json:"limit,omitempty"`
+	DryRun   bool               `json:"dry_run"`
+}
+
+// EventReplayResult reports the outcome of replaying a single event. In a dry
+// run Changes lists the rows the event would have written.
+type EventReplayResult struct {
+	EventID string                 `json:"event_id"`
+	Type    string                 `json:"type,omitempty"`
+	DryRun  bool                   `json:"dry_run"`
+	Status  models.EventStatus     `json:"status,omitempty"`
+	Error   string                 `json:"error,omitempty"`
+	Changes []repository.RowChange `json:"changes,omitempty"`
+}
+
+// EventReplayResponse represents the results of a replay request
+type EventReplayResponse struct {
+	Results []EventReplayResult `json:"results"`
+}
@@ -0,0 +1,40 @@
+package dto
+
+import "github.com/naventro/payment-service/internal/models"
+
+// Refund states of an invoice
+const (
+	RefundStateNone    = "none"
+	RefundStatePartial = "partial"
+	RefundStateFull    = "full"
+)
+
+// InvoiceHistoryEntry is an invoice with the refunds issued against it
+type InvoiceHistoryEntry struct {
+	*models.Invoice
+	AmountRefunded int64            `json:"amount_refunded"`
+	RefundState    string           `json:"refund_state"`
+	Refunds        []*models.Refund `json:"refunds"`
+}
+
+// InvoiceHistoryResponse represents a user's invoices, newest first
+type InvoiceHistoryResponse struct {
+	Invoices []InvoiceHistoryEntry `json:"invoices"`
+}
+
+// RefundRequest represents the request body for refunding an invoice
+type RefundRequest struct {
+	// Amount in the smallest currency unit; omitted refunds the remaining balance
+	Amount int64 `json:"amount,omitempty"`
+	// Reason is one of duplicate, fraudulent or requested_by_customer
+	Reason string `json:"reason,omitempty"`
+}
+
+// RefundResponse represents a refund issued through the API
+type RefundResponse struct {
+	RefundID  string              `json:"refund_id"`
+	InvoiceID string              `json:"invoice_id"`
+	Amount    int64               `json:"amount"`
+	Currency  string              `json:"currency"`
+	Status    models.RefundStatus `json:"status"`
+}
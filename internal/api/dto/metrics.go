@@ -0,0 +1,8 @@
+package dto
+
+import "github.com/naventro/payment-service/internal/metrics"
+
+// MetricsResponse represents the in-process counters since the service started
+type MetricsResponse struct {
+	Counters []metrics.Counter `json:"counters"`
+}
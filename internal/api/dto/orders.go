@@ -0,0 +1,23 @@
+package dto
+
+import (
+	"github.com/naventro/payment-service/internal/catalog"
+	"github.com/naventro/payment-service/internal/models"
+)
+
+// OrderListResponse represents a user's one-time purchases, newest first
+type OrderListResponse struct {
+	Orders []*models.Order `json:"orders"`
+}
+
+// PlanListResponse represents the subscription plans and the currencies they are sold in
+type PlanListResponse struct {
+	// Currency is charged when a checkout sets no currency or country
+	Currency string         `json:"currency"`
+	Plans    []catalog.Plan `json:"plans"`
+}
+
+// ProductListResponse represents the products available for one-time checkout
+type ProductListResponse struct {
+	Products []catalog.Product `json:"products"`
+}
@@ -0,0 +1,28 @@
+package dto
+
+// PaymentMethod represents a card saved on the user's Stripe customer
+type PaymentMethod struct {
+	ID        string `json:"id"`
+	Brand     string `json:"brand"`
+	Last4     string `json:"last4"`
+	ExpMonth  int64  `json:"exp_month"`
+	ExpYear   int64  `json:"exp_year"`
+	Expired   bool   `json:"expired"`
+	IsDefault bool   `json:"is_default"`
+}
+
+// PaymentMethodListResponse represents the saved cards of a user
+type PaymentMethodListResponse struct {
+	PaymentMethods []PaymentMethod `json:"payment_methods"`
+}
+
+// SetupIntentResponse represents a SetupIntent to confirm with Stripe.js to save a new card
+type SetupIntentResponse struct {
+	SetupIntentID string `json:"setup_intent_id"`
+	ClientSecret  string `json:"client_secret"`
+}
+
+// DefaultPaymentMethodRequest represents the request body for changing the default card
+type DefaultPaymentMethodRequest struct {
+	PaymentMethodID string `json:"payment_method_id"`
+}
@@ -0,0 +1,24 @@
+package dto
+
+import (
+	"time"
+
+	"github.com/naventro/payment-service/internal/models"
+)
+
+// RevenueResponse represents the revenue of a period, one entry per currency
+type RevenueResponse struct {
+	Tenant     string                    `json:"tenant,omitempty"`
+	From       *time.Time                `json:"from,omitempty"`
+	To         *time.Time                `json:"to,omitempty"`
+	Currencies []*models.CurrencyRevenue `json:"currencies"`
+}
+
+// RecognitionResponse represents the recognized and deferred revenue per
+// tenant, currency and month
+type RecognitionResponse struct {
+	Tenant string                     `json:"tenant,omitempty"`
+	From   time.Time                  `json:"from"`
+	To     time.Time                  `json:"to"`
+	Months []*models.RecognitionMonth `json:"months"`
+}
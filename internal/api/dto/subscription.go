@@ -0,0 +1,8 @@
+package dto
+
+// QuantityRequest represents the request body for changing the number of seats of a subscription
+type QuantityRequest struct {
+	Quantity int64 `json:"quantity"`
+	// ProrationBehavior is create_prorations (default), always_invoice or none
+	ProrationBehavior string `json:"proration_behavior,omitempty"`
+}
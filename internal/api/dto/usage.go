@@ -0,0 +1,35 @@
+package dto
+
+import "time"
+
+// UsageRequest represents the request body for recording metered usage
+type UsageRequest struct {
+	UserID string `json:"user_id"`
+	Meter  string `json:"meter"`
+	// Quantity is the amount of usage, e.g. the number of online orders
+	Quantity int64 `json:"quantity"`
+	// IdempotencyKey identifies the event; retrying with the same key does not
+	// record it twice
+	IdempotencyKey string `json:"idempotency_key"`
+	// Timestamp is when the usage happened, now if unset
+	Timestamp *time.Time `json:"timestamp,omitempty"`
+}
+
+// UsageResponse represents a user's metered usage in the current billing period
+type UsageResponse struct {
+	UserID      string       `json:"user_id"`
+	PeriodStart *time.Time   `json:"period_start,omitempty"`
+	PeriodEnd   *time.Time   `json:"period_end,omitempty"`
+	Meters      []MeterUsage `json:"meters"`
+}
+
+// MeterUsage is the usage of one meter in the billing period. Billable is
+// the usage above the free units.
+type MeterUsage struct {
+	Meter     string `json:"meter"`
+	Name      string `json:"name"`
+	Quantity  int64  `json:"quantity"`
+	Reported  int64  `json:"reported"`
+	FreeUnits int64  `json:"free_units"`
+	Billable  int64  `json:"billable"`
+}
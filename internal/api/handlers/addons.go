@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"log"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/naventro/payment-service/internal/api/dto"
+	"github.com/naventro/payment-service/internal/models"
+	"github.com/stripe/stripe-go/v84"
+)
+
+// NewAddonsHandler creates a Fiber handler for listing the catalog add-ons
+func NewAddonsHandler(deps *Dependencies) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		return dto.SendSuccess(c, fiber.StatusOK, dto.AddonListResponse{Addons: deps.Catalog.Addons()})
+	}
+}
+
+// NewAttachAddonHandler creates a Fiber handler for adding an add-on to a
+// subscription as an extra item billed on the same invoice
+func NewAttachAddonHandler(deps *Dependencies) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get tenant from locals (set by middleware)
+		tenant := c.Locals("tenant").(string)
+
+		var req dto.AttachAddonRequest
+		if err := c.BodyParser(&req); err != nil {
+			return dto.SendError(c, fiber.StatusBadRequest, "Invalid request body")
+		}
+
+		addon, ok := deps.Catalog.Addon(req.Addon)
+		if !ok {
+			return dto.SendError(c, fiber.StatusBadRequest, "Invalid add-on")
+		}
+
+		if req.Quantity == 0 {
+			req.Quantity = 1
+		}
+		if req.Quantity < 1 {
+			return dto.SendError(c, fiber.StatusBadRequest, "quantity must be at least 1")
+		}
+
+		if req.ProrationBehavior == "" {
+			req.ProrationBehavior = "create_prorations"
+		}
+		if !isValidProrationBehavior(req.ProrationBehavior) {
+			return dto.SendError(c, fiber.StatusBadRequest, "proration_behavior must be one of create_prorations, always_invoice or none")
+		}
+
+		subscription, items, status, message := addonSubscription(deps, c.Params("userID"), tenant)
+		if subscription == nil {
+			return dto.SendError(c, status, message)
+		}
+
+		if findAddonItem(items, addon.Key) != nil {
+			return dto.SendError(c, fiber.StatusConflict, "Add-on is already attached")
+		}
+
+		// Every item of a subscription must share the plan's currency
+		priceID, ok := deps.Catalog.AddonPrice(addon, subscription.Currency)
+		if !ok {
+			return dto.SendError(c, fiber.StatusBadRequest, "Add-on is not sold in the subscription currency")
+		}
+
+		if _, err := deps.StripeClient.AddSubscriptionItem(subscription.StripeSubscriptionID, priceID, req.Quantity, req.ProrationBehavior); err != nil {
+			log.Printf("Error attaching add-on %s: %v", addon.Key, err)
+			return dto.SendError(c, fiber.StatusInternalServerError, "Error attaching add-on")
+		}
+
+		return respondSubscriptionItems(c, deps, subscription, fiber.StatusCreated)
+	}
+}
+
+// NewDetachAddonHandler creates a Fiber handler for removing an add-on from a
+// subscription. The proration_behavior query parameter works as when attaching.
+func NewDetachAddonHandler(deps *Dependencies) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get tenant from locals (set by middleware)
+		tenant := c.Locals("tenant").(string)
+
+		prorationBehavior := c.Query("proration_behavior", "create_prorations")
+		if !isValidProrationBehavior(prorationBehavior) {
+			return dto.SendError(c, fiber.StatusBadRequest, "proration_behavior must be one of create_prorations, always_invoice or none")
+		}
+
+		subscription, items, status, message := addonSubscription(deps, c.Params("userID"), tenant)
+		if subscription == nil {
+			return dto.SendError(c, status, message)
+		}
+
+		item := findAddonItem(items, c.Params("addon"))
+		if item == nil {
+			return dto.SendError(c, fiber.StatusNotFound, "Add-on is not attached")
+		}
+
+		if err := deps.StripeClient.DeleteSubscriptionItem(item.StripeSubscriptionItemID, prorationBehavior); err != nil {
+			log.Printf("Error detaching add-on %s: %v", c.Params("addon"), err)
+			return dto.SendError(c, fiber.StatusInternalServerError, "Error detaching add-on")
+		}
+
+		return respondSubscriptionItems(c, deps, subscription, fiber.StatusOK)
+	}
+}
+
+// addonSubscription returns a user's subscription and its stored items if
+// add-ons can be changed on it. On failure it returns a nil subscription with
+// the response status and message.
+func addonSubscription(deps *Dependencies, userID, tenant string) (*models.Subscription, []*models.SubscriptionItem, int, string) {
+	subscription, err := deps.SubRepo.GetByUserID(userID, tenant)
+	if err != nil {
+		return nil, nil, fiber.StatusInternalServerError, "Error fetching subscription"
+	}
+
+	if subscription == nil {
+		return nil, nil, fiber.StatusNotFound, "Subscription not found"
+	}
+
+	if subscription.Status == models.StatusCanceled {
+		return nil, nil, fiber.StatusBadRequest, "Subscription is canceled"
+	}
+
+	items, err := deps.SubscriptionItemRepo.GetBySubscriptionID(subscription.ID)
+	if err != nil {
+		return nil, nil, fiber.StatusInternalServerError, "Error fetching subscription items"
+	}
+
+	return subscription, items, 0, ""
+}
+
+// respondSubscriptionItems refreshes the stored items of a subscription from
+// Stripe after a change and returns them. customer.subscription.updated
+// brings the same change; syncing now lets the caller see it immediately.
+func respondSubscriptionItems(c *fiber.Ctx, deps *Dependencies, subscription *models.Subscription, status int) error {
+	stripeSub, err := deps.StripeClient.GetSubscription(subscription.StripeSubscriptionID)
+	if err != nil {
+		log.Printf("Error fetching subscription %s: %v", subscription.StripeSubscriptionID, err)
+		return dto.SendError(c, fiber.StatusInternalServerError, "Error fetching subscription")
+	}
+
+	items, err := syncSubscriptionItems(deps, subscription.ID, stripeSub)
+	if err != nil {
+		log.Printf("Error syncing subscription items: %v", err)
+		return dto.SendError(c, fiber.StatusInternalServerError, "Error updating subscription items")
+	}
+
+	return dto.SendSuccess(c, status, dto.SubscriptionItemsResponse{
+		SubscriptionID: subscription.StripeSubscriptionID,
+		Items:          items,
+	})
+}
+
+// syncSubscriptionItems stores every item of a Stripe subscription, labeled
+// as the plan, a catalog add-on or a metered price
+func syncSubscriptionItems(deps *Dependencies, subscriptionID int, sub *stripe.Subscription) ([]*models.SubscriptionItem, error) {
+	items := []*models.SubscriptionItem{}
+	if sub.Items != nil {
+		for _, stripeItem := range sub.Items.Data {
+			if stripeItem.Price == nil {
+				continue
+			}
+
+			item := &models.SubscriptionItem{
+				StripeSubscriptionItemID: stripeItem.ID,
+				StripePriceID:            stripeItem.Price.ID,
+				Kind:                     models.SubscriptionItemKindOther,
+				Quantity:                 stripeItem.Quantity,
+			}
+
+			if _, _, ok := deps.Catalog.PlanForPrice(stripeItem.Price.ID); ok {
+				item.Kind = models.SubscriptionItemKindPlan
+			} else if addon, ok := deps.Catalog.AddonForPrice(stripeItem.Price.ID); ok {
+				item.Kind = models.SubscriptionItemKindAddon
+				item.Addon = &addon.Key
+			} else if stripeItem.Price.Recurring != nil && stripeItem.Price.Recurring.UsageType == stripe.PriceRecurringUsageTypeMetered {
+				item.Kind = models.SubscriptionItemKindMetered
+			}
+
+			items = append(items, item)
+		}
+	}
+
+	if err := deps.SubscriptionItemRepo.Sync(subscriptionID, items); err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+func findAddonItem(items []*models.SubscriptionItem, addon string) *models.SubscriptionItem {
+	for _, item := range items {
+		if item.Kind == models.SubscriptionItemKindAddon && item.Addon != nil && *item.Addon == addon {
+			return item
+		}
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/naventro/payment-service/internal/analytics"
+	"github.com/naventro/payment-service/internal/api/dto"
+	"github.com/naventro/payment-service/internal/models"
+)
+
+// NewAnalyticsHandler creates a Fiber handler for the MRR, churn and
+// retention report. The period defaults to the last twelve calendar months
+// up to now.
+func NewAnalyticsHandler(deps *Dependencies) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		now := time.Now().UTC()
+		query := analytics.Query{
+			Tenant:   c.Query("tenant"),
+			Plan:     models.Plan(c.Query("plan")),
+			From:     time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, -11, 0),
+			To:       now,
+			Currency: c.Query("currency"),
+		}
+
+		if query.Plan != "" && !query.Plan.IsValid() {
+			return dto.SendError(c, fiber.StatusBadRequest, "Invalid plan")
+		}
+
+		for param, target := range map[string]*time.Time{"from": &query.From, "to": &query.To} {
+			value := c.Query(param)
+			if value == "" {
+				continue
+			}
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return dto.SendError(c, fiber.StatusBadRequest, param+" must be an RFC 3339 timestamp")
+			}
+			*target = t
+		}
+
+		if !query.From.Before(query.To) {
+			return dto.SendError(c, fiber.StatusBadRequest, "from must be before to")
+		}
+
+		if !deps.Analytics.HasRate(query.Currency) {
+			return dto.SendError(c, fiber.StatusBadRequest, "No exchange rate configured for "+query.Currency)
+		}
+
+		report, err := deps.Analytics.Report(query)
+		if err != nil {
+			log.Printf("Error computing analytics: %v", err)
+			return dto.SendError(c, fiber.StatusInternalServerError, "Error computing analytics")
+		}
+
+		return dto.SendSuccess(c, fiber.StatusOK, report)
+	}
+}
@@ -1,8 +1,14 @@
 package handlers
 
 import (
+	"log"
+
 	"github.com/gofiber/fiber/v2"
 	"github.com/naventro/payment-service/internal/api/dto"
+	"github.com/naventro/payment-service/internal/catalog"
+	"github.com/naventro/payment-service/internal/models"
+	stripeclient "github.com/naventro/payment-service/internal/stripe"
+	"github.com/stripe/stripe-go/v84"
 )
 
 // NewCheckoutHandler creates a Fiber handler for creating checkout sessions
@@ -21,29 +27,205 @@ func NewCheckoutHandler(deps *Dependencies) fiber.Handler {
 			return dto.SendError(c, fiber.StatusBadRequest, "user_id is required")
 		}
 
-		if !req.Plan.IsValid() {
+		// A checkout buys either a subscription plan or a catalog product
+		var product catalog.Product
+		if req.Product != "" {
+			if req.Plan != "" {
+				return dto.SendError(c, fiber.StatusBadRequest, "Only one of plan or product can be set")
+			}
+
+			var ok bool
+			product, ok = deps.Catalog.Get(req.Product)
+			if !ok {
+				return dto.SendError(c, fiber.StatusBadRequest, "Invalid product")
+			}
+		} else if !req.Plan.IsValid() {
 			return dto.SendError(c, fiber.StatusBadRequest, "Invalid plan")
 		}
 
+		// Plans are billed per seat; products are always bought once
+		if req.Quantity == 0 {
+			req.Quantity = 1
+		}
+		if req.Quantity < 1 {
+			return dto.SendError(c, fiber.StatusBadRequest, "quantity must be at least 1")
+		}
+		if product.Key != "" && req.Quantity != 1 {
+			return dto.SendError(c, fiber.StatusBadRequest, "quantity is only supported for plans")
+		}
+
 		if req.SuccessURL == "" || req.CancelURL == "" {
 			return dto.SendError(c, fiber.StatusBadRequest, "success_url and cancel_url are required")
 		}
 
+		if req.Locale != "" && !stripeclient.IsCheckoutLocale(req.Locale) {
+			return dto.SendError(c, fiber.StatusBadRequest, "Unsupported locale")
+		}
+
+		// Plans are priced per currency; products have a single price
+		var currency, priceID string
+		if product.Key == "" {
+			var err error
+			currency, err = deps.Catalog.ResolveCurrency(req.Plan, req.Currency, req.Country)
+			if err != nil {
+				return dto.SendError(c, fiber.StatusBadRequest, err.Error())
+			}
+
+			var ok bool
+			priceID, ok = deps.Catalog.PlanPrice(req.Plan, currency)
+			if !ok {
+				log.Printf("Plan %s has no price in the catalog currency %s", req.Plan, currency)
+				return dto.SendError(c, fiber.StatusInternalServerError, "Plan has no price configured")
+			}
+		} else if req.Currency != "" {
+			return dto.SendError(c, fiber.StatusBadRequest, "currency is only supported for plans")
+		}
+
+		// Resolve the Stripe customer from the local mapping, creating it under a DB lock
+		cust, err := deps.CustomerRepo.GetOrCreate(req.UserID, tenant, func() (*models.Customer, error) {
+			stripeCustomer, err := deps.StripeClient.FindOrCreateCustomer(req.UserID, tenant, req.Email, req.Name)
+			if err != nil {
+				return nil, err
+			}
+			return &models.Customer{
+				StripeCustomerID: stripeCustomer.ID,
+				Email:            stripeCustomer.Email,
+				Name:             stripeCustomer.Name,
+			}, nil
+		})
+		if err != nil {
+			return dto.SendError(c, fiber.StatusInternalServerError, "Error getting/creating customer: "+err.Error())
+		}
+
+		settings := deps.Tenants.Get(tenant)
+		params := stripeclient.CheckoutParams{
+			CustomerID: cust.StripeCustomerID,
+			UserID:     req.UserID,
+			Tenant:     tenant,
+			Locale:     req.Locale,
+			SuccessURL: req.SuccessURL,
+			CancelURL:  req.CancelURL,
+			Tax: stripeclient.TaxSettings{
+				AutomaticTax:             settings.AutomaticTax,
+				BillingAddressCollection: settings.BillingAddressCollection,
+				TaxIDCollection:          settings.TaxIDCollection,
+			},
+		}
+
 		// Create Stripe checkout session
-		session, err := deps.StripeClient.CreateCheckoutSession(
-			req.UserID,
-			tenant,
-			req.Plan,
-			req.SuccessURL,
-			req.CancelURL,
-		)
+		var session *stripe.CheckoutSession
+		if product.Key != "" {
+			params.Product = product.Key
+			params.PriceID = product.PriceID
+			session, err = deps.StripeClient.CreatePaymentCheckoutSession(params)
+		} else {
+			// Stripe requires every item of a subscription to share its
+			// currency, so meters not priced in it are left out
+			var meteredPrices []string
+			for _, meter := range deps.Catalog.PlanMeters(req.Plan) {
+				meterPrice, ok := deps.Catalog.MeterPrice(meter, currency)
+				if !ok {
+					log.Printf("Meter %s has no price in %s, not billing it", meter.Key, currency)
+					continue
+				}
+				meteredPrices = append(meteredPrices, meterPrice)
+			}
+
+			params.Plan = req.Plan
+			params.PriceID = priceID
+			params.Quantity = req.Quantity
+			params.MeteredPriceIDs = meteredPrices
+			session, err = deps.StripeClient.CreateCheckoutSession(params)
+		}
 		if err != nil {
 			return dto.SendError(c, fiber.StatusInternalServerError, "Error creating checkout session: "+err.Error())
 		}
 
+		// Record the checkout attempt; the webhook recreates it from metadata if this fails
+		checkoutSession := &models.CheckoutSession{
+			StripeSessionID:  session.ID,
+			UserID:           req.UserID,
+			Tenant:           tenant,
+			Plan:             req.Plan,
+			StripeCustomerID: &cust.StripeCustomerID,
+			SuccessURL:       req.SuccessURL,
+			CancelURL:        req.CancelURL,
+			Status:           models.CheckoutSessionStatusOpen,
+		}
+		if product.Key != "" {
+			checkoutSession.Product = &product.Key
+		}
+		if err := deps.CheckoutSessionRepo.Create(checkoutSession); err != nil {
+			log.Printf("Error saving checkout session: %v", err)
+		}
+
 		response := dto.CheckoutResponse{
 			SessionID:  session.ID,
 			SessionURL: session.URL,
+			Currency:   currency,
+		}
+
+		return dto.SendSuccess(c, fiber.StatusOK, response)
+	}
+}
+
+// NewCheckoutStatusHandler creates a Fiber handler for polling the provisioning status of a checkout session
+func NewCheckoutStatusHandler(deps *Dependencies) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get tenant from locals (set by middleware)
+		tenant := c.Locals("tenant").(string)
+
+		sessionID := c.Params("sessionID")
+		if sessionID == "" {
+			return dto.SendError(c, fiber.StatusBadRequest, "Session ID is required")
+		}
+
+		session, err := deps.CheckoutSessionRepo.GetByStripeSessionID(sessionID)
+		if err != nil {
+			return dto.SendError(c, fiber.StatusInternalServerError, "Error fetching checkout session")
+		}
+
+		if session == nil || session.Tenant != tenant {
+			return dto.SendError(c, fiber.StatusNotFound, "Checkout session not found")
+		}
+
+		response := dto.CheckoutSessionResponse{
+			SessionID:   session.StripeSessionID,
+			UserID:      session.UserID,
+			Plan:        session.Plan,
+			Product:     stringValue(session.Product),
+			Status:      session.Status,
+			CreatedAt:   session.CreatedAt,
+			CompletedAt: session.CompletedAt,
+			ExpiredAt:   session.ExpiredAt,
+		}
+
+		// The session is provisioned once the resulting subscription has been stored
+		if session.StripeSubscriptionID != nil {
+			response.SubscriptionID = *session.StripeSubscriptionID
+
+			subscription, err := deps.SubRepo.GetByStripeSubscriptionID(*session.StripeSubscriptionID)
+			if err != nil {
+				return dto.SendError(c, fiber.StatusInternalServerError, "Error fetching subscription")
+			}
+
+			if subscription != nil {
+				response.Provisioned = true
+				response.SubscriptionStatus = subscription.Status
+			}
+		}
+
+		// One-time purchases are provisioned once the order is paid
+		if session.Product != nil {
+			order, err := deps.OrderRepo.GetByCheckoutSessionID(session.StripeSessionID)
+			if err != nil {
+				return dto.SendError(c, fiber.StatusInternalServerError, "Error fetching order")
+			}
+
+			if order != nil {
+				response.OrderStatus = order.Status
+				response.Provisioned = order.Status == models.OrderStatusPaid
+			}
 		}
 
 		return dto.SendSuccess(c, fiber.StatusOK, response)
@@ -1,19 +1,72 @@
 package handlers
 
 import (
+	"database/sql"
+
+	"github.com/naventro/payment-service/internal/analytics"
+	"github.com/naventro/payment-service/internal/catalog"
 	"github.com/naventro/payment-service/internal/config"
 	"github.com/naventro/payment-service/internal/database"
+	"github.com/naventro/payment-service/internal/export"
+	"github.com/naventro/payment-service/internal/invoicepdf"
+	"github.com/naventro/payment-service/internal/metrics"
+	"github.com/naventro/payment-service/internal/notify"
 	"github.com/naventro/payment-service/internal/repository"
 	"github.com/naventro/payment-service/internal/stripe"
+	"github.com/naventro/payment-service/internal/tenant"
 	"github.com/naventro/payment-service/internal/webhook"
 )
 
 // Dependencies contains all dependencies needed by handlers
 type Dependencies struct {
-	Config        *config.Config
-	DB            *database.DB
-	SubRepo       *repository.SubscriptionRepository
-	InvoiceRepo   *repository.InvoiceRepository
-	StripeClient  *stripe.Client
-	WebhookClient *webhook.Client
+	Config                  *config.Config
+	DB                      *database.DB
+	SubRepo                 *repository.SubscriptionRepository
+	InvoiceRepo             *repository.InvoiceRepository
+	CustomerRepo            *repository.CustomerRepository
+	CheckoutSessionRepo     *repository.CheckoutSessionRepository
+	EventRepo               *repository.EventRepository
+	SyncStateRepo           *repository.SyncStateRepository
+	DisputeRepo             *repository.DisputeRepository
+	RefundRepo              *repository.RefundRepository
+	OrderRepo               *repository.OrderRepository
+	UsageRepo               *repository.UsageRepository
+	SubscriptionItemRepo    *repository.SubscriptionItemRepository
+	RevenueRepo             *repository.RevenueRepository
+	SubscriptionHistoryRepo *repository.SubscriptionHistoryRepository
+	ExportRepo              *repository.ExportRepository
+	RevenueRecognitionRepo  *repository.RevenueRecognitionRepository
+	StripeClient            *stripe.Client
+	WebhookClient           *webhook.Client
+	Metrics                 *metrics.Registry
+	Tenants                 *tenant.Registry
+	Catalog                 *catalog.Catalog
+	Analytics               *analytics.Analytics
+	Exporter                *export.Exporter
+	InvoicePDFs             *invoicepdf.Store
+	Notifier                *notify.Notifier
+}
+
+// withTx returns a copy of deps whose repositories run inside tx and whose
+// backend notifications and emails are logged instead of sent
+func (d *Dependencies) withTx(tx *sql.Tx) *Dependencies {
+	txDeps := *d
+	txDeps.SubRepo = d.SubRepo.WithTx(tx)
+	txDeps.InvoiceRepo = d.InvoiceRepo.WithTx(tx)
+	txDeps.CustomerRepo = d.CustomerRepo.WithTx(tx)
+	txDeps.CheckoutSessionRepo = d.CheckoutSessionRepo.WithTx(tx)
+	txDeps.EventRepo = d.EventRepo.WithTx(tx)
+	txDeps.SyncStateRepo = d.SyncStateRepo.WithTx(tx)
+	txDeps.DisputeRepo = d.DisputeRepo.WithTx(tx)
+	txDeps.RefundRepo = d.RefundRepo.WithTx(tx)
+	txDeps.OrderRepo = d.OrderRepo.WithTx(tx)
+	txDeps.UsageRepo = d.UsageRepo.WithTx(tx)
+	txDeps.SubscriptionItemRepo = d.SubscriptionItemRepo.WithTx(tx)
+	txDeps.RevenueRepo = d.RevenueRepo.WithTx(tx)
+	txDeps.SubscriptionHistoryRepo = d.SubscriptionHistoryRepo.WithTx(tx)
+	txDeps.ExportRepo = d.ExportRepo.WithTx(tx)
+	txDeps.RevenueRecognitionRepo = d.RevenueRecognitionRepo.WithTx(tx)
+	txDeps.WebhookClient = d.WebhookClient.DryRun()
+	txDeps.Notifier = d.Notifier.DryRun()
+	return &txDeps
 }
@@ -0,0 +1,224 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/naventro/payment-service/internal/api/dto"
+	"github.com/naventro/payment-service/internal/models"
+	"github.com/naventro/payment-service/internal/repository"
+	"github.com/naventro/payment-service/internal/webhook"
+	"github.com/stripe/stripe-go/v84"
+)
+
+// NewListDisputesHandler creates a Fiber handler for listing disputes across tenants
+func NewListDisputesHandler(deps *Dependencies) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		filter := repository.DisputeFilter{
+			Tenant: c.Query("tenant"),
+			UserID: c.Query("user_id"),
+			Status: models.DisputeStatus(c.Query("status")),
+			Open:   c.QueryBool("open"),
+			Limit:  c.QueryInt("limit", defaultEventLimit),
+		}
+
+		if filter.Limit <= 0 || filter.Limit > maxEventLimit {
+			return dto.SendError(c, fiber.StatusBadRequest, "Invalid limit")
+		}
+
+		disputes, err := deps.DisputeRepo.List(filter)
+		if err != nil {
+			return dto.SendError(c, fiber.StatusInternalServerError, "Error fetching disputes")
+		}
+
+		if disputes == nil {
+			disputes = []*models.Dispute{}
+		}
+
+		return dto.SendSuccess(c, fiber.StatusOK, dto.DisputeListResponse{Disputes: disputes})
+	}
+}
+
+// NewGetDisputeHandler creates a Fiber handler for fetching a single dispute
+func NewGetDisputeHandler(deps *Dependencies) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		dispute, err := deps.DisputeRepo.GetByStripeDisputeID(c.Params("disputeID"))
+		if err != nil {
+			return dto.SendError(c, fiber.StatusInternalServerError, "Error fetching dispute")
+		}
+
+		if dispute == nil {
+			return dto.SendError(c, fiber.StatusNotFound, "Dispute not found")
+		}
+
+		return dto.SendSuccess(c, fiber.StatusOK, dispute)
+	}
+}
+
+// handleDispute records charge.dispute.created, updated and closed events
+func handleDispute(deps *Dependencies, event stripe.Event) error {
+	var d stripe.Dispute
+	if err := json.Unmarshal(event.Data.Raw, &d); err != nil {
+		return permanentf("error unmarshaling dispute: %w", err)
+	}
+
+	if d.Charge == nil {
+		return permanentf("dispute %s has no charge", d.ID)
+	}
+
+	dispute, err := deps.DisputeRepo.GetByStripeDisputeID(d.ID)
+	if err != nil {
+		return err
+	}
+
+	isNew := dispute == nil
+	previousStatus := models.DisputeStatus("")
+	if isNew {
+		dispute = &models.Dispute{
+			StripeDisputeID: d.ID,
+			StripeChargeID:  d.Charge.ID,
+			Currency:        string(d.Currency),
+		}
+		if d.PaymentIntent != nil {
+			dispute.StripePaymentIntentID = &d.PaymentIntent.ID
+		}
+	} else {
+		previousStatus = dispute.Status
+	}
+
+	dispute.Amount = d.Amount
+	dispute.Reason = string(d.Reason)
+	dispute.Status = models.DisputeStatus(d.Status)
+	if d.EvidenceDetails != nil && d.EvidenceDetails.DueBy > 0 {
+		dueBy := time.Unix(d.EvidenceDetails.DueBy, 0)
+		dispute.EvidenceDueBy = &dueBy
+	}
+	if dispute.Status.IsClosed() && dispute.ClosedAt == nil {
+		now := time.Now()
+		dispute.ClosedAt = &now
+	}
+
+	tenantKnown := dispute.Tenant != nil
+	if dispute.InvoiceID == nil && dispute.OrderID == nil {
+		if err := linkDispute(deps, dispute); err != nil {
+			return err
+		}
+	}
+
+	// The tenant policy applies once the tenant is known, which may be after
+	// the dispute opened when its invoice was stored late
+	linked := !tenantKnown && dispute.Tenant != nil
+	if linked && !dispute.Status.IsClosed() {
+		dispute.EntitlementsRevoked = deps.Tenants.Get(*dispute.Tenant).RevokeOnDispute
+	}
+
+	if isNew {
+		err = deps.DisputeRepo.Create(dispute)
+	} else {
+		err = deps.DisputeRepo.Update(dispute)
+	}
+	if err != nil {
+		return err
+	}
+
+	// A lost dispute takes back the payment of a one-time purchase, and with
+	// it the entitlement the purchase granted
+	if dispute.OrderID != nil && dispute.Status == models.DisputeStatusLost {
+		if _, err := deps.OrderRepo.UpdateStatus(*dispute.OrderID, models.OrderStatusDisputed); err != nil {
+			return err
+		}
+	}
+
+	// The backend hears of a dispute once it can be attributed to a user
+	if dispute.Status != previousStatus || linked {
+		notifyDispute(deps, event.ID, dispute, webhook.EventType(strings.TrimPrefix(string(event.Type), "charge.")))
+	}
+
+	log.Printf("Dispute %s recorded with status %s", d.ID, d.Status)
+	return nil
+}
+
+// linkDispute attaches the dispute to the invoice it was filed against, and
+// through it to the subscription and user, or to the one-time order whose
+// payment it disputes
+func linkDispute(deps *Dependencies, dispute *models.Dispute) error {
+	paymentIntentID := ""
+	if dispute.StripePaymentIntentID != nil {
+		paymentIntentID = *dispute.StripePaymentIntentID
+	}
+
+	if paymentIntentID != "" {
+		order, err := deps.OrderRepo.GetByPaymentIntentID(paymentIntentID)
+		if err != nil {
+			return err
+		}
+		if order != nil {
+			dispute.OrderID = &order.ID
+			dispute.UserID = &order.UserID
+			dispute.Tenant = &order.Tenant
+			return nil
+		}
+	}
+
+	invoice, err := findInvoiceForPayment(deps, paymentIntentID, dispute.StripeChargeID)
+	if err != nil {
+		return err
+	}
+
+	if invoice == nil {
+		log.Printf("Dispute %s does not match a known invoice or order", dispute.StripeDisputeID)
+		return nil
+	}
+
+	dispute.InvoiceID = &invoice.ID
+	dispute.SubscriptionID = &invoice.SubscriptionID
+	dispute.UserID = &invoice.UserID
+	dispute.Tenant = &invoice.Tenant
+	return nil
+}
+
+// notifyDispute tells the backend that a dispute was opened, changed or
+// closed; eventType is dispute.created, dispute.updated or dispute.closed
+func notifyDispute(deps *Dependencies, source string, dispute *models.Dispute, eventType webhook.EventType) {
+	if dispute.UserID == nil || dispute.Tenant == nil {
+		return
+	}
+
+	payload := webhook.DisputeData{
+		UserID:              *dispute.UserID,
+		DisputeID:           dispute.StripeDisputeID,
+		Amount:              dispute.Amount,
+		Currency:            dispute.Currency,
+		Reason:              dispute.Reason,
+		Status:              string(dispute.Status),
+		EvidenceDueBy:       dispute.EvidenceDueBy,
+		EntitlementsRevoked: dispute.BlocksEntitlements(),
+	}
+
+	if dispute.InvoiceID != nil {
+		if invoice, err := deps.InvoiceRepo.GetByID(*dispute.InvoiceID); err != nil {
+			log.Printf("Error fetching invoice for dispute notification: %v", err)
+		} else if invoice != nil {
+			payload.InvoiceID = invoice.StripeInvoiceID
+		}
+	}
+
+	if dispute.OrderID != nil {
+		payload.OrderID = stringValue(dispute.StripePaymentIntentID)
+	}
+
+	if dispute.SubscriptionID != nil {
+		if sub, err := deps.SubRepo.GetByID(*dispute.SubscriptionID); err != nil {
+			log.Printf("Error fetching subscription for dispute notification: %v", err)
+		} else if sub != nil {
+			payload.SubscriptionID = sub.StripeSubscriptionID
+		}
+	}
+
+	if err := deps.WebhookClient.Notify(webhook.NewEvent(source, eventType, *dispute.Tenant, payload)); err != nil {
+		log.Printf("Error notifying backend: %v", err)
+	}
+}
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"log"
+	"time"
+
+	"github.com/naventro/payment-service/internal/models"
+	"github.com/naventro/payment-service/internal/notify"
+)
+
+// sendEmail sends a lifecycle email to the customer of a subscription. A
+// failed email does not fail the event, so it is only logged.
+func sendEmail(deps *Dependencies, kind notify.Kind, sub *models.Subscription, data notify.Data) {
+	email := getCustomerEmail(deps, sub.StripeCustomerID)
+
+	cust, err := deps.CustomerRepo.GetByStripeCustomerID(sub.StripeCustomerID)
+	if err != nil {
+		log.Printf("Error fetching customer name: %v", err)
+	}
+	if cust != nil {
+		data.Name = cust.Name
+	}
+	data.Plan = planName(deps, sub.Plan)
+
+	if err := deps.Notifier.Send(kind, sub.Tenant, email, data); err != nil {
+		log.Printf("Error sending %s email to user %s: %v", kind, sub.UserID, err)
+	}
+}
+
+// planName returns the catalog name of a plan, or its key if it has none
+func planName(deps *Dependencies, key models.Plan) string {
+	if plan, ok := deps.Catalog.Plan(key); ok && plan.Name != "" {
+		return plan.Name
+	}
+	return string(key)
+}
+
+// linesPeriod returns the earliest start and latest end of the service
+// periods billed by an invoice's lines. Stripe's own invoice period is the
+// previous billing period on renewals.
+func linesPeriod(lines models.InvoiceLines) (start, end *time.Time) {
+	for _, line := range lines {
+		if line.PeriodStart != nil && (start == nil || line.PeriodStart.Before(*start)) {
+			start = line.PeriodStart
+		}
+		if line.PeriodEnd != nil && (end == nil || line.PeriodEnd.After(*end)) {
+			end = line.PeriodEnd
+		}
+	}
+	return start, end
+}
@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/naventro/payment-service/internal/api/dto"
+	"github.com/naventro/payment-service/internal/entitlements"
+)
+
+// NewEntitlementsHandler creates a Fiber handler for resolving what a user has access to
+func NewEntitlementsHandler(deps *Dependencies) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get tenant from locals (set by middleware)
+		tenant := c.Locals("tenant").(string)
+
+		userID := c.Params("userID")
+		if userID == "" {
+			return dto.SendError(c, fiber.StatusBadRequest, "User ID is required")
+		}
+
+		resolver := entitlements.New(deps.SubRepo, deps.SubscriptionItemRepo, deps.DisputeRepo, deps.OrderRepo)
+		result, err := resolver.Resolve(userID, tenant)
+		if err != nil {
+			return dto.SendError(c, fiber.StatusInternalServerError, "Error resolving entitlements")
+		}
+
+		return dto.SendSuccess(c, fiber.StatusOK, result)
+	}
+}
@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/stripe/stripe-go/v84"
+)
+
+// PermanentError marks an event processing failure that delivering the event
+// again cannot fix, such as a payload without the metadata needed to
+// attribute it. Permanent failures are recorded and acknowledged to Stripe.
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+// permanentf returns a PermanentError with a formatted message
+func permanentf(format string, args ...interface{}) error {
+	return &PermanentError{Err: fmt.Errorf(format, args...)}
+}
+
+// orderingWindow is how long an event referencing a record that does not
+// exist yet is retried. Stripe does not guarantee delivery order, so the event
+// creating the record may still be on its way.
+const orderingWindow = time.Hour
+
+// missingDependency returns the error for an event whose related record is
+// not stored: retryable while the event is recent, permanent afterwards so
+// that Stripe stops redelivering it
+func missingDependency(event stripe.Event, format string, args ...interface{}) error {
+	if time.Since(time.Unix(event.Created, 0)) < orderingWindow {
+		return fmt.Errorf(format, args...)
+	}
+	return permanentf(format, args...)
+}
+
+// IsRetryable reports whether processing an event may succeed if it is
+// delivered again. Failures are retryable unless marked permanent or caused
+// by data that will not change: database constraint or data errors and Stripe
+// client errors other than rate limiting. Unique violations are permanent as
+// well: repositories resolve the conflicts they expect, e.g. with ON CONFLICT,
+// so one reaching here would fail again on every redelivery. Connection
+// failures, timeouts and other database or network errors are retryable.
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var permanent *PermanentError
+	if errors.As(err, &permanent) {
+		return false
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code.Class() {
+		case "22", "23": // data exception, integrity constraint violation
+			return false
+		}
+		return true
+	}
+
+	var stripeErr *stripe.Error
+	if errors.As(err, &stripeErr) {
+		status := stripeErr.HTTPStatusCode
+		return status == 0 || status == 429 || status >= 500
+	}
+
+	return true
+}
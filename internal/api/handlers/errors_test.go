@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/stripe/stripe-go/v84"
+)
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"permanent", permanentf("missing metadata"), false},
+		{"wrapped permanent", fmt.Errorf("handling event: %w", permanentf("missing metadata")), false},
+		{"pq data exception", &pq.Error{Code: "22001"}, false},
+		{"pq foreign key violation", &pq.Error{Code: "23503"}, false},
+		{"pq not null violation", &pq.Error{Code: "23502"}, false},
+		{"pq unique violation", fmt.Errorf("error creating customer: %w", &pq.Error{Code: "23505"}), false},
+		{"pq connection failure", &pq.Error{Code: "08006"}, true},
+		{"pq serialization failure", &pq.Error{Code: "40001"}, true},
+		{"stripe bad request", &stripe.Error{HTTPStatusCode: 400}, false},
+		{"stripe not found", fmt.Errorf("error fetching invoice: %w", &stripe.Error{HTTPStatusCode: 404}), false},
+		{"stripe rate limited", &stripe.Error{HTTPStatusCode: 429}, true},
+		{"stripe server error", &stripe.Error{HTTPStatusCode: 500}, true},
+		{"stripe no response", &stripe.Error{HTTPStatusCode: 0}, true},
+		{"network error", fmt.Errorf("error notifying backend: %w", &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}), true},
+		{"plain error", errors.New("something went wrong"), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsRetryable(tt.err); got != tt.want {
+				t.Errorf("IsRetryable(%v) = %t, want %t", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMissingDependency(t *testing.T) {
+	tests := []struct {
+		name      string
+		age       time.Duration
+		retryable bool
+	}{
+		{"recent event", time.Minute, true},
+		{"just inside window", orderingWindow - time.Minute, true},
+		{"outside window", orderingWindow + time.Minute, false},
+		{"old event", 24 * time.Hour, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event := stripe.Event{Created: time.Now().Add(-tt.age).Unix()}
+			err := missingDependency(event, "subscription %s not found", "sub_123")
+
+			if err == nil {
+				t.Fatal("missingDependency returned nil")
+			}
+			if err.Error() != "subscription sub_123 not found" {
+				t.Errorf("error = %q", err)
+			}
+			if got := IsRetryable(err); got != tt.retryable {
+				t.Errorf("IsRetryable = %t, want %t", got, tt.retryable)
+			}
+		})
+	}
+}
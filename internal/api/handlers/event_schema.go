@@ -0,0 +1,15 @@
+package handlers
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/naventro/payment-service/internal/webhook"
+)
+
+// NewEventSchemaHandler creates a Fiber handler that serves the JSON Schema
+// of the events sent to the backend
+func NewEventSchemaHandler(deps *Dependencies) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		c.Set(fiber.HeaderContentType, "application/schema+json")
+		return c.Send(webhook.EventSchema)
+	}
+}
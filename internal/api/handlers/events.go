@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/naventro/payment-service/internal/api/dto"
+	"github.com/naventro/payment-service/internal/models"
+	"github.com/naventro/payment-service/internal/repository"
+	"github.com/stripe/stripe-go/v84"
+)
+
+const (
+	defaultEventLimit = 100
+	maxEventLimit     = 1000
+)
+
+// NewListEventsHandler creates a Fiber handler for listing stored Stripe events
+func NewListEventsHandler(deps *Dependencies) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		filter := repository.EventFilter{
+			Type:   c.Query("type"),
+			Status: models.EventStatus(c.Query("status")),
+			Limit:  c.QueryInt("limit", defaultEventLimit),
+		}
+
+		if !isValidEventStatus(filter.Status) {
+			return dto.SendError(c, fiber.StatusBadRequest, "Invalid status")
+		}
+
+		if filter.Limit <= 0 || filter.Limit > maxEventLimit {
+			return dto.SendError(c, fiber.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxEventLimit))
+		}
+
+		for param, target := range map[string]**time.Time{"from": &filter.From, "to": &filter.To} {
+			value := c.Query(param)
+			if value == "" {
+				continue
+			}
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return dto.SendError(c, fiber.StatusBadRequest, param+" must be an RFC 3339 timestamp")
+			}
+			*target = &t
+		}
+
+		events, err := deps.EventRepo.List(filter)
+		if err != nil {
+			return dto.SendError(c, fiber.StatusInternalServerError, "Error fetching events")
+		}
+
+		if events == nil {
+			events = []*models.StripeEvent{}
+		}
+
+		return dto.SendSuccess(c, fiber.StatusOK, dto.EventListResponse{Events: events})
+	}
+}
+
+// NewGetEventHandler creates a Fiber handler for fetching a stored Stripe event with its payload
+func NewGetEventHandler(deps *Dependencies) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		event, err := deps.EventRepo.GetByStripeEventID(c.Params("eventID"))
+		if err != nil {
+			return dto.SendError(c, fiber.StatusInternalServerError, "Error fetching event")
+		}
+
+		if event == nil {
+			return dto.SendError(c, fiber.StatusNotFound, "Event not found")
+		}
+
+		return dto.SendSuccess(c, fiber.StatusOK, event)
+	}
+}
+
+// NewReplayEventsHandler creates a Fiber handler for re-running stored Stripe
+// events through the webhook handlers
+func NewReplayEventsHandler(deps *Dependencies) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req dto.EventReplayRequest
+		if err := c.BodyParser(&req); err != nil {
+			return dto.SendError(c, fiber.StatusBadRequest, "Invalid request body")
+		}
+
+		if !isValidEventStatus(req.Status) {
+			return dto.SendError(c, fiber.StatusBadRequest, "Invalid status")
+		}
+
+		var events []*models.StripeEvent
+		if len(req.EventIDs) > 0 {
+			for _, id := range req.EventIDs {
+				event, err := deps.EventRepo.GetByStripeEventID(id)
+				if err != nil {
+					return dto.SendError(c, fiber.StatusInternalServerError, "Error fetching event")
+				}
+				if event == nil {
+					return dto.SendError(c, fiber.StatusNotFound, "Event not found: "+id)
+				}
+				events = append(events, event)
+			}
+		} else {
+			// Refuse to replay the whole table by accident
+			if req.Type == "" && req.Status == "" && req.From == nil && req.To == nil {
+				return dto.SendError(c, fiber.StatusBadRequest, "event_ids or a filter is required")
+			}
+
+			if req.Limit == 0 {
+				req.Limit = defaultEventLimit
+			}
+			if req.Limit < 0 || req.Limit > maxEventLimit {
+				return dto.SendError(c, fiber.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxEventLimit))
+			}
+
+			listed, err := deps.EventRepo.List(repository.EventFilter{
+				Type:   req.Type,
+				Status: req.Status,
+				From:   req.From,
+				To:     req.To,
+				Limit:  req.Limit,
+			})
+			if err != nil {
+				return dto.SendError(c, fiber.StatusInternalServerError, "Error fetching events")
+			}
+
+			// Listed newest first; replay in the order the events were received
+			for i := len(listed) - 1; i >= 0; i-- {
+				event, err := deps.EventRepo.GetByStripeEventID(listed[i].StripeEventID)
+				if err != nil {
+					return dto.SendError(c, fiber.StatusInternalServerError, "Error fetching event")
+				}
+				events = append(events, event)
+			}
+		}
+
+		response := dto.EventReplayResponse{Results: make([]dto.EventReplayResult, 0, len(events))}
+		for _, event := range events {
+			response.Results = append(response.Results, ReplayEvent(deps, event, req.DryRun))
+		}
+
+		return dto.SendSuccess(c, fiber.StatusOK, response)
+	}
+}
+
+// ReplayEvent re-runs a stored event through the webhook handlers. A dry run
+// processes the event inside a transaction that is rolled back, reports the
+// rows it would have changed and sends no backend notifications; otherwise
+// the outcome is recorded on the stored event.
+func ReplayEvent(deps *Dependencies, stored *models.StripeEvent, dryRun bool) dto.EventReplayResult {
+	result := dto.EventReplayResult{
+		EventID: stored.StripeEventID,
+		Type:    stored.Type,
+		DryRun:  dryRun,
+	}
+
+	var event stripe.Event
+	if err := json.Unmarshal(stored.Payload, &event); err != nil {
+		result.Error = fmt.Sprintf("error decoding stored event: %v", err)
+		return result
+	}
+
+	log.Printf("Replaying Stripe event %s (%s, dry run: %t)", event.ID, event.Type, dryRun)
+
+	var processErr error
+	if dryRun {
+		changes, err := repository.DryRun(deps.DB.DB, func(tx *sql.Tx) error {
+			processErr = processEvent(deps.withTx(tx), event)
+			return processErr
+		})
+		if processErr == nil && err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		result.Changes = changes
+	} else {
+		processErr = processEvent(deps, event)
+		if err := recordEventResult(deps, stored, processErr); err != nil {
+			log.Printf("Error replaying event %s: %v", stored.StripeEventID, err)
+		}
+	}
+
+	result.Status = models.EventStatusProcessed
+	if processErr != nil {
+		result.Status = models.EventStatusFailed
+		result.Error = processErr.Error()
+	}
+
+	return result
+}
+
+func isValidEventStatus(status models.EventStatus) bool {
+	switch status {
+	case "", models.EventStatusReceived, models.EventStatusProcessed, models.EventStatusFailed:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/naventro/payment-service/internal/api/dto"
+	"github.com/naventro/payment-service/internal/export"
+	"github.com/naventro/payment-service/internal/repository"
+)
+
+// NewExportHandler creates a Fiber handler that streams an accounting export.
+// The response is written while the records are read, so an error part of
+// the way can only be logged and leaves the download truncated.
+func NewExportHandler(deps *Dependencies) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		opts := export.Options{}
+
+		var err error
+		if opts.Dataset, err = export.ParseDataset(c.Params("dataset")); err != nil {
+			return dto.SendError(c, fiber.StatusNotFound, err.Error())
+		}
+		if opts.Format, err = export.ParseFormat(c.Query("format", string(export.FormatCSV))); err != nil {
+			return dto.SendError(c, fiber.StatusBadRequest, err.Error())
+		}
+
+		from, to, err := export.ResolvePeriod(c.Query("month"), c.Query("from"), c.Query("to"), time.Now())
+		if err != nil {
+			return dto.SendError(c, fiber.StatusBadRequest, err.Error())
+		}
+		opts.Filter = repository.ExportFilter{Tenant: c.Query("tenant"), From: from, To: to}
+
+		if c.QueryBool("fetch_fees") {
+			if _, err := deps.Exporter.BackfillFees(opts.Filter); err != nil {
+				log.Printf("Error fetching fees: %v", err)
+				return dto.SendError(c, fiber.StatusInternalServerError, "Error fetching fees")
+			}
+		}
+
+		c.Set(fiber.HeaderContentType, opts.Format.ContentType())
+		c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="%s"`, export.Filename(opts)))
+
+		c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+			count, err := deps.Exporter.Export(w, opts)
+			if err != nil {
+				log.Printf("Error exporting %s after %d records: %v", opts.Dataset, count, err)
+			}
+			w.Flush()
+		})
+
+		return nil
+	}
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/naventro/payment-service/internal/api/dto"
+	"github.com/naventro/payment-service/internal/invoicepdf"
+	"github.com/naventro/payment-service/internal/models"
+)
+
+// NewInvoicePDFHandler creates a Fiber handler that serves the PDF of a paid
+// invoice, rendering it with the tenant's branding if it is not stored or
+// regenerate=true is given
+func NewInvoicePDFHandler(deps *Dependencies) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get tenant from locals (set by middleware)
+		tenant := c.Locals("tenant").(string)
+
+		invoice, err := deps.InvoiceRepo.GetByStripeInvoiceID(c.Params("invoiceID"))
+		if err != nil {
+			return dto.SendError(c, fiber.StatusInternalServerError, "Error fetching invoice")
+		}
+
+		if invoice == nil || invoice.Tenant != tenant {
+			return dto.SendError(c, fiber.StatusNotFound, "Invoice not found")
+		}
+
+		if invoice.Status != models.InvoiceStatusPaid {
+			return dto.SendError(c, fiber.StatusBadRequest, "Only paid invoices have a PDF")
+		}
+
+		// Invoices paid before numbering was enabled are numbered in payment
+		// order by the "number" command, not on download
+		if invoice.Number == nil {
+			return dto.SendError(c, fiber.StatusConflict, "Invoice has no number yet")
+		}
+
+		data, found, err := deps.InvoicePDFs.Load(tenant, invoice.StripeInvoiceID)
+		if err != nil {
+			log.Printf("Error loading PDF of invoice %s: %v", invoice.StripeInvoiceID, err)
+		}
+
+		if !found || c.QueryBool("regenerate") {
+			data, err = renderInvoicePDF(deps, invoice)
+			if err != nil {
+				log.Printf("Error rendering PDF of invoice %s: %v", invoice.StripeInvoiceID, err)
+				return dto.SendError(c, fiber.StatusInternalServerError, "Error rendering invoice PDF")
+			}
+
+			if err := deps.InvoicePDFs.Save(tenant, invoice.StripeInvoiceID, data); err != nil {
+				log.Printf("Error storing PDF of invoice %s: %v", invoice.StripeInvoiceID, err)
+			}
+		}
+
+		c.Set(fiber.HeaderContentType, "application/pdf")
+		c.Set(fiber.HeaderContentDisposition, fmt.Sprintf("inline; filename=%q", *invoice.Number+".pdf"))
+		return c.Send(data)
+	}
+}
+
+// renderInvoicePDF renders a stored invoice with the settings of its tenant
+func renderInvoicePDF(deps *Dependencies, invoice *models.Invoice) ([]byte, error) {
+	// Invoices stored without lines are shown as a single line for the plan
+	description, quantity := "Subscription", int64(1)
+	if len(invoice.Lines) == 0 {
+		sub, err := deps.SubRepo.GetByID(invoice.SubscriptionID)
+		if err != nil {
+			return nil, err
+		}
+		if sub != nil {
+			description = planName(deps, sub.Plan)
+			if sub.Quantity > 0 {
+				quantity = sub.Quantity
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	doc := invoicepdf.FromInvoice(invoice, description, quantity)
+	if err := invoicepdf.Render(&buf, doc, deps.Tenants.Get(invoice.Tenant).Invoice); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// assignInvoiceNumber gives a paid invoice the next number of its tenant's
+// sequence for the date it was paid, unless it already has one, and stores it.
+// Numbers follow the order of payment, so while invoices of the tenant paid
+// earlier are still unnumbered the invoice is left for the number command.
+func assignInvoiceNumber(deps *Dependencies, invoice *models.Invoice, paidAt time.Time) error {
+	pending, err := deps.InvoiceRepo.HasUnnumberedPaidBefore(invoice.Tenant, invoice.ID, paidAt)
+	if err != nil {
+		return err
+	}
+	if pending {
+		log.Printf("Invoice %s left unnumbered: tenant %s has older invoices without a number, run the number command", invoice.StripeInvoiceID, invoice.Tenant)
+		return nil
+	}
+
+	settings := deps.Tenants.Get(invoice.Tenant).Invoice
+	series := settings.Series(paidAt.UTC())
+
+	number, err := deps.InvoiceRepo.AssignNumber(invoice.ID, invoice.Tenant, series, func(n int64) string {
+		return settings.FormatNumber(series, n)
+	})
+	if err != nil {
+		return err
+	}
+
+	invoice.Number = &number
+	return nil
+}
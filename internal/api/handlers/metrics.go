@@ -0,0 +1,13 @@
+package handlers
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/naventro/payment-service/internal/api/dto"
+)
+
+// NewMetricsHandler creates a Fiber handler for reading the service counters
+func NewMetricsHandler(deps *Dependencies) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		return dto.SendSuccess(c, fiber.StatusOK, dto.MetricsResponse{Counters: deps.Metrics.Counters()})
+	}
+}
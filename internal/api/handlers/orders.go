@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/naventro/payment-service/internal/api/dto"
+	"github.com/naventro/payment-service/internal/models"
+	"github.com/naventro/payment-service/internal/webhook"
+	"github.com/stripe/stripe-go/v84"
+)
+
+// NewOrdersHandler creates a Fiber handler for a user's one-time purchase history
+func NewOrdersHandler(deps *Dependencies) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get tenant from locals (set by middleware)
+		tenant := c.Locals("tenant").(string)
+
+		userID := c.Params("userID")
+		if userID == "" {
+			return dto.SendError(c, fiber.StatusBadRequest, "User ID is required")
+		}
+
+		orders, err := deps.OrderRepo.GetByUserID(userID, tenant)
+		if err != nil {
+			return dto.SendError(c, fiber.StatusInternalServerError, "Error fetching orders")
+		}
+
+		if orders == nil {
+			orders = []*models.Order{}
+		}
+
+		return dto.SendSuccess(c, fiber.StatusOK, dto.OrderListResponse{Orders: orders})
+	}
+}
+
+// NewPlansHandler creates a Fiber handler for listing the plans with their price per currency
+func NewPlansHandler(deps *Dependencies) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		return dto.SendSuccess(c, fiber.StatusOK, dto.PlanListResponse{
+			Currency: deps.Catalog.Currency(),
+			Plans:    deps.Catalog.Plans(),
+		})
+	}
+}
+
+// NewProductsHandler creates a Fiber handler for listing the catalog products
+func NewProductsHandler(deps *Dependencies) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		return dto.SendSuccess(c, fiber.StatusOK, dto.ProductListResponse{Products: deps.Catalog.Products()})
+	}
+}
+
+// recordCheckoutOrder records the order of a completed payment mode checkout.
+// Payment methods that settle later leave the order pending until
+// payment_intent.succeeded.
+func recordCheckoutOrder(deps *Dependencies, event stripe.Event, session *stripe.CheckoutSession, checkoutSession *models.CheckoutSession) error {
+	if checkoutSession.Product == nil {
+		log.Printf("Checkout session %s has no product, skipping order", session.ID)
+		return nil
+	}
+
+	if session.PaymentIntent == nil {
+		log.Printf("Checkout session %s has no payment, skipping order", session.ID)
+		return nil
+	}
+
+	order := &models.Order{
+		StripePaymentIntentID:   session.PaymentIntent.ID,
+		StripeCheckoutSessionID: &session.ID,
+		StripeCustomerID:        checkoutSession.StripeCustomerID,
+		UserID:                  checkoutSession.UserID,
+		Tenant:                  checkoutSession.Tenant,
+		Product:                 *checkoutSession.Product,
+		Amount:                  session.AmountTotal,
+		Currency:                string(session.Currency),
+		Status:                  models.OrderStatusPending,
+	}
+	if session.TotalDetails != nil {
+		order.Tax = session.TotalDetails.AmountTax
+	}
+	if session.PaymentStatus == stripe.CheckoutSessionPaymentStatusPaid {
+		order.Status = models.OrderStatusPaid
+	}
+
+	return recordOrder(deps, event.ID, order)
+}
+
+// handlePaymentIntentSucceeded marks the order of a one-time purchase paid.
+// PaymentIntents without a product in their metadata, such as the ones paying
+// subscription invoices, are ignored.
+func handlePaymentIntentSucceeded(deps *Dependencies, event stripe.Event) error {
+	var pi stripe.PaymentIntent
+	if err := json.Unmarshal(event.Data.Raw, &pi); err != nil {
+		return permanentf("error unmarshaling payment intent: %w", err)
+	}
+
+	product := pi.Metadata["product"]
+	if product == "" {
+		return nil
+	}
+
+	userID, tenant := pi.Metadata["user_id"], pi.Metadata["tenant"]
+	if userID == "" || tenant == "" {
+		return permanentf("payment intent %s is missing metadata", pi.ID)
+	}
+
+	order := &models.Order{
+		StripePaymentIntentID: pi.ID,
+		UserID:                userID,
+		Tenant:                tenant,
+		Product:               product,
+		Amount:                pi.AmountReceived,
+		Currency:              string(pi.Currency),
+		Status:                models.OrderStatusPaid,
+	}
+	if pi.Customer != nil {
+		order.StripeCustomerID = &pi.Customer.ID
+	}
+
+	return recordOrder(deps, event.ID, order)
+}
+
+// recordOrder stores an order or merges it into the stored one, since the
+// checkout and payment events can arrive in either order and often together.
+// The backend is notified when the order becomes paid, with an event derived
+// from source, the ID of the Stripe event being processed.
+func recordOrder(deps *Dependencies, source string, order *models.Order) error {
+	if order.Status == models.OrderStatusPaid {
+		now := time.Now()
+		order.PaidAt = &now
+	}
+
+	// The entitlement is fixed when the order is first stored so later
+	// catalog changes do not take away what was bought
+	if product, ok := deps.Catalog.Get(order.Product); !ok {
+		log.Printf("Order %s is for unknown product %s", order.StripePaymentIntentID, order.Product)
+	} else if product.Lifetime {
+		order.Entitlement = &product.Key
+	}
+
+	becamePaid, err := deps.OrderRepo.Save(order)
+	if err != nil {
+		return err
+	}
+
+	if becamePaid {
+		notifyOrder(deps, source, order)
+	}
+
+	log.Printf("Order %s recorded with status %s", order.StripePaymentIntentID, order.Status)
+	return nil
+}
+
+func notifyOrder(deps *Dependencies, source string, order *models.Order) {
+	payload := webhook.OrderData{
+		UserID:      order.UserID,
+		OrderID:     order.StripePaymentIntentID,
+		Product:     order.Product,
+		Amount:      order.Amount,
+		Currency:    order.Currency,
+		Status:      string(order.Status),
+		Entitlement: stringValue(order.Entitlement),
+	}
+
+	if err := deps.WebhookClient.Notify(webhook.NewEvent(source, webhook.EventOrderPaid, order.Tenant, payload)); err != nil {
+		log.Printf("Error notifying backend: %v", err)
+	}
+}
@@ -0,0 +1,338 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/naventro/payment-service/internal/api/dto"
+	"github.com/naventro/payment-service/internal/models"
+	"github.com/naventro/payment-service/internal/webhook"
+	"github.com/stripe/stripe-go/v84"
+)
+
+// NewListPaymentMethodsHandler creates a Fiber handler for listing a user's saved cards
+func NewListPaymentMethodsHandler(deps *Dependencies) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get tenant from locals (set by middleware)
+		tenant := c.Locals("tenant").(string)
+
+		customerID, sub, err := resolveCustomer(deps, c.Params("userID"), tenant)
+		if err != nil {
+			return dto.SendError(c, fiber.StatusInternalServerError, "Error fetching customer")
+		}
+
+		if customerID == "" {
+			return dto.SendError(c, fiber.StatusNotFound, "Customer not found")
+		}
+
+		methods, err := deps.StripeClient.ListPaymentMethods(customerID)
+		if err != nil {
+			log.Printf("Error listing payment methods: %v", err)
+			return dto.SendError(c, fiber.StatusInternalServerError, "Error listing payment methods")
+		}
+
+		defaultID, err := defaultPaymentMethodID(deps, customerID, sub)
+		if err != nil {
+			log.Printf("Error fetching default payment method: %v", err)
+			return dto.SendError(c, fiber.StatusInternalServerError, "Error listing payment methods")
+		}
+
+		response := dto.PaymentMethodListResponse{PaymentMethods: []dto.PaymentMethod{}}
+		for _, pm := range methods {
+			method := paymentMethodResponse(pm)
+			method.IsDefault = pm.ID == defaultID
+			response.PaymentMethods = append(response.PaymentMethods, method)
+		}
+
+		return dto.SendSuccess(c, fiber.StatusOK, response)
+	}
+}
+
+// NewSetupIntentHandler creates a Fiber handler for starting to save a new card
+func NewSetupIntentHandler(deps *Dependencies) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get tenant from locals (set by middleware)
+		tenant := c.Locals("tenant").(string)
+		userID := c.Params("userID")
+
+		customerID, _, err := resolveCustomer(deps, userID, tenant)
+		if err != nil {
+			return dto.SendError(c, fiber.StatusInternalServerError, "Error fetching customer")
+		}
+
+		if customerID == "" {
+			return dto.SendError(c, fiber.StatusNotFound, "Customer not found")
+		}
+
+		si, err := deps.StripeClient.CreateSetupIntent(customerID, userID, tenant)
+		if err != nil {
+			log.Printf("Error creating setup intent: %v", err)
+			return dto.SendError(c, fiber.StatusInternalServerError, "Error creating setup intent")
+		}
+
+		return dto.SendSuccess(c, fiber.StatusCreated, dto.SetupIntentResponse{
+			SetupIntentID: si.ID,
+			ClientSecret:  si.ClientSecret,
+		})
+	}
+}
+
+// NewSetDefaultPaymentMethodHandler creates a Fiber handler for choosing the card
+// invoices and renewals are charged to
+func NewSetDefaultPaymentMethodHandler(deps *Dependencies) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get tenant from locals (set by middleware)
+		tenant := c.Locals("tenant").(string)
+
+		var req dto.DefaultPaymentMethodRequest
+		if err := c.BodyParser(&req); err != nil {
+			return dto.SendError(c, fiber.StatusBadRequest, "Invalid request body")
+		}
+
+		if req.PaymentMethodID == "" {
+			return dto.SendError(c, fiber.StatusBadRequest, "payment_method_id is required")
+		}
+
+		customerID, sub, err := resolveCustomer(deps, c.Params("userID"), tenant)
+		if err != nil {
+			return dto.SendError(c, fiber.StatusInternalServerError, "Error fetching customer")
+		}
+
+		if customerID == "" {
+			return dto.SendError(c, fiber.StatusNotFound, "Customer not found")
+		}
+
+		pm, status, message := customerPaymentMethod(deps, customerID, req.PaymentMethodID)
+		if pm == nil {
+			return dto.SendError(c, status, message)
+		}
+
+		if _, err := deps.StripeClient.SetDefaultPaymentMethod(customerID, pm.ID); err != nil {
+			log.Printf("Error setting default payment method: %v", err)
+			return dto.SendError(c, fiber.StatusInternalServerError, "Error setting default payment method")
+		}
+
+		// Subscriptions created by Checkout carry their own default, which
+		// takes precedence over the customer's, so it is updated as well
+		if sub != nil && sub.Status != models.StatusCanceled {
+			if _, err := deps.StripeClient.SetSubscriptionPaymentMethod(sub.StripeSubscriptionID, pm.ID); err != nil {
+				log.Printf("Error setting subscription payment method: %v", err)
+				return dto.SendError(c, fiber.StatusInternalServerError, "Error setting default payment method")
+			}
+		}
+
+		method := paymentMethodResponse(pm)
+		method.IsDefault = true
+		return dto.SendSuccess(c, fiber.StatusOK, method)
+	}
+}
+
+// NewDetachPaymentMethodHandler creates a Fiber handler for removing a saved card
+func NewDetachPaymentMethodHandler(deps *Dependencies) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get tenant from locals (set by middleware)
+		tenant := c.Locals("tenant").(string)
+
+		customerID, _, err := resolveCustomer(deps, c.Params("userID"), tenant)
+		if err != nil {
+			return dto.SendError(c, fiber.StatusInternalServerError, "Error fetching customer")
+		}
+
+		if customerID == "" {
+			return dto.SendError(c, fiber.StatusNotFound, "Customer not found")
+		}
+
+		pm, status, message := customerPaymentMethod(deps, customerID, c.Params("paymentMethodID"))
+		if pm == nil {
+			return dto.SendError(c, status, message)
+		}
+
+		if _, err := deps.StripeClient.DetachPaymentMethod(pm.ID); err != nil {
+			log.Printf("Error detaching payment method: %v", err)
+			return dto.SendError(c, fiber.StatusInternalServerError, "Error detaching payment method")
+		}
+
+		return dto.SendSuccess(c, fiber.StatusOK, fiber.Map{
+			"status":  "success",
+			"message": "Payment method detached",
+		})
+	}
+}
+
+// handlePaymentMethodAttached notifies the backend that a card was saved
+func handlePaymentMethodAttached(deps *Dependencies, event stripe.Event) error {
+	var pm stripe.PaymentMethod
+	if err := json.Unmarshal(event.Data.Raw, &pm); err != nil {
+		return permanentf("error unmarshaling payment method: %w", err)
+	}
+
+	if pm.Customer == nil {
+		return nil
+	}
+
+	method := paymentMethodResponse(&pm)
+	return notifyPaymentMethod(deps, event.ID, pm.Customer.ID, webhook.EventPaymentMethodAttached, method)
+}
+
+// handleSourceExpiring notifies the backend that a card expires at the end of
+// the month, so it can prompt the user before the next renewal fails
+func handleSourceExpiring(deps *Dependencies, event stripe.Event) error {
+	var card stripe.Card
+	if err := json.Unmarshal(event.Data.Raw, &card); err != nil {
+		return permanentf("error unmarshaling card: %w", err)
+	}
+
+	if card.Customer == nil {
+		return nil
+	}
+
+	method := dto.PaymentMethod{
+		ID:       card.ID,
+		Brand:    string(card.Brand),
+		Last4:    card.Last4,
+		ExpMonth: card.ExpMonth,
+		ExpYear:  card.ExpYear,
+	}
+	return notifyPaymentMethod(deps, event.ID, card.Customer.ID, webhook.EventPaymentMethodExpiring, method)
+}
+
+func notifyPaymentMethod(deps *Dependencies, source, customerID string, eventType webhook.EventType, method dto.PaymentMethod) error {
+	userID, tenant, err := customerUser(deps, customerID)
+	if err != nil {
+		return err
+	}
+
+	if userID == "" {
+		log.Printf("Customer %s is not mapped to a user, skipping", customerID)
+		return nil
+	}
+
+	payload := webhook.PaymentMethodData{
+		UserID:          userID,
+		PaymentMethodID: method.ID,
+		Brand:           method.Brand,
+		Last4:           method.Last4,
+		ExpMonth:        method.ExpMonth,
+		ExpYear:         method.ExpYear,
+	}
+
+	if err := deps.WebhookClient.Notify(webhook.NewEvent(source, eventType, tenant, payload)); err != nil {
+		log.Printf("Error notifying backend: %v", err)
+	}
+
+	return nil
+}
+
+// resolveCustomer returns the Stripe customer of a user from their
+// subscription, falling back to the customers table for users who never
+// subscribed. The customer ID is empty if the user has neither.
+func resolveCustomer(deps *Dependencies, userID, tenant string) (string, *models.Subscription, error) {
+	sub, err := deps.SubRepo.GetByUserID(userID, tenant)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if sub != nil && sub.StripeCustomerID != "" {
+		return sub.StripeCustomerID, sub, nil
+	}
+
+	cust, err := deps.CustomerRepo.GetByUserID(userID, tenant)
+	if err != nil || cust == nil {
+		return "", sub, err
+	}
+
+	return cust.StripeCustomerID, sub, nil
+}
+
+// customerUser returns the user a Stripe customer belongs to, or empty
+// strings if it is not mapped
+func customerUser(deps *Dependencies, customerID string) (userID, tenant string, err error) {
+	sub, err := deps.SubRepo.GetByStripeCustomerID(customerID)
+	if err != nil {
+		return "", "", err
+	}
+
+	if sub != nil {
+		return sub.UserID, sub.Tenant, nil
+	}
+
+	cust, err := deps.CustomerRepo.GetByStripeCustomerID(customerID)
+	if err != nil || cust == nil {
+		return "", "", err
+	}
+
+	return cust.UserID, cust.Tenant, nil
+}
+
+// customerPaymentMethod fetches a payment method and checks that it is saved on
+// the customer. On failure it returns nil with the response status and message.
+func customerPaymentMethod(deps *Dependencies, customerID, paymentMethodID string) (*stripe.PaymentMethod, int, string) {
+	pm, err := deps.StripeClient.GetPaymentMethod(paymentMethodID)
+	if err != nil {
+		if !IsRetryable(err) {
+			return nil, fiber.StatusNotFound, "Payment method not found"
+		}
+		log.Printf("Error fetching payment method: %v", err)
+		return nil, fiber.StatusInternalServerError, "Error fetching payment method"
+	}
+
+	if pm.Customer == nil || pm.Customer.ID != customerID {
+		return nil, fiber.StatusNotFound, "Payment method not found"
+	}
+
+	return pm, 0, ""
+}
+
+// defaultPaymentMethodID returns the card renewals are charged to: the
+// subscription's default if it has one, otherwise the customer's
+func defaultPaymentMethodID(deps *Dependencies, customerID string, sub *models.Subscription) (string, error) {
+	if sub != nil && sub.Status != models.StatusCanceled {
+		stripeSub, err := deps.StripeClient.GetSubscription(sub.StripeSubscriptionID)
+		if err != nil {
+			return "", err
+		}
+		if stripeSub.DefaultPaymentMethod != nil {
+			return stripeSub.DefaultPaymentMethod.ID, nil
+		}
+	}
+
+	cust, err := deps.StripeClient.GetCustomer(customerID)
+	if err != nil {
+		return "", err
+	}
+
+	if cust.InvoiceSettings != nil && cust.InvoiceSettings.DefaultPaymentMethod != nil {
+		return cust.InvoiceSettings.DefaultPaymentMethod.ID, nil
+	}
+
+	return "", nil
+}
+
+func paymentMethodResponse(pm *stripe.PaymentMethod) dto.PaymentMethod {
+	method := dto.PaymentMethod{
+		ID:    pm.ID,
+		Brand: string(pm.Type),
+	}
+
+	if pm.Card != nil {
+		method.Brand = string(pm.Card.Brand)
+		method.Last4 = pm.Card.Last4
+		method.ExpMonth = pm.Card.ExpMonth
+		method.ExpYear = pm.Card.ExpYear
+		method.Expired = cardExpired(pm.Card.ExpMonth, pm.Card.ExpYear, time.Now())
+	}
+
+	return method
+}
+
+// cardExpired reports whether a card expiring at the end of the given month
+// has expired at now
+func cardExpired(month, year int64, now time.Time) bool {
+	if month == 0 || year == 0 {
+		return false
+	}
+	endOfMonth := time.Date(int(year), time.Month(month)+1, 1, 0, 0, 0, 0, time.UTC)
+	return !now.Before(endOfMonth)
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"log"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/naventro/payment-service/internal/api/dto"
+	"github.com/naventro/payment-service/internal/models"
+	stripeclient "github.com/naventro/payment-service/internal/stripe"
+)
+
+// NewQuantityHandler creates a Fiber handler for changing the number of seats
+// of a subscription mid-cycle
+func NewQuantityHandler(deps *Dependencies) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get tenant from locals (set by middleware)
+		tenant := c.Locals("tenant").(string)
+
+		userID := c.Params("userID")
+		if userID == "" {
+			return dto.SendError(c, fiber.StatusBadRequest, "User ID is required")
+		}
+
+		var req dto.QuantityRequest
+		if err := c.BodyParser(&req); err != nil {
+			return dto.SendError(c, fiber.StatusBadRequest, "Invalid request body")
+		}
+
+		if req.Quantity < 1 {
+			return dto.SendError(c, fiber.StatusBadRequest, "quantity must be at least 1")
+		}
+
+		if req.ProrationBehavior == "" {
+			req.ProrationBehavior = "create_prorations"
+		}
+		if !isValidProrationBehavior(req.ProrationBehavior) {
+			return dto.SendError(c, fiber.StatusBadRequest, "proration_behavior must be one of create_prorations, always_invoice or none")
+		}
+
+		subscription, err := deps.SubRepo.GetByUserID(userID, tenant)
+		if err != nil {
+			return dto.SendError(c, fiber.StatusInternalServerError, "Error fetching subscription")
+		}
+
+		if subscription == nil {
+			return dto.SendError(c, fiber.StatusNotFound, "Subscription not found")
+		}
+
+		if subscription.Status == models.StatusCanceled {
+			return dto.SendError(c, fiber.StatusBadRequest, "Subscription is canceled")
+		}
+
+		if subscription.Quantity == req.Quantity {
+			return dto.SendSuccess(c, fiber.StatusOK, subscription)
+		}
+
+		stripeSub, err := deps.StripeClient.UpdateSubscriptionQuantity(subscription.StripeSubscriptionID, req.Quantity, req.ProrationBehavior, deps.Catalog.IsPlanPrice)
+		if err != nil {
+			log.Printf("Error updating Stripe subscription quantity: %v", err)
+			return dto.SendError(c, fiber.StatusInternalServerError, "Error updating subscription quantity")
+		}
+
+		// customer.subscription.updated brings the same change and notifies
+		// the backend, as it does for add-on changes; storing it now lets the
+		// caller read it back immediately
+		subscription.Quantity = stripeclient.SubscriptionQuantity(stripeSub, deps.Catalog.IsPlanPrice)
+		if err := deps.SubRepo.Update(subscription); err != nil {
+			log.Printf("Error updating subscription: %v", err)
+			return dto.SendError(c, fiber.StatusInternalServerError, "Error updating subscription")
+		}
+		if _, err := syncSubscriptionItems(deps, subscription.ID, stripeSub); err != nil {
+			log.Printf("Error syncing subscription items: %v", err)
+		}
+
+		return dto.SendSuccess(c, fiber.StatusOK, subscription)
+	}
+}
+
+func isValidProrationBehavior(behavior string) bool {
+	switch behavior {
+	case "create_prorations", "always_invoice", "none":
+		return true
+	}
+	return false
+}
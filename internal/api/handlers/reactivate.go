@@ -54,24 +54,8 @@ func NewReactivateHandler(deps *Dependencies) fiber.Handler {
 			return dto.SendError(c, fiber.StatusInternalServerError, "Error updating subscription")
 		}
 
-		// Get customer email from Stripe
-		email := getCustomerEmail(subscription.StripeCustomerID)
-
-		// Notify backend with complete payload
-		payload := webhook.SubscriptionWebhookPayload{
-			UserID:             userID,
-			Email:              email,
-			Status:             string(subscription.Status),
-			Plan:               string(subscription.Plan),
-			SubscriptionID:     subscription.StripeSubscriptionID,
-			CurrentPeriodStart: subscription.CurrentPeriodStart,
-			CurrentPeriodEnd:   subscription.CurrentPeriodEnd,
-			CancelAtPeriodEnd:  subscription.CancelAtPeriodEnd,
-		}
-
-		if err := deps.WebhookClient.NotifySubscriptionChange(payload); err != nil {
-			log.Printf("Error notifying backend: %v", err)
-		}
+		// Notify backend
+		notifyBackend(deps, "", webhook.EventSubscriptionReactivated, subscription)
 
 		return dto.SendSuccess(c, fiber.StatusOK, fiber.Map{
 			"status":  "success",
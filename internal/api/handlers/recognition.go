@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/naventro/payment-service/internal/api/dto"
+	"github.com/naventro/payment-service/internal/models"
+	"github.com/naventro/payment-service/internal/recognition"
+	"github.com/naventro/payment-service/internal/repository"
+	"github.com/stripe/stripe-go/v84"
+)
+
+// NewRevenueRecognitionHandler creates a Fiber handler for the recognized
+// and deferred revenue of each month, by default the last 12 calendar months
+func NewRevenueRecognitionHandler(deps *Dependencies) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		now := time.Now().UTC()
+		filter := repository.RecognitionFilter{
+			Tenant: c.Query("tenant"),
+			From:   time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, -11, 0),
+			To:     now,
+		}
+
+		for param, target := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+			value := c.Query(param)
+			if value == "" {
+				continue
+			}
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return dto.SendError(c, fiber.StatusBadRequest, param+" must be an RFC 3339 timestamp")
+			}
+			*target = t.UTC()
+		}
+
+		if !filter.From.Before(filter.To) {
+			return dto.SendError(c, fiber.StatusBadRequest, "from must be before to")
+		}
+
+		months := []*models.RecognitionMonth{}
+		err := deps.RevenueRecognitionRepo.Monthly(filter, func(month *models.RecognitionMonth) error {
+			months = append(months, month)
+			return nil
+		})
+		if err != nil {
+			log.Printf("Error fetching revenue recognition: %v", err)
+			return dto.SendError(c, fiber.StatusInternalServerError, "Error fetching revenue recognition")
+		}
+
+		return dto.SendSuccess(c, fiber.StatusOK, dto.RecognitionResponse{
+			Tenant: filter.Tenant,
+			From:   filter.From,
+			To:     filter.To,
+			Months: months,
+		})
+	}
+}
+
+// scheduleRevenue stores the revenue recognition schedule of a paid invoice
+// from the service periods of its lines
+func scheduleRevenue(deps *Dependencies, event stripe.Event, stored *models.Invoice, invoice *stripe.Invoice, items []*stripe.InvoiceLineItem) error {
+	lines := recognition.StripeLines(items)
+	if len(lines) == 0 {
+		lines = recognition.InvoiceLines(stored)
+	}
+
+	return recognition.SaveSchedule(deps.RevenueRecognitionRepo, stored, lines, paidAt(event, invoice), recognition.Granularity(deps.Config.RevenueRecognition))
+}
+
+// paidAt returns when an invoice was paid, or when the event reporting the
+// payment was created if Stripe does not say
+func paidAt(event stripe.Event, invoice *stripe.Invoice) time.Time {
+	if invoice.StatusTransitions != nil && invoice.StatusTransitions.PaidAt > 0 {
+		return time.Unix(invoice.StatusTransitions.PaidAt, 0)
+	}
+	return time.Unix(event.Created, 0)
+}
@@ -0,0 +1,477 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/naventro/payment-service/internal/api/dto"
+	"github.com/naventro/payment-service/internal/models"
+	"github.com/naventro/payment-service/internal/recognition"
+	"github.com/naventro/payment-service/internal/webhook"
+	"github.com/stripe/stripe-go/v84"
+)
+
+// NewInvoicesHandler creates a Fiber handler for a user's invoice history, including refunds
+func NewInvoicesHandler(deps *Dependencies) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get tenant from locals (set by middleware)
+		tenant := c.Locals("tenant").(string)
+
+		userID := c.Params("userID")
+		if userID == "" {
+			return dto.SendError(c, fiber.StatusBadRequest, "User ID is required")
+		}
+
+		invoices, err := deps.InvoiceRepo.GetByUserID(userID, tenant)
+		if err != nil {
+			return dto.SendError(c, fiber.StatusInternalServerError, "Error fetching invoices")
+		}
+
+		refunds, err := deps.RefundRepo.GetByUserID(userID, tenant)
+		if err != nil {
+			return dto.SendError(c, fiber.StatusInternalServerError, "Error fetching refunds")
+		}
+
+		byInvoice := make(map[int][]*models.Refund)
+		for _, refund := range refunds {
+			if refund.InvoiceID != nil {
+				byInvoice[*refund.InvoiceID] = append(byInvoice[*refund.InvoiceID], refund)
+			}
+		}
+
+		response := dto.InvoiceHistoryResponse{Invoices: []dto.InvoiceHistoryEntry{}}
+		for _, invoice := range invoices {
+			response.Invoices = append(response.Invoices, invoiceHistoryEntry(invoice, byInvoice[invoice.ID]))
+		}
+
+		return dto.SendSuccess(c, fiber.StatusOK, response)
+	}
+}
+
+// NewRefundHandler creates a Fiber handler for refunding all or part of a paid invoice
+func NewRefundHandler(deps *Dependencies) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get tenant from locals (set by middleware)
+		tenant := c.Locals("tenant").(string)
+
+		var req dto.RefundRequest
+		if len(c.Body()) > 0 {
+			if err := c.BodyParser(&req); err != nil {
+				return dto.SendError(c, fiber.StatusBadRequest, "Invalid request body")
+			}
+		}
+
+		if req.Amount < 0 {
+			return dto.SendError(c, fiber.StatusBadRequest, "amount must be positive")
+		}
+
+		if !isValidRefundReason(req.Reason) {
+			return dto.SendError(c, fiber.StatusBadRequest, "reason must be one of duplicate, fraudulent or requested_by_customer")
+		}
+
+		invoice, err := deps.InvoiceRepo.GetByStripeInvoiceID(c.Params("invoiceID"))
+		if err != nil {
+			return dto.SendError(c, fiber.StatusInternalServerError, "Error fetching invoice")
+		}
+
+		if invoice == nil || invoice.Tenant != tenant {
+			return dto.SendError(c, fiber.StatusNotFound, "Invoice not found")
+		}
+
+		if invoice.Status != models.InvoiceStatusPaid {
+			return dto.SendError(c, fiber.StatusBadRequest, "Only paid invoices can be refunded")
+		}
+
+		// Concurrent requests for the invoice wait here, so each one sees the
+		// refunds issued before it
+		var response *dto.RefundResponse
+		err = deps.RefundRepo.WithInvoiceLock(invoice.ID, func() error {
+			var err error
+			response, err = issueRefund(deps, invoice, req, c.Get("Idempotency-Key"))
+			return err
+		})
+
+		var fiberErr *fiber.Error
+		var stripeErr *stripe.Error
+		switch {
+		case errors.As(err, &fiberErr):
+			return dto.SendError(c, fiberErr.Code, fiberErr.Message)
+		case errors.As(err, &stripeErr) && stripeErr.Type == stripe.ErrorTypeIdempotency:
+			return dto.SendError(c, fiber.StatusConflict, "Idempotency-Key was already used for a different refund")
+		case err != nil:
+			log.Printf("Error refunding invoice %s: %v", invoice.StripeInvoiceID, err)
+			return dto.SendError(c, fiber.StatusInternalServerError, "Error creating refund")
+		}
+
+		return dto.SendSuccess(c, fiber.StatusCreated, response)
+	}
+}
+
+// issueRefund refunds amount of the invoice in Stripe, or its whole
+// refundable balance, and records the refund. Requests with an invalid amount
+// fail with a *fiber.Error. A request retried with the client's
+// Idempotency-Key returns the refund it created. The key, scoped to the
+// invoice, is also sent to Stripe; without one, the Stripe key is derived from
+// the invoice, amount and balance, so requests on the same balance issue one
+// refund.
+func issueRefund(deps *Dependencies, invoice *models.Invoice, req dto.RefundRequest, clientKey string) (*dto.RefundResponse, error) {
+	refunds, err := deps.RefundRepo.GetByInvoiceID(invoice.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if clientKey != "" {
+		for _, refund := range refunds {
+			if stringValue(refund.IdempotencyKey) == clientKey {
+				return &dto.RefundResponse{
+					RefundID:  refund.StripeRefundID,
+					InvoiceID: invoice.StripeInvoiceID,
+					Amount:    refund.Amount,
+					Currency:  refund.Currency,
+					Status:    refund.Status,
+				}, nil
+			}
+		}
+	}
+
+	// Pending refunds count against the balance so they are not issued twice
+	remaining := invoice.AmountPaid
+	for _, refund := range refunds {
+		if refund.Status.Outstanding() {
+			remaining -= refund.Amount
+		}
+	}
+
+	if remaining <= 0 {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "Invoice is already fully refunded")
+	}
+
+	amount := req.Amount
+	if amount == 0 {
+		amount = remaining
+	}
+
+	if amount > remaining {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "amount exceeds the refundable balance of the invoice")
+	}
+
+	paymentIntentID, chargeID, err := invoicePayment(deps, invoice)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching payment: %w", err)
+	}
+
+	if paymentIntentID == "" && chargeID == "" {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "Invoice has no payment to refund")
+	}
+
+	metadata := map[string]string{
+		"user_id":    invoice.UserID,
+		"tenant":     invoice.Tenant,
+		"invoice_id": invoice.StripeInvoiceID,
+	}
+	idempotencyKey := fmt.Sprintf("refund-%s-%d-%d", invoice.StripeInvoiceID, amount, remaining)
+	if clientKey != "" {
+		metadata["idempotency_key"] = clientKey
+		idempotencyKey = fmt.Sprintf("refund-%s-%s", invoice.StripeInvoiceID, clientKey)
+	}
+
+	stripeRefund, err := deps.StripeClient.CreateRefund(paymentIntentID, chargeID, amount, req.Reason, metadata, idempotencyKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// The refund exists in Stripe now; if it cannot be stored here the
+	// refund webhook records it later, so the request still succeeds
+	if err := recordRefund(deps, "", stripeRefund); err != nil {
+		log.Printf("Error recording refund %s: %v", stripeRefund.ID, err)
+	}
+
+	return &dto.RefundResponse{
+		RefundID:  stripeRefund.ID,
+		InvoiceID: invoice.StripeInvoiceID,
+		Amount:    stripeRefund.Amount,
+		Currency:  string(stripeRefund.Currency),
+		Status:    models.RefundStatus(stripeRefund.Status),
+	}, nil
+}
+
+// handleChargeRefunded records the refunds of a charge.refunded event
+func handleChargeRefunded(deps *Dependencies, event stripe.Event) error {
+	var charge stripe.Charge
+	if err := json.Unmarshal(event.Data.Raw, &charge); err != nil {
+		return permanentf("error unmarshaling charge: %w", err)
+	}
+
+	refunds, err := deps.StripeClient.ListChargeRefunds(charge.ID)
+	if err != nil {
+		return err
+	}
+
+	// A charge.refunded event may carry several refunds, so each one is
+	// notified as a change of its own
+	for _, refund := range refunds {
+		if err := recordRefund(deps, event.ID+":"+refund.ID, refund); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// handleRefundUpdated records the new status of a refund
+func handleRefundUpdated(deps *Dependencies, event stripe.Event) error {
+	var refund stripe.Refund
+	if err := json.Unmarshal(event.Data.Raw, &refund); err != nil {
+		return permanentf("error unmarshaling refund: %w", err)
+	}
+
+	return recordRefund(deps, event.ID, &refund)
+}
+
+// recordRefund stores a Stripe refund against the invoice or one-time order
+// it refunds and notifies the backend when it is new or its status changed. source is the ID
+// of the Stripe event being processed, or empty for refunds issued through
+// the API.
+func recordRefund(deps *Dependencies, source string, r *stripe.Refund) error {
+	existing, err := deps.RefundRepo.GetByStripeRefundID(r.ID)
+	if err != nil {
+		return err
+	}
+
+	refund := &models.Refund{
+		StripeRefundID: r.ID,
+		Amount:         r.Amount,
+		Currency:       string(r.Currency),
+		Status:         models.RefundStatus(r.Status),
+	}
+	if r.Charge != nil && r.Charge.ID != "" {
+		refund.StripeChargeID = &r.Charge.ID
+	}
+	if r.PaymentIntent != nil && r.PaymentIntent.ID != "" {
+		refund.StripePaymentIntentID = &r.PaymentIntent.ID
+	}
+	if r.Reason != "" {
+		reason := string(r.Reason)
+		refund.Reason = &reason
+	}
+	if r.FailureReason != "" {
+		failureReason := string(r.FailureReason)
+		refund.FailureReason = &failureReason
+	}
+	if key := r.Metadata["idempotency_key"]; key != "" {
+		refund.IdempotencyKey = &key
+	}
+
+	var invoice *models.Invoice
+	var order *models.Order
+	switch {
+	case existing != nil && existing.InvoiceID != nil:
+		invoice, err = deps.InvoiceRepo.GetByID(*existing.InvoiceID)
+	case refund.StripePaymentIntentID != nil:
+		// One-time purchases are paid without an invoice
+		order, err = deps.OrderRepo.GetByPaymentIntentID(*refund.StripePaymentIntentID)
+	}
+	if err != nil {
+		return err
+	}
+
+	if invoice == nil && order == nil {
+		invoice, err = findInvoiceForPayment(deps, stringValue(refund.StripePaymentIntentID), stringValue(refund.StripeChargeID))
+		if err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case invoice != nil:
+		refund.InvoiceID = &invoice.ID
+		refund.UserID = &invoice.UserID
+		refund.Tenant = &invoice.Tenant
+	case order != nil:
+		refund.OrderID = &order.ID
+		refund.UserID = &order.UserID
+		refund.Tenant = &order.Tenant
+	default:
+		log.Printf("Refund %s does not match a known invoice or order", r.ID)
+	}
+
+	if err := deps.RefundRepo.Save(refund); err != nil {
+		return err
+	}
+
+	if order != nil {
+		return recordOrderRefund(deps, source, order, refund, existing)
+	}
+
+	if invoice != nil {
+		if err := recognition.SaveRefund(deps.RevenueRecognitionRepo, refund, invoice, recognition.Granularity(deps.Config.RevenueRecognition)); err != nil {
+			return err
+		}
+	}
+
+	if invoice != nil && existing == nil {
+		notifyRefund(deps, source, webhook.EventRefundCreated, refund, invoice)
+	} else if invoice != nil && existing.Status != refund.Status {
+		notifyRefund(deps, source, webhook.EventRefundUpdated, refund, invoice)
+	}
+
+	log.Printf("Refund %s recorded with status %s", r.ID, r.Status)
+	return nil
+}
+
+// recordOrderRefund marks a one-time order refunded once its refunds add up
+// to the amount paid, which withdraws its entitlement, and notifies the
+// backend of the refund
+func recordOrderRefund(deps *Dependencies, source string, order *models.Order, refund *models.Refund, existing *models.Refund) error {
+	refunds, err := deps.RefundRepo.GetByOrderID(order.ID)
+	if err != nil {
+		return err
+	}
+
+	var refunded int64
+	for _, r := range refunds {
+		if r.Status == models.RefundStatusSucceeded {
+			refunded += r.Amount
+		}
+	}
+
+	fullyRefunded := order.Amount > 0 && refunded >= order.Amount
+	if fullyRefunded {
+		if _, err := deps.OrderRepo.UpdateStatus(order.ID, models.OrderStatusRefunded); err != nil {
+			return err
+		}
+	}
+
+	eventType := webhook.EventRefundCreated
+	if existing != nil {
+		if existing.Status == refund.Status {
+			return nil
+		}
+		eventType = webhook.EventRefundUpdated
+	}
+
+	payload := webhook.RefundData{
+		UserID:         order.UserID,
+		RefundID:       refund.StripeRefundID,
+		OrderID:        order.StripePaymentIntentID,
+		Amount:         refund.Amount,
+		Currency:       refund.Currency,
+		Reason:         stringValue(refund.Reason),
+		Status:         string(refund.Status),
+		FailureReason:  stringValue(refund.FailureReason),
+		AmountRefunded: refunded,
+		FullyRefunded:  fullyRefunded,
+	}
+
+	if err := deps.WebhookClient.Notify(webhook.NewEvent(source, eventType, order.Tenant, payload)); err != nil {
+		log.Printf("Error notifying backend: %v", err)
+	}
+
+	log.Printf("Refund %s of order %s recorded with status %s", refund.StripeRefundID, order.StripePaymentIntentID, refund.Status)
+	return nil
+}
+
+func notifyRefund(deps *Dependencies, source string, eventType webhook.EventType, refund *models.Refund, invoice *models.Invoice) {
+	refunds, err := deps.RefundRepo.GetByInvoiceID(invoice.ID)
+	if err != nil {
+		log.Printf("Error fetching refunds for refund notification: %v", err)
+		return
+	}
+
+	entry := invoiceHistoryEntry(invoice, refunds)
+	payload := webhook.RefundData{
+		UserID:         invoice.UserID,
+		RefundID:       refund.StripeRefundID,
+		InvoiceID:      invoice.StripeInvoiceID,
+		Amount:         refund.Amount,
+		Currency:       refund.Currency,
+		Reason:         stringValue(refund.Reason),
+		Status:         string(refund.Status),
+		FailureReason:  stringValue(refund.FailureReason),
+		AmountRefunded: entry.AmountRefunded,
+		FullyRefunded:  entry.RefundState == dto.RefundStateFull,
+	}
+
+	if sub, err := deps.SubRepo.GetByID(invoice.SubscriptionID); err != nil {
+		log.Printf("Error fetching subscription for refund notification: %v", err)
+	} else if sub != nil {
+		payload.SubscriptionID = sub.StripeSubscriptionID
+	}
+
+	if err := deps.WebhookClient.Notify(webhook.NewEvent(source, eventType, invoice.Tenant, payload)); err != nil {
+		log.Printf("Error notifying backend: %v", err)
+	}
+}
+
+// invoiceHistoryEntry summarizes the refunds of an invoice. Only succeeded
+// refunds count towards the refunded amount.
+func invoiceHistoryEntry(invoice *models.Invoice, refunds []*models.Refund) dto.InvoiceHistoryEntry {
+	entry := dto.InvoiceHistoryEntry{
+		Invoice:     invoice,
+		RefundState: dto.RefundStateNone,
+		Refunds:     refunds,
+	}
+	if entry.Refunds == nil {
+		entry.Refunds = []*models.Refund{}
+	}
+
+	for _, refund := range refunds {
+		if refund.Status == models.RefundStatusSucceeded {
+			entry.AmountRefunded += refund.Amount
+		}
+	}
+
+	switch {
+	case entry.AmountRefunded > 0 && entry.AmountRefunded >= invoice.AmountPaid:
+		entry.RefundState = dto.RefundStateFull
+	case entry.AmountRefunded > 0:
+		entry.RefundState = dto.RefundStatePartial
+	}
+
+	return entry
+}
+
+// invoicePayment returns the payment that settled an invoice, fetching it
+// from Stripe and backfilling the invoice if it was not recorded
+func invoicePayment(deps *Dependencies, invoice *models.Invoice) (paymentIntentID, chargeID string, err error) {
+	paymentIntentID = stringValue(invoice.StripePaymentIntentID)
+	chargeID = stringValue(invoice.StripeChargeID)
+	if paymentIntentID != "" || chargeID != "" {
+		return paymentIntentID, chargeID, nil
+	}
+
+	paymentIntentID, chargeID, err = deps.StripeClient.GetInvoicePayment(invoice.StripeInvoiceID)
+	if err != nil {
+		return "", "", err
+	}
+
+	if paymentIntentID != "" {
+		invoice.StripePaymentIntentID = &paymentIntentID
+	}
+	if chargeID != "" {
+		invoice.StripeChargeID = &chargeID
+	}
+	if err := deps.InvoiceRepo.Update(invoice); err != nil {
+		log.Printf("Error backfilling payment of invoice %s: %v", invoice.StripeInvoiceID, err)
+	}
+
+	return paymentIntentID, chargeID, nil
+}
+
+func isValidRefundReason(reason string) bool {
+	switch stripe.RefundReason(reason) {
+	case "", stripe.RefundReasonDuplicate, stripe.RefundReasonFraudulent, stripe.RefundReasonRequestedByCustomer:
+		return true
+	}
+	return false
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
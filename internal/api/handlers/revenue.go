@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/naventro/payment-service/internal/api/dto"
+	"github.com/naventro/payment-service/internal/models"
+	"github.com/naventro/payment-service/internal/repository"
+)
+
+// NewRevenueHandler creates a Fiber handler for the revenue report. Amounts
+// are grouped by currency since they cannot be added up across currencies.
+func NewRevenueHandler(deps *Dependencies) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		filter := repository.RevenueFilter{Tenant: c.Query("tenant")}
+
+		for param, target := range map[string]**time.Time{"from": &filter.From, "to": &filter.To} {
+			value := c.Query(param)
+			if value == "" {
+				continue
+			}
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return dto.SendError(c, fiber.StatusBadRequest, param+" must be an RFC 3339 timestamp")
+			}
+			*target = &t
+		}
+
+		revenue, err := deps.RevenueRepo.ByCurrency(filter)
+		if err != nil {
+			return dto.SendError(c, fiber.StatusInternalServerError, "Error fetching revenue")
+		}
+
+		if revenue == nil {
+			revenue = []*models.CurrencyRevenue{}
+		}
+
+		return dto.SendSuccess(c, fiber.StatusOK, dto.RevenueResponse{
+			Tenant:     filter.Tenant,
+			From:       filter.From,
+			To:         filter.To,
+			Currencies: revenue,
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/naventro/payment-service/internal/api/dto"
+	"github.com/naventro/payment-service/internal/models"
+	"github.com/naventro/payment-service/internal/usage"
+)
+
+// NewRecordUsageHandler creates a Fiber handler for recording metered usage.
+// Events are reported to Stripe in the background; sending the same
+// idempotency key again returns the event recorded the first time.
+func NewRecordUsageHandler(deps *Dependencies) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get tenant from locals (set by middleware)
+		tenant := c.Locals("tenant").(string)
+
+		var req dto.UsageRequest
+		if err := c.BodyParser(&req); err != nil {
+			return dto.SendError(c, fiber.StatusBadRequest, "Invalid request body")
+		}
+
+		if req.UserID == "" || req.IdempotencyKey == "" {
+			return dto.SendError(c, fiber.StatusBadRequest, "user_id and idempotency_key are required")
+		}
+
+		if req.Quantity <= 0 {
+			return dto.SendError(c, fiber.StatusBadRequest, "quantity must be positive")
+		}
+
+		meter, ok := deps.Catalog.Meter(req.Meter)
+		if !ok {
+			return dto.SendError(c, fiber.StatusBadRequest, "Invalid meter")
+		}
+
+		occurredAt := time.Now()
+		if req.Timestamp != nil {
+			occurredAt = *req.Timestamp
+		}
+		if time.Since(occurredAt) > usage.MaxEventAge || time.Until(occurredAt) > 5*time.Minute {
+			return dto.SendError(c, fiber.StatusBadRequest, "timestamp must be within the last 35 days")
+		}
+
+		subscription, err := deps.SubRepo.GetByUserID(req.UserID, tenant)
+		if err != nil {
+			return dto.SendError(c, fiber.StatusInternalServerError, "Error fetching subscription")
+		}
+
+		if subscription == nil || subscription.Status == models.StatusCanceled {
+			return dto.SendError(c, fiber.StatusNotFound, "Subscription not found")
+		}
+
+		if !meter.HasPlan(subscription.Plan) {
+			return dto.SendError(c, fiber.StatusBadRequest, "Meter is not billed on the user's plan")
+		}
+
+		event, created, err := deps.UsageRepo.Record(&models.UsageEvent{
+			IdempotencyKey:   req.IdempotencyKey,
+			UserID:           req.UserID,
+			Tenant:           tenant,
+			Meter:            meter.Key,
+			Quantity:         req.Quantity,
+			StripeCustomerID: subscription.StripeCustomerID,
+			OccurredAt:       occurredAt,
+		})
+		if err != nil {
+			log.Printf("Error recording usage: %v", err)
+			return dto.SendError(c, fiber.StatusInternalServerError, "Error recording usage")
+		}
+
+		if !created {
+			return dto.SendSuccess(c, fiber.StatusOK, event)
+		}
+
+		return dto.SendSuccess(c, fiber.StatusCreated, event)
+	}
+}
+
+// NewUsageHandler creates a Fiber handler for a user's metered usage in the
+// current billing period
+func NewUsageHandler(deps *Dependencies) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get tenant from locals (set by middleware)
+		tenant := c.Locals("tenant").(string)
+
+		userID := c.Params("userID")
+		if userID == "" {
+			return dto.SendError(c, fiber.StatusBadRequest, "User ID is required")
+		}
+
+		subscription, err := deps.SubRepo.GetByUserID(userID, tenant)
+		if err != nil {
+			return dto.SendError(c, fiber.StatusInternalServerError, "Error fetching subscription")
+		}
+
+		if subscription == nil || subscription.CurrentPeriodStart == nil || subscription.CurrentPeriodEnd == nil {
+			return dto.SendError(c, fiber.StatusNotFound, "Subscription not found")
+		}
+
+		totals, err := deps.UsageRepo.Totals(userID, tenant, *subscription.CurrentPeriodStart, *subscription.CurrentPeriodEnd)
+		if err != nil {
+			return dto.SendError(c, fiber.StatusInternalServerError, "Error fetching usage")
+		}
+
+		byMeter := make(map[string]models.UsageTotal)
+		for _, total := range totals {
+			byMeter[total.Meter] = total
+		}
+
+		response := dto.UsageResponse{
+			UserID:      userID,
+			PeriodStart: subscription.CurrentPeriodStart,
+			PeriodEnd:   subscription.CurrentPeriodEnd,
+			Meters:      []dto.MeterUsage{},
+		}
+		for _, meter := range deps.Catalog.PlanMeters(subscription.Plan) {
+			total := byMeter[meter.Key]
+			meterUsage := dto.MeterUsage{
+				Meter:     meter.Key,
+				Name:      meter.Name,
+				Quantity:  total.Quantity,
+				Reported:  total.Reported,
+				FreeUnits: meter.FreeUnits,
+			}
+			if total.Quantity > meter.FreeUnits {
+				meterUsage.Billable = total.Quantity - meter.FreeUnits
+			}
+			response.Meters = append(response.Meters, meterUsage)
+		}
+
+		return dto.SendSuccess(c, fiber.StatusOK, response)
+	}
+}
@@ -2,15 +2,20 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"sort"
+	"strconv"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/naventro/payment-service/internal/api/dto"
 	"github.com/naventro/payment-service/internal/models"
+	"github.com/naventro/payment-service/internal/notify"
+	stripeclient "github.com/naventro/payment-service/internal/stripe"
 	"github.com/naventro/payment-service/internal/webhook"
 	"github.com/stripe/stripe-go/v84"
-	"github.com/stripe/stripe-go/v84/customer"
 	stripewebhook "github.com/stripe/stripe-go/v84/webhook"
 )
 
@@ -28,95 +33,407 @@ func NewWebhookHandler(deps *Dependencies) fiber.Handler {
 			return dto.SendError(c, fiber.StatusBadRequest, "Missing Stripe-Signature header")
 		}
 
+		// Per-tenant routes verify against their own secrets
+		endpoint, secrets := "default", deps.Config.StripeWebhookSecrets
+		if tenant := c.Params("tenant"); tenant != "" {
+			endpoint, secrets = tenant, deps.Config.TenantWebhookSecrets[tenant]
+			if len(secrets) == 0 {
+				return dto.SendError(c, fiber.StatusNotFound, "Unknown webhook endpoint")
+			}
+		}
+
 		// Verify webhook signature
-		event, err := stripewebhook.ConstructEvent(body, signature, deps.Config.StripeWebhookSecret)
+		event, secret, err := constructEvent(body, signature, secrets)
 		if err != nil {
-			log.Printf("Error verifying webhook signature: %v", err)
+			log.Printf("Error verifying webhook signature on %s endpoint: %v", endpoint, err)
+			deps.Metrics.Inc("webhook_signature_failures", map[string]string{"endpoint": endpoint})
 			return dto.SendError(c, fiber.StatusBadRequest, "Invalid signature")
 		}
 
+		// Secrets are numbered from 1 in configuration order
+		deps.Metrics.Inc("webhook_signature_matches", map[string]string{
+			"endpoint": endpoint,
+			"secret":   strconv.Itoa(secret + 1),
+		})
+
 		log.Printf("Received Stripe webhook event: %s", event.Type)
 
-		// Handle different event types
-		switch event.Type {
-		case "checkout.session.completed":
-			handleCheckoutSessionCompleted(event)
-		case "customer.subscription.created":
-			handleSubscriptionCreated(deps, event)
-		case "customer.subscription.updated":
-			handleSubscriptionUpdated(deps, event)
-		case "customer.subscription.deleted":
-			handleSubscriptionDeleted(deps, event)
-		case "invoice.paid":
-			handleInvoicePaid(deps, event)
-		case "invoice.payment_failed":
-			handleInvoicePaymentFailed(event)
-		default:
-			log.Printf("Unhandled event type: %s", event.Type)
+		// Stripe redeliveries retry events whose previous attempt failed
+		handled, err := ReceiveEvent(deps, event, body, true)
+		if err != nil && !handled {
+			// Not stored or its result not recorded; let Stripe deliver it again
+			log.Printf("Error storing event %s: %v", event.ID, err)
+			return dto.SendError(c, fiber.StatusInternalServerError, "Error storing event")
+		}
+		if err != nil {
+			log.Printf("Error processing event %s (%s): %v", event.ID, event.Type, err)
+
+			// A non-2xx response makes Stripe deliver the event again
+			if IsRetryable(err) {
+				return dto.SendError(c, fiber.StatusInternalServerError, "Error processing event")
+			}
+
+			// Permanent failures are recorded for replay and acknowledged
+			return dto.SendSuccess(c, fiber.StatusOK, fiber.Map{"status": "failed", "error": err.Error()})
 		}
 
 		return dto.SendSuccess(c, fiber.StatusOK, fiber.Map{"status": "success"})
 	}
 }
 
-// getCustomerEmail retrieves the email from a Stripe customer
-func getCustomerEmail(customerID string) string {
+// constructEvent verifies the signature against each secret in turn and
+// returns the event with the index of the secret that matched
+func constructEvent(body []byte, signature string, secrets []string) (stripe.Event, int, error) {
+	err := stripewebhook.ErrNoValidSignature
+	for i, secret := range secrets {
+		var event stripe.Event
+		event, err = stripewebhook.ConstructEvent(body, signature, secret)
+		if err == nil {
+			return event, i, nil
+		}
+
+		// Other errors (expired timestamp, malformed header) do not depend on the secret
+		if !errors.Is(err, stripewebhook.ErrNoValidSignature) {
+			break
+		}
+	}
+
+	return stripe.Event{}, -1, err
+}
+
+// eventHandlers maps the Stripe event types the service handles to their handler
+var eventHandlers = map[stripe.EventType]func(*Dependencies, stripe.Event) error{
+	"checkout.session.completed":           handleCheckoutSessionCompleted,
+	"checkout.session.expired":             handleCheckoutSessionExpired,
+	"customer.subscription.created":        handleSubscriptionCreated,
+	"customer.subscription.updated":        handleSubscriptionUpdated,
+	"customer.subscription.deleted":        handleSubscriptionDeleted,
+	"invoice.paid":                         handleInvoicePaid,
+	"invoice.payment_failed":               handleInvoicePaymentFailed,
+	"invoice.payment_action_required":      handleInvoicePaymentActionRequired,
+	"customer.subscription.trial_will_end": handleTrialWillEnd,
+	"customer.created":                     handleCustomerUpdated,
+	"customer.updated":                     handleCustomerUpdated,
+	"customer.deleted":                     handleCustomerDeleted,
+	"charge.dispute.created":               handleDispute,
+	"charge.dispute.updated":               handleDispute,
+	"charge.dispute.closed":                handleDispute,
+	"charge.refunded":                      handleChargeRefunded,
+	"refund.updated":                       handleRefundUpdated,
+	"payment_method.attached":              handlePaymentMethodAttached,
+	"customer.source.expiring":             handleSourceExpiring,
+	"payment_intent.succeeded":             handlePaymentIntentSucceeded,
+}
+
+// IsHandledEvent reports whether the service has a handler for an event type
+func IsHandledEvent(eventType stripe.EventType) bool {
+	_, ok := eventHandlers[eventType]
+	return ok
+}
+
+// HandledEventTypes returns the event types the service has a handler for
+func HandledEventTypes() []string {
+	types := make([]string, 0, len(eventHandlers))
+	for eventType := range eventHandlers {
+		types = append(types, string(eventType))
+	}
+	sort.Strings(types)
+	return types
+}
+
+// eventLease is how long an event being processed is left to the attempt
+// that claimed it. An event still unfinished after the lease was interrupted,
+// by a crash or a failure to record its result, and is processed again.
+const eventLease = 5 * time.Minute
+
+// ReceiveEvent stores a Stripe event and processes it unless it was seen
+// before. An event that was already stored is processed again when its
+// previous attempt was interrupted, or when retry is set and that attempt
+// failed; events being processed or already processed are skipped. handled
+// reports whether the event was processed by this call; an error with
+// handled unset means the event could not be stored or the result of
+// processing it could not be recorded, so it must be delivered again.
+func ReceiveEvent(deps *Dependencies, event stripe.Event, payload []byte, retry bool) (handled bool, err error) {
+	stored, inserted, err := deps.EventRepo.Record(&models.StripeEvent{
+		StripeEventID:   event.ID,
+		Type:            string(event.Type),
+		Status:          models.EventStatusReceived,
+		Payload:         append([]byte(nil), payload...),
+		Livemode:        event.Livemode,
+		StripeCreatedAt: time.Unix(event.Created, 0),
+	})
+	if err != nil {
+		return false, err
+	}
+
+	if !inserted {
+		claimed, err := deps.EventRepo.Claim(stored.ID, retry, eventLease)
+		if err != nil {
+			return false, err
+		}
+		if !claimed {
+			log.Printf("Event %s already %s, skipping", event.ID, stored.Status)
+			return false, nil
+		}
+	}
+
+	processErr := processEvent(deps, event)
+	if err := recordEventResult(deps, stored, processErr); err != nil {
+		return false, err
+	}
+
+	return true, processErr
+}
+
+// processEvent dispatches a Stripe event to its handler
+func processEvent(deps *Dependencies, event stripe.Event) error {
+	handler, ok := eventHandlers[event.Type]
+	if !ok {
+		log.Printf("Unhandled event type: %s", event.Type)
+		return nil
+	}
+
+	return handler(deps, event)
+}
+
+// recordEventResult marks a stored event as processed or failed
+func recordEventResult(deps *Dependencies, stored *models.StripeEvent, processErr error) error {
+	status := models.EventStatusProcessed
+	var errMessage *string
+	if processErr != nil {
+		status = models.EventStatusFailed
+		message := processErr.Error()
+		errMessage = &message
+	}
+
+	if err := deps.EventRepo.MarkResult(stored.ID, status, errMessage); err != nil {
+		return fmt.Errorf("error recording result of event %s: %w", stored.StripeEventID, err)
+	}
+
+	return nil
+}
+
+// findInvoiceForPayment returns the invoice paid by a PaymentIntent or charge.
+// Invoices paid before payments were recorded are looked up in Stripe and
+// backfilled. It returns nil if the payment did not pay a known invoice.
+func findInvoiceForPayment(deps *Dependencies, paymentIntentID, chargeID string) (*models.Invoice, error) {
+	invoice, err := deps.InvoiceRepo.GetByPayment(paymentIntentID, chargeID)
+	if err != nil || invoice != nil || paymentIntentID == "" {
+		return invoice, err
+	}
+
+	stripeInvoiceID, err := deps.StripeClient.GetInvoiceIDForPaymentIntent(paymentIntentID)
+	if err != nil || stripeInvoiceID == "" {
+		return nil, err
+	}
+
+	invoice, err = deps.InvoiceRepo.GetByStripeInvoiceID(stripeInvoiceID)
+	if err != nil || invoice == nil {
+		return nil, err
+	}
+
+	invoice.StripePaymentIntentID = &paymentIntentID
+	if chargeID != "" {
+		invoice.StripeChargeID = &chargeID
+	}
+	if err := deps.InvoiceRepo.Update(invoice); err != nil {
+		return nil, err
+	}
+
+	return invoice, nil
+}
+
+// getCustomerEmail returns the email for a Stripe customer from the local cache.
+// Customers missing from the cache, or cached without an email like the ones
+// backfilled from subscriptions, are fetched from Stripe and cached.
+func getCustomerEmail(deps *Dependencies, customerID string) string {
 	if customerID == "" {
 		return ""
 	}
 
-	cust, err := customer.Get(customerID, nil)
+	cust, err := deps.CustomerRepo.GetByStripeCustomerID(customerID)
 	if err != nil {
 		log.Printf("Error fetching customer email: %v", err)
 		return ""
 	}
 
-	return cust.Email
+	if cust != nil && cust.Email != "" {
+		return cust.Email
+	}
+
+	stripeCustomer, err := deps.StripeClient.GetCustomer(customerID)
+	if err != nil {
+		log.Printf("Error fetching customer email: %v", err)
+		return ""
+	}
+
+	if err := syncCustomer(deps, stripeCustomer); err != nil {
+		log.Printf("Error caching customer: %v", err)
+	}
+
+	return stripeCustomer.Email
 }
 
-func handleCheckoutSessionCompleted(event stripe.Event) {
+func handleCheckoutSessionCompleted(deps *Dependencies, event stripe.Event) error {
 	var session stripe.CheckoutSession
 	if err := json.Unmarshal(event.Data.Raw, &session); err != nil {
-		log.Printf("Error unmarshaling checkout session: %v", err)
-		return
+		return permanentf("error unmarshaling checkout session: %w", err)
+	}
+
+	// Fill in any profile details the cached customer is still missing
+	if session.Customer != nil && session.CustomerDetails != nil {
+		cust, err := deps.CustomerRepo.GetByStripeCustomerID(session.Customer.ID)
+		if err != nil {
+			log.Printf("Error fetching customer: %v", err)
+		} else if cust != nil && (cust.Email == "" || cust.Name == "") {
+			if cust.Email == "" {
+				cust.Email = session.CustomerDetails.Email
+			}
+			if cust.Name == "" {
+				cust.Name = session.CustomerDetails.Name
+			}
+			if err := deps.CustomerRepo.Update(cust); err != nil {
+				log.Printf("Error updating customer: %v", err)
+			}
+		}
+	}
+
+	checkoutSession, err := getOrRecordCheckoutSession(deps, &session)
+	if err != nil {
+		return err
+	}
+
+	if checkoutSession == nil {
+		return permanentf("checkout session %s is missing metadata", session.ID)
+	}
+
+	now := time.Now()
+	checkoutSession.Status = models.CheckoutSessionStatusComplete
+	checkoutSession.CompletedAt = &now
+
+	// Link the subscription if its created event has already been processed
+	if session.Subscription != nil {
+		checkoutSession.StripeSubscriptionID = &session.Subscription.ID
+
+		sub, err := deps.SubRepo.GetByStripeSubscriptionID(session.Subscription.ID)
+		if err != nil {
+			log.Printf("Error fetching subscription: %v", err)
+		} else if sub != nil {
+			checkoutSession.SubscriptionID = &sub.ID
+		}
+	}
+
+	if err := deps.CheckoutSessionRepo.Update(checkoutSession); err != nil {
+		return err
+	}
+
+	// One-time purchases are recorded as orders
+	if session.Mode == stripe.CheckoutSessionModePayment {
+		if err := recordCheckoutOrder(deps, event, &session, checkoutSession); err != nil {
+			return err
+		}
 	}
 
 	log.Printf("Checkout session completed: %s", session.ID)
+	return nil
+}
+
+func handleCheckoutSessionExpired(deps *Dependencies, event stripe.Event) error {
+	var session stripe.CheckoutSession
+	if err := json.Unmarshal(event.Data.Raw, &session); err != nil {
+		return permanentf("error unmarshaling checkout session: %w", err)
+	}
+
+	checkoutSession, err := getOrRecordCheckoutSession(deps, &session)
+	if err != nil {
+		return err
+	}
+
+	if checkoutSession == nil {
+		return permanentf("checkout session %s is missing metadata", session.ID)
+	}
+
+	now := time.Now()
+	checkoutSession.Status = models.CheckoutSessionStatusExpired
+	checkoutSession.ExpiredAt = &now
+
+	if err := deps.CheckoutSessionRepo.Update(checkoutSession); err != nil {
+		return err
+	}
+
+	log.Printf("Checkout session expired: %s", session.ID)
+	return nil
+}
+
+// getOrRecordCheckoutSession returns the stored checkout session, recording it
+// from the session metadata when it was not saved at creation time
+func getOrRecordCheckoutSession(deps *Dependencies, session *stripe.CheckoutSession) (*models.CheckoutSession, error) {
+	checkoutSession, err := deps.CheckoutSessionRepo.GetByStripeSessionID(session.ID)
+	if err != nil || checkoutSession != nil {
+		return checkoutSession, err
+	}
+
+	userID, tenant := session.Metadata["user_id"], session.Metadata["tenant"]
+	if userID == "" || tenant == "" {
+		return nil, nil
+	}
+
+	checkoutSession = &models.CheckoutSession{
+		StripeSessionID: session.ID,
+		UserID:          userID,
+		Tenant:          tenant,
+		Plan:            models.Plan(session.Metadata["plan"]),
+		SuccessURL:      session.SuccessURL,
+		CancelURL:       session.CancelURL,
+		Status:          models.CheckoutSessionStatusOpen,
+	}
+	if product := session.Metadata["product"]; product != "" {
+		checkoutSession.Product = &product
+	}
+	if session.Customer != nil {
+		checkoutSession.StripeCustomerID = &session.Customer.ID
+	}
+
+	if err := deps.CheckoutSessionRepo.Create(checkoutSession); err != nil {
+		return nil, err
+	}
+
+	return checkoutSession, nil
 }
 
-func handleSubscriptionCreated(deps *Dependencies, event stripe.Event) {
+func handleSubscriptionCreated(deps *Dependencies, event stripe.Event) error {
 	var sub stripe.Subscription
 	if err := json.Unmarshal(event.Data.Raw, &sub); err != nil {
-		log.Printf("Error unmarshaling subscription: %v", err)
-		return
+		return permanentf("error unmarshaling subscription: %w", err)
 	}
 
-	userID, ok := sub.Metadata["user_id"]
-	if !ok {
-		log.Printf("Missing user_id in subscription metadata")
-		return
+	// Redeliveries and replays of an already stored subscription are no-ops
+	existingSub, err := deps.SubRepo.GetByStripeSubscriptionID(sub.ID)
+	if err != nil {
+		return err
 	}
 
-	tenant, ok := sub.Metadata["tenant"]
-	if !ok {
-		log.Printf("Missing tenant in subscription metadata")
-		return
+	if existingSub != nil {
+		log.Printf("Subscription already exists: %s", sub.ID)
+		return nil
 	}
 
-	plan, ok := sub.Metadata["plan"]
-	if !ok {
-		log.Printf("Missing plan in subscription metadata")
-		return
+	userID, tenant, plan, err := resolveSubscriptionOwner(deps, &sub)
+	if err != nil {
+		return err
 	}
 
-	// Save subscription to database
-	// In API v84+, period dates are at subscription item level
-	var periodStart, periodEnd time.Time
-	if len(sub.Items.Data) > 0 {
-		periodStart = time.Unix(sub.Items.Data[0].CurrentPeriodStart, 0)
-		periodEnd = time.Unix(sub.Items.Data[0].CurrentPeriodEnd, 0)
+	if userID == "" || tenant == "" {
+		return permanentf("missing user_id or tenant in subscription metadata and no customer mapping for %s", sub.ID)
 	}
 
+	if plan == "" {
+		return permanentf("missing plan in subscription metadata and unknown price for %s", sub.ID)
+	}
+
+	// Save subscription to database
+	periodStart, periodEnd := stripeclient.SubscriptionPeriod(&sub, deps.Catalog.IsPlanPrice)
+
 	subscription := &models.Subscription{
 		UserID:               userID,
 		Tenant:               tenant,
@@ -124,151 +441,377 @@ func handleSubscriptionCreated(deps *Dependencies, event stripe.Event) {
 		StripeSubscriptionID: sub.ID,
 		Status:               models.SubscriptionStatus(sub.Status),
 		Plan:                 models.Plan(plan),
-		CurrentPeriodStart:   &periodStart,
-		CurrentPeriodEnd:     &periodEnd,
+		CurrentPeriodStart:   periodStart,
+		CurrentPeriodEnd:     periodEnd,
 		CancelAtPeriodEnd:    sub.CancelAtPeriodEnd,
+		Quantity:             stripeclient.SubscriptionQuantity(&sub, deps.Catalog.IsPlanPrice),
+		Currency:             string(sub.Currency),
 	}
 
 	if err := deps.SubRepo.Create(subscription); err != nil {
-		log.Printf("Error creating subscription in database: %v", err)
-		return
+		return err
 	}
 
-	// Get customer email
-	email := getCustomerEmail(sub.Customer.ID)
+	// Later updates sync the items again, so a failure here is not fatal
+	if _, err := syncSubscriptionItems(deps, subscription.ID, &sub); err != nil {
+		log.Printf("Error saving subscription items: %v", err)
+	}
+
+	if err := recordSubscriptionHistory(deps, event, subscription, &sub); err != nil {
+		log.Printf("Error recording subscription history: %v", err)
+	}
+
+	// Link the checkout session that produced this subscription, if already completed
+	if err := deps.CheckoutSessionRepo.LinkSubscription(sub.ID, subscription.ID); err != nil {
+		log.Printf("Error linking checkout session: %v", err)
+	}
 
 	// Notify backend
-	notifyBackend(deps, userID, email, string(subscription.Status), plan, sub.ID)
+	notifyBackend(deps, event.ID, webhook.EventSubscriptionCreated, subscription)
 
 	log.Printf("Subscription created successfully for user %s", userID)
+	return nil
 }
 
-func handleSubscriptionUpdated(deps *Dependencies, event stripe.Event) {
+// resolveSubscriptionOwner returns the user, tenant and plan of a subscription.
+// Subscriptions created outside our checkout lack metadata, so the user falls
+// back to the local customer mapping and the plan to the subscribed price.
+func resolveSubscriptionOwner(deps *Dependencies, sub *stripe.Subscription) (userID, tenant, plan string, err error) {
+	userID, tenant, plan = sub.Metadata["user_id"], sub.Metadata["tenant"], sub.Metadata["plan"]
+
+	if (userID == "" || tenant == "") && sub.Customer != nil {
+		cust, err := deps.CustomerRepo.GetByStripeCustomerID(sub.Customer.ID)
+		if err != nil {
+			return "", "", "", err
+		}
+		if cust != nil {
+			userID, tenant = cust.UserID, cust.Tenant
+		}
+	}
+
+	if item := stripeclient.PlanItem(sub, deps.Catalog.IsPlanPrice); plan == "" && item != nil && item.Price != nil {
+		if p, _, ok := deps.Catalog.PlanForPrice(item.Price.ID); ok {
+			plan = string(p)
+		}
+	}
+
+	return userID, tenant, plan, nil
+}
+
+func handleSubscriptionUpdated(deps *Dependencies, event stripe.Event) error {
 	var sub stripe.Subscription
 	if err := json.Unmarshal(event.Data.Raw, &sub); err != nil {
-		log.Printf("Error unmarshaling subscription: %v", err)
-		return
+		return permanentf("error unmarshaling subscription: %w", err)
 	}
 
 	// Get existing subscription from database
 	existingSub, err := deps.SubRepo.GetByStripeSubscriptionID(sub.ID)
 	if err != nil {
-		log.Printf("Error fetching subscription: %v", err)
-		return
+		return err
 	}
 
 	if existingSub == nil {
-		log.Printf("Subscription not found in database: %s", sub.ID)
-		return
+		return missingDependency(event, "subscription not found in database: %s", sub.ID)
 	}
 
 	// Update subscription
-	// In API v84+, period dates are at subscription item level
-	var periodStart, periodEnd time.Time
-	if len(sub.Items.Data) > 0 {
-		periodStart = time.Unix(sub.Items.Data[0].CurrentPeriodStart, 0)
-		periodEnd = time.Unix(sub.Items.Data[0].CurrentPeriodEnd, 0)
-	}
-
 	existingSub.Status = models.SubscriptionStatus(sub.Status)
-	existingSub.CurrentPeriodStart = &periodStart
-	existingSub.CurrentPeriodEnd = &periodEnd
+	existingSub.CurrentPeriodStart, existingSub.CurrentPeriodEnd = stripeclient.SubscriptionPeriod(&sub, deps.Catalog.IsPlanPrice)
 	existingSub.CancelAtPeriodEnd = sub.CancelAtPeriodEnd
+	existingSub.Quantity = stripeclient.SubscriptionQuantity(&sub, deps.Catalog.IsPlanPrice)
+	existingSub.Currency = string(sub.Currency)
 
 	if err := deps.SubRepo.Update(existingSub); err != nil {
-		log.Printf("Error updating subscription: %v", err)
-		return
+		return err
 	}
 
-	// Get customer email
-	email := getCustomerEmail(sub.Customer.ID)
+	if _, err := syncSubscriptionItems(deps, existingSub.ID, &sub); err != nil {
+		return err
+	}
+
+	if err := recordSubscriptionHistory(deps, event, existingSub, &sub); err != nil {
+		return err
+	}
 
 	// Notify backend
-	notifyBackend(deps, existingSub.UserID, email, string(existingSub.Status), string(existingSub.Plan), sub.ID)
+	eventType := subscriptionUpdateType(event, existingSub)
+	notifyBackend(deps, event.ID, eventType, existingSub)
+
+	// Confirm cancellations scheduled for the period end and their reversal
+	switch eventType {
+	case webhook.EventSubscriptionCancellationScheduled:
+		sendEmail(deps, notify.Canceled, existingSub, notify.Data{PeriodEnd: existingSub.CurrentPeriodEnd})
+	case webhook.EventSubscriptionReactivated:
+		sendEmail(deps, notify.Reactivated, existingSub, notify.Data{PeriodEnd: existingSub.CurrentPeriodEnd})
+	}
 
 	log.Printf("Subscription updated successfully: %s", sub.ID)
+	return nil
 }
 
-func handleSubscriptionDeleted(deps *Dependencies, event stripe.Event) {
+func handleSubscriptionDeleted(deps *Dependencies, event stripe.Event) error {
 	var sub stripe.Subscription
 	if err := json.Unmarshal(event.Data.Raw, &sub); err != nil {
-		log.Printf("Error unmarshaling subscription: %v", err)
-		return
+		return permanentf("error unmarshaling subscription: %w", err)
 	}
 
 	// Get existing subscription from database
 	existingSub, err := deps.SubRepo.GetByStripeSubscriptionID(sub.ID)
 	if err != nil {
-		log.Printf("Error fetching subscription: %v", err)
-		return
+		return err
 	}
 
 	if existingSub == nil {
-		log.Printf("Subscription not found in database: %s", sub.ID)
-		return
+		return missingDependency(event, "subscription not found in database: %s", sub.ID)
 	}
 
-	// Update status to canceled
+	// Update status to canceled; a pending payment action no longer applies
 	existingSub.Status = models.StatusCanceled
+	existingSub.ClearPaymentAction()
 
 	if err := deps.SubRepo.Update(existingSub); err != nil {
-		log.Printf("Error updating subscription: %v", err)
-		return
+		return err
 	}
 
-	// Get customer email
-	email := getCustomerEmail(sub.Customer.ID)
+	if err := recordSubscriptionHistory(deps, event, existingSub, &sub); err != nil {
+		return err
+	}
 
 	// Notify backend
-	notifyBackend(deps, existingSub.UserID, email, "canceled", string(existingSub.Plan), sub.ID)
+	notifyBackend(deps, event.ID, webhook.EventSubscriptionCanceled, existingSub)
+
+	// Cancellations scheduled for the period end were confirmed when scheduled
+	if !sub.CancelAtPeriodEnd {
+		sendEmail(deps, notify.Canceled, existingSub, notify.Data{})
+	}
 
 	log.Printf("Subscription deleted successfully: %s", sub.ID)
+	return nil
+}
+
+// recordSubscriptionHistory stores the state of a subscription after event
+// for MRR and churn analytics
+func recordSubscriptionHistory(deps *Dependencies, event stripe.Event, subscription *models.Subscription, sub *stripe.Subscription) error {
+	return deps.SubscriptionHistoryRepo.Record(&models.SubscriptionHistory{
+		SubscriptionID: subscription.ID,
+		StripeEventID:  &event.ID,
+		UserID:         subscription.UserID,
+		Tenant:         subscription.Tenant,
+		Plan:           subscription.Plan,
+		Status:         subscription.Status,
+		Quantity:       subscription.Quantity,
+		Currency:       subscription.Currency,
+		MRR:            stripeclient.MonthlyRecurringAmount(sub),
+		RecordedAt:     time.Unix(event.Created, 0),
+	})
 }
 
-func handleInvoicePaid(deps *Dependencies, event stripe.Event) {
+func handleInvoicePaid(deps *Dependencies, event stripe.Event) error {
 	var invoice stripe.Invoice
 	if err := json.Unmarshal(event.Data.Raw, &invoice); err != nil {
-		log.Printf("Error unmarshaling invoice: %v", err)
-		return
+		return permanentf("error unmarshaling invoice: %w", err)
 	}
 
+	sub, err := invoiceSubscription(deps, event, &invoice)
+	if err != nil || sub == nil {
+		return err
+	}
+
+	stored, err := saveInvoice(deps, sub, &invoice)
+	if err != nil {
+		return err
+	}
+
+	// Record the payment so disputes and refunds can be matched to the invoice
+	if stored.StripePaymentIntentID == nil && stored.StripeChargeID == nil {
+		paymentIntentID, chargeID, err := deps.StripeClient.GetInvoicePayment(invoice.ID)
+		if err != nil {
+			log.Printf("Error fetching payment for invoice %s: %v", invoice.ID, err)
+		}
+		if paymentIntentID != "" {
+			stored.StripePaymentIntentID = &paymentIntentID
+		}
+		if chargeID != "" {
+			stored.StripeChargeID = &chargeID
+		}
+	}
+
+	// The fee is only needed for accounting exports, which fetch it later
+	// if it is not known yet
+	if stored.StripeFee == nil && stored.StripeChargeID != nil {
+		if err := setInvoiceFee(deps.StripeClient, stored); err != nil {
+			log.Printf("Error fetching fee for invoice %s: %v", invoice.ID, err)
+		}
+	}
+
+	items, err := deps.StripeClient.InvoiceLines(&invoice)
+	if err != nil {
+		return err
+	}
+	stored.Lines = stripeclient.ToInvoiceLines(items)
+
+	if stored.PaidAt == nil {
+		paid := paidAt(event, &invoice)
+		stored.PaidAt = &paid
+	}
+
+	// Paid invoices are numbered in the tenant's sequence for their PDFs
+	if stored.Number == nil {
+		if err := assignInvoiceNumber(deps, stored, *stored.PaidAt); err != nil {
+			return err
+		}
+	}
+
+	stored.PaymentActionRequired = false
+	if err := deps.InvoiceRepo.Update(stored); err != nil {
+		return err
+	}
+
+	if err := scheduleRevenue(deps, event, stored, &invoice, items); err != nil {
+		return err
+	}
+
+	// Paying the invoice completes the payment action it was waiting for
+	if sub.PaymentActionRequired && stringValue(sub.PaymentActionInvoiceID) == invoice.ID {
+		sub.ClearPaymentAction()
+		if err := deps.SubRepo.Update(sub); err != nil {
+			return err
+		}
+
+		notifyBackend(deps, event.ID, webhook.EventSubscriptionUpdated, sub)
+	}
+
+	notifyInvoicePaid(deps, event.ID, sub, stored)
+
+	if stored.AmountPaid > 0 {
+		_, periodEnd := linesPeriod(stored.Lines)
+		sendEmail(deps, notify.Receipt, sub, notify.Data{
+			Amount:        stored.AmountPaid,
+			Currency:      stored.Currency,
+			InvoiceNumber: stringValue(stored.Number),
+			InvoiceURL:    stringValue(stored.HostedInvoiceURL),
+			PeriodEnd:     periodEnd,
+		})
+	}
+
+	log.Printf("Invoice saved successfully: %s", invoice.ID)
+	return nil
+}
+
+// handleInvoicePaymentActionRequired records a renewal waiting for the
+// customer to authenticate the payment (3-D Secure) and sends the backend the
+// hosted invoice page where they can complete it
+func handleInvoicePaymentActionRequired(deps *Dependencies, event stripe.Event) error {
+	var invoice stripe.Invoice
+	if err := json.Unmarshal(event.Data.Raw, &invoice); err != nil {
+		return permanentf("error unmarshaling invoice: %w", err)
+	}
+
+	sub, err := invoiceSubscription(deps, event, &invoice)
+	if err != nil || sub == nil {
+		return err
+	}
+
+	stored, err := saveInvoice(deps, sub, &invoice)
+	if err != nil {
+		return err
+	}
+
+	// A redelivery after the invoice was paid must not reopen the action
+	if stored.Status == models.InvoiceStatusPaid {
+		log.Printf("Invoice %s is already paid, ignoring payment action", invoice.ID)
+		return nil
+	}
+
+	stored.PaymentActionRequired = true
+	if err := deps.InvoiceRepo.Update(stored); err != nil {
+		return err
+	}
+
+	sub.PaymentActionRequired = true
+	sub.PaymentActionInvoiceID = &invoice.ID
+	sub.PaymentActionURL = &invoice.HostedInvoiceURL
+	if err := deps.SubRepo.Update(sub); err != nil {
+		return err
+	}
+
+	notifyBackend(deps, event.ID, webhook.EventSubscriptionPaymentActionRequired, sub)
+
+	log.Printf("Invoice %s requires payment action", invoice.ID)
+	return nil
+}
+
+// notifyInvoicePaid tells the backend that a subscription invoice was paid
+func notifyInvoicePaid(deps *Dependencies, source string, sub *models.Subscription, invoice *models.Invoice) {
+	data := webhook.InvoiceData{
+		UserID:           invoice.UserID,
+		InvoiceID:        invoice.StripeInvoiceID,
+		SubscriptionID:   sub.StripeSubscriptionID,
+		Number:           stringValue(invoice.Number),
+		AmountPaid:       invoice.AmountPaid,
+		Subtotal:         invoice.Subtotal,
+		Tax:              invoice.Tax,
+		Total:            invoice.Total,
+		Currency:         invoice.Currency,
+		HostedInvoiceURL: stringValue(invoice.HostedInvoiceURL),
+	}
+	data.PeriodStart, data.PeriodEnd = linesPeriod(invoice.Lines)
+
+	if err := deps.WebhookClient.Notify(webhook.NewEvent(source, webhook.EventInvoicePaid, invoice.Tenant, data)); err != nil {
+		log.Printf("Error notifying backend: %v", err)
+	}
+}
+
+// invoiceSubscription returns the stored subscription an invoice bills, or
+// nil if the invoice is not for a subscription
+func invoiceSubscription(deps *Dependencies, event stripe.Event, invoice *stripe.Invoice) (*models.Subscription, error) {
 	// In API v84+, subscription is in invoice.Parent.SubscriptionDetails.Subscription
 	if invoice.Parent == nil || invoice.Parent.SubscriptionDetails == nil || invoice.Parent.SubscriptionDetails.Subscription == nil {
 		log.Printf("Invoice %s is not associated with a subscription", invoice.ID)
-		return
+		return nil, nil
 	}
 
 	subscriptionID := invoice.Parent.SubscriptionDetails.Subscription.ID
 	if subscriptionID == "" {
 		log.Printf("No subscription ID found for invoice: %s", invoice.ID)
-		return
+		return nil, nil
 	}
 
 	// Get subscription from database
 	sub, err := deps.SubRepo.GetByStripeSubscriptionID(subscriptionID)
 	if err != nil {
-		log.Printf("Error fetching subscription: %v", err)
-		return
+		return nil, err
 	}
 
 	if sub == nil {
-		log.Printf("Subscription not found for invoice: %s", invoice.ID)
-		return
+		return nil, missingDependency(event, "subscription not found for invoice: %s", invoice.ID)
 	}
 
-	// Check if invoice already exists
-	existingInvoice, err := deps.InvoiceRepo.GetByStripeInvoiceID(invoice.ID)
+	return sub, nil
+}
+
+// saveInvoice creates or updates the stored copy of a Stripe invoice. An
+// invoice is first stored by whichever event arrives first, e.g.
+// invoice.payment_action_required before invoice.paid.
+func saveInvoice(deps *Dependencies, sub *models.Subscription, invoice *stripe.Invoice) (*models.Invoice, error) {
+	stored, err := deps.InvoiceRepo.GetByStripeInvoiceID(invoice.ID)
 	if err != nil {
-		log.Printf("Error checking existing invoice: %v", err)
-		return
+		return nil, err
 	}
 
-	if existingInvoice != nil {
-		log.Printf("Invoice already exists: %s", invoice.ID)
-		return
+	if stored != nil {
+		// A late event must not move a paid invoice back to open
+		if stored.Status != models.InvoiceStatusPaid {
+			stored.Status = models.InvoiceStatus(invoice.Status)
+		}
+		stored.AmountPaid = invoice.AmountPaid
+		stored.InvoicePDF = &invoice.InvoicePDF
+		stored.HostedInvoiceURL = &invoice.HostedInvoiceURL
+		setInvoiceTax(deps, stored, invoice)
+		stripeclient.SetInvoiceCustomer(stored, invoice)
+		return stored, nil
 	}
 
-	// Save invoice to database
 	var periodStart, periodEnd *time.Time
 	if invoice.PeriodStart > 0 {
 		t := time.Unix(invoice.PeriodStart, 0)
@@ -279,7 +822,7 @@ func handleInvoicePaid(deps *Dependencies, event stripe.Event) {
 		periodEnd = &t
 	}
 
-	newInvoice := &models.Invoice{
+	stored = &models.Invoice{
 		SubscriptionID:   sub.ID,
 		StripeInvoiceID:  invoice.ID,
 		UserID:           sub.UserID,
@@ -292,55 +835,232 @@ func handleInvoicePaid(deps *Dependencies, event stripe.Event) {
 		PeriodStart:      periodStart,
 		PeriodEnd:        periodEnd,
 	}
+	setInvoiceTax(deps, stored, invoice)
+	stripeclient.SetInvoiceCustomer(stored, invoice)
 
-	if err := deps.InvoiceRepo.Create(newInvoice); err != nil {
-		log.Printf("Error creating invoice: %v", err)
-		return
+	if err := deps.InvoiceRepo.Create(stored); err != nil {
+		return nil, err
 	}
 
-	log.Printf("Invoice saved successfully: %s", invoice.ID)
+	return stored, nil
 }
 
-func handleInvoicePaymentFailed(event stripe.Event) {
+// setInvoiceFee sets the Stripe fee of the charge that paid an invoice, if
+// its balance transaction exists
+func setInvoiceFee(stripeClient *stripeclient.Client, invoice *models.Invoice) error {
+	fee, currency, ok, err := stripeClient.GetChargeFee(*invoice.StripeChargeID)
+	if err != nil || !ok {
+		return err
+	}
+
+	invoice.StripeFee = &fee
+	invoice.StripeFeeCurrency = &currency
+	return nil
+}
+
+// setInvoiceTax copies the tax of a Stripe invoice, naming its tax rates.
+// The amounts are stored even if the rates cannot be fetched.
+func setInvoiceTax(deps *Dependencies, stored *models.Invoice, invoice *stripe.Invoice) {
+	stripeclient.SetInvoiceTax(stored, invoice)
+	if err := deps.StripeClient.DescribeTaxes(stored.TaxBreakdown); err != nil {
+		log.Printf("Error describing taxes of invoice %s: %v", invoice.ID, err)
+	}
+}
+
+// handleInvoicePaymentFailed asks the customer to update their payment
+// method after a subscription payment failed
+func handleInvoicePaymentFailed(deps *Dependencies, event stripe.Event) error {
 	var invoice stripe.Invoice
 	if err := json.Unmarshal(event.Data.Raw, &invoice); err != nil {
-		log.Printf("Error unmarshaling invoice: %v", err)
-		return
+		return permanentf("error unmarshaling invoice: %w", err)
 	}
 
 	log.Printf("Invoice payment failed: %s", invoice.ID)
-}
 
-func notifyBackend(deps *Dependencies, userID, email, status, plan, subscriptionID string) {
-	// Obtener subscription completa de la DB para enviar todos los datos
-	sub, err := deps.SubRepo.GetByStripeSubscriptionID(subscriptionID)
+	sub, err := invoiceSubscription(deps, event, &invoice)
 	if err != nil || sub == nil {
-		log.Printf("Error fetching subscription for webhook: %v", err)
-		// Fallback: enviar sin period data
-		payload := webhook.SubscriptionWebhookPayload{
-			UserID:         userID,
-			Email:          email,
-			Status:         status,
-			Plan:           plan,
-			SubscriptionID: subscriptionID,
+		return err
+	}
+
+	failed := webhook.PaymentFailedData{
+		UserID:           sub.UserID,
+		InvoiceID:        invoice.ID,
+		SubscriptionID:   sub.StripeSubscriptionID,
+		AmountDue:        invoice.AmountDue,
+		Currency:         string(invoice.Currency),
+		AttemptCount:     invoice.AttemptCount,
+		HostedInvoiceURL: invoice.HostedInvoiceURL,
+	}
+	if invoice.NextPaymentAttempt > 0 {
+		t := time.Unix(invoice.NextPaymentAttempt, 0)
+		failed.NextPaymentAttempt = &t
+	}
+	if err := deps.WebhookClient.Notify(webhook.NewEvent(event.ID, webhook.EventPaymentFailed, sub.Tenant, failed)); err != nil {
+		log.Printf("Error notifying backend: %v", err)
+	}
+
+	data := notify.Data{
+		Amount:     invoice.AmountDue,
+		Currency:   string(invoice.Currency),
+		InvoiceURL: invoice.HostedInvoiceURL,
+	}
+	data.NextAttempt = failed.NextPaymentAttempt
+	sendEmail(deps, notify.PaymentFailed, sub, data)
+
+	return nil
+}
+
+// handleTrialWillEnd reminds the customer that their trial converts to a
+// paid subscription, three days before it ends
+func handleTrialWillEnd(deps *Dependencies, event stripe.Event) error {
+	var sub stripe.Subscription
+	if err := json.Unmarshal(event.Data.Raw, &sub); err != nil {
+		return permanentf("error unmarshaling subscription: %w", err)
+	}
+
+	existingSub, err := deps.SubRepo.GetByStripeSubscriptionID(sub.ID)
+	if err != nil {
+		return err
+	}
+
+	if existingSub == nil {
+		return missingDependency(event, "subscription not found in database: %s", sub.ID)
+	}
+
+	// A trial that was ended early or canceled needs no reminder
+	if sub.Status != stripe.SubscriptionStatusTrialing || sub.TrialEnd == 0 || sub.CancelAtPeriodEnd {
+		return nil
+	}
+
+	trialEnd := time.Unix(sub.TrialEnd, 0)
+	sendEmail(deps, notify.TrialEnding, existingSub, notify.Data{TrialEnd: &trialEnd})
+
+	log.Printf("Trial ending reminder processed: %s", sub.ID)
+	return nil
+}
+
+func handleCustomerUpdated(deps *Dependencies, event stripe.Event) error {
+	var cust stripe.Customer
+	if err := json.Unmarshal(event.Data.Raw, &cust); err != nil {
+		return permanentf("error unmarshaling customer: %w", err)
+	}
+
+	return syncCustomer(deps, &cust)
+}
+
+// syncCustomer stores the email and name of a Stripe customer in the local
+// cache, mapping customers created outside checkout from their metadata
+func syncCustomer(deps *Dependencies, cust *stripe.Customer) error {
+	existing, err := deps.CustomerRepo.GetByStripeCustomerID(cust.ID)
+	if err != nil {
+		return err
+	}
+
+	if existing == nil {
+		userID, tenant := cust.Metadata["user_id"], cust.Metadata["tenant"]
+		if userID == "" || tenant == "" {
+			log.Printf("Customer %s is not mapped to a user, skipping", cust.ID)
+			return nil
+		}
+
+		newCustomer := &models.Customer{
+			UserID:           userID,
+			Tenant:           tenant,
+			StripeCustomerID: cust.ID,
+			Email:            cust.Email,
+			Name:             cust.Name,
+		}
+		created, err := deps.CustomerRepo.Create(newCustomer)
+		if err != nil {
+			return err
+		}
+
+		// Duplicate customers of a user keep the mapping made first, which
+		// is the one checkout uses
+		if !created {
+			log.Printf("User %s of tenant %s is already mapped to another customer, keeping it instead of %s", userID, tenant, cust.ID)
+			return nil
 		}
-		_ = deps.WebhookClient.NotifySubscriptionChange(payload)
-		return
+
+		log.Printf("Customer mapped successfully: %s", cust.ID)
+		return nil
+	}
+
+	existing.Email = cust.Email
+	existing.Name = cust.Name
+	if err := deps.CustomerRepo.Update(existing); err != nil {
+		return err
+	}
+
+	log.Printf("Customer updated successfully: %s", cust.ID)
+	return nil
+}
+
+func handleCustomerDeleted(deps *Dependencies, event stripe.Event) error {
+	var cust stripe.Customer
+	if err := json.Unmarshal(event.Data.Raw, &cust); err != nil {
+		return permanentf("error unmarshaling customer: %w", err)
 	}
 
-	// Enviar payload completo con todos los datos
-	payload := webhook.SubscriptionWebhookPayload{
-		UserID:             userID,
-		Email:              email,
-		Status:             status,
-		Plan:               plan,
-		SubscriptionID:     subscriptionID,
-		CurrentPeriodStart: sub.CurrentPeriodStart,
-		CurrentPeriodEnd:   sub.CurrentPeriodEnd,
-		CancelAtPeriodEnd:  sub.CancelAtPeriodEnd,
+	// Drop the mapping so the next checkout provisions a fresh customer
+	if err := deps.CustomerRepo.DeleteByStripeCustomerID(cust.ID); err != nil {
+		return err
 	}
 
-	if err := deps.WebhookClient.NotifySubscriptionChange(payload); err != nil {
+	log.Printf("Customer deleted successfully: %s", cust.ID)
+	return nil
+}
+
+// notifyBackend sends the state of a subscription to the backend as an
+// event of eventType. source is the ID of the Stripe event that changed the
+// subscription, or empty for changes requested through the API.
+func notifyBackend(deps *Dependencies, source string, eventType webhook.EventType, sub *models.Subscription) {
+	event := webhook.NewEvent(source, eventType, sub.Tenant, subscriptionData(deps, sub))
+	if err := deps.WebhookClient.Notify(event); err != nil {
 		log.Printf("Error notifying backend: %v", err)
 	}
 }
+
+// subscriptionData returns the state of a subscription sent to the backend
+func subscriptionData(deps *Dependencies, sub *models.Subscription) webhook.SubscriptionData {
+	data := webhook.SubscriptionData{
+		UserID:                sub.UserID,
+		Email:                 getCustomerEmail(deps, sub.StripeCustomerID),
+		Status:                string(sub.Status),
+		Plan:                  string(sub.Plan),
+		SubscriptionID:        sub.StripeSubscriptionID,
+		CurrentPeriodStart:    sub.CurrentPeriodStart,
+		CurrentPeriodEnd:      sub.CurrentPeriodEnd,
+		CancelAtPeriodEnd:     sub.CancelAtPeriodEnd,
+		Quantity:              sub.Quantity,
+		Currency:              sub.Currency,
+		PaymentActionRequired: sub.PaymentActionRequired,
+		PaymentActionURL:      stringValue(sub.PaymentActionURL),
+	}
+
+	items, err := deps.SubscriptionItemRepo.GetBySubscriptionID(sub.ID)
+	if err != nil {
+		log.Printf("Error fetching subscription items for webhook: %v", err)
+	}
+	for _, item := range items {
+		if item.Kind == models.SubscriptionItemKindAddon && item.Addon != nil {
+			data.Addons = append(data.Addons, *item.Addon)
+		}
+	}
+
+	return data
+}
+
+// subscriptionUpdateType returns the event type of a subscription update:
+// scheduling or undoing a cancellation at the period end, or any other
+// change. It is read from the Stripe event so that changes made in the
+// dashboard or customer portal are told apart too.
+func subscriptionUpdateType(event stripe.Event, sub *models.Subscription) webhook.EventType {
+	if _, changed := event.Data.PreviousAttributes["cancel_at_period_end"]; !changed {
+		return webhook.EventSubscriptionUpdated
+	}
+	if sub.CancelAtPeriodEnd {
+		return webhook.EventSubscriptionCancellationScheduled
+	}
+	return webhook.EventSubscriptionReactivated
+}
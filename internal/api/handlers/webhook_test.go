@@ -0,0 +1,227 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/lib/pq"
+	"github.com/naventro/payment-service/internal/config"
+	"github.com/naventro/payment-service/internal/metrics"
+	"github.com/naventro/payment-service/internal/repository"
+	"github.com/stripe/stripe-go/v84"
+	stripewebhook "github.com/stripe/stripe-go/v84/webhook"
+)
+
+const testWebhookSecret = "whsec_test"
+
+func TestWebhookHandlerFailures(t *testing.T) {
+	eventHandlers["test.retryable"] = func(*Dependencies, stripe.Event) error {
+		return errors.New("connection reset")
+	}
+	eventHandlers["test.permanent"] = func(*Dependencies, stripe.Event) error {
+		return permanentf("missing metadata")
+	}
+	eventHandlers["test.ok"] = func(*Dependencies, stripe.Event) error {
+		return nil
+	}
+	t.Cleanup(func() {
+		delete(eventHandlers, "test.retryable")
+		delete(eventHandlers, "test.permanent")
+		delete(eventHandlers, "test.ok")
+	})
+
+	tests := []struct {
+		eventType  string
+		wantStatus int
+		wantResult string
+	}{
+		{"test.retryable", fiber.StatusInternalServerError, "failed"},
+		{"test.permanent", fiber.StatusOK, "failed"},
+		{"test.ok", fiber.StatusOK, "processed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.eventType, func(t *testing.T) {
+			db := &fakeEventDB{}
+			resp := postEvent(t, db, tt.eventType, `{}`)
+
+			if resp.StatusCode != tt.wantStatus {
+				body, _ := io.ReadAll(resp.Body)
+				t.Fatalf("status = %d, want %d: %s", resp.StatusCode, tt.wantStatus, body)
+			}
+			if got := db.markedStatus(); got != tt.wantResult {
+				t.Errorf("event marked %q, want %q", got, tt.wantResult)
+			}
+		})
+	}
+}
+
+func TestWebhookHandlerResultNotRecorded(t *testing.T) {
+	eventHandlers["test.ok"] = func(*Dependencies, stripe.Event) error {
+		return nil
+	}
+	t.Cleanup(func() { delete(eventHandlers, "test.ok") })
+
+	db := &fakeEventDB{failMark: true}
+	resp := postEvent(t, db, "test.ok", `{}`)
+
+	// The event stays claimed; Stripe must deliver it again
+	if resp.StatusCode != fiber.StatusInternalServerError {
+		t.Errorf("status = %d, want %d", resp.StatusCode, fiber.StatusInternalServerError)
+	}
+}
+
+func TestWebhookHandlerCustomerAlreadyMapped(t *testing.T) {
+	// The user is mapped to another Stripe customer: Postgres rejects a
+	// plain insert and returns no row for one that skips conflicts
+	inserted := false
+	db := &fakeEventDB{query: func(query string) (driver.Rows, error) {
+		if !strings.Contains(query, "INSERT INTO customers") {
+			return nil, nil
+		}
+		inserted = true
+		if !strings.Contains(query, "ON CONFLICT (user_id, tenant) DO NOTHING") {
+			return nil, &pq.Error{Code: "23505", Message: "duplicate key value violates unique constraint"}
+		}
+		return &fakeRows{}, nil
+	}}
+
+	resp := postEvent(t, db, "customer.created", `{"id":"cus_duplicate","object":"customer","metadata":{"user_id":"user_1","tenant":"menuum"}}`)
+
+	if resp.StatusCode != fiber.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		t.Fatalf("status = %d, want %d: %s", resp.StatusCode, fiber.StatusOK, body)
+	}
+	if !inserted {
+		t.Error("customer mapping was not inserted")
+	}
+	if got := db.markedStatus(); got != "processed" {
+		t.Errorf("event marked %q, want %q", got, "processed")
+	}
+}
+
+// postEvent sends a signed event of eventType with object as its data to the
+// webhook handler
+func postEvent(t *testing.T, db *fakeEventDB, eventType, object string) *http.Response {
+	t.Helper()
+
+	conn := sql.OpenDB(db)
+	deps := &Dependencies{
+		Config:       &config.Config{StripeWebhookSecrets: []string{testWebhookSecret}},
+		EventRepo:    repository.NewEventRepository(conn),
+		CustomerRepo: repository.NewCustomerRepository(conn),
+		Metrics:      metrics.NewRegistry(),
+	}
+
+	app := fiber.New()
+	app.Post("/payments/webhook", NewWebhookHandler(deps))
+
+	payload := fmt.Sprintf(
+		`{"id":"evt_test","object":"event","type":%q,"api_version":%q,"created":%d,"data":{"object":%s}}`,
+		eventType, stripe.APIVersion, time.Now().Unix(), object,
+	)
+	signed := stripewebhook.GenerateTestSignedPayload(&stripewebhook.UnsignedPayload{
+		Payload: []byte(payload),
+		Secret:  testWebhookSecret,
+	})
+
+	req := httptest.NewRequest("POST", "/payments/webhook", strings.NewReader(payload))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Stripe-Signature", signed.Header)
+
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("app.Test: %v", err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+
+	return resp
+}
+
+// fakeEventDB is a database/sql connector storing a new event on every
+// insert and recording the status events are marked with. Other queries
+// return no rows unless query answers them.
+type fakeEventDB struct {
+	mu       sync.Mutex
+	marked   []string
+	failMark bool
+	query    func(query string) (driver.Rows, error)
+}
+
+func (db *fakeEventDB) markedStatus() string {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if len(db.marked) == 0 {
+		return ""
+	}
+	return db.marked[len(db.marked)-1]
+}
+
+func (db *fakeEventDB) Connect(context.Context) (driver.Conn, error) { return fakeConn{db}, nil }
+func (db *fakeEventDB) Driver() driver.Driver                        { return nil }
+
+type fakeConn struct{ db *fakeEventDB }
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) { return fakeStmt{c.db, query}, nil }
+func (c fakeConn) Close() error                              { return nil }
+func (c fakeConn) Begin() (driver.Tx, error)                 { return nil, errors.New("transactions not supported") }
+
+type fakeStmt struct {
+	db    *fakeEventDB
+	query string
+}
+
+func (s fakeStmt) Close() error  { return nil }
+func (s fakeStmt) NumInput() int { return -1 }
+
+func (s fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	if strings.Contains(s.query, "processed_at = CURRENT_TIMESTAMP") {
+		if s.db.failMark {
+			return nil, errors.New("connection lost")
+		}
+		s.db.mu.Lock()
+		s.db.marked = append(s.db.marked, args[0].(string))
+		s.db.mu.Unlock()
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (s fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	if strings.Contains(s.query, "INSERT INTO stripe_events") {
+		return &fakeRows{columns: []string{"id", "received_at"}, values: [][]driver.Value{{int64(1), time.Now()}}}, nil
+	}
+	if s.db.query != nil {
+		if rows, err := s.db.query(s.query); rows != nil || err != nil {
+			return rows, err
+		}
+	}
+	return &fakeRows{}, nil
+}
+
+type fakeRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
@@ -0,0 +1,41 @@
+package routes
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/naventro/payment-service/internal/api/handlers"
+	"github.com/naventro/payment-service/internal/api/middleware"
+)
+
+// setupAdminRoutes registers operator routes (require the admin API key, no tenant header)
+func setupAdminRoutes(router fiber.Router, deps *handlers.Dependencies) {
+	// Admin routes are only exposed when an admin key is configured
+	if deps.Config.AdminAPIKey == "" {
+		return
+	}
+
+	admin := router.Group("/admin", middleware.NewKeyAuthMiddleware(deps.Config.AdminAPIKey))
+
+	// Stored Stripe events
+	admin.Get("/events", handlers.NewListEventsHandler(deps))
+	admin.Get("/events/:eventID", handlers.NewGetEventHandler(deps))
+	admin.Post("/events/replay", handlers.NewReplayEventsHandler(deps))
+
+	// Disputes across tenants
+	admin.Get("/disputes", handlers.NewListDisputesHandler(deps))
+	admin.Get("/disputes/:disputeID", handlers.NewGetDisputeHandler(deps))
+
+	// Revenue per currency across tenants
+	admin.Get("/revenue", handlers.NewRevenueHandler(deps))
+
+	// Recognized and deferred revenue per tenant and month
+	admin.Get("/revenue/recognition", handlers.NewRevenueRecognitionHandler(deps))
+
+	// MRR, churn and retention per tenant and plan
+	admin.Get("/analytics", handlers.NewAnalyticsHandler(deps))
+
+	// Accounting exports: invoices, refunds, disputes and the journal as CSV or JSON
+	admin.Get("/exports/:dataset", handlers.NewExportHandler(deps))
+
+	// Service counters, e.g. which webhook signing secret matched
+	admin.Get("/metrics", handlers.NewMetricsHandler(deps))
+}
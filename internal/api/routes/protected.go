@@ -16,9 +16,41 @@ func setupProtectedRoutes(router fiber.Router, deps *handlers.Dependencies) {
 
 	// Checkout endpoint
 	protected.Post("/checkout", handlers.NewCheckoutHandler(deps))
+	protected.Get("/checkout/:sessionID", handlers.NewCheckoutStatusHandler(deps))
+
+	// Plans and their prices per currency
+	protected.Get("/plans", handlers.NewPlansHandler(deps))
+
+	// One-time purchases
+	protected.Get("/products", handlers.NewProductsHandler(deps))
+	protected.Get("/orders/:userID", handlers.NewOrdersHandler(deps))
 
 	// Subscription endpoints
 	protected.Get("/subscription/:userID", handlers.NewSubscriptionHandler(deps))
+	protected.Post("/subscription/:userID/quantity", handlers.NewQuantityHandler(deps))
+
+	// Subscription add-ons
+	protected.Get("/addons", handlers.NewAddonsHandler(deps))
+	protected.Post("/subscription/:userID/addons", handlers.NewAttachAddonHandler(deps))
+	protected.Delete("/subscription/:userID/addons/:addon", handlers.NewDetachAddonHandler(deps))
+
+	// Metered usage
+	protected.Post("/usage", handlers.NewRecordUsageHandler(deps))
+	protected.Get("/usage/:userID", handlers.NewUsageHandler(deps))
+
+	// Entitlements endpoint
+	protected.Get("/entitlements/:userID", handlers.NewEntitlementsHandler(deps))
+
+	// Invoice history, refunds and PDFs
+	protected.Get("/invoices/:userID", handlers.NewInvoicesHandler(deps))
+	protected.Post("/invoices/:invoiceID/refund", handlers.NewRefundHandler(deps))
+	protected.Get("/invoices/:invoiceID/pdf", handlers.NewInvoicePDFHandler(deps))
+
+	// Saved payment methods
+	protected.Get("/payment-methods/:userID", handlers.NewListPaymentMethodsHandler(deps))
+	protected.Post("/payment-methods/:userID/setup-intent", handlers.NewSetupIntentHandler(deps))
+	protected.Post("/payment-methods/:userID/default", handlers.NewSetDefaultPaymentMethodHandler(deps))
+	protected.Delete("/payment-methods/:userID/:paymentMethodID", handlers.NewDetachPaymentMethodHandler(deps))
 
 	// Cancel endpoint
 	protected.Post("/cancel/:userID", handlers.NewCancelHandler(deps))
@@ -10,6 +10,10 @@ func setupPublicRoutes(router fiber.Router, deps *handlers.Dependencies) {
 	// Health check endpoint
 	router.Get("/health", handlers.NewHealthHandler(deps))
 
+	// JSON Schema of the events sent to the backend
+	router.Get("/events/schema", handlers.NewEventSchemaHandler(deps))
+
 	// Stripe webhook endpoint (signature-verified)
 	router.Post("/webhook", handlers.NewWebhookHandler(deps))
+	router.Post("/webhook/:tenant", handlers.NewWebhookHandler(deps))
 }
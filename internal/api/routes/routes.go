@@ -13,6 +13,10 @@ func Setup(app *fiber.App, deps *handlers.Dependencies) {
 	// Register public routes
 	setupPublicRoutes(payments, deps)
 
+	// Register admin routes (before the protected group, whose middleware
+	// would otherwise run first)
+	setupAdminRoutes(payments, deps)
+
 	// Register protected routes
 	setupProtectedRoutes(payments, deps)
 }
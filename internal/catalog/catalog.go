@@ -0,0 +1,476 @@
+package catalog
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/naventro/payment-service/internal/models"
+)
+
+// DefaultCurrency is the currency of the default prices unless CATALOG_FILE
+// sets another one
+const DefaultCurrency = "usd"
+
+// Plan is a subscription plan with a Stripe price per currency it is sold in
+type Plan struct {
+	Key  models.Plan `json:"key"`
+	Name string      `json:"name"`
+	// Prices maps a lowercase ISO 4217 currency code to a Stripe Price ID
+	Prices map[string]string `json:"prices"`
+}
+
+// Currencies returns the currencies the plan is sold in, sorted
+func (p Plan) Currencies() []string {
+	currencies := make([]string, 0, len(p.Prices))
+	for currency := range p.Prices {
+		currencies = append(currencies, currency)
+	}
+	sort.Strings(currencies)
+	return currencies
+}
+
+// Product is an item sold with a one-time payment
+type Product struct {
+	Key     string `json:"key"`
+	Name    string `json:"name"`
+	PriceID string `json:"price_id"`
+	// Lifetime products grant an entitlement named after the product that
+	// never expires, e.g. a lifetime premium plan
+	Lifetime bool `json:"lifetime"`
+}
+
+// Addon is an optional recurring price billed as an extra item of a
+// subscription, e.g. extra menu languages or a custom domain
+type Addon struct {
+	Key  string `json:"key"`
+	Name string `json:"name"`
+	// PriceID is the price in the catalog currency
+	PriceID string `json:"price_id"`
+	// Prices holds the Stripe Price IDs in other currencies, keyed by currency
+	Prices map[string]string `json:"prices,omitempty"`
+}
+
+// Meter is a usage-based charge billed on top of a subscription plan, e.g.
+// online orders processed above a free tier
+type Meter struct {
+	Key  string `json:"key"`
+	Name string `json:"name"`
+	// EventName is the event_name of the Stripe billing meter usage is reported to
+	EventName string `json:"event_name"`
+	// PriceID is the metered Stripe price added to subscriptions of Plans
+	// in the catalog currency
+	PriceID string `json:"price_id"`
+	// Prices holds the metered Stripe Price IDs in other currencies, keyed by currency
+	Prices map[string]string `json:"prices,omitempty"`
+	// FreeUnits are included in every billing period. The Stripe price is
+	// expected to be graduated with a free first tier of the same size.
+	FreeUnits int64         `json:"free_units"`
+	Plans     []models.Plan `json:"plans"`
+}
+
+// HasPlan reports whether subscriptions to plan are billed for the meter
+func (m Meter) HasPlan(plan models.Plan) bool {
+	for _, p := range m.Plans {
+		if p == plan {
+			return true
+		}
+	}
+	return false
+}
+
+// Catalog lists the subscription plans and their prices per currency, the
+// products that can be bought through checkout, the add-ons that can be
+// attached to subscriptions and the metered charges of subscription plans
+type Catalog struct {
+	// currency is charged when a checkout sets neither a currency nor a
+	// country with a currency the plan is sold in
+	currency string
+	// countryCurrencies maps an ISO 3166 alpha-2 country code to the
+	// currency its customers are charged in
+	countryCurrencies map[string]string
+	plans             map[models.Plan]Plan
+	products          map[string]Product
+	addons            map[string]Addon
+	meters            map[string]Meter
+}
+
+// defaultPlans are sold in the default currency. CATALOG_FILE can add prices
+// in other currencies.
+var defaultPlans = []Plan{
+	{
+		Key:  models.PlanPremiumMonthly,
+		Name: "Premium monthly",
+		// Replace with actual Stripe Price ID for monthly plan
+		Prices: map[string]string{DefaultCurrency: "price_1SqQiMEOzQkrhqSSgh3KVRps"},
+	},
+	{
+		Key:  models.PlanPremiumYearly,
+		Name: "Premium yearly",
+		// Replace with actual Stripe Price ID for yearly plan
+		Prices: map[string]string{DefaultCurrency: "price_1SqQjcEOzQkrhqSSLqO5rDdb"},
+	},
+}
+
+// defaultCountryCurrencies pick the local currency for the countries the
+// service sells in. Countries without an entry, or whose currency a plan is
+// not sold in, are charged in the catalog currency.
+var defaultCountryCurrencies = map[string]string{
+	"AR": "ars", "BR": "brl", "CL": "clp", "CO": "cop", "MX": "mxn", "PE": "pen", "UY": "uyu",
+	"GB": "gbp",
+	"AT": "eur", "BE": "eur", "DE": "eur", "ES": "eur", "FI": "eur", "FR": "eur",
+	"IE": "eur", "IT": "eur", "NL": "eur", "PT": "eur",
+}
+
+// defaultProducts are available unless CATALOG_FILE replaces them
+var defaultProducts = []Product{
+	{
+		Key:  "menu_design_pack",
+		Name: "Menu design pack",
+		// Replace with actual Stripe Price ID
+		PriceID: "price_1SqQkREOzQkrhqSSmDsgnPck",
+	},
+	{
+		Key:  "setup_fee",
+		Name: "Setup fee",
+		// Replace with actual Stripe Price ID
+		PriceID: "price_1SqQl8EOzQkrhqSSaX0pJ3tw",
+	},
+	{
+		Key:  "premium_lifetime",
+		Name: "Premium lifetime",
+		// Replace with actual Stripe Price ID
+		PriceID:  "price_1SqQlnEOzQkrhqSSvB7cYq2h",
+		Lifetime: true,
+	},
+}
+
+// defaultAddons can be attached unless CATALOG_FILE replaces them
+var defaultAddons = []Addon{
+	{
+		Key:  "extra_languages",
+		Name: "Extra languages",
+		// Replace with actual Stripe Price ID
+		PriceID: "price_1SqQn4EOzQkrhqSSbZ7tLw2e",
+	},
+	{
+		Key:  "custom_domain",
+		Name: "Custom domain",
+		// Replace with actual Stripe Price ID
+		PriceID: "price_1SqQnhEOzQkrhqSSy1FfGk9r",
+	},
+}
+
+// defaultMeters are billed unless CATALOG_FILE replaces them
+var defaultMeters = []Meter{
+	{
+		Key:       "online_orders",
+		Name:      "Online orders",
+		EventName: "online_orders",
+		// Replace with actual Stripe Price ID
+		PriceID:   "price_1SqQmPEOzQkrhqSSd4WnUe8K",
+		FreeUnits: 100,
+		Plans:     []models.Plan{models.PlanPremiumMonthly, models.PlanPremiumYearly},
+	},
+}
+
+// configFile is the layout of CATALOG_FILE, keyed by plan, product, add-on and meter
+type configFile struct {
+	Currency          string               `json:"currency"`
+	CountryCurrencies map[string]string    `json:"country_currencies"`
+	Plans             map[models.Plan]Plan `json:"plans"`
+	Products          map[string]Product   `json:"products"`
+	Addons            map[string]Addon     `json:"addons"`
+	Meters            map[string]Meter     `json:"meters"`
+}
+
+// Load returns the default catalog with the products, add-ons and meters in the JSON
+// file at path added or replacing defaults with the same key. Plan prices and
+// country currencies in the file are merged into the defaults one currency or
+// country at a time. An empty path returns the defaults.
+func Load(path string) (*Catalog, error) {
+	c := &Catalog{
+		currency:          DefaultCurrency,
+		countryCurrencies: make(map[string]string),
+		plans:             make(map[models.Plan]Plan),
+		products:          make(map[string]Product),
+		addons:            make(map[string]Addon),
+		meters:            make(map[string]Meter),
+	}
+	for country, currency := range defaultCountryCurrencies {
+		c.countryCurrencies[country] = currency
+	}
+	for _, plan := range defaultPlans {
+		prices := make(map[string]string, len(plan.Prices))
+		for currency, priceID := range plan.Prices {
+			prices[currency] = priceID
+		}
+		plan.Prices = prices
+		c.plans[plan.Key] = plan
+	}
+	for _, product := range defaultProducts {
+		c.products[product.Key] = product
+	}
+	for _, addon := range defaultAddons {
+		c.addons[addon.Key] = addon
+	}
+	for _, meter := range defaultMeters {
+		c.meters[meter.Key] = meter
+	}
+
+	if path == "" {
+		return c, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading catalog: %w", err)
+	}
+
+	var file configFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("error parsing catalog: %w", err)
+	}
+
+	if file.Currency != "" {
+		c.currency = strings.ToLower(file.Currency)
+	}
+
+	for country, currency := range file.CountryCurrencies {
+		c.countryCurrencies[strings.ToUpper(country)] = strings.ToLower(currency)
+	}
+
+	for key, plan := range file.Plans {
+		existing, ok := c.plans[key]
+		if !ok {
+			return nil, fmt.Errorf("catalog plan %q is not a known plan", key)
+		}
+		if plan.Name != "" {
+			existing.Name = plan.Name
+		}
+		for currency, priceID := range plan.Prices {
+			if priceID == "" {
+				return nil, fmt.Errorf("catalog plan %q has no price for %q", key, currency)
+			}
+			existing.Prices[strings.ToLower(currency)] = priceID
+		}
+		c.plans[key] = existing
+	}
+
+	for key, product := range file.Products {
+		if product.PriceID == "" {
+			return nil, fmt.Errorf("catalog product %q has no price_id", key)
+		}
+		product.Key = key
+		c.products[key] = product
+	}
+
+	for key, addon := range file.Addons {
+		if addon.PriceID == "" {
+			return nil, fmt.Errorf("catalog add-on %q has no price_id", key)
+		}
+		addon.Key = key
+		addon.Prices = lowerKeys(addon.Prices)
+		c.addons[key] = addon
+	}
+
+	for key, meter := range file.Meters {
+		if meter.PriceID == "" || meter.EventName == "" {
+			return nil, fmt.Errorf("catalog meter %q needs a price_id and an event_name", key)
+		}
+		for _, plan := range meter.Plans {
+			if !plan.IsValid() {
+				return nil, fmt.Errorf("catalog meter %q has invalid plan %q", key, plan)
+			}
+		}
+		meter.Key = key
+		meter.Prices = lowerKeys(meter.Prices)
+		c.meters[key] = meter
+	}
+
+	return c, nil
+}
+
+func lowerKeys(prices map[string]string) map[string]string {
+	if prices == nil {
+		return nil
+	}
+	lowered := make(map[string]string, len(prices))
+	for currency, priceID := range prices {
+		lowered[strings.ToLower(currency)] = priceID
+	}
+	return lowered
+}
+
+// Currency returns the currency charged when no other one applies
+func (c *Catalog) Currency() string {
+	return c.currency
+}
+
+// CountryCurrency returns the local currency of an ISO 3166 alpha-2 country
+// code, or an empty string if it has none configured
+func (c *Catalog) CountryCurrency(country string) string {
+	return c.countryCurrencies[strings.ToUpper(country)]
+}
+
+// Plan returns the plan with the given key
+func (c *Catalog) Plan(key models.Plan) (Plan, bool) {
+	plan, ok := c.plans[key]
+	return plan, ok
+}
+
+// Plans returns every plan, sorted by key
+func (c *Catalog) Plans() []Plan {
+	plans := make([]Plan, 0, len(c.plans))
+	for _, plan := range c.plans {
+		plans = append(plans, plan)
+	}
+	sort.Slice(plans, func(i, j int) bool { return plans[i].Key < plans[j].Key })
+	return plans
+}
+
+// PlanPrice returns the Stripe Price ID of plan in currency
+func (c *Catalog) PlanPrice(plan models.Plan, currency string) (string, bool) {
+	priceID, ok := c.plans[plan].Prices[strings.ToLower(currency)]
+	return priceID, ok
+}
+
+// PlanForPrice returns the plan and currency sold at the given Stripe Price ID
+func (c *Catalog) PlanForPrice(priceID string) (models.Plan, string, bool) {
+	for _, plan := range c.plans {
+		for currency, id := range plan.Prices {
+			if id == priceID {
+				return plan.Key, currency, true
+			}
+		}
+	}
+	return "", "", false
+}
+
+// IsPlanPrice reports whether priceID is the Stripe Price ID of a plan in
+// any currency
+func (c *Catalog) IsPlanPrice(priceID string) bool {
+	_, _, ok := c.PlanForPrice(priceID)
+	return ok
+}
+
+// ResolveCurrency picks the currency a checkout for plan is charged in: the
+// requested currency, which must be one the plan is sold in, otherwise the
+// local currency of country when the plan is sold in it, otherwise the
+// catalog currency
+func (c *Catalog) ResolveCurrency(plan models.Plan, currency, country string) (string, error) {
+	if currency != "" {
+		currency = strings.ToLower(currency)
+		if _, ok := c.PlanPrice(plan, currency); !ok {
+			return "", fmt.Errorf("plan %s is not sold in %s", plan, currency)
+		}
+		return currency, nil
+	}
+
+	if local := c.CountryCurrency(country); local != "" {
+		if _, ok := c.PlanPrice(plan, local); ok {
+			return local, nil
+		}
+	}
+
+	return c.currency, nil
+}
+
+// AddonPrice returns the Stripe Price ID of addon in currency
+func (c *Catalog) AddonPrice(addon Addon, currency string) (string, bool) {
+	return c.price(addon.PriceID, addon.Prices, currency)
+}
+
+// MeterPrice returns the metered Stripe Price ID of meter in currency
+func (c *Catalog) MeterPrice(meter Meter, currency string) (string, bool) {
+	return c.price(meter.PriceID, meter.Prices, currency)
+}
+
+// price returns the price in currency from prices, falling back to priceID
+// when currency is the catalog currency
+func (c *Catalog) price(priceID string, prices map[string]string, currency string) (string, bool) {
+	currency = strings.ToLower(currency)
+	if id, ok := prices[currency]; ok {
+		return id, true
+	}
+	if currency == c.currency && priceID != "" {
+		return priceID, true
+	}
+	return "", false
+}
+
+// Get returns the product with the given key
+func (c *Catalog) Get(key string) (Product, bool) {
+	product, ok := c.products[key]
+	return product, ok
+}
+
+// Products returns every product, sorted by key
+func (c *Catalog) Products() []Product {
+	products := make([]Product, 0, len(c.products))
+	for _, product := range c.products {
+		products = append(products, product)
+	}
+	sort.Slice(products, func(i, j int) bool { return products[i].Key < products[j].Key })
+	return products
+}
+
+// Addon returns the add-on with the given key
+func (c *Catalog) Addon(key string) (Addon, bool) {
+	addon, ok := c.addons[key]
+	return addon, ok
+}
+
+// AddonForPrice returns the add-on sold at the given Stripe Price ID
+func (c *Catalog) AddonForPrice(priceID string) (Addon, bool) {
+	for _, addon := range c.addons {
+		if addon.PriceID == priceID {
+			return addon, true
+		}
+		for _, id := range addon.Prices {
+			if id == priceID {
+				return addon, true
+			}
+		}
+	}
+	return Addon{}, false
+}
+
+// Addons returns every add-on, sorted by key
+func (c *Catalog) Addons() []Addon {
+	addons := make([]Addon, 0, len(c.addons))
+	for _, addon := range c.addons {
+		addons = append(addons, addon)
+	}
+	sort.Slice(addons, func(i, j int) bool { return addons[i].Key < addons[j].Key })
+	return addons
+}
+
+// Meter returns the meter with the given key
+func (c *Catalog) Meter(key string) (Meter, bool) {
+	meter, ok := c.meters[key]
+	return meter, ok
+}
+
+// Meters returns every meter, sorted by key
+func (c *Catalog) Meters() []Meter {
+	meters := make([]Meter, 0, len(c.meters))
+	for _, meter := range c.meters {
+		meters = append(meters, meter)
+	}
+	sort.Slice(meters, func(i, j int) bool { return meters[i].Key < meters[j].Key })
+	return meters
+}
+
+// PlanMeters returns the meters billed on subscriptions to plan, sorted by key
+func (c *Catalog) PlanMeters(plan models.Plan) []Meter {
+	var meters []Meter
+	for _, meter := range c.Meters() {
+		if meter.HasPlan(plan) {
+			meters = append(meters, meter)
+		}
+	}
+	return meters
+}
@@ -0,0 +1,162 @@
+package catchup
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/naventro/payment-service/internal/repository"
+	"github.com/naventro/payment-service/internal/stripe"
+	stripego "github.com/stripe/stripe-go/v84"
+)
+
+// cursorName is the sync_state entry holding the creation time of the newest
+// event the poller has gone through
+const cursorName = "stripe_events_catchup"
+
+const (
+	// overlap re-lists events just before the cursor, since several events can
+	// share a creation second and the list API may surface them late
+	overlap = 5 * time.Minute
+	// settle leaves the newest events to the webhook endpoint
+	settle = time.Minute
+	// maxLookback is how long Stripe keeps events
+	maxLookback = 30 * 24 * time.Hour
+	// cursorStep is how far the cursor moves before it is saved mid-run
+	cursorStep = time.Minute
+)
+
+// ReceiveFunc feeds an event into the webhook processing pipeline. handled
+// reports whether the event was processed, as opposed to skipped because it
+// had been seen before; an error with handled unset means the event could
+// not be stored.
+type ReceiveFunc func(event *stripego.Event) (handled bool, err error)
+
+// Result summarizes a catch-up run
+type Result struct {
+	From      time.Time `json:"from"`
+	To        time.Time `json:"to"`
+	Listed    int       `json:"listed"`
+	Processed int       `json:"processed"`
+	Failed    int       `json:"failed"`
+	Skipped   int       `json:"skipped"`
+}
+
+// Poller lists Stripe events missed by the webhook endpoint, for instance
+// during an outage longer than Stripe's retry window, and feeds them into
+// the same pipeline
+type Poller struct {
+	stripeClient  *stripe.Client
+	syncStateRepo *repository.SyncStateRepository
+	receive       ReceiveFunc
+	types         []string
+	lookback      time.Duration
+}
+
+// New creates a poller listing the event types given. lookback bounds the
+// first run, before any cursor has been stored.
+func New(
+	stripeClient *stripe.Client,
+	syncStateRepo *repository.SyncStateRepository,
+	receive ReceiveFunc,
+	types []string,
+	lookback time.Duration,
+) *Poller {
+	if lookback <= 0 || lookback > maxLookback {
+		lookback = maxLookback
+	}
+
+	return &Poller{
+		stripeClient:  stripeClient,
+		syncStateRepo: syncStateRepo,
+		receive:       receive,
+		types:         types,
+		lookback:      lookback,
+	}
+}
+
+// Run lists the events created since the stored cursor and receives the ones
+// not seen before. Events whose handler fails are recorded as failed and
+// left for replay; the cursor stops at the first event that cannot be
+// stored, so it is listed again on the next run.
+func (p *Poller) Run() (*Result, error) {
+	now := time.Now()
+	from := now.Add(-p.lookback)
+
+	cursor, err := p.loadCursor()
+	if err != nil {
+		return nil, err
+	}
+	if cursor != nil {
+		from = cursor.Add(-overlap)
+	}
+	if oldest := now.Add(-maxLookback); from.Before(oldest) {
+		from = oldest
+	}
+
+	result := &Result{From: from, To: now.Add(-settle)}
+	if !result.To.After(result.From) {
+		return result, nil
+	}
+
+	// Events are walked oldest first and the cursor follows them, so a run
+	// that stops part way resumes from there
+	saved := result.From
+	err = p.stripeClient.ListEvents(result.From, result.To, p.types, func(event *stripego.Event) error {
+		result.Listed++
+		created := time.Unix(event.Created, 0)
+
+		handled, err := p.receive(event)
+		switch {
+		case err != nil && !handled:
+			if saveErr := p.saveCursor(created); saveErr != nil {
+				log.Printf("Error saving catch-up cursor: %v", saveErr)
+			}
+			return fmt.Errorf("error storing event %s: %w", event.ID, err)
+		case err != nil:
+			log.Printf("Error processing event %s (%s): %v", event.ID, event.Type, err)
+			result.Failed++
+		case handled:
+			result.Processed++
+		default:
+			result.Skipped++
+		}
+
+		if created.Sub(saved) >= cursorStep {
+			if err := p.saveCursor(created); err != nil {
+				return err
+			}
+			saved = created
+		}
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+
+	if err := p.saveCursor(result.To); err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+func (p *Poller) loadCursor() (*time.Time, error) {
+	value, err := p.syncStateRepo.Get(cursorName)
+	if err != nil || value == "" {
+		return nil, err
+	}
+
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid catch-up cursor %q: %w", value, err)
+	}
+
+	cursor := time.Unix(seconds, 0)
+	return &cursor, nil
+}
+
+func (p *Poller) saveCursor(cursor time.Time) error {
+	return p.syncStateRepo.Set(cursorName, strconv.FormatInt(cursor.Unix(), 10))
+}
@@ -3,6 +3,9 @@ package config
 import (
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type Config struct {
@@ -10,8 +13,64 @@ type Config struct {
 	DatabaseURL         string
 	StripeSecretKey     string
 	StripeWebhookSecret string
-	APIKey              string
-	BackendWebhookURL   string
+	// Active signing secrets for /payments/webhook, tried in order. Several
+	// secrets allow rotation and receiving from more than one Stripe account.
+	StripeWebhookSecrets []string
+	// Signing secrets for the per-tenant /payments/webhook/:tenant routes
+	TenantWebhookSecrets map[string][]string
+	APIKey               string
+	BackendWebhookURL    string
+	// Format of backend notifications: legacy, envelope or both
+	BackendWebhookFormat string
+
+	// JSON file with per-tenant settings; every tenant uses the defaults when empty
+	TenantConfigFile string
+
+	// JSON file with the products sold through one-time checkout; the built-in
+	// catalog is used when empty
+	CatalogFile string
+
+	// Key for the /payments/admin routes; admin routes are disabled when empty
+	AdminAPIKey string
+
+	// Reconciliation against Stripe; a zero interval disables the scheduled job
+	ReconcileInterval time.Duration
+	ReconcileTenants  []string
+	ReconcileRepair   bool
+	ReconcileNotify   bool
+
+	// Catch-up polling of the Stripe Events API; a zero interval disables it.
+	// The lookback bounds the first run, before a cursor is stored.
+	EventCatchupInterval time.Duration
+	EventCatchupLookback time.Duration
+
+	// Reporting of recorded usage to Stripe meters; a zero interval disables
+	// it. Failed reports are retried up to the max attempts.
+	UsageReportInterval    time.Duration
+	UsageReportMaxAttempts int
+
+	// Analytics amounts are converted to the reporting currency with the
+	// exchange rates, in units of the reporting currency per unit of each
+	// currency
+	ReportingCurrency string
+	ExchangeRates     map[string]float64
+
+	// Whether revenue of paid invoices is recognized per day or per month
+	RevenueRecognition string
+
+	// Directory where rendered invoice PDFs are kept; empty renders them on
+	// every request
+	InvoicePDFDir string
+
+	// Transport of customer emails: none, log, file (written to MailFileDir)
+	// or smtp. MailFrom is the sender unless a tenant sets its own.
+	MailBackend  string
+	MailFrom     string
+	MailFileDir  string
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUsername string
+	SMTPPassword string
 }
 
 func Load() (*Config, error) {
@@ -26,9 +85,19 @@ func Load() (*Config, error) {
 		return nil, fmt.Errorf("STRIPE_SECRET_KEY is required")
 	}
 
+	// STRIPE_WEBHOOK_SECRET is kept as the first secret for existing deployments
 	stripeWebhookSecret := getEnv("STRIPE_WEBHOOK_SECRET", "")
-	if stripeWebhookSecret == "" {
-		return nil, fmt.Errorf("STRIPE_WEBHOOK_SECRET is required")
+	stripeWebhookSecrets := getEnvList("STRIPE_WEBHOOK_SECRETS")
+	if stripeWebhookSecret != "" && !contains(stripeWebhookSecrets, stripeWebhookSecret) {
+		stripeWebhookSecrets = append([]string{stripeWebhookSecret}, stripeWebhookSecrets...)
+	}
+	if len(stripeWebhookSecrets) == 0 {
+		return nil, fmt.Errorf("STRIPE_WEBHOOK_SECRET or STRIPE_WEBHOOK_SECRETS is required")
+	}
+
+	tenantWebhookSecrets, err := getEnvPairs("STRIPE_WEBHOOK_TENANT_SECRETS")
+	if err != nil {
+		return nil, err
 	}
 
 	apiKey := getEnv("API_KEY", "")
@@ -41,13 +110,116 @@ func Load() (*Config, error) {
 		return nil, fmt.Errorf("BACKEND_WEBHOOK_URL is required")
 	}
 
+	backendWebhookFormat := strings.ToLower(getEnv("BACKEND_WEBHOOK_FORMAT", "legacy"))
+	switch backendWebhookFormat {
+	case "legacy", "envelope", "both":
+	default:
+		return nil, fmt.Errorf("BACKEND_WEBHOOK_FORMAT must be legacy, envelope or both")
+	}
+
+	reconcileInterval, err := getEnvDuration("RECONCILE_INTERVAL", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	reconcileRepair, err := getEnvBool("RECONCILE_REPAIR", false)
+	if err != nil {
+		return nil, err
+	}
+
+	reconcileNotify, err := getEnvBool("RECONCILE_NOTIFY", false)
+	if err != nil {
+		return nil, err
+	}
+
+	eventCatchupInterval, err := getEnvDuration("EVENT_CATCHUP_INTERVAL", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	eventCatchupLookback, err := getEnvDuration("EVENT_CATCHUP_LOOKBACK", 72*time.Hour)
+	if err != nil {
+		return nil, err
+	}
+
+	usageReportInterval, err := getEnvDuration("USAGE_REPORT_INTERVAL", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	usageReportMaxAttempts, err := getEnvInt("USAGE_REPORT_MAX_ATTEMPTS", 10)
+	if err != nil {
+		return nil, err
+	}
+
+	exchangeRates, err := getEnvRates("EXCHANGE_RATES")
+	if err != nil {
+		return nil, err
+	}
+
+	revenueRecognition := strings.ToLower(getEnv("REVENUE_RECOGNITION", "monthly"))
+	if revenueRecognition != "monthly" && revenueRecognition != "daily" {
+		return nil, fmt.Errorf("REVENUE_RECOGNITION must be monthly or daily")
+	}
+
+	mailBackend := strings.ToLower(getEnv("MAIL_BACKEND", "none"))
+	mailFrom := getEnv("MAIL_FROM", "")
+	mailFileDir := getEnv("MAIL_FILE_DIR", "")
+	smtpHost := getEnv("SMTP_HOST", "")
+	switch mailBackend {
+	case "none", "log":
+	case "file":
+		if mailFileDir == "" {
+			return nil, fmt.Errorf("MAIL_FILE_DIR is required with MAIL_BACKEND=file")
+		}
+	case "smtp":
+		if smtpHost == "" {
+			return nil, fmt.Errorf("SMTP_HOST is required with MAIL_BACKEND=smtp")
+		}
+	default:
+		return nil, fmt.Errorf("MAIL_BACKEND must be none, log, file or smtp")
+	}
+	if mailBackend != "none" && mailFrom == "" {
+		return nil, fmt.Errorf("MAIL_FROM is required with MAIL_BACKEND=%s", mailBackend)
+	}
+
+	smtpPort, err := getEnvInt("SMTP_PORT", 587)
+	if err != nil {
+		return nil, err
+	}
+
 	return &Config{
-		Port:                port,
-		DatabaseURL:         databaseURL,
-		StripeSecretKey:     stripeSecretKey,
-		StripeWebhookSecret: stripeWebhookSecret,
-		APIKey:              apiKey,
-		BackendWebhookURL:   backendWebhookURL,
+		Port:                   port,
+		DatabaseURL:            databaseURL,
+		StripeSecretKey:        stripeSecretKey,
+		StripeWebhookSecret:    stripeWebhookSecret,
+		StripeWebhookSecrets:   stripeWebhookSecrets,
+		TenantWebhookSecrets:   tenantWebhookSecrets,
+		APIKey:                 apiKey,
+		BackendWebhookURL:      backendWebhookURL,
+		BackendWebhookFormat:   backendWebhookFormat,
+		TenantConfigFile:       getEnv("TENANT_CONFIG_FILE", ""),
+		CatalogFile:            getEnv("CATALOG_FILE", ""),
+		AdminAPIKey:            getEnv("ADMIN_API_KEY", ""),
+		ReconcileInterval:      reconcileInterval,
+		ReconcileTenants:       getEnvList("RECONCILE_TENANTS"),
+		ReconcileRepair:        reconcileRepair,
+		ReconcileNotify:        reconcileNotify,
+		EventCatchupInterval:   eventCatchupInterval,
+		EventCatchupLookback:   eventCatchupLookback,
+		UsageReportInterval:    usageReportInterval,
+		UsageReportMaxAttempts: usageReportMaxAttempts,
+		ReportingCurrency:      strings.ToLower(getEnv("REPORTING_CURRENCY", "usd")),
+		ExchangeRates:          exchangeRates,
+		RevenueRecognition:     revenueRecognition,
+		InvoicePDFDir:          getEnv("INVOICE_PDF_DIR", ""),
+		MailBackend:            mailBackend,
+		MailFrom:               mailFrom,
+		MailFileDir:            mailFileDir,
+		SMTPHost:               smtpHost,
+		SMTPPort:               smtpPort,
+		SMTPUsername:           getEnv("SMTP_USERNAME", ""),
+		SMTPPassword:           getEnv("SMTP_PASSWORD", ""),
 	}, nil
 }
 
@@ -57,3 +229,95 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+func getEnvDuration(key string, defaultValue time.Duration) (time.Duration, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be a duration (e.g. 1h): %w", key, err)
+	}
+	return d, nil
+}
+
+func getEnvInt(key string, defaultValue int) (int, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be an integer: %w", key, err)
+	}
+	return n, nil
+}
+
+func getEnvBool(key string, defaultValue bool) (bool, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("%s must be a boolean: %w", key, err)
+	}
+	return b, nil
+}
+
+// getEnvList splits a comma-separated variable, ignoring empty entries
+func getEnvList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// getEnvPairs parses a comma-separated list of key:value pairs. A key may
+// appear more than once; its values are kept in order.
+func getEnvPairs(key string) (map[string][]string, error) {
+	pairs := make(map[string][]string)
+	for _, entry := range getEnvList(key) {
+		k, v, ok := strings.Cut(entry, ":")
+		k, v = strings.TrimSpace(k), strings.TrimSpace(v)
+		if !ok || k == "" || v == "" {
+			return nil, fmt.Errorf("%s must be a list of key:value pairs, got %q", key, entry)
+		}
+		pairs[k] = append(pairs[k], v)
+	}
+	return pairs, nil
+}
+
+// getEnvRates parses a list of currency:rate pairs, e.g. "mxn:0.055,eur:1.08"
+func getEnvRates(key string) (map[string]float64, error) {
+	pairs, err := getEnvPairs(key)
+	if err != nil {
+		return nil, err
+	}
+
+	rates := make(map[string]float64, len(pairs))
+	for currency, values := range pairs {
+		rate, err := strconv.ParseFloat(values[len(values)-1], 64)
+		if err != nil || rate <= 0 {
+			return nil, fmt.Errorf("%s must have a positive rate for %s, got %q", key, currency, values[len(values)-1])
+		}
+		rates[strings.ToLower(currency)] = rate
+	}
+	return rates, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,21 @@
+-- Create customers table
+CREATE TABLE IF NOT EXISTS customers (
+    id SERIAL PRIMARY KEY,
+    user_id VARCHAR(255) NOT NULL,
+    tenant VARCHAR(100) NOT NULL,
+    stripe_customer_id VARCHAR(255) NOT NULL,
+    email VARCHAR(255) NOT NULL DEFAULT '',
+    name VARCHAR(255) NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(user_id, tenant),
+    UNIQUE(stripe_customer_id)
+);
+
+-- Create index for faster lookups
+CREATE INDEX idx_customers_tenant ON customers(tenant);
+
+-- Backfill mappings for customers that already have a subscription
+INSERT INTO customers (user_id, tenant, stripe_customer_id)
+SELECT user_id, tenant, stripe_customer_id FROM subscriptions
+ON CONFLICT DO NOTHING;
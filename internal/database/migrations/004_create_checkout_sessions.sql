@@ -0,0 +1,25 @@
+-- Create checkout_sessions table
+CREATE TABLE IF NOT EXISTS checkout_sessions (
+    id SERIAL PRIMARY KEY,
+    stripe_session_id VARCHAR(255) NOT NULL,
+    user_id VARCHAR(255) NOT NULL,
+    tenant VARCHAR(100) NOT NULL,
+    plan VARCHAR(50) NOT NULL,
+    stripe_customer_id VARCHAR(255),
+    success_url VARCHAR(1000) NOT NULL,
+    cancel_url VARCHAR(1000) NOT NULL,
+    status VARCHAR(50) NOT NULL,
+    stripe_subscription_id VARCHAR(255),
+    subscription_id INTEGER REFERENCES subscriptions(id) ON DELETE SET NULL,
+    completed_at TIMESTAMP,
+    expired_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(stripe_session_id)
+);
+
+-- Create indexes for faster lookups
+CREATE INDEX idx_checkout_sessions_user_id ON checkout_sessions(user_id);
+CREATE INDEX idx_checkout_sessions_tenant ON checkout_sessions(tenant);
+CREATE INDEX idx_checkout_sessions_status ON checkout_sessions(status);
+CREATE INDEX idx_checkout_sessions_stripe_subscription_id ON checkout_sessions(stripe_subscription_id);
@@ -0,0 +1,20 @@
+-- Create stripe_events table to keep every received Stripe event for replay
+CREATE TABLE IF NOT EXISTS stripe_events (
+    id SERIAL PRIMARY KEY,
+    stripe_event_id VARCHAR(255) NOT NULL,
+    type VARCHAR(100) NOT NULL,
+    status VARCHAR(50) NOT NULL,
+    payload JSONB NOT NULL,
+    error TEXT,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    livemode BOOLEAN NOT NULL DEFAULT FALSE,
+    stripe_created_at TIMESTAMP NOT NULL,
+    received_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    processed_at TIMESTAMP,
+    UNIQUE(stripe_event_id)
+);
+
+-- Create indexes for filtering by type, status and time range
+CREATE INDEX idx_stripe_events_type ON stripe_events(type);
+CREATE INDEX idx_stripe_events_status ON stripe_events(status);
+CREATE INDEX idx_stripe_events_received_at ON stripe_events(received_at);
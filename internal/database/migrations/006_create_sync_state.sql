@@ -0,0 +1,8 @@
+-- Create sync_state table holding cursors of background sync jobs
+CREATE TABLE IF NOT EXISTS sync_state (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    value TEXT NOT NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(name)
+);
@@ -0,0 +1,34 @@
+-- Record the payment that settled each invoice so disputes and refunds can be matched to it
+ALTER TABLE invoices ADD COLUMN IF NOT EXISTS stripe_payment_intent_id VARCHAR(255);
+ALTER TABLE invoices ADD COLUMN IF NOT EXISTS stripe_charge_id VARCHAR(255);
+
+CREATE INDEX IF NOT EXISTS idx_invoices_stripe_payment_intent_id ON invoices(stripe_payment_intent_id);
+CREATE INDEX IF NOT EXISTS idx_invoices_stripe_charge_id ON invoices(stripe_charge_id);
+
+-- Create disputes table
+CREATE TABLE IF NOT EXISTS disputes (
+    id SERIAL PRIMARY KEY,
+    stripe_dispute_id VARCHAR(255) NOT NULL,
+    stripe_charge_id VARCHAR(255) NOT NULL,
+    stripe_payment_intent_id VARCHAR(255),
+    invoice_id INTEGER REFERENCES invoices(id) ON DELETE SET NULL,
+    subscription_id INTEGER REFERENCES subscriptions(id) ON DELETE SET NULL,
+    user_id VARCHAR(255),
+    tenant VARCHAR(100),
+    amount INTEGER NOT NULL,
+    currency VARCHAR(10) NOT NULL,
+    reason VARCHAR(100) NOT NULL,
+    status VARCHAR(50) NOT NULL,
+    evidence_due_by TIMESTAMP,
+    entitlements_revoked BOOLEAN NOT NULL DEFAULT FALSE,
+    closed_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(stripe_dispute_id)
+);
+
+-- Create indexes for faster lookups
+CREATE INDEX idx_disputes_user_id ON disputes(user_id);
+CREATE INDEX idx_disputes_tenant ON disputes(tenant);
+CREATE INDEX idx_disputes_status ON disputes(status);
+CREATE INDEX idx_disputes_subscription_id ON disputes(subscription_id);
@@ -0,0 +1,23 @@
+-- Create refunds table
+CREATE TABLE IF NOT EXISTS refunds (
+    id SERIAL PRIMARY KEY,
+    stripe_refund_id VARCHAR(255) NOT NULL,
+    stripe_charge_id VARCHAR(255),
+    stripe_payment_intent_id VARCHAR(255),
+    invoice_id INTEGER REFERENCES invoices(id) ON DELETE SET NULL,
+    user_id VARCHAR(255),
+    tenant VARCHAR(100),
+    amount INTEGER NOT NULL,
+    currency VARCHAR(10) NOT NULL,
+    reason VARCHAR(100),
+    status VARCHAR(50) NOT NULL,
+    failure_reason VARCHAR(100),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(stripe_refund_id)
+);
+
+-- Create indexes for faster lookups
+CREATE INDEX idx_refunds_invoice_id ON refunds(invoice_id);
+CREATE INDEX idx_refunds_user_id ON refunds(user_id);
+CREATE INDEX idx_refunds_tenant ON refunds(tenant);
@@ -0,0 +1,6 @@
+-- Track invoices waiting for the customer to authenticate the payment (3-D Secure)
+ALTER TABLE invoices ADD COLUMN IF NOT EXISTS payment_action_required BOOLEAN NOT NULL DEFAULT FALSE;
+
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS payment_action_required BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS payment_action_invoice_id VARCHAR(255);
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS payment_action_url TEXT;
@@ -0,0 +1,26 @@
+-- Checkout sessions for one-time purchases record the product instead of a plan
+ALTER TABLE checkout_sessions ADD COLUMN IF NOT EXISTS product VARCHAR(100);
+
+-- Create orders table
+CREATE TABLE IF NOT EXISTS orders (
+    id SERIAL PRIMARY KEY,
+    stripe_payment_intent_id VARCHAR(255) NOT NULL,
+    stripe_checkout_session_id VARCHAR(255),
+    stripe_customer_id VARCHAR(255),
+    user_id VARCHAR(255) NOT NULL,
+    tenant VARCHAR(100) NOT NULL,
+    product VARCHAR(100) NOT NULL,
+    amount INTEGER NOT NULL,
+    currency VARCHAR(10) NOT NULL,
+    status VARCHAR(50) NOT NULL,
+    entitlement VARCHAR(100),
+    paid_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(stripe_payment_intent_id)
+);
+
+-- Create indexes for faster lookups
+CREATE INDEX idx_orders_user_id ON orders(user_id);
+CREATE INDEX idx_orders_tenant ON orders(tenant);
+CREATE INDEX idx_orders_stripe_checkout_session_id ON orders(stripe_checkout_session_id);
@@ -0,0 +1,2 @@
+-- Number of seats (e.g. restaurant locations) covered by a subscription
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS quantity INTEGER NOT NULL DEFAULT 1;
@@ -0,0 +1,24 @@
+-- Create usage_events table
+-- Usage recorded through POST /payments/usage, reported to Stripe billing meters
+CREATE TABLE IF NOT EXISTS usage_events (
+    id SERIAL PRIMARY KEY,
+    idempotency_key VARCHAR(255) NOT NULL,
+    user_id VARCHAR(255) NOT NULL,
+    tenant VARCHAR(100) NOT NULL,
+    meter VARCHAR(100) NOT NULL,
+    quantity BIGINT NOT NULL,
+    stripe_customer_id VARCHAR(255) NOT NULL,
+    occurred_at TIMESTAMP NOT NULL,
+    status VARCHAR(50) NOT NULL DEFAULT 'pending',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    last_error TEXT,
+    reported_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(tenant, idempotency_key)
+);
+
+-- Create indexes for period totals and the reporter's queue
+CREATE INDEX idx_usage_events_user_meter ON usage_events(user_id, tenant, meter, occurred_at);
+CREATE INDEX idx_usage_events_pending ON usage_events(next_attempt_at) WHERE status = 'pending';
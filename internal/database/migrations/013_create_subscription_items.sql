@@ -0,0 +1,17 @@
+-- Create subscription_items table
+-- Every item of a Stripe subscription: the plan, attached add-ons and metered prices
+CREATE TABLE IF NOT EXISTS subscription_items (
+    id SERIAL PRIMARY KEY,
+    subscription_id INTEGER NOT NULL REFERENCES subscriptions(id) ON DELETE CASCADE,
+    stripe_subscription_item_id VARCHAR(255) NOT NULL,
+    stripe_price_id VARCHAR(255) NOT NULL,
+    kind VARCHAR(50) NOT NULL,
+    addon VARCHAR(100),
+    quantity INTEGER NOT NULL DEFAULT 1,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(stripe_subscription_item_id)
+);
+
+-- Create indexes for faster lookups
+CREATE INDEX idx_subscription_items_subscription_id ON subscription_items(subscription_id);
@@ -0,0 +1,10 @@
+-- Tax amounts and the customer's tax details of each invoice
+ALTER TABLE invoices ADD COLUMN IF NOT EXISTS subtotal BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE invoices ADD COLUMN IF NOT EXISTS tax BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE invoices ADD COLUMN IF NOT EXISTS total BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE invoices ADD COLUMN IF NOT EXISTS customer_country VARCHAR(2);
+ALTER TABLE invoices ADD COLUMN IF NOT EXISTS customer_tax_ids JSONB NOT NULL DEFAULT '[]';
+ALTER TABLE invoices ADD COLUMN IF NOT EXISTS tax_breakdown JSONB NOT NULL DEFAULT '[]';
+
+-- Invoices stored before tax was collected were charged without tax
+UPDATE invoices SET subtotal = amount_paid, total = amount_paid WHERE total = 0;
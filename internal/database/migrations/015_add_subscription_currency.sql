@@ -0,0 +1,12 @@
+-- Currency a subscription is billed in. Existing subscriptions take the
+-- currency of their latest invoice; all plans were sold in USD before.
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS currency VARCHAR(10) NOT NULL DEFAULT 'usd';
+
+UPDATE subscriptions s
+SET currency = i.currency
+FROM (
+    SELECT DISTINCT ON (subscription_id) subscription_id, currency
+    FROM invoices
+    ORDER BY subscription_id, created_at DESC
+) i
+WHERE i.subscription_id = s.id AND i.currency <> '';
@@ -0,0 +1,46 @@
+-- Create subscription_history table
+-- A snapshot of a subscription after every change, used for MRR and churn analytics.
+-- mrr is the monthly recurring amount of its licensed items, in the smallest
+-- unit of currency, whatever the status.
+CREATE TABLE IF NOT EXISTS subscription_history (
+    id SERIAL PRIMARY KEY,
+    subscription_id INTEGER NOT NULL REFERENCES subscriptions(id) ON DELETE CASCADE,
+    stripe_event_id VARCHAR(255),
+    user_id VARCHAR(255) NOT NULL,
+    tenant VARCHAR(100) NOT NULL,
+    plan VARCHAR(50) NOT NULL,
+    status VARCHAR(50) NOT NULL,
+    quantity INTEGER NOT NULL DEFAULT 1,
+    currency VARCHAR(10) NOT NULL,
+    mrr BIGINT NOT NULL DEFAULT 0,
+    recorded_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(stripe_event_id)
+);
+
+-- Create indexes for faster lookups
+CREATE INDEX idx_subscription_history_subscription_id ON subscription_history(subscription_id, recorded_at);
+CREATE INDEX idx_subscription_history_tenant ON subscription_history(tenant, recorded_at);
+
+-- Existing subscriptions start with their current state when they were
+-- created, priced from their latest paid invoice. Canceled ones are assumed
+-- active until their last update.
+INSERT INTO subscription_history (subscription_id, user_id, tenant, plan, status, quantity, currency, mrr, recorded_at)
+SELECT s.id, s.user_id, s.tenant, s.plan,
+       CASE WHEN s.status = 'canceled' THEN 'active' ELSE s.status END,
+       s.quantity, s.currency,
+       COALESCE(i.subtotal / CASE WHEN s.plan = 'premium_yearly' THEN 12 ELSE 1 END, 0),
+       s.created_at
+FROM subscriptions s
+LEFT JOIN LATERAL (
+    SELECT subtotal FROM invoices
+    WHERE subscription_id = s.id AND status = 'paid'
+    ORDER BY created_at DESC
+    LIMIT 1
+) i ON TRUE;
+
+INSERT INTO subscription_history (subscription_id, user_id, tenant, plan, status, quantity, currency, mrr, recorded_at)
+SELECT h.subscription_id, h.user_id, h.tenant, h.plan, 'canceled', h.quantity, h.currency, h.mrr, s.updated_at
+FROM subscription_history h
+JOIN subscriptions s ON s.id = h.subscription_id
+WHERE s.status = 'canceled';
@@ -0,0 +1,6 @@
+-- Stripe processing fee of the payment of each invoice, in the currency of
+-- the balance it settled in. NULL until the balance transaction is known.
+ALTER TABLE invoices ADD COLUMN IF NOT EXISTS stripe_fee BIGINT;
+ALTER TABLE invoices ADD COLUMN IF NOT EXISTS stripe_fee_currency VARCHAR(10);
+
+CREATE INDEX IF NOT EXISTS idx_invoices_tenant_created_at ON invoices(tenant, created_at);
@@ -0,0 +1,20 @@
+-- Revenue recognition schedule of paid invoices. Each row recognizes part of
+-- an invoice's revenue (before tax) on a day or in a month; refunds add
+-- negative rows. booked_on is when the amount was paid or refunded, so rows
+-- booked but not yet recognized make up the deferred revenue.
+CREATE TABLE IF NOT EXISTS revenue_recognition (
+    id SERIAL PRIMARY KEY,
+    invoice_id INTEGER NOT NULL REFERENCES invoices(id) ON DELETE CASCADE,
+    refund_id INTEGER REFERENCES refunds(id) ON DELETE CASCADE,
+    tenant VARCHAR(100) NOT NULL,
+    currency VARCHAR(10) NOT NULL,
+    booked_on DATE NOT NULL,
+    recognized_on DATE NOT NULL,
+    amount BIGINT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create indexes for faster lookups
+CREATE INDEX IF NOT EXISTS idx_revenue_recognition_invoice_id ON revenue_recognition(invoice_id);
+CREATE INDEX IF NOT EXISTS idx_revenue_recognition_refund_id ON revenue_recognition(refund_id);
+CREATE INDEX IF NOT EXISTS idx_revenue_recognition_tenant_booked_on ON revenue_recognition(tenant, booked_on);
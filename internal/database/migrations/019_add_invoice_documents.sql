@@ -0,0 +1,18 @@
+-- Details printed on invoice PDFs: the tenant's invoice number, the billed
+-- customer and the invoice lines
+ALTER TABLE invoices ADD COLUMN IF NOT EXISTS number VARCHAR(50);
+ALTER TABLE invoices ADD COLUMN IF NOT EXISTS customer_name VARCHAR(255);
+ALTER TABLE invoices ADD COLUMN IF NOT EXISTS customer_email VARCHAR(255);
+ALTER TABLE invoices ADD COLUMN IF NOT EXISTS customer_address JSONB;
+ALTER TABLE invoices ADD COLUMN IF NOT EXISTS lines JSONB NOT NULL DEFAULT '[]';
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_invoices_tenant_number ON invoices(tenant, number);
+
+-- Last invoice number issued in each numbering series of a tenant
+CREATE TABLE IF NOT EXISTS invoice_number_sequences (
+    tenant VARCHAR(100) NOT NULL,
+    series VARCHAR(50) NOT NULL,
+    last_number BIGINT NOT NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (tenant, series)
+);
@@ -0,0 +1,5 @@
+-- When processing of a stored event last started. Events still "received"
+-- long after their claim were interrupted and are processed again.
+ALTER TABLE stripe_events ADD COLUMN IF NOT EXISTS claimed_at TIMESTAMP;
+
+UPDATE stripe_events SET claimed_at = received_at WHERE claimed_at IS NULL;
@@ -0,0 +1,3 @@
+-- Idempotency-Key of the API request that issued a refund, so a retried
+-- request returns the refund it created
+ALTER TABLE refunds ADD COLUMN IF NOT EXISTS idempotency_key VARCHAR(255);
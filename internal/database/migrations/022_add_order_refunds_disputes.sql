@@ -0,0 +1,6 @@
+-- Refunds and disputes of one-time purchases are matched to their order
+ALTER TABLE refunds ADD COLUMN IF NOT EXISTS order_id INTEGER REFERENCES orders(id);
+ALTER TABLE disputes ADD COLUMN IF NOT EXISTS order_id INTEGER REFERENCES orders(id);
+
+CREATE INDEX IF NOT EXISTS idx_refunds_order_id ON refunds(order_id);
+CREATE INDEX IF NOT EXISTS idx_disputes_order_id ON disputes(order_id);
@@ -0,0 +1,18 @@
+-- When each invoice was paid, which dates its payment in the accounting
+-- journal. Invoices paid before this column fall back to the day their
+-- revenue was booked, or to when they were stored.
+ALTER TABLE invoices ADD COLUMN IF NOT EXISTS paid_at TIMESTAMP;
+
+UPDATE invoices i
+SET paid_at = COALESCE(
+    (SELECT MIN(r.booked_on) FROM revenue_recognition r WHERE r.invoice_id = i.id AND r.refund_id IS NULL),
+    i.created_at
+)
+WHERE i.status = 'paid' AND i.paid_at IS NULL;
+
+CREATE INDEX IF NOT EXISTS idx_invoices_tenant_paid_at ON invoices(tenant, paid_at);
+
+-- Tax collected on one-time purchases, taken from their checkout session
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS tax BIGINT NOT NULL DEFAULT 0;
+
+CREATE INDEX IF NOT EXISTS idx_orders_tenant_paid_at ON orders(tenant, paid_at);
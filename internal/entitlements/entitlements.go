@@ -0,0 +1,156 @@
+package entitlements
+
+import (
+	"time"
+
+	"github.com/naventro/payment-service/internal/models"
+	"github.com/naventro/payment-service/internal/repository"
+)
+
+// Sources an entitlement can be granted by
+const (
+	SourceSubscription = "subscription"
+	SourceOrder        = "order"
+	SourceAddon        = "addon"
+)
+
+// Entitlement is something the user currently has access to
+type Entitlement struct {
+	Key       string     `json:"key"`
+	Source    string     `json:"source"`
+	SourceID  string     `json:"source_id"`
+	Quantity  int64      `json:"quantity"` // seats or add-on units granted, 1 for orders
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// Revocation is an entitlement the user would have but that is withheld
+type Revocation struct {
+	Entitlement
+	Reason    string `json:"reason"`
+	DisputeID string `json:"dispute_id,omitempty"`
+}
+
+// Entitlements is the resolved access of a user within a tenant
+type Entitlements struct {
+	UserID       string        `json:"user_id"`
+	Tenant       string        `json:"tenant"`
+	Active       bool          `json:"active"`
+	Entitlements []Entitlement `json:"entitlements"`
+	Revoked      []Revocation  `json:"revoked,omitempty"`
+}
+
+// Resolver derives entitlements from the stored billing state
+type Resolver struct {
+	subRepo     *repository.SubscriptionRepository
+	itemRepo    *repository.SubscriptionItemRepository
+	disputeRepo *repository.DisputeRepository
+	orderRepo   *repository.OrderRepository
+}
+
+func New(
+	subRepo *repository.SubscriptionRepository,
+	itemRepo *repository.SubscriptionItemRepository,
+	disputeRepo *repository.DisputeRepository,
+	orderRepo *repository.OrderRepository,
+) *Resolver {
+	return &Resolver{
+		subRepo:     subRepo,
+		itemRepo:    itemRepo,
+		disputeRepo: disputeRepo,
+		orderRepo:   orderRepo,
+	}
+}
+
+// Resolve returns the entitlements of a user. A subscription grants its plan
+// and add-ons while it is active, trialing or past due, and a paid lifetime
+// purchase grants its product without expiry. A dispute opened under a tenant
+// policy that revokes entitlements withholds all of them until it is won.
+func (r *Resolver) Resolve(userID, tenant string) (*Entitlements, error) {
+	result := &Entitlements{
+		UserID:       userID,
+		Tenant:       tenant,
+		Entitlements: []Entitlement{},
+	}
+
+	var granted []Entitlement
+
+	sub, err := r.subRepo.GetByUserID(userID, tenant)
+	if err != nil {
+		return nil, err
+	}
+	if sub != nil && grantsAccess(sub.Status) {
+		granted = append(granted, Entitlement{
+			Key:       string(sub.Plan),
+			Source:    SourceSubscription,
+			SourceID:  sub.StripeSubscriptionID,
+			Quantity:  sub.Quantity,
+			ExpiresAt: sub.CurrentPeriodEnd,
+		})
+
+		items, err := r.itemRepo.GetBySubscriptionID(sub.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			if item.Kind != models.SubscriptionItemKindAddon || item.Addon == nil {
+				continue
+			}
+			granted = append(granted, Entitlement{
+				Key:       *item.Addon,
+				Source:    SourceAddon,
+				SourceID:  item.StripeSubscriptionItemID,
+				Quantity:  item.Quantity,
+				ExpiresAt: sub.CurrentPeriodEnd,
+			})
+		}
+	}
+
+	orders, err := r.orderRepo.ListEntitlements(userID, tenant)
+	if err != nil {
+		return nil, err
+	}
+	for _, order := range orders {
+		granted = append(granted, Entitlement{
+			Key:      *order.Entitlement,
+			Source:   SourceOrder,
+			SourceID: order.StripePaymentIntentID,
+			Quantity: 1,
+		})
+	}
+
+	disputes, err := r.disputeRepo.List(repository.DisputeFilter{UserID: userID, Tenant: tenant})
+	if err != nil {
+		return nil, err
+	}
+
+	var blocking *models.Dispute
+	for _, dispute := range disputes {
+		if dispute.BlocksEntitlements() {
+			blocking = dispute
+			break
+		}
+	}
+
+	for _, entitlement := range granted {
+		if blocking != nil {
+			result.Revoked = append(result.Revoked, Revocation{
+				Entitlement: entitlement,
+				Reason:      "dispute_" + string(blocking.Status),
+				DisputeID:   blocking.StripeDisputeID,
+			})
+			continue
+		}
+		result.Entitlements = append(result.Entitlements, entitlement)
+	}
+
+	result.Active = len(result.Entitlements) > 0
+	return result, nil
+}
+
+func grantsAccess(status models.SubscriptionStatus) bool {
+	switch status {
+	case models.StatusActive, models.StatusTrialing, models.StatusPastDue:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,433 @@
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/naventro/payment-service/internal/models"
+	"github.com/naventro/payment-service/internal/repository"
+	"github.com/naventro/payment-service/internal/stripe"
+)
+
+// Dataset is what an export contains
+type Dataset string
+
+const (
+	DatasetInvoices Dataset = "invoices"
+	DatasetRefunds  Dataset = "refunds"
+	DatasetDisputes Dataset = "disputes"
+	// DatasetJournal is the double-entry journal of the other datasets
+	DatasetJournal Dataset = "journal"
+	// DatasetRecognition is the recognized and deferred revenue per tenant,
+	// currency and month
+	DatasetRecognition Dataset = "recognition"
+)
+
+// Datasets lists every dataset that can be exported
+var Datasets = []Dataset{DatasetInvoices, DatasetRefunds, DatasetDisputes, DatasetJournal, DatasetRecognition}
+
+// Format is how an export is encoded
+type Format string
+
+const (
+	// FormatCSV writes a header row and amounts as decimal numbers
+	FormatCSV Format = "csv"
+	// FormatJSON writes an array of objects with amounts in the smallest
+	// currency unit, like the rest of the API
+	FormatJSON Format = "json"
+)
+
+// ParseDataset validates a dataset name
+func ParseDataset(value string) (Dataset, error) {
+	for _, dataset := range Datasets {
+		if string(dataset) == value {
+			return dataset, nil
+		}
+	}
+	return "", fmt.Errorf("unknown dataset %q (available: invoices, refunds, disputes, journal, recognition)", value)
+}
+
+// ParseFormat validates a format name
+func ParseFormat(value string) (Format, error) {
+	switch Format(value) {
+	case FormatCSV, FormatJSON:
+		return Format(value), nil
+	}
+	return "", fmt.Errorf("unknown format %q (available: csv, json)", value)
+}
+
+// ContentType returns the MIME type of the format
+func (f Format) ContentType() string {
+	if f == FormatJSON {
+		return "application/json"
+	}
+	return "text/csv; charset=utf-8"
+}
+
+// ResolvePeriod returns the period of an export given as a calendar month
+// (YYYY-MM, in UTC) or as from and to RFC 3339 times. Without either it is
+// the calendar month before now.
+func ResolvePeriod(month, from, to string, now time.Time) (time.Time, time.Time, error) {
+	if month != "" {
+		if from != "" || to != "" {
+			return time.Time{}, time.Time{}, fmt.Errorf("month cannot be combined with from and to")
+		}
+		start, err := time.Parse("2006-01", month)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("month must have the form YYYY-MM")
+		}
+		return start, start.AddDate(0, 1, 0), nil
+	}
+
+	if from == "" && to == "" {
+		now = now.UTC()
+		end := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+		return end.AddDate(0, -1, 0), end, nil
+	}
+	if from == "" || to == "" {
+		return time.Time{}, time.Time{}, fmt.Errorf("from and to must be given together")
+	}
+
+	start, err := time.Parse(time.RFC3339, from)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("from must be an RFC 3339 timestamp")
+	}
+	end, err := time.Parse(time.RFC3339, to)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("to must be an RFC 3339 timestamp")
+	}
+	if !start.Before(end) {
+		return time.Time{}, time.Time{}, fmt.Errorf("to must be after from")
+	}
+	return start.UTC(), end.UTC(), nil
+}
+
+// Filename returns a file name for an export, e.g. journal_2024-05-01_2024-06-01.csv
+func Filename(opts Options) string {
+	name := string(opts.Dataset)
+	if opts.Filter.Tenant != "" {
+		name += "_" + opts.Filter.Tenant
+	}
+	return fmt.Sprintf("%s_%s_%s.%s", name, opts.Filter.From.Format("2006-01-02"), opts.Filter.To.Format("2006-01-02"), opts.Format)
+}
+
+// Options select what is exported
+type Options struct {
+	Dataset Dataset
+	Format  Format
+	Filter  repository.ExportFilter
+}
+
+// Exporter writes accounting exports. Records are written as they are read
+// from the database, so exports of long periods use constant memory.
+type Exporter struct {
+	exportRepo      *repository.ExportRepository
+	recognitionRepo *repository.RevenueRecognitionRepository
+	stripeClient    *stripe.Client
+}
+
+// New creates an exporter
+func New(
+	exportRepo *repository.ExportRepository,
+	recognitionRepo *repository.RevenueRecognitionRepository,
+	stripeClient *stripe.Client,
+) *Exporter {
+	return &Exporter{
+		exportRepo:      exportRepo,
+		recognitionRepo: recognitionRepo,
+		stripeClient:    stripeClient,
+	}
+}
+
+// Export writes the dataset to w and returns how many records it wrote. If
+// it fails part of the way, w holds the records written so far.
+func (e *Exporter) Export(w io.Writer, opts Options) (int, error) {
+	if !opts.Filter.From.Before(opts.Filter.To) {
+		return 0, fmt.Errorf("the period must end after it starts")
+	}
+
+	var out recordWriter
+	switch opts.Format {
+	case FormatCSV:
+		out = &csvWriter{w: csv.NewWriter(w), header: header(opts.Dataset)}
+	case FormatJSON:
+		out = &jsonWriter{w: w}
+	default:
+		return 0, fmt.Errorf("unknown format %q", opts.Format)
+	}
+
+	var err error
+	switch opts.Dataset {
+	case DatasetInvoices:
+		err = e.exportRepo.StreamInvoices(opts.Filter, func(invoice *models.InvoiceExport) error {
+			return out.write(invoiceRecord(invoice), invoice)
+		})
+	case DatasetRefunds:
+		err = e.exportRepo.StreamRefunds(opts.Filter, func(refund *models.RefundExport) error {
+			return out.write(refundRecord(refund), refund)
+		})
+	case DatasetDisputes:
+		err = e.exportRepo.StreamDisputes(opts.Filter, func(dispute *models.DisputeExport) error {
+			return out.write(disputeRecord(dispute), dispute)
+		})
+	case DatasetJournal:
+		err = e.journal(opts, func(line *JournalLine) error {
+			return out.write(line.record(), line)
+		})
+	case DatasetRecognition:
+		filter := repository.RecognitionFilter(opts.Filter)
+		err = e.recognitionRepo.Monthly(filter, func(month *models.RecognitionMonth) error {
+			return out.write(recognitionRecord(month), month)
+		})
+	default:
+		return 0, fmt.Errorf("unknown dataset %q", opts.Dataset)
+	}
+
+	if closeErr := out.close(); err == nil {
+		err = closeErr
+	}
+	return out.count(), err
+}
+
+// BackfillFees asks Stripe for the fees of the period's paid invoices that
+// do not have one yet and stores them, so exports can book them. It runs
+// before an export instead of during it: exports only read stored fees.
+// Invoices whose fee cannot be fetched are logged and keep no fee. It returns
+// how many fees it stored.
+func (e *Exporter) BackfillFees(filter repository.ExportFilter) (int, error) {
+	invoices, err := e.exportRepo.InvoicesMissingFee(filter)
+	if err != nil {
+		return 0, err
+	}
+
+	stored := 0
+	for _, invoice := range invoices {
+		fee, currency, ok, err := e.stripeClient.GetChargeFee(*invoice.StripeChargeID)
+		if err != nil {
+			log.Printf("Error fetching fee for invoice %s: %v", invoice.StripeInvoiceID, err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+
+		if err := e.exportRepo.SetInvoiceFee(invoice.ID, fee, currency); err != nil {
+			return stored, fmt.Errorf("error storing fee for invoice %s: %w", invoice.StripeInvoiceID, err)
+		}
+		stored++
+	}
+
+	return stored, nil
+}
+
+// recordWriter encodes records as CSV rows or JSON objects
+type recordWriter interface {
+	write(row []string, value interface{}) error
+	close() error
+	count() int
+}
+
+type csvWriter struct {
+	w       *csv.Writer
+	header  []string
+	written int
+	started bool
+}
+
+func (c *csvWriter) start() error {
+	if c.started {
+		return nil
+	}
+	c.started = true
+	return c.w.Write(c.header)
+}
+
+func (c *csvWriter) write(row []string, _ interface{}) error {
+	if err := c.start(); err != nil {
+		return err
+	}
+	if err := c.w.Write(row); err != nil {
+		return err
+	}
+	c.written++
+	return nil
+}
+
+// close writes the header of empty exports and flushes the buffered rows
+func (c *csvWriter) close() error {
+	if err := c.start(); err != nil {
+		return err
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func (c *csvWriter) count() int {
+	return c.written
+}
+
+type jsonWriter struct {
+	w       io.Writer
+	written int
+}
+
+func (j *jsonWriter) write(_ []string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	separator := ",\n"
+	if j.written == 0 {
+		separator = "[\n"
+	}
+	if _, err := io.WriteString(j.w, separator); err != nil {
+		return err
+	}
+	if _, err := j.w.Write(data); err != nil {
+		return err
+	}
+	j.written++
+	return nil
+}
+
+func (j *jsonWriter) close() error {
+	closing := "\n]\n"
+	if j.written == 0 {
+		closing = "[]\n"
+	}
+	_, err := io.WriteString(j.w, closing)
+	return err
+}
+
+func (j *jsonWriter) count() int {
+	return j.written
+}
+
+func header(dataset Dataset) []string {
+	switch dataset {
+	case DatasetInvoices:
+		return []string{
+			"invoice_id", "created_at", "tenant", "user_id", "subscription_id", "plan",
+			"quantity", "status", "currency", "subtotal", "tax", "total", "amount_paid",
+			"stripe_fee", "stripe_fee_currency", "customer_country", "customer_tax_ids",
+			"period_start", "period_end", "payment_intent_id", "charge_id",
+		}
+	case DatasetRefunds:
+		return []string{
+			"refund_id", "created_at", "tenant", "user_id", "invoice_id", "charge_id",
+			"status", "reason", "currency", "amount", "failure_reason",
+		}
+	case DatasetDisputes:
+		return []string{
+			"dispute_id", "created_at", "tenant", "user_id", "invoice_id", "charge_id",
+			"status", "reason", "currency", "amount", "closed_at",
+		}
+	case DatasetRecognition:
+		return []string{"month", "tenant", "currency", "billed", "recognized", "deferred"}
+	}
+	return []string{
+		"date", "entry_id", "account", "debit", "credit", "currency", "tenant",
+		"reference", "description",
+	}
+}
+
+func invoiceRecord(invoice *models.InvoiceExport) []string {
+	taxIDs := make([]string, 0, len(invoice.CustomerTaxIDs))
+	for _, id := range invoice.CustomerTaxIDs {
+		taxIDs = append(taxIDs, id.Type+":"+id.Value)
+	}
+
+	fee, feeCurrency := "", ""
+	if invoice.StripeFee != nil && invoice.StripeFeeCurrency != nil {
+		fee = models.FormatAmount(*invoice.StripeFee, *invoice.StripeFeeCurrency)
+		feeCurrency = *invoice.StripeFeeCurrency
+	}
+
+	return []string{
+		invoice.StripeInvoiceID,
+		formatTime(&invoice.CreatedAt),
+		invoice.Tenant,
+		invoice.UserID,
+		invoice.StripeSubscriptionID,
+		string(invoice.Plan),
+		strconv.FormatInt(invoice.Quantity, 10),
+		string(invoice.Status),
+		invoice.Currency,
+		models.FormatAmount(invoice.Subtotal, invoice.Currency),
+		models.FormatAmount(invoice.Tax, invoice.Currency),
+		models.FormatAmount(invoice.Total, invoice.Currency),
+		models.FormatAmount(invoice.AmountPaid, invoice.Currency),
+		fee,
+		feeCurrency,
+		stringValue(invoice.CustomerCountry),
+		strings.Join(taxIDs, ";"),
+		formatTime(invoice.PeriodStart),
+		formatTime(invoice.PeriodEnd),
+		stringValue(invoice.StripePaymentIntentID),
+		stringValue(invoice.StripeChargeID),
+	}
+}
+
+func refundRecord(refund *models.RefundExport) []string {
+	return []string{
+		refund.StripeRefundID,
+		formatTime(&refund.CreatedAt),
+		stringValue(refund.Tenant),
+		stringValue(refund.UserID),
+		stringValue(refund.StripeInvoiceID),
+		stringValue(refund.StripeChargeID),
+		string(refund.Status),
+		stringValue(refund.Reason),
+		refund.Currency,
+		models.FormatAmount(refund.Amount, refund.Currency),
+		stringValue(refund.FailureReason),
+	}
+}
+
+func disputeRecord(dispute *models.DisputeExport) []string {
+	return []string{
+		dispute.StripeDisputeID,
+		formatTime(&dispute.CreatedAt),
+		stringValue(dispute.Tenant),
+		stringValue(dispute.UserID),
+		stringValue(dispute.StripeInvoiceID),
+		dispute.StripeChargeID,
+		string(dispute.Status),
+		dispute.Reason,
+		dispute.Currency,
+		models.FormatAmount(dispute.Amount, dispute.Currency),
+		formatTime(dispute.ClosedAt),
+	}
+}
+
+func recognitionRecord(month *models.RecognitionMonth) []string {
+	return []string{
+		month.Month,
+		month.Tenant,
+		month.Currency,
+		models.FormatAmount(month.Billed, month.Currency),
+		models.FormatAmount(month.Recognized, month.Currency),
+		models.FormatAmount(month.Deferred, month.Currency),
+	}
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -0,0 +1,200 @@
+package export
+
+import (
+	"time"
+
+	"github.com/naventro/payment-service/internal/models"
+)
+
+// Accounts of the journal. Money collected by Stripe is booked to
+// stripe_balance until it is paid out.
+const (
+	AccountStripeBalance = "stripe_balance"
+	AccountRevenue       = "revenue"
+	AccountTaxPayable    = "tax_payable"
+	AccountStripeFees    = "stripe_fees"
+	AccountRefunds       = "refunds"
+	AccountDisputes      = "disputes"
+)
+
+// JournalLine is one side of a journal entry. The lines of an entry share
+// its EntryID, and their debits and credits add up to the same amount.
+// Amounts are in the smallest unit of Currency.
+type JournalLine struct {
+	Date        string `json:"date"`
+	EntryID     string `json:"entry_id"`
+	Account     string `json:"account"`
+	Debit       int64  `json:"debit"`
+	Credit      int64  `json:"credit"`
+	Currency    string `json:"currency"`
+	Tenant      string `json:"tenant"`
+	Reference   string `json:"reference"`
+	Description string `json:"description"`
+}
+
+func (l *JournalLine) record() []string {
+	debit, credit := "", ""
+	if l.Debit != 0 {
+		debit = models.FormatAmount(l.Debit, l.Currency)
+	}
+	if l.Credit != 0 {
+		credit = models.FormatAmount(l.Credit, l.Currency)
+	}
+
+	return []string{l.Date, l.EntryID, l.Account, debit, credit, l.Currency, l.Tenant, l.Reference, l.Description}
+}
+
+// entry builds the lines of one journal entry, leaving out zero amounts
+type entry struct {
+	lines []*JournalLine
+	base  JournalLine
+}
+
+func newEntry(id, date, currency, tenant, reference, description string) *entry {
+	return &entry{base: JournalLine{
+		Date:        date,
+		EntryID:     id,
+		Currency:    currency,
+		Tenant:      tenant,
+		Reference:   reference,
+		Description: description,
+	}}
+}
+
+func (e *entry) debit(account string, amount int64) *entry {
+	return e.add(account, amount, 0)
+}
+
+func (e *entry) credit(account string, amount int64) *entry {
+	return e.add(account, 0, amount)
+}
+
+func (e *entry) add(account string, debit, credit int64) *entry {
+	if debit == 0 && credit == 0 {
+		return e
+	}
+	line := e.base
+	line.Account = account
+	line.Debit = debit
+	line.Credit = credit
+	e.lines = append(e.lines, &line)
+	return e
+}
+
+func (e *entry) emit(fn func(*JournalLine) error) error {
+	for _, line := range e.lines {
+		if err := fn(line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// journal books the period's subscription payments, Stripe fees, one-time
+// orders, refunds and lost disputes. Payments are dated when they were made.
+// Entries are grouped by source, each group sorted by date. Refunds and
+// disputes of payments that did not pay an invoice or an order are not
+// included.
+func (e *Exporter) journal(opts Options, fn func(*JournalLine) error) error {
+	err := e.exportRepo.StreamPaidInvoices(opts.Filter, func(invoice *models.InvoiceExport) error {
+		if invoice.AmountPaid == 0 {
+			return nil
+		}
+
+		paidAt := invoice.CreatedAt
+		if invoice.PaidAt != nil {
+			paidAt = *invoice.PaidAt
+		}
+
+		date := formatDate(paidAt)
+		err := newEntry("invoice:"+invoice.StripeInvoiceID, date, invoice.Currency, invoice.Tenant, invoice.StripeInvoiceID, "Subscription payment").
+			debit(AccountStripeBalance, invoice.AmountPaid).
+			credit(AccountRevenue, invoice.AmountPaid-invoice.Tax).
+			credit(AccountTaxPayable, invoice.Tax).
+			emit(fn)
+		if err != nil || invoice.StripeFee == nil || invoice.StripeFeeCurrency == nil {
+			return err
+		}
+
+		return newEntry("fee:"+invoice.StripeInvoiceID, date, *invoice.StripeFeeCurrency, invoice.Tenant, invoice.StripeInvoiceID, "Stripe processing fee").
+			debit(AccountStripeFees, *invoice.StripeFee).
+			credit(AccountStripeBalance, *invoice.StripeFee).
+			emit(fn)
+	})
+	if err != nil {
+		return err
+	}
+
+	err = e.exportRepo.StreamPaidOrders(opts.Filter, func(order *models.Order) error {
+		if order.Amount == 0 || order.PaidAt == nil {
+			return nil
+		}
+
+		return newEntry("order:"+order.StripePaymentIntentID, formatDate(*order.PaidAt), order.Currency, order.Tenant, order.StripePaymentIntentID, "One-time purchase: "+order.Product).
+			debit(AccountStripeBalance, order.Amount).
+			credit(AccountRevenue, order.Amount-order.Tax).
+			credit(AccountTaxPayable, order.Tax).
+			emit(fn)
+	})
+	if err != nil {
+		return err
+	}
+
+	err = e.exportRepo.StreamRefunds(opts.Filter, func(refund *models.RefundExport) error {
+		if refund.Status != models.RefundStatusSucceeded {
+			return nil
+		}
+
+		// The tax returned is the refunded share of the invoice's or order's tax
+		var reference string
+		var tax int64
+		switch {
+		case refund.StripeInvoiceID != nil:
+			reference = *refund.StripeInvoiceID
+			if refund.InvoiceAmountPaid != nil && refund.InvoiceTax != nil {
+				tax = models.RefundedTax(refund.Amount, *refund.InvoiceAmountPaid, *refund.InvoiceTax)
+			}
+		case refund.OrderID != nil && refund.StripePaymentIntentID != nil:
+			reference = *refund.StripePaymentIntentID
+			if refund.OrderAmount != nil && refund.OrderTax != nil {
+				tax = models.RefundedTax(refund.Amount, *refund.OrderAmount, *refund.OrderTax)
+			}
+		default:
+			return nil
+		}
+
+		return newEntry("refund:"+refund.StripeRefundID, formatDate(refund.CreatedAt), refund.Currency, stringValue(refund.Tenant), reference, "Refund").
+			debit(AccountRefunds, refund.Amount-tax).
+			debit(AccountTaxPayable, tax).
+			credit(AccountStripeBalance, refund.Amount).
+			emit(fn)
+	})
+	if err != nil {
+		return err
+	}
+
+	return e.exportRepo.StreamLostDisputes(opts.Filter, func(dispute *models.DisputeExport) error {
+		if dispute.ClosedAt == nil {
+			return nil
+		}
+
+		var reference string
+		switch {
+		case dispute.StripeInvoiceID != nil:
+			reference = *dispute.StripeInvoiceID
+		case dispute.OrderID != nil && dispute.StripePaymentIntentID != nil:
+			reference = *dispute.StripePaymentIntentID
+		default:
+			return nil
+		}
+
+		return newEntry("dispute:"+dispute.StripeDisputeID, formatDate(*dispute.ClosedAt), dispute.Currency, stringValue(dispute.Tenant), reference, "Lost dispute: "+dispute.Reason).
+			debit(AccountDisputes, dispute.Amount).
+			credit(AccountStripeBalance, dispute.Amount).
+			emit(fn)
+	})
+}
+
+func formatDate(t time.Time) string {
+	return t.UTC().Format("2006-01-02")
+}
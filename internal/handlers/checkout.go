@@ -4,12 +4,14 @@ import (
 	"encoding/json"
 	"net/http"
 
+	"github.com/naventro/payment-service/internal/catalog"
 	"github.com/naventro/payment-service/internal/models"
 	"github.com/naventro/payment-service/internal/stripe"
 )
 
 type CheckoutHandler struct {
 	stripeClient *stripe.Client
+	catalog      *catalog.Catalog
 }
 
 type CheckoutRequest struct {
@@ -24,9 +26,10 @@ type CheckoutResponse struct {
 	SessionURL string `json:"session_url"`
 }
 
-func NewCheckoutHandler(stripeClient *stripe.Client) *CheckoutHandler {
+func NewCheckoutHandler(stripeClient *stripe.Client, catalog *catalog.Catalog) *CheckoutHandler {
 	return &CheckoutHandler{
 		stripeClient: stripeClient,
+		catalog:      catalog,
 	}
 }
 
@@ -65,14 +68,30 @@ func (h *CheckoutHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	priceID, ok := h.catalog.PlanPrice(req.Plan, h.catalog.Currency())
+	if !ok {
+		respondWithError(w, http.StatusInternalServerError, "Plan has no price configured")
+		return
+	}
+
+	// Create or retrieve customer
+	cust, err := h.stripeClient.FindOrCreateCustomer(req.UserID, tenant, "", "")
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error getting/creating customer: "+err.Error())
+		return
+	}
+
 	// Create Stripe checkout session
-	session, err := h.stripeClient.CreateCheckoutSession(
-		req.UserID,
-		tenant,
-		req.Plan,
-		req.SuccessURL,
-		req.CancelURL,
-	)
+	session, err := h.stripeClient.CreateCheckoutSession(stripe.CheckoutParams{
+		CustomerID: cust.ID,
+		UserID:     req.UserID,
+		Tenant:     tenant,
+		Plan:       req.Plan,
+		PriceID:    priceID,
+		Quantity:   1,
+		SuccessURL: req.SuccessURL,
+		CancelURL:  req.CancelURL,
+	})
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Error creating checkout session: "+err.Error())
 		return
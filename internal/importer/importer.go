@@ -0,0 +1,343 @@
+package importer
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/naventro/payment-service/internal/catalog"
+	"github.com/naventro/payment-service/internal/models"
+	"github.com/naventro/payment-service/internal/repository"
+	"github.com/naventro/payment-service/internal/stripe"
+	stripego "github.com/stripe/stripe-go/v84"
+)
+
+// Row maps a Stripe customer or subscription to a user and tenant
+type Row struct {
+	Line     int         `json:"line"`
+	StripeID string      `json:"stripe_id"`
+	UserID   string      `json:"user_id"`
+	Tenant   string      `json:"tenant"`
+	Plan     models.Plan `json:"plan,omitempty"`
+}
+
+// Result reports what was (or, in a dry run, would be) done for a row
+type Result struct {
+	Row            Row      `json:"row"`
+	SubscriptionID string   `json:"subscription_id,omitempty"`
+	Actions        []string `json:"actions"`
+	Error          string   `json:"error,omitempty"`
+}
+
+// ParseCSV reads a mapping file with the header
+// stripe_id,user_id,tenant[,plan]. The stripe_id column accepts either a
+// customer (cus_...) or a subscription (sub_...) ID.
+func ParseCSV(r io.Reader) ([]Row, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("error reading CSV header: %w", err)
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"stripe_id", "user_id", "tenant"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("CSV header is missing column %q", required)
+		}
+	}
+
+	field := func(record []string, name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	var rows []Row
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading CSV line %d: %w", line, err)
+		}
+
+		row := Row{
+			Line:     line,
+			StripeID: field(record, "stripe_id"),
+			UserID:   field(record, "user_id"),
+			Tenant:   field(record, "tenant"),
+			Plan:     models.Plan(field(record, "plan")),
+		}
+		if row.StripeID == "" || row.UserID == "" || row.Tenant == "" {
+			return nil, fmt.Errorf("CSV line %d: stripe_id, user_id and tenant are required", line)
+		}
+		if row.Plan != "" && !row.Plan.IsValid() {
+			return nil, fmt.Errorf("CSV line %d: invalid plan %q", line, row.Plan)
+		}
+
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+// Importer backfills subscriptions created in Stripe before this service existed
+type Importer struct {
+	stripeClient *stripe.Client
+	catalog      *catalog.Catalog
+	subRepo      *repository.SubscriptionRepository
+	invoiceRepo  *repository.InvoiceRepository
+	customerRepo *repository.CustomerRepository
+	historyRepo  *repository.SubscriptionHistoryRepository
+}
+
+func New(
+	stripeClient *stripe.Client,
+	catalog *catalog.Catalog,
+	subRepo *repository.SubscriptionRepository,
+	invoiceRepo *repository.InvoiceRepository,
+	customerRepo *repository.CustomerRepository,
+	historyRepo *repository.SubscriptionHistoryRepository,
+) *Importer {
+	return &Importer{
+		stripeClient: stripeClient,
+		catalog:      catalog,
+		subRepo:      subRepo,
+		invoiceRepo:  invoiceRepo,
+		customerRepo: customerRepo,
+		historyRepo:  historyRepo,
+	}
+}
+
+// Import processes every row. With dryRun set nothing is written to Stripe or
+// the database; the results describe the actions that would be taken.
+func (i *Importer) Import(rows []Row, dryRun bool) []Result {
+	results := make([]Result, 0, len(rows))
+	for _, row := range rows {
+		result := Result{Row: row}
+		if err := i.importRow(row, dryRun, &result); err != nil {
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+func (i *Importer) importRow(row Row, dryRun bool, result *Result) error {
+	sub, err := i.resolveSubscription(row.StripeID)
+	if err != nil {
+		return err
+	}
+	result.SubscriptionID = sub.ID
+
+	if sub.Customer == nil {
+		return fmt.Errorf("subscription %s has no customer", sub.ID)
+	}
+	customerID := sub.Customer.ID
+
+	plan := row.Plan
+	if item := stripe.PlanItem(sub, i.catalog.IsPlanPrice); plan == "" && item != nil && item.Price != nil {
+		plan, _, _ = i.catalog.PlanForPrice(item.Price.ID)
+	}
+	if plan == "" {
+		return fmt.Errorf("cannot determine plan for subscription %s, set the plan column", sub.ID)
+	}
+
+	// Refuse to remap a user that is already linked to another customer or subscription
+	existingCustomer, err := i.customerRepo.GetByUserID(row.UserID, row.Tenant)
+	if err != nil {
+		return err
+	}
+	if existingCustomer != nil && existingCustomer.StripeCustomerID != customerID {
+		return fmt.Errorf("user is already mapped to customer %s", existingCustomer.StripeCustomerID)
+	}
+
+	existingSub, err := i.subRepo.GetByStripeSubscriptionID(sub.ID)
+	if err != nil {
+		return err
+	}
+	if existingSub == nil {
+		userSub, err := i.subRepo.GetByUserID(row.UserID, row.Tenant)
+		if err != nil {
+			return err
+		}
+		if userSub != nil {
+			return fmt.Errorf("user already has subscription %s", userSub.StripeSubscriptionID)
+		}
+	}
+
+	action := func(format string, args ...interface{}) {
+		result.Actions = append(result.Actions, fmt.Sprintf(format, args...))
+	}
+
+	// Patch Stripe metadata so future webhooks carry our keys
+	customerMetadata := map[string]string{"user_id": row.UserID, "tenant": row.Tenant}
+	subMetadata := map[string]string{"user_id": row.UserID, "tenant": row.Tenant, "plan": string(plan)}
+
+	cust, err := i.stripeClient.GetCustomer(customerID)
+	if err != nil {
+		return err
+	}
+	if !hasMetadata(cust.Metadata, customerMetadata) {
+		action("set metadata on customer %s", customerID)
+		if !dryRun {
+			if _, err := i.stripeClient.UpdateCustomerMetadata(customerID, customerMetadata); err != nil {
+				return err
+			}
+		}
+	}
+
+	if !hasMetadata(sub.Metadata, subMetadata) {
+		action("set metadata on subscription %s", sub.ID)
+		if !dryRun {
+			if _, err := i.stripeClient.UpdateSubscriptionMetadata(sub.ID, subMetadata); err != nil {
+				return err
+			}
+		}
+	}
+
+	if existingCustomer == nil {
+		action("insert customer %s", customerID)
+		if !dryRun {
+			created, err := i.customerRepo.Create(&models.Customer{
+				UserID:           row.UserID,
+				Tenant:           row.Tenant,
+				StripeCustomerID: customerID,
+				Email:            cust.Email,
+				Name:             cust.Name,
+			})
+			if err != nil {
+				return err
+			}
+			if !created {
+				return fmt.Errorf("user was mapped to another customer while importing")
+			}
+		}
+	}
+
+	if existingSub == nil {
+		action("insert subscription %s (%s, %s)", sub.ID, plan, sub.Status)
+
+		subscription := stripe.ToSubscription(sub, i.catalog.IsPlanPrice)
+		subscription.UserID = row.UserID
+		subscription.Tenant = row.Tenant
+		subscription.Plan = plan
+
+		if dryRun {
+			// Nothing to attach invoices to; report what would be inserted
+			return i.listPaidInvoices(sub.ID, func(inv *models.Invoice) error {
+				action("insert invoice %s (%d %s)", inv.StripeInvoiceID, inv.AmountPaid, inv.Currency)
+				return nil
+			})
+		}
+
+		if err := i.subRepo.Create(subscription); err != nil {
+			return err
+		}
+		existingSub = subscription
+
+		// Analytics start from the current state as of the subscription's start
+		err := i.historyRepo.Record(&models.SubscriptionHistory{
+			SubscriptionID: subscription.ID,
+			UserID:         subscription.UserID,
+			Tenant:         subscription.Tenant,
+			Plan:           subscription.Plan,
+			Status:         subscription.Status,
+			Quantity:       subscription.Quantity,
+			Currency:       subscription.Currency,
+			MRR:            stripe.MonthlyRecurringAmount(sub),
+			RecordedAt:     time.Unix(sub.StartDate, 0),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return i.listPaidInvoices(sub.ID, func(inv *models.Invoice) error {
+		existing, err := i.invoiceRepo.GetByStripeInvoiceID(inv.StripeInvoiceID)
+		if err != nil || existing != nil {
+			return err
+		}
+
+		action("insert invoice %s (%d %s)", inv.StripeInvoiceID, inv.AmountPaid, inv.Currency)
+		if dryRun {
+			return nil
+		}
+
+		inv.SubscriptionID = existingSub.ID
+		inv.UserID = existingSub.UserID
+		inv.Tenant = existingSub.Tenant
+		return i.invoiceRepo.Create(inv)
+	})
+}
+
+// resolveSubscription returns the subscription referenced by a row. For a
+// customer ID it picks the customer's only live subscription, or the most
+// recent one if all of them have ended.
+func (i *Importer) resolveSubscription(stripeID string) (*stripego.Subscription, error) {
+	switch {
+	case strings.HasPrefix(stripeID, "sub_"):
+		return i.stripeClient.GetSubscription(stripeID)
+
+	case strings.HasPrefix(stripeID, "cus_"):
+		var live, latest *stripego.Subscription
+		liveCount := 0
+		err := i.stripeClient.ListCustomerSubscriptions(stripeID, func(sub *stripego.Subscription) error {
+			if latest == nil || sub.Created > latest.Created {
+				latest = sub
+			}
+			if sub.Status != stripego.SubscriptionStatusCanceled && sub.Status != stripego.SubscriptionStatusIncompleteExpired {
+				live = sub
+				liveCount++
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case liveCount > 1:
+			return nil, fmt.Errorf("customer %s has %d live subscriptions, map them by subscription ID", stripeID, liveCount)
+		case live != nil:
+			return live, nil
+		case latest != nil:
+			return latest, nil
+		default:
+			return nil, fmt.Errorf("customer %s has no subscriptions", stripeID)
+		}
+
+	default:
+		return nil, fmt.Errorf("unsupported Stripe ID %q, expected a customer or subscription ID", stripeID)
+	}
+}
+
+func (i *Importer) listPaidInvoices(subscriptionID string, fn func(*models.Invoice) error) error {
+	return i.stripeClient.ListInvoices(subscriptionID, func(inv *stripego.Invoice) error {
+		if inv.Status != stripego.InvoiceStatusPaid {
+			return nil
+		}
+		return fn(stripe.ToInvoice(inv))
+	})
+}
+
+// hasMetadata reports whether metadata already contains every wanted key and value
+func hasMetadata(metadata, wanted map[string]string) bool {
+	for key, value := range wanted {
+		if metadata[key] != value {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,415 @@
+package invoicepdf
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-pdf/fpdf"
+	"github.com/naventro/payment-service/internal/models"
+	"github.com/naventro/payment-service/internal/tenant"
+)
+
+// Document is what an invoice PDF shows. Amounts are in the smallest unit
+// of Currency.
+type Document struct {
+	Number   string
+	IssuedAt time.Time
+	// Reference is the Stripe invoice ID
+	Reference string
+	Currency  string
+
+	CustomerName    string
+	CustomerEmail   string
+	CustomerAddress []string
+	CustomerTaxIDs  []string
+
+	Lines      models.InvoiceLines
+	Subtotal   int64
+	Taxes      models.InvoiceTaxes
+	Total      int64
+	AmountPaid int64
+}
+
+// FromInvoice builds the document of a stored invoice. Invoices stored
+// without their lines show a single line with description for the whole
+// subtotal.
+func FromInvoice(invoice *models.Invoice, description string, quantity int64) *Document {
+	doc := &Document{
+		IssuedAt:   invoice.CreatedAt,
+		Reference:  invoice.StripeInvoiceID,
+		Currency:   invoice.Currency,
+		Lines:      invoice.Lines,
+		Subtotal:   invoice.Subtotal,
+		Taxes:      invoice.TaxBreakdown,
+		Total:      invoice.Total,
+		AmountPaid: invoice.AmountPaid,
+	}
+
+	if invoice.Number != nil {
+		doc.Number = *invoice.Number
+	}
+	if invoice.CustomerName != nil {
+		doc.CustomerName = *invoice.CustomerName
+	}
+	if invoice.CustomerEmail != nil {
+		doc.CustomerEmail = *invoice.CustomerEmail
+	}
+	if address := invoice.CustomerAddress; address != nil {
+		doc.CustomerAddress = nonEmpty(
+			address.Line1,
+			address.Line2,
+			strings.TrimSpace(address.PostalCode+" "+address.City),
+			address.State,
+			address.Country,
+		)
+	} else if invoice.CustomerCountry != nil {
+		doc.CustomerAddress = []string{*invoice.CustomerCountry}
+	}
+	for _, id := range invoice.CustomerTaxIDs {
+		doc.CustomerTaxIDs = append(doc.CustomerTaxIDs, id.Value)
+	}
+
+	if len(doc.Taxes) == 0 && invoice.Tax != 0 {
+		doc.Taxes = models.InvoiceTaxes{{Amount: invoice.Tax}}
+	}
+
+	if len(doc.Lines) == 0 {
+		doc.Lines = models.InvoiceLines{{
+			Description: description,
+			Quantity:    quantity,
+			Amount:      invoice.Subtotal,
+			PeriodStart: invoice.PeriodStart,
+			PeriodEnd:   invoice.PeriodEnd,
+		}}
+	}
+
+	// Invoices created before Stripe reported totals only know the amount paid
+	if doc.Total == 0 && doc.Subtotal == 0 {
+		doc.Subtotal = invoice.AmountPaid
+		doc.Total = invoice.AmountPaid
+	}
+
+	return doc
+}
+
+// labels are the texts of the invoice in each locale
+var labels = map[string]map[string]string{
+	"en": {
+		"invoice":     "Invoice",
+		"number":      "Invoice number",
+		"date":        "Date",
+		"reference":   "Reference",
+		"bill_to":     "Bill to",
+		"tax_id":      "Tax ID",
+		"description": "Description",
+		"quantity":    "Qty",
+		"amount":      "Amount",
+		"subtotal":    "Subtotal",
+		"tax":         "Tax",
+		"total":       "Total",
+		"paid":        "Amount paid",
+	},
+	"es": {
+		"invoice":     "Factura",
+		"number":      "Número de factura",
+		"date":        "Fecha",
+		"reference":   "Referencia",
+		"bill_to":     "Facturar a",
+		"tax_id":      "NIF",
+		"description": "Descripción",
+		"quantity":    "Cant.",
+		"amount":      "Importe",
+		"subtotal":    "Base imponible",
+		"tax":         "Impuesto",
+		"total":       "Total",
+		"paid":        "Importe pagado",
+	},
+}
+
+// renderer draws a document with the settings of its tenant
+type renderer struct {
+	pdf      *fpdf.Fpdf
+	tr       func(string) string
+	doc      *Document
+	settings tenant.InvoiceSettings
+	labels   map[string]string
+	accent   [3]int
+	compact  bool
+}
+
+// Render writes the PDF of doc laid out with the tenant's template
+func Render(w io.Writer, doc *Document, settings tenant.InvoiceSettings) error {
+	pdf := fpdf.New("P", "mm", "A4", "")
+	pdf.SetMargins(18, 18, 18)
+	pdf.SetAutoPageBreak(true, 25)
+	pdf.SetTitle(doc.Number, true)
+	pdf.SetCreator(settings.LegalName, true)
+
+	locale := settings.Locale
+	if locale == "" {
+		locale = "en"
+	}
+
+	r := &renderer{
+		pdf:      pdf,
+		tr:       pdf.UnicodeTranslatorFromDescriptor(""),
+		doc:      doc,
+		settings: settings,
+		labels:   labels[locale],
+		accent:   parseColor(settings.AccentColor),
+		compact:  settings.Template == "compact",
+	}
+
+	if settings.Footer != "" {
+		pdf.SetFooterFunc(r.footer)
+	}
+
+	pdf.AddPage()
+	r.header()
+	r.parties()
+	r.lines()
+	r.totals()
+
+	if err := pdf.Error(); err != nil {
+		return fmt.Errorf("error rendering invoice PDF: %w", err)
+	}
+
+	return pdf.Output(w)
+}
+
+func (r *renderer) header() {
+	pdf := r.pdf
+	left, top, _, _ := pdf.GetMargins()
+	pageWidth, _ := pdf.GetPageSize()
+	right := pageWidth - left
+
+	logoHeight := 18.0
+	if r.compact {
+		logoHeight = 12
+	}
+	if r.settings.LogoFile != "" {
+		pdf.ImageOptions(r.settings.LogoFile, left, top, 0, logoHeight, false, fpdf.ImageOptions{ReadDpi: true}, 0, "")
+	}
+
+	// Seller details, right aligned
+	pdf.SetXY(left, top)
+	pdf.SetFont("Helvetica", "B", 11)
+	pdf.SetTextColor(0, 0, 0)
+	pdf.CellFormat(right-left, 5, r.tr(r.settings.LegalName), "", 2, "R", false, 0, "")
+	pdf.SetFont("Helvetica", "", 9)
+	seller := append([]string{}, r.settings.Address...)
+	if r.settings.FiscalID != "" {
+		seller = append(seller, r.labels["tax_id"]+": "+r.settings.FiscalID)
+	}
+	if r.settings.Email != "" {
+		seller = append(seller, r.settings.Email)
+	}
+	for _, line := range seller {
+		pdf.CellFormat(right-left, 4.5, r.tr(line), "", 2, "R", false, 0, "")
+	}
+
+	y := pdf.GetY()
+	if logoTop := top + logoHeight; y < logoTop {
+		y = logoTop
+	}
+	pdf.SetXY(left, y+8)
+
+	// Title and invoice details
+	pdf.SetTextColor(r.accent[0], r.accent[1], r.accent[2])
+	if r.compact {
+		pdf.SetFont("Helvetica", "B", 14)
+		pdf.CellFormat(0, 7, r.tr(r.labels["invoice"]+" "+r.doc.Number), "", 1, "L", false, 0, "")
+	} else {
+		pdf.SetFont("Helvetica", "B", 22)
+		pdf.CellFormat(0, 10, r.tr(strings.ToUpper(r.labels["invoice"])), "", 1, "L", false, 0, "")
+	}
+	pdf.SetTextColor(0, 0, 0)
+	pdf.Ln(2)
+
+	details := [][2]string{
+		{r.labels["number"], r.doc.Number},
+		{r.labels["date"], r.formatDate(r.doc.IssuedAt)},
+		{r.labels["reference"], r.doc.Reference},
+	}
+	if r.compact {
+		details = details[1:]
+	}
+	for _, detail := range details {
+		pdf.SetFont("Helvetica", "B", 9)
+		pdf.CellFormat(38, 5, r.tr(detail[0]), "", 0, "L", false, 0, "")
+		pdf.SetFont("Helvetica", "", 9)
+		pdf.CellFormat(0, 5, r.tr(detail[1]), "", 1, "L", false, 0, "")
+	}
+	pdf.Ln(6)
+}
+
+func (r *renderer) parties() {
+	pdf := r.pdf
+
+	pdf.SetFont("Helvetica", "B", 10)
+	pdf.SetTextColor(r.accent[0], r.accent[1], r.accent[2])
+	pdf.CellFormat(0, 6, r.tr(r.labels["bill_to"]), "", 1, "L", false, 0, "")
+	pdf.SetTextColor(0, 0, 0)
+
+	pdf.SetFont("Helvetica", "B", 9)
+	if r.doc.CustomerName != "" {
+		pdf.CellFormat(0, 5, r.tr(r.doc.CustomerName), "", 1, "L", false, 0, "")
+	}
+	pdf.SetFont("Helvetica", "", 9)
+	customer := append([]string{}, r.doc.CustomerAddress...)
+	for _, id := range r.doc.CustomerTaxIDs {
+		customer = append(customer, r.labels["tax_id"]+": "+id)
+	}
+	if r.doc.CustomerEmail != "" {
+		customer = append(customer, r.doc.CustomerEmail)
+	}
+	for _, line := range customer {
+		pdf.CellFormat(0, 4.5, r.tr(line), "", 1, "L", false, 0, "")
+	}
+	pdf.Ln(8)
+}
+
+func (r *renderer) lines() {
+	pdf := r.pdf
+	left, _, right, _ := pdf.GetMargins()
+	pageWidth, _ := pdf.GetPageSize()
+	width := pageWidth - left - right
+	quantityWidth, amountWidth := 18.0, 35.0
+	descriptionWidth := width - quantityWidth - amountWidth
+
+	pdf.SetFont("Helvetica", "B", 9)
+	if r.compact {
+		pdf.SetDrawColor(r.accent[0], r.accent[1], r.accent[2])
+		pdf.CellFormat(descriptionWidth, 7, r.tr(r.labels["description"]), "B", 0, "L", false, 0, "")
+		pdf.CellFormat(quantityWidth, 7, r.tr(r.labels["quantity"]), "B", 0, "R", false, 0, "")
+		pdf.CellFormat(amountWidth, 7, r.tr(r.labels["amount"]), "B", 1, "R", false, 0, "")
+	} else {
+		pdf.SetFillColor(r.accent[0], r.accent[1], r.accent[2])
+		pdf.SetTextColor(255, 255, 255)
+		pdf.CellFormat(descriptionWidth, 8, " "+r.tr(r.labels["description"]), "", 0, "L", true, 0, "")
+		pdf.CellFormat(quantityWidth, 8, r.tr(r.labels["quantity"]), "", 0, "R", true, 0, "")
+		pdf.CellFormat(amountWidth, 8, r.tr(r.labels["amount"])+" ", "", 1, "R", true, 0, "")
+		pdf.SetTextColor(0, 0, 0)
+	}
+
+	pdf.SetFont("Helvetica", "", 9)
+	pdf.SetDrawColor(220, 220, 220)
+	for _, line := range r.doc.Lines {
+		description := line.Description
+		if line.PeriodStart != nil && line.PeriodEnd != nil {
+			description += "\n" + r.formatDate(*line.PeriodStart) + " - " + r.formatDate(*line.PeriodEnd)
+		}
+
+		x, y := pdf.GetXY()
+		pdf.MultiCell(descriptionWidth, 5, r.tr(description), "", "L", false)
+		height := pdf.GetY() - y
+
+		pdf.SetXY(x+descriptionWidth, y)
+		quantity := ""
+		if line.Quantity > 0 {
+			quantity = strconv.FormatInt(line.Quantity, 10)
+		}
+		pdf.CellFormat(quantityWidth, 5, quantity, "", 0, "R", false, 0, "")
+		pdf.CellFormat(amountWidth, 5, r.tr(r.formatAmount(line.Amount))+" ", "", 0, "R", false, 0, "")
+
+		pdf.SetXY(x, y+height+1)
+		pdf.Line(x, pdf.GetY(), x+width, pdf.GetY())
+		pdf.Ln(1)
+	}
+	pdf.Ln(4)
+}
+
+func (r *renderer) totals() {
+	pdf := r.pdf
+	_, _, right, _ := pdf.GetMargins()
+	pageWidth, _ := pdf.GetPageSize()
+	labelWidth, amountWidth := 60.0, 35.0
+	x := pageWidth - right - labelWidth - amountWidth
+
+	row := func(label string, amount int64, bold bool) {
+		style := ""
+		if bold {
+			style = "B"
+		}
+		pdf.SetX(x)
+		pdf.SetFont("Helvetica", style, 9)
+		pdf.CellFormat(labelWidth, 6, r.tr(label), "", 0, "R", false, 0, "")
+		pdf.CellFormat(amountWidth, 6, r.tr(r.formatAmount(amount))+" ", "", 1, "R", false, 0, "")
+	}
+
+	row(r.labels["subtotal"], r.doc.Subtotal, false)
+	for _, tax := range r.doc.Taxes {
+		row(r.taxLabel(tax), tax.Amount, false)
+	}
+
+	pdf.SetDrawColor(r.accent[0], r.accent[1], r.accent[2])
+	pdf.Line(x, pdf.GetY(), pageWidth-right, pdf.GetY())
+	row(r.labels["total"], r.doc.Total, true)
+	row(r.labels["paid"], r.doc.AmountPaid, false)
+}
+
+func (r *renderer) footer() {
+	pdf := r.pdf
+	pdf.SetY(-20)
+	pdf.SetFont("Helvetica", "", 7.5)
+	pdf.SetTextColor(110, 110, 110)
+	pdf.MultiCell(0, 3.5, r.tr(r.settings.Footer), "", "C", false)
+}
+
+func (r *renderer) taxLabel(tax models.InvoiceTax) string {
+	label := tax.DisplayName
+	if label == "" {
+		label = r.labels["tax"]
+	}
+	if tax.Percentage > 0 {
+		label += " " + strconv.FormatFloat(tax.Percentage, 'f', -1, 64) + "%"
+	}
+	if tax.Jurisdiction != "" {
+		label += " (" + tax.Jurisdiction + ")"
+	}
+	return label
+}
+
+func (r *renderer) formatAmount(amount int64) string {
+	value := models.FormatAmount(amount, r.doc.Currency)
+	if r.settings.Locale == "es" {
+		value = strings.Replace(value, ".", ",", 1)
+	}
+	return value + " " + strings.ToUpper(r.doc.Currency)
+}
+
+func (r *renderer) formatDate(t time.Time) string {
+	if r.settings.Locale == "es" {
+		return t.UTC().Format("02/01/2006")
+	}
+	return t.UTC().Format("Jan 2, 2006")
+}
+
+// parseColor parses a #rrggbb color, defaulting to dark gray
+func parseColor(hex string) [3]int {
+	color := [3]int{51, 51, 51}
+	if len(hex) != 7 {
+		return color
+	}
+	for i := range color {
+		value, err := strconv.ParseUint(hex[1+2*i:3+2*i], 16, 8)
+		if err != nil {
+			return [3]int{51, 51, 51}
+		}
+		color[i] = int(value)
+	}
+	return color
+}
+
+func nonEmpty(values ...string) []string {
+	var result []string
+	for _, value := range values {
+		if value != "" {
+			result = append(result, value)
+		}
+	}
+	return result
+}
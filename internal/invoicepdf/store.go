@@ -0,0 +1,78 @@
+package invoicepdf
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Store keeps rendered invoices in a directory, one per tenant. A store
+// without a directory keeps nothing and invoices are rendered on every
+// request.
+type Store struct {
+	dir string
+}
+
+func NewStore(dir string) *Store {
+	return &Store{dir: dir}
+}
+
+// Enabled reports whether rendered invoices are kept
+func (s *Store) Enabled() bool {
+	return s.dir != ""
+}
+
+func (s *Store) path(tenant, stripeInvoiceID string) string {
+	return filepath.Join(s.dir, filepath.Base(tenant), filepath.Base(stripeInvoiceID)+".pdf")
+}
+
+// Load returns the stored PDF of an invoice, or false if there is none
+func (s *Store) Load(tenant, stripeInvoiceID string) ([]byte, bool, error) {
+	if !s.Enabled() {
+		return nil, false, nil
+	}
+
+	data, err := os.ReadFile(s.path(tenant, stripeInvoiceID))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("error reading invoice PDF: %w", err)
+	}
+
+	return data, true, nil
+}
+
+// Save stores the PDF of an invoice, replacing the previous one
+func (s *Store) Save(tenant, stripeInvoiceID string, data []byte) error {
+	if !s.Enabled() {
+		return nil
+	}
+
+	path := s.path(tenant, stripeInvoiceID)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("error creating invoice PDF directory: %w", err)
+	}
+
+	// Write to a temporary file first so readers never see a partial PDF
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".invoice-*.pdf")
+	if err != nil {
+		return fmt.Errorf("error writing invoice PDF: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing invoice PDF: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error writing invoice PDF: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("error writing invoice PDF: %w", err)
+	}
+
+	return nil
+}
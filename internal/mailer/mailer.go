@@ -0,0 +1,213 @@
+package mailer
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/naventro/payment-service/internal/config"
+)
+
+// Message is a plain text email
+type Message struct {
+	From    string
+	To      string
+	ReplyTo string
+	Subject string
+	Text    string
+}
+
+// Mailer delivers emails
+type Mailer interface {
+	Send(msg *Message) error
+}
+
+// New returns the mailer of the configured backend, or nil if emails are
+// disabled
+func New(cfg *config.Config) Mailer {
+	switch cfg.MailBackend {
+	case "log":
+		return LogMailer{}
+	case "file":
+		return NewFileMailer(cfg.MailFileDir)
+	case "smtp":
+		return NewSMTPMailer(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword)
+	}
+	return nil
+}
+
+// smtpTimeout bounds connecting to the SMTP server and the whole delivery of
+// an email, which runs in the request or event that sends it
+const smtpTimeout = 30 * time.Second
+
+// SMTPMailer sends emails through an SMTP server, upgrading the connection
+// with STARTTLS when the server supports it
+type SMTPMailer struct {
+	host string
+	addr string
+	auth smtp.Auth
+}
+
+func NewSMTPMailer(host string, port int, username, password string) *SMTPMailer {
+	m := &SMTPMailer{host: host, addr: host + ":" + strconv.Itoa(port)}
+	if username != "" {
+		m.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return m
+}
+
+func (m *SMTPMailer) Send(msg *Message) error {
+	from, err := mail.ParseAddress(msg.From)
+	if err != nil {
+		return fmt.Errorf("invalid sender %q: %w", msg.From, err)
+	}
+
+	data, err := msg.Bytes()
+	if err != nil {
+		return err
+	}
+
+	if err := m.send(from.Address, msg.To, data); err != nil {
+		return fmt.Errorf("error sending email: %w", err)
+	}
+
+	return nil
+}
+
+// send delivers data like smtp.SendMail, but gives up once smtpTimeout has
+// passed instead of waiting on an unresponsive server
+func (m *SMTPMailer) send(from, to string, data []byte) error {
+	conn, err := net.DialTimeout("tcp", m.addr, smtpTimeout)
+	if err != nil {
+		return err
+	}
+	if err := conn.SetDeadline(time.Now().Add(smtpTimeout)); err != nil {
+		conn.Close()
+		return err
+	}
+
+	c, err := smtp.NewClient(conn, m.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: m.host}); err != nil {
+			return err
+		}
+	}
+	if m.auth != nil {
+		if err := c.Auth(m.auth); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+	if err := c.Rcpt(to); err != nil {
+		return err
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
+
+// FileMailer writes each email to an .eml file in a directory, for
+// development and tests
+type FileMailer struct {
+	dir string
+}
+
+func NewFileMailer(dir string) *FileMailer {
+	return &FileMailer{dir: dir}
+}
+
+func (m *FileMailer) Send(msg *Message) error {
+	data, err := msg.Bytes()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(m.dir, 0o755); err != nil {
+		return fmt.Errorf("error creating mail directory: %w", err)
+	}
+
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405.000000000"), filepath.Base(msg.To))
+	if err := os.WriteFile(filepath.Join(m.dir, name), data, 0o644); err != nil {
+		return fmt.Errorf("error writing email: %w", err)
+	}
+
+	return nil
+}
+
+// LogMailer logs emails instead of sending them
+type LogMailer struct{}
+
+func (LogMailer) Send(msg *Message) error {
+	log.Printf("Email to %s from %s: %s\n%s", msg.To, msg.From, msg.Subject, msg.Text)
+	return nil
+}
+
+// Bytes returns the message in RFC 5322 format, its body encoded as
+// quoted-printable UTF-8
+func (msg *Message) Bytes() ([]byte, error) {
+	var buf bytes.Buffer
+	header := func(name, value string) {
+		if value != "" {
+			buf.WriteString(name + ": " + value + "\r\n")
+		}
+	}
+
+	header("From", encodeAddress(msg.From))
+	header("To", msg.To)
+	header("Reply-To", encodeAddress(msg.ReplyTo))
+	header("Subject", mime.QEncoding.Encode("utf-8", msg.Subject))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("MIME-Version", "1.0")
+	header("Content-Type", "text/plain; charset=utf-8")
+	header("Content-Transfer-Encoding", "quoted-printable")
+	buf.WriteString("\r\n")
+
+	w := quotedprintable.NewWriter(&buf)
+	if _, err := w.Write([]byte(strings.ReplaceAll(msg.Text, "\n", "\r\n"))); err != nil {
+		return nil, fmt.Errorf("error encoding email: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("error encoding email: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// encodeAddress encodes the display name of an address, which may not be ASCII
+func encodeAddress(address string) string {
+	parsed, err := mail.ParseAddress(address)
+	if err != nil {
+		return address
+	}
+	return parsed.String()
+}
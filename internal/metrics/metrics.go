@@ -0,0 +1,87 @@
+package metrics
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Counter is a named, labeled count
+type Counter struct {
+	Name   string            `json:"name"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Value  int64             `json:"value"`
+}
+
+// Registry holds in-process counters. Counts reset when the service restarts.
+type Registry struct {
+	mu       sync.Mutex
+	counters map[string]*Counter
+}
+
+func NewRegistry() *Registry {
+	return &Registry{counters: make(map[string]*Counter)}
+}
+
+// Inc increments the counter identified by name and labels
+func (r *Registry) Inc(name string, labels map[string]string) {
+	key := counterKey(name, labels)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	counter, ok := r.counters[key]
+	if !ok {
+		counter = &Counter{Name: name, Labels: copyLabels(labels)}
+		r.counters[key] = counter
+	}
+	counter.Value++
+}
+
+// Counters returns a snapshot of every counter, sorted by name and labels
+func (r *Registry) Counters() []Counter {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	keys := make([]string, 0, len(r.counters))
+	for key := range r.counters {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	counters := make([]Counter, 0, len(keys))
+	for _, key := range keys {
+		counter := *r.counters[key]
+		counter.Labels = copyLabels(counter.Labels)
+		counters = append(counters, counter)
+	}
+
+	return counters
+}
+
+func counterKey(name string, labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for label := range labels {
+		names = append(names, label)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString(name)
+	for _, label := range names {
+		b.WriteString("|" + label + "=" + labels[label])
+	}
+	return b.String()
+}
+
+func copyLabels(labels map[string]string) map[string]string {
+	if len(labels) == 0 {
+		return nil
+	}
+
+	copied := make(map[string]string, len(labels))
+	for k, v := range labels {
+		copied[k] = v
+	}
+	return copied
+}
@@ -0,0 +1,36 @@
+package models
+
+import "time"
+
+type CheckoutSessionStatus string
+
+const (
+	CheckoutSessionStatusOpen     CheckoutSessionStatus = "open"
+	CheckoutSessionStatusComplete CheckoutSessionStatus = "complete"
+	CheckoutSessionStatusExpired  CheckoutSessionStatus = "expired"
+)
+
+// CheckoutSession records a checkout attempt and the subscription it produced
+type CheckoutSession struct {
+	ID              int    `json:"id"`
+	StripeSessionID string `json:"stripe_session_id"`
+	UserID          string `json:"user_id"`
+	Tenant          string `json:"tenant"`
+	Plan            Plan   `json:"plan"`
+	// Product is set instead of Plan for one-time purchases
+	Product              *string               `json:"product,omitempty"`
+	StripeCustomerID     *string               `json:"stripe_customer_id,omitempty"`
+	SuccessURL           string                `json:"success_url"`
+	CancelURL            string                `json:"cancel_url"`
+	Status               CheckoutSessionStatus `json:"status"`
+	StripeSubscriptionID *string               `json:"stripe_subscription_id,omitempty"`
+	SubscriptionID       *int                  `json:"subscription_id,omitempty"`
+	CompletedAt          *time.Time            `json:"completed_at,omitempty"`
+	ExpiredAt            *time.Time            `json:"expired_at,omitempty"`
+	CreatedAt            time.Time             `json:"created_at"`
+	UpdatedAt            time.Time             `json:"updated_at"`
+}
+
+func (s CheckoutSessionStatus) String() string {
+	return string(s)
+}
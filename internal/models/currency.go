@@ -0,0 +1,39 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+)
+
+// zeroDecimalCurrencies have no minor unit, so Stripe amounts in them are
+// whole units
+var zeroDecimalCurrencies = map[string]bool{
+	"bif": true, "clp": true, "djf": true, "gnf": true, "jpy": true, "kmf": true,
+	"krw": true, "mga": true, "pyg": true, "rwf": true, "ugx": true, "vnd": true,
+	"vuv": true, "xaf": true, "xof": true, "xpf": true,
+}
+
+// MinorUnits returns how many of the smallest unit of currency, the unit of
+// Stripe amounts, make one whole unit
+func MinorUnits(currency string) int64 {
+	if zeroDecimalCurrencies[strings.ToLower(currency)] {
+		return 1
+	}
+	return 100
+}
+
+// FormatAmount formats an amount in the smallest unit of currency as a
+// decimal number, e.g. 1999 usd as "19.99"
+func FormatAmount(amount int64, currency string) string {
+	units := MinorUnits(currency)
+	if units == 1 {
+		return fmt.Sprintf("%d", amount)
+	}
+
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, amount/units, amount%units)
+}
@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// Customer maps a user within a tenant to their Stripe customer
+type Customer struct {
+	ID               int       `json:"id"`
+	UserID           string    `json:"user_id"`
+	Tenant           string    `json:"tenant"`
+	StripeCustomerID string    `json:"stripe_customer_id"`
+	Email            string    `json:"email"`
+	Name             string    `json:"name"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
@@ -0,0 +1,62 @@
+package models
+
+import "time"
+
+type DisputeStatus string
+
+const (
+	DisputeStatusWarningNeedsResponse DisputeStatus = "warning_needs_response"
+	DisputeStatusWarningUnderReview   DisputeStatus = "warning_under_review"
+	DisputeStatusWarningClosed        DisputeStatus = "warning_closed"
+	DisputeStatusNeedsResponse        DisputeStatus = "needs_response"
+	DisputeStatusUnderReview          DisputeStatus = "under_review"
+	DisputeStatusWon                  DisputeStatus = "won"
+	DisputeStatusLost                 DisputeStatus = "lost"
+	DisputeStatusPrevented            DisputeStatus = "prevented"
+)
+
+// Dispute is a chargeback filed against a payment
+type Dispute struct {
+	ID                    int     `json:"id"`
+	StripeDisputeID       string  `json:"stripe_dispute_id"`
+	StripeChargeID        string  `json:"stripe_charge_id"`
+	StripePaymentIntentID *string `json:"stripe_payment_intent_id,omitempty"`
+	InvoiceID             *int    `json:"invoice_id,omitempty"`
+	SubscriptionID        *int    `json:"subscription_id,omitempty"`
+	// OrderID is set instead of InvoiceID for disputes of one-time purchases
+	OrderID             *int          `json:"order_id,omitempty"`
+	UserID              *string       `json:"user_id,omitempty"`
+	Tenant              *string       `json:"tenant,omitempty"`
+	Amount              int64         `json:"amount"`
+	Currency            string        `json:"currency"`
+	Reason              string        `json:"reason"`
+	Status              DisputeStatus `json:"status"`
+	EvidenceDueBy       *time.Time    `json:"evidence_due_by,omitempty"`
+	EntitlementsRevoked bool          `json:"entitlements_revoked"`
+	ClosedAt            *time.Time    `json:"closed_at,omitempty"`
+	CreatedAt           time.Time     `json:"created_at"`
+	UpdatedAt           time.Time     `json:"updated_at"`
+}
+
+func (s DisputeStatus) String() string {
+	return string(s)
+}
+
+// IsClosed reports whether the dispute has reached a final status
+func (s DisputeStatus) IsClosed() bool {
+	switch s {
+	case DisputeStatusWon, DisputeStatusLost, DisputeStatusWarningClosed, DisputeStatusPrevented:
+		return true
+	}
+	return false
+}
+
+// BlocksEntitlements reports whether the dispute keeps the user's
+// entitlements revoked: from the moment it opens until it is resolved in the
+// merchant's favor
+func (d *Dispute) BlocksEntitlements() bool {
+	if !d.EntitlementsRevoked {
+		return false
+	}
+	return !d.Status.IsClosed() || d.Status == DisputeStatusLost
+}
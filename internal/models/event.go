@@ -0,0 +1,33 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type EventStatus string
+
+const (
+	EventStatusReceived  EventStatus = "received"
+	EventStatusProcessed EventStatus = "processed"
+	EventStatusFailed    EventStatus = "failed"
+)
+
+// StripeEvent is a Stripe event as received by the webhook endpoint
+type StripeEvent struct {
+	ID              int             `json:"id"`
+	StripeEventID   string          `json:"stripe_event_id"`
+	Type            string          `json:"type"`
+	Status          EventStatus     `json:"status"`
+	Payload         json.RawMessage `json:"payload,omitempty"`
+	Error           *string         `json:"error,omitempty"`
+	Attempts        int             `json:"attempts"`
+	Livemode        bool            `json:"livemode"`
+	StripeCreatedAt time.Time       `json:"stripe_created_at"`
+	ReceivedAt      time.Time       `json:"received_at"`
+	ProcessedAt     *time.Time      `json:"processed_at,omitempty"`
+}
+
+func (s EventStatus) String() string {
+	return string(s)
+}
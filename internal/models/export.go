@@ -0,0 +1,31 @@
+package models
+
+// InvoiceExport is an invoice together with the subscription it bills, as
+// exported for accounting
+type InvoiceExport struct {
+	Invoice
+	StripeSubscriptionID string `json:"stripe_subscription_id"`
+	Plan                 Plan   `json:"plan"`
+	Quantity             int64  `json:"quantity"`
+}
+
+// RefundExport is a refund together with the invoice or order it returns
+// money for, if any
+type RefundExport struct {
+	Refund
+	StripeInvoiceID *string `json:"stripe_invoice_id,omitempty"`
+	// Amount and tax of the refunded invoice, used to split the refund
+	// between revenue and tax payable
+	InvoiceAmountPaid *int64 `json:"invoice_amount_paid,omitempty"`
+	InvoiceTax        *int64 `json:"invoice_tax,omitempty"`
+	// Amount and tax of the refunded order, for refunds of one-time purchases
+	OrderAmount *int64 `json:"order_amount,omitempty"`
+	OrderTax    *int64 `json:"order_tax,omitempty"`
+}
+
+// DisputeExport is a dispute together with the invoice it was filed against,
+// if any
+type DisputeExport struct {
+	Dispute
+	StripeInvoiceID *string `json:"stripe_invoice_id,omitempty"`
+}
@@ -1,6 +1,11 @@
 package models
 
-import "time"
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+)
 
 type InvoiceStatus string
 
@@ -23,9 +28,137 @@ type Invoice struct {
 	Status           InvoiceStatus `json:"status"`
 	InvoicePDF       *string       `json:"invoice_pdf,omitempty"`
 	HostedInvoiceURL *string       `json:"hosted_invoice_url,omitempty"`
-	PeriodStart      *time.Time    `json:"period_start,omitempty"`
-	PeriodEnd        *time.Time    `json:"period_end,omitempty"`
-	CreatedAt        time.Time     `json:"created_at"`
+	// Payment that settled the invoice, used to match disputes and refunds
+	StripePaymentIntentID *string `json:"stripe_payment_intent_id,omitempty"`
+	StripeChargeID        *string `json:"stripe_charge_id,omitempty"`
+	// PaymentActionRequired is set while the customer has to authenticate the payment
+	PaymentActionRequired bool `json:"payment_action_required"`
+	// Amounts before and after tax; Tax includes inclusive and exclusive taxes
+	Subtotal int64 `json:"subtotal"`
+	Tax      int64 `json:"tax"`
+	Total    int64 `json:"total"`
+	// Billing country and tax IDs the customer gave, e.g. for reverse charge VAT
+	CustomerCountry *string       `json:"customer_country,omitempty"`
+	CustomerTaxIDs  InvoiceTaxIDs `json:"customer_tax_ids"`
+	TaxBreakdown    InvoiceTaxes  `json:"tax_breakdown"`
+	// Stripe's processing fee, in the currency of the balance the payment
+	// settled in; nil until it is known
+	StripeFee         *int64  `json:"stripe_fee,omitempty"`
+	StripeFeeCurrency *string `json:"stripe_fee_currency,omitempty"`
+	// Number is the tenant's sequential invoice number, assigned on payment
+	Number          *string         `json:"number,omitempty"`
+	CustomerName    *string         `json:"customer_name,omitempty"`
+	CustomerEmail   *string         `json:"customer_email,omitempty"`
+	CustomerAddress *InvoiceAddress `json:"customer_address,omitempty"`
+	Lines           InvoiceLines    `json:"lines"`
+	PeriodStart     *time.Time      `json:"period_start,omitempty"`
+	PeriodEnd       *time.Time      `json:"period_end,omitempty"`
+	// PaidAt is when the invoice was paid; nil until it is
+	PaidAt    *time.Time `json:"paid_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// InvoiceTaxID is a tax ID of the customer, e.g. an EU VAT number
+type InvoiceTaxID struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// InvoiceTax is the tax charged on an invoice at one rate
+type InvoiceTax struct {
+	TaxRateID     string  `json:"tax_rate_id,omitempty"`
+	DisplayName   string  `json:"display_name,omitempty"`
+	Percentage    float64 `json:"percentage,omitempty"`
+	Country       string  `json:"country,omitempty"`
+	Jurisdiction  string  `json:"jurisdiction,omitempty"`
+	Amount        int64   `json:"amount"`
+	TaxableAmount int64   `json:"taxable_amount"`
+	// TaxBehavior is inclusive or exclusive
+	TaxBehavior      string `json:"tax_behavior,omitempty"`
+	TaxabilityReason string `json:"taxability_reason,omitempty"`
+}
+
+// InvoiceAddress is the billing address of the customer
+type InvoiceAddress struct {
+	Line1      string `json:"line1,omitempty"`
+	Line2      string `json:"line2,omitempty"`
+	City       string `json:"city,omitempty"`
+	State      string `json:"state,omitempty"`
+	PostalCode string `json:"postal_code,omitempty"`
+	Country    string `json:"country,omitempty"`
+}
+
+// InvoiceLine is an item billed on an invoice. Amount is before tax.
+type InvoiceLine struct {
+	Description string     `json:"description"`
+	Quantity    int64      `json:"quantity"`
+	Amount      int64      `json:"amount"`
+	PeriodStart *time.Time `json:"period_start,omitempty"`
+	PeriodEnd   *time.Time `json:"period_end,omitempty"`
+}
+
+// InvoiceLines is stored as a JSONB array
+type InvoiceLines []InvoiceLine
+
+// InvoiceTaxIDs is stored as a JSONB array
+type InvoiceTaxIDs []InvoiceTaxID
+
+// InvoiceTaxes is stored as a JSONB array
+type InvoiceTaxes []InvoiceTax
+
+func (ids InvoiceTaxIDs) Value() (driver.Value, error) {
+	return jsonArrayValue(ids, len(ids))
+}
+
+func (ids *InvoiceTaxIDs) Scan(src interface{}) error {
+	return scanJSONArray(src, ids)
+}
+
+func (taxes InvoiceTaxes) Value() (driver.Value, error) {
+	return jsonArrayValue(taxes, len(taxes))
+}
+
+func (taxes *InvoiceTaxes) Scan(src interface{}) error {
+	return scanJSONArray(src, taxes)
+}
+
+func (lines InvoiceLines) Value() (driver.Value, error) {
+	return jsonArrayValue(lines, len(lines))
+}
+
+func (lines *InvoiceLines) Scan(src interface{}) error {
+	return scanJSONArray(src, lines)
+}
+
+// Value stores the address as a JSONB object, or NULL if there is none
+func (a *InvoiceAddress) Value() (driver.Value, error) {
+	if a == nil {
+		return nil, nil
+	}
+	return json.Marshal(a)
+}
+
+func (a *InvoiceAddress) Scan(src interface{}) error {
+	return scanJSONArray(src, a)
+}
+
+func jsonArrayValue(v interface{}, length int) (driver.Value, error) {
+	if length == 0 {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(v)
+}
+
+func scanJSONArray(src interface{}, dst interface{}) error {
+	switch data := src.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return json.Unmarshal(data, dst)
+	case string:
+		return json.Unmarshal([]byte(data), dst)
+	}
+	return fmt.Errorf("cannot scan %T into a JSON array", src)
 }
 
 func (i InvoiceStatus) String() string {
@@ -0,0 +1,44 @@
+package models
+
+import "time"
+
+type OrderStatus string
+
+const (
+	// OrderStatusPending is an order whose payment has not settled yet, e.g.
+	// a bank debit started from checkout
+	OrderStatusPending OrderStatus = "pending"
+	OrderStatusPaid    OrderStatus = "paid"
+	// OrderStatusRefunded is a paid order whose amount was refunded in full
+	OrderStatusRefunded OrderStatus = "refunded"
+	// OrderStatusDisputed is a paid order whose payment was lost to a dispute
+	OrderStatusDisputed OrderStatus = "disputed"
+)
+
+// Order is a one-time purchase of a catalog product
+type Order struct {
+	ID                      int         `json:"id"`
+	StripePaymentIntentID   string      `json:"stripe_payment_intent_id"`
+	StripeCheckoutSessionID *string     `json:"stripe_checkout_session_id,omitempty"`
+	StripeCustomerID        *string     `json:"stripe_customer_id,omitempty"`
+	UserID                  string      `json:"user_id"`
+	Tenant                  string      `json:"tenant"`
+	Product                 string      `json:"product"`
+	Amount                  int64       `json:"amount"`
+	Currency                string      `json:"currency"`
+	Status                  OrderStatus `json:"status"`
+	// Tax is the part of Amount collected as tax, known when the order was
+	// paid through checkout
+	Tax int64 `json:"tax"`
+	// Entitlement is granted for good once the order is paid, until it is
+	// refunded in full or lost to a dispute; empty for products that do not
+	// grant access
+	Entitlement *string    `json:"entitlement,omitempty"`
+	PaidAt      *time.Time `json:"paid_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+func (s OrderStatus) String() string {
+	return string(s)
+}
@@ -0,0 +1,58 @@
+package models
+
+import "time"
+
+type RefundStatus string
+
+const (
+	RefundStatusPending        RefundStatus = "pending"
+	RefundStatusRequiresAction RefundStatus = "requires_action"
+	RefundStatusSucceeded      RefundStatus = "succeeded"
+	RefundStatusFailed         RefundStatus = "failed"
+	RefundStatusCanceled       RefundStatus = "canceled"
+)
+
+// Refund is money returned to the customer for a payment
+type Refund struct {
+	ID                    int     `json:"id"`
+	StripeRefundID        string  `json:"stripe_refund_id"`
+	StripeChargeID        *string `json:"stripe_charge_id,omitempty"`
+	StripePaymentIntentID *string `json:"stripe_payment_intent_id,omitempty"`
+	InvoiceID             *int    `json:"invoice_id,omitempty"`
+	// OrderID is set instead of InvoiceID for refunds of one-time purchases
+	OrderID       *int         `json:"order_id,omitempty"`
+	UserID        *string      `json:"user_id,omitempty"`
+	Tenant        *string      `json:"tenant,omitempty"`
+	Amount        int64        `json:"amount"`
+	Currency      string       `json:"currency"`
+	Reason        *string      `json:"reason,omitempty"`
+	Status        RefundStatus `json:"status"`
+	FailureReason *string      `json:"failure_reason,omitempty"`
+	// IdempotencyKey is the Idempotency-Key of the API request that issued it
+	IdempotencyKey *string   `json:"idempotency_key,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+func (s RefundStatus) String() string {
+	return string(s)
+}
+
+// RefundedTax returns the share of an invoice's tax returned by a refund of
+// amount, in proportion to the amount paid
+func RefundedTax(amount, invoiceAmountPaid, invoiceTax int64) int64 {
+	if invoiceAmountPaid <= 0 || invoiceTax == 0 {
+		return 0
+	}
+	return (amount*invoiceTax + invoiceAmountPaid/2) / invoiceAmountPaid
+}
+
+// Outstanding reports whether the refund has returned, or may still return,
+// money to the customer
+func (s RefundStatus) Outstanding() bool {
+	switch s {
+	case RefundStatusPending, RefundStatusRequiresAction, RefundStatusSucceeded:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,17 @@
+package models
+
+// CurrencyRevenue totals what was collected in one currency. Amounts are in
+// the smallest unit of the currency and are never summed across currencies.
+type CurrencyRevenue struct {
+	Currency string `json:"currency"`
+	// ActiveSubscriptions are active or trialing now, regardless of the period
+	ActiveSubscriptions int64 `json:"active_subscriptions"`
+	Invoices            int64 `json:"invoices"`
+	InvoiceAmount       int64 `json:"invoice_amount"`
+	Tax                 int64 `json:"tax"`
+	Orders              int64 `json:"orders"`
+	OrderAmount         int64 `json:"order_amount"`
+	Refunded            int64 `json:"refunded"`
+	// Net is the invoice and order amounts less succeeded refunds
+	Net int64 `json:"net"`
+}
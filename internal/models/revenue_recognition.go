@@ -0,0 +1,34 @@
+package models
+
+import "time"
+
+// RevenueEntry recognizes part of the revenue of a paid invoice. Entries
+// of a refund have RefundID set and negative amounts.
+type RevenueEntry struct {
+	ID        int    `json:"id"`
+	InvoiceID int    `json:"invoice_id"`
+	RefundID  *int   `json:"refund_id,omitempty"`
+	Tenant    string `json:"tenant"`
+	Currency  string `json:"currency"`
+	// BookedOn is the day the amount was paid or refunded and RecognizedOn
+	// the day, or first day of the month, it is recognized
+	BookedOn     time.Time `json:"booked_on"`
+	RecognizedOn time.Time `json:"recognized_on"`
+	// Amount in the smallest currency unit, before tax
+	Amount    int64     `json:"amount"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// RecognitionMonth is the recognized and deferred revenue of a tenant in one
+// currency and month. Amounts are in the smallest currency unit, before tax.
+type RecognitionMonth struct {
+	Month    string `json:"month"` // YYYY-MM
+	Tenant   string `json:"tenant"`
+	Currency string `json:"currency"`
+	// Billed is the revenue paid in the month, net of refunds
+	Billed int64 `json:"billed"`
+	// Recognized is the revenue earned in the month
+	Recognized int64 `json:"recognized"`
+	// Deferred is the revenue paid but not earned at the end of the month
+	Deferred int64 `json:"deferred"`
+}
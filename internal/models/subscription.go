@@ -32,14 +32,28 @@ type Subscription struct {
 	CurrentPeriodStart   *time.Time         `json:"current_period_start,omitempty"`
 	CurrentPeriodEnd     *time.Time         `json:"current_period_end,omitempty"`
 	CancelAtPeriodEnd    bool               `json:"cancel_at_period_end"`
-	CreatedAt            time.Time          `json:"created_at"`
-	UpdatedAt            time.Time          `json:"updated_at"`
+	Quantity             int64              `json:"quantity"` // seats, e.g. restaurant locations
+	Currency             string             `json:"currency"` // lowercase ISO 4217 code
+	// Set while a renewal waits for the customer to authenticate the payment;
+	// PaymentActionURL is the hosted invoice page where they complete it
+	PaymentActionRequired  bool      `json:"payment_action_required"`
+	PaymentActionInvoiceID *string   `json:"payment_action_invoice_id,omitempty"`
+	PaymentActionURL       *string   `json:"payment_action_url,omitempty"`
+	CreatedAt              time.Time `json:"created_at"`
+	UpdatedAt              time.Time `json:"updated_at"`
 }
 
 func (s SubscriptionStatus) String() string {
 	return string(s)
 }
 
+// ClearPaymentAction removes the pending payment action
+func (s *Subscription) ClearPaymentAction() {
+	s.PaymentActionRequired = false
+	s.PaymentActionInvoiceID = nil
+	s.PaymentActionURL = nil
+}
+
 func (p Plan) String() string {
 	return string(p)
 }
@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// SubscriptionHistory is the state of a subscription after a change
+type SubscriptionHistory struct {
+	ID             int                `json:"id"`
+	SubscriptionID int                `json:"subscription_id"`
+	StripeEventID  *string            `json:"stripe_event_id,omitempty"` // event that made the change
+	UserID         string             `json:"user_id"`
+	Tenant         string             `json:"tenant"`
+	Plan           Plan               `json:"plan"`
+	Status         SubscriptionStatus `json:"status"`
+	Quantity       int64              `json:"quantity"`
+	Currency       string             `json:"currency"`
+	// MRR is the monthly recurring amount of the licensed items in the
+	// smallest unit of Currency, whatever the status
+	MRR        int64     `json:"mrr"`
+	RecordedAt time.Time `json:"recorded_at"`
+	CreatedAt  time.Time `json:"created_at"`
+}
@@ -0,0 +1,31 @@
+package models
+
+import "time"
+
+type SubscriptionItemKind string
+
+const (
+	SubscriptionItemKindPlan    SubscriptionItemKind = "plan"
+	SubscriptionItemKindAddon   SubscriptionItemKind = "addon"
+	SubscriptionItemKindMetered SubscriptionItemKind = "metered"
+	// SubscriptionItemKindOther is a price that is not in the catalog, e.g.
+	// one added from the Stripe dashboard
+	SubscriptionItemKindOther SubscriptionItemKind = "other"
+)
+
+// SubscriptionItem is one price billed on a subscription's invoices
+type SubscriptionItem struct {
+	ID                       int                  `json:"id"`
+	SubscriptionID           int                  `json:"subscription_id"`
+	StripeSubscriptionItemID string               `json:"stripe_subscription_item_id"`
+	StripePriceID            string               `json:"stripe_price_id"`
+	Kind                     SubscriptionItemKind `json:"kind"`
+	Addon                    *string              `json:"addon,omitempty"` // catalog key of add-on items
+	Quantity                 int64                `json:"quantity"`
+	CreatedAt                time.Time            `json:"created_at"`
+	UpdatedAt                time.Time            `json:"updated_at"`
+}
+
+func (k SubscriptionItemKind) String() string {
+	return string(k)
+}
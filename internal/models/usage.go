@@ -0,0 +1,45 @@
+package models
+
+import "time"
+
+type UsageEventStatus string
+
+const (
+	UsageEventStatusPending  UsageEventStatus = "pending"
+	UsageEventStatusReported UsageEventStatus = "reported"
+	// UsageEventStatusFailed is an event Stripe rejected or that ran out of
+	// report attempts; it is not billed unless reset to pending
+	UsageEventStatusFailed UsageEventStatus = "failed"
+)
+
+// UsageEvent is an amount of metered usage, e.g. online orders, recorded for
+// a user and reported to a Stripe billing meter
+type UsageEvent struct {
+	ID               int              `json:"id"`
+	IdempotencyKey   string           `json:"idempotency_key"`
+	UserID           string           `json:"user_id"`
+	Tenant           string           `json:"tenant"`
+	Meter            string           `json:"meter"`
+	Quantity         int64            `json:"quantity"`
+	StripeCustomerID string           `json:"stripe_customer_id"`
+	OccurredAt       time.Time        `json:"occurred_at"`
+	Status           UsageEventStatus `json:"status"`
+	Attempts         int              `json:"attempts"`
+	NextAttemptAt    *time.Time       `json:"next_attempt_at,omitempty"`
+	LastError        *string          `json:"last_error,omitempty"`
+	ReportedAt       *time.Time       `json:"reported_at,omitempty"`
+	CreatedAt        time.Time        `json:"created_at"`
+	UpdatedAt        time.Time        `json:"updated_at"`
+}
+
+// UsageTotal is the usage of a meter summed over a period
+type UsageTotal struct {
+	Meter    string `json:"meter"`
+	Quantity int64  `json:"quantity"`
+	// Reported is the part of Quantity already sent to Stripe
+	Reported int64 `json:"reported"`
+}
+
+func (s UsageEventStatus) String() string {
+	return string(s)
+}
@@ -0,0 +1,228 @@
+package notify
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/naventro/payment-service/internal/mailer"
+	"github.com/naventro/payment-service/internal/models"
+	"github.com/naventro/payment-service/internal/tenant"
+)
+
+// Kind is a lifecycle email sent to customers
+type Kind string
+
+const (
+	// PaymentFailed asks the customer to update their card after a renewal failed
+	PaymentFailed Kind = "payment_failed"
+	// TrialEnding warns that the trial converts to a paid plan in a few days
+	TrialEnding Kind = "trial_ending"
+	// Canceled confirms a cancellation, effective now or at the period end
+	Canceled Kind = "canceled"
+	// Reactivated confirms that a scheduled cancellation was undone
+	Reactivated Kind = "reactivated"
+	// Receipt confirms a payment
+	Receipt Kind = "receipt"
+)
+
+//go:embed templates
+var builtinTemplates embed.FS
+
+// Data fills the templates. Dates and amounts that do not apply to a kind are
+// left empty.
+type Data struct {
+	// Sender is the tenant's product name, set by the notifier
+	Sender string
+	// Name of the customer, empty if unknown
+	Name string
+	Plan string
+
+	Amount        int64
+	Currency      string
+	InvoiceNumber string
+	// InvoiceURL is Stripe's hosted invoice page, where a failed payment can
+	// also be retried with another card
+	InvoiceURL string
+
+	// PeriodEnd is when access ends after a cancellation, or the next
+	// renewal after a reactivation
+	PeriodEnd   *time.Time
+	TrialEnd    *time.Time
+	NextAttempt *time.Time
+}
+
+// Notifier renders the emails of each tenant in its language and sends them
+// through the mailer
+type Notifier struct {
+	mailer  mailer.Mailer
+	tenants *tenant.Registry
+	from    string
+	dryRun  bool
+	cache   *templateCache
+}
+
+// New returns a notifier sending from the default sender from. A nil mailer
+// disables emails.
+func New(m mailer.Mailer, tenants *tenant.Registry, from string) *Notifier {
+	return &Notifier{
+		mailer:  m,
+		tenants: tenants,
+		from:    from,
+		cache:   &templateCache{templates: make(map[string]*template.Template)},
+	}
+}
+
+// DryRun returns a copy of the notifier that logs emails instead of sending them
+func (n *Notifier) DryRun() *Notifier {
+	dryRun := *n
+	dryRun.dryRun = true
+	return &dryRun
+}
+
+// Send renders an email of kind for a customer of tenant and sends it to
+// the address to. Tenants that opted out of kind, and customers without an
+// email address, get nothing.
+func (n *Notifier) Send(kind Kind, tenantName, to string, data Data) error {
+	if n.mailer == nil {
+		return nil
+	}
+
+	settings := n.tenants.Get(tenantName).Emails
+	if !settings.Sends(string(kind)) {
+		return nil
+	}
+
+	if to == "" {
+		log.Printf("No email address for %s email of tenant %s, skipping", kind, tenantName)
+		return nil
+	}
+
+	locale := settings.Locale
+	if locale == "" {
+		locale = "en"
+	}
+
+	tmpl, err := n.cache.get(settings.TemplateDir, locale, kind)
+	if err != nil {
+		return err
+	}
+
+	data.Sender = settings.SenderName
+	if data.Sender == "" {
+		data.Sender = tenantName
+	}
+
+	var subject, text bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return fmt.Errorf("error rendering %s email subject: %w", kind, err)
+	}
+	if err := tmpl.ExecuteTemplate(&text, "body", data); err != nil {
+		return fmt.Errorf("error rendering %s email: %w", kind, err)
+	}
+
+	from := settings.From
+	if from == "" {
+		from = n.from
+	}
+
+	msg := &mailer.Message{
+		From:    from,
+		To:      to,
+		ReplyTo: settings.ReplyTo,
+		Subject: strings.TrimSpace(subject.String()),
+		Text:    strings.TrimSpace(text.String()) + "\n",
+	}
+
+	if n.dryRun {
+		log.Printf("Dry run, not sending %s email to %s: %s", kind, to, msg.Subject)
+		return nil
+	}
+
+	if err := n.mailer.Send(msg); err != nil {
+		return err
+	}
+
+	log.Printf("Sent %s email to %s for tenant %s", kind, to, tenantName)
+	return nil
+}
+
+// templateCache holds the parsed templates, shared by dry-run copies
+type templateCache struct {
+	mu        sync.Mutex
+	templates map[string]*template.Template
+}
+
+// get returns the template of kind in locale. A template in dir, laid out as
+// <locale>/<kind>.tmpl, replaces the built-in one.
+func (c *templateCache) get(dir, locale string, kind Kind) (*template.Template, error) {
+	key := dir + "|" + locale + "|" + string(kind)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if tmpl, ok := c.templates[key]; ok {
+		return tmpl, nil
+	}
+
+	name := filepath.Join(locale, string(kind)+".tmpl")
+	var source []byte
+	var err error
+	if dir != "" {
+		source, err = os.ReadFile(filepath.Join(dir, name))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("error reading %s email template: %w", kind, err)
+		}
+	}
+	if source == nil {
+		source, err = builtinTemplates.ReadFile("templates/" + locale + "/" + string(kind) + ".tmpl")
+		if err != nil {
+			return nil, fmt.Errorf("no %s email template for locale %s", kind, locale)
+		}
+	}
+
+	tmpl, err := template.New(name).Funcs(funcs(locale)).Parse(string(source))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s email template: %w", kind, err)
+	}
+
+	c.templates[key] = tmpl
+	return tmpl, nil
+}
+
+var spanishMonths = [...]string{
+	"enero", "febrero", "marzo", "abril", "mayo", "junio",
+	"julio", "agosto", "septiembre", "octubre", "noviembre", "diciembre",
+}
+
+// funcs are the template functions formatting amounts and dates for locale
+func funcs(locale string) template.FuncMap {
+	return template.FuncMap{
+		"amount": func(amount int64, currency string) string {
+			value := models.FormatAmount(amount, currency)
+			if locale == "es" {
+				value = strings.Replace(value, ".", ",", 1)
+			}
+			return value + " " + strings.ToUpper(currency)
+		},
+		"date": func(t *time.Time) string {
+			if t == nil {
+				return ""
+			}
+			u := t.UTC()
+			if locale == "es" {
+				return fmt.Sprintf("%d de %s de %d", u.Day(), spanishMonths[u.Month()-1], u.Year())
+			}
+			return u.Format("January 2, 2006")
+		},
+	}
+}
@@ -0,0 +1,261 @@
+package recognition
+
+import (
+	"sort"
+	"time"
+
+	"github.com/naventro/payment-service/internal/models"
+	"github.com/naventro/payment-service/internal/repository"
+	stripego "github.com/stripe/stripe-go/v84"
+)
+
+// Granularity is how finely revenue is recognized. Either way it accrues
+// evenly per second of the service period; monthly schedules add it up by
+// calendar month.
+type Granularity string
+
+const (
+	Monthly Granularity = "monthly"
+	Daily   Granularity = "daily"
+)
+
+// bucket returns the day or first day of the month t falls in, in UTC
+func (g Granularity) bucket(t time.Time) time.Time {
+	t = t.UTC()
+	if g == Daily {
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	}
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// next returns the bucket after b
+func (g Granularity) next(b time.Time) time.Time {
+	if g == Daily {
+		return b.AddDate(0, 0, 1)
+	}
+	return b.AddDate(0, 1, 0)
+}
+
+// Line is an invoice line billed for a service period. Amounts only weigh
+// the lines against each other; credits for unused time have negative
+// amounts.
+type Line struct {
+	Amount int64
+	Start  time.Time
+	End    time.Time
+}
+
+// StripeLines returns the service periods billed by the lines of a Stripe
+// invoice. Proration lines of a plan change carry the credit for the unused
+// time of the old plan, which cancels out the rest of its schedule.
+func StripeLines(items []*stripego.InvoiceLineItem) []Line {
+	lines := make([]Line, 0, len(items))
+	for _, item := range items {
+		line := Line{Amount: item.Amount}
+		if item.Period != nil {
+			line.Start = time.Unix(item.Period.Start, 0)
+			line.End = time.Unix(item.Period.End, 0)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// InvoiceLines returns the invoice's own period as its only line, for
+// invoices whose lines are unknown
+func InvoiceLines(invoice *models.Invoice) []Line {
+	line := Line{Amount: invoice.AmountPaid}
+	if invoice.PeriodStart != nil && invoice.PeriodEnd != nil {
+		line.Start = *invoice.PeriodStart
+		line.End = *invoice.PeriodEnd
+	}
+	return []Line{line}
+}
+
+// Schedule spreads the revenue of a paid invoice, its amount paid less tax,
+// over the service periods of its lines in proportion to their amounts.
+// Revenue of lines without a period, and of periods before the payment,
+// is recognized when the invoice was paid.
+func Schedule(invoice *models.Invoice, lines []Line, paidAt time.Time, g Granularity) []*models.RevenueEntry {
+	revenue := invoice.AmountPaid - invoice.Tax
+	if revenue == 0 {
+		return nil
+	}
+
+	var weight int64
+	for _, line := range lines {
+		weight += line.Amount
+	}
+	if weight <= 0 {
+		lines = []Line{{Amount: 1}}
+		weight = 1
+	}
+
+	paidBucket := g.bucket(paidAt)
+	amounts := make(map[time.Time]int64)
+	var cumulative, allocated int64
+	for _, line := range lines {
+		cumulative += line.Amount
+		share := split(revenue, cumulative, weight) - allocated
+		allocated += share
+
+		for b, amount := range spread(share, line.Start, line.End, g) {
+			if b.Before(paidBucket) {
+				b = paidBucket
+			}
+			amounts[b] += amount
+		}
+	}
+
+	return entries(amounts, invoice, nil, Daily.bucket(paidAt))
+}
+
+// Refund returns the entries that take the revenue of a succeeded refund,
+// its amount less the refunded share of the invoice's tax, out of the
+// invoice's schedule. The revenue still deferred after the refund date is
+// reduced first, evenly; what exceeds it reverses revenue already
+// recognized, on the refund date. schedule holds the invoice's entries
+// other than this refund's.
+func Refund(refund *models.Refund, invoice *models.Invoice, schedule []*models.RevenueEntry, g Granularity) []*models.RevenueEntry {
+	if refund.Status != models.RefundStatusSucceeded {
+		return nil
+	}
+
+	revenue := refund.Amount - models.RefundedTax(refund.Amount, invoice.AmountPaid, invoice.Tax)
+	if revenue <= 0 {
+		return nil
+	}
+
+	refundBucket := g.bucket(refund.CreatedAt)
+	deferred := make(map[time.Time]int64)
+	var total int64
+	for _, entry := range schedule {
+		if entry.RecognizedOn.After(refundBucket) {
+			deferred[entry.RecognizedOn.UTC()] += entry.Amount
+			total += entry.Amount
+		}
+	}
+
+	amounts := make(map[time.Time]int64)
+	booked := Daily.bucket(refund.CreatedAt)
+	if total <= 0 {
+		amounts[refundBucket] = -revenue
+		return entries(amounts, invoice, refund, booked)
+	}
+
+	reduction := revenue
+	if reduction > total {
+		reduction = total
+		amounts[refundBucket] = -(revenue - total)
+	}
+
+	var cumulative, allocated int64
+	for _, b := range sortedBuckets(deferred) {
+		cumulative += deferred[b]
+		share := split(reduction, cumulative, total) - allocated
+		allocated += share
+		amounts[b] -= share
+	}
+
+	return entries(amounts, invoice, refund, booked)
+}
+
+// SaveSchedule stores the schedule of a paid invoice, replacing any previous
+// one. Its refunds keep the entries computed against the old schedule.
+func SaveSchedule(repo *repository.RevenueRecognitionRepository, invoice *models.Invoice, lines []Line, paidAt time.Time, g Granularity) error {
+	return repo.ReplaceInvoiceSchedule(invoice.ID, Schedule(invoice, lines, paidAt, g))
+}
+
+// SaveRefund stores the entries of a refund of invoice, or removes them if
+// the refund did not succeed. Each refund is applied to the schedule as left
+// by the refunds recorded before it.
+func SaveRefund(repo *repository.RevenueRecognitionRepository, refund *models.Refund, invoice *models.Invoice, g Granularity) error {
+	stored, err := repo.GetByInvoiceID(invoice.ID)
+	if err != nil {
+		return err
+	}
+
+	schedule := make([]*models.RevenueEntry, 0, len(stored))
+	for _, entry := range stored {
+		if entry.RefundID == nil || *entry.RefundID < refund.ID {
+			schedule = append(schedule, entry)
+		}
+	}
+
+	return repo.ReplaceRefundEntries(refund.ID, Refund(refund, invoice, schedule, g))
+}
+
+// spread divides amount over the buckets of [start, end) in proportion to
+// the time in each. Periods that are empty or unknown go to the bucket of
+// start, or to the zero time if there is none.
+func spread(amount int64, start, end time.Time, g Granularity) map[time.Time]int64 {
+	if amount == 0 {
+		return nil
+	}
+	if start.IsZero() || !end.After(start) {
+		return map[time.Time]int64{g.bucket(start): amount}
+	}
+
+	total := int64(end.Sub(start) / time.Second)
+	amounts := make(map[time.Time]int64)
+	var elapsed, allocated int64
+	for b := g.bucket(start); b.Before(end); b = g.next(b) {
+		until := g.next(b)
+		if until.After(end) {
+			until = end
+		}
+		from := b
+		if from.Before(start) {
+			from = start
+		}
+
+		elapsed += int64(until.Sub(from) / time.Second)
+		share := split(amount, elapsed, total) - allocated
+		allocated += share
+		if share != 0 {
+			amounts[b] += share
+		}
+	}
+	return amounts
+}
+
+// split returns amount * part / total rounded to the nearest unit. Rounding
+// cumulative parts keeps the pieces adding up to amount.
+func split(amount, part, total int64) int64 {
+	product := amount * part
+	if product < 0 {
+		return -((-product + total/2) / total)
+	}
+	return (product + total/2) / total
+}
+
+func sortedBuckets(amounts map[time.Time]int64) []time.Time {
+	buckets := make([]time.Time, 0, len(amounts))
+	for b := range amounts {
+		buckets = append(buckets, b)
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].Before(buckets[j]) })
+	return buckets
+}
+
+func entries(amounts map[time.Time]int64, invoice *models.Invoice, refund *models.Refund, booked time.Time) []*models.RevenueEntry {
+	var result []*models.RevenueEntry
+	for _, b := range sortedBuckets(amounts) {
+		if amounts[b] == 0 {
+			continue
+		}
+		entry := &models.RevenueEntry{
+			InvoiceID:    invoice.ID,
+			Tenant:       invoice.Tenant,
+			Currency:     invoice.Currency,
+			BookedOn:     booked,
+			RecognizedOn: b,
+			Amount:       amounts[b],
+		}
+		if refund != nil {
+			entry.RefundID = &refund.ID
+		}
+		result = append(result, entry)
+	}
+	return result
+}
@@ -0,0 +1,318 @@
+package recognition
+
+import (
+	"testing"
+	"time"
+
+	"github.com/naventro/payment-service/internal/models"
+)
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+// byBucket sums entries by the day or month they are recognized on
+func byBucket(entries []*models.RevenueEntry) map[time.Time]int64 {
+	amounts := make(map[time.Time]int64)
+	for _, entry := range entries {
+		amounts[entry.RecognizedOn] += entry.Amount
+	}
+	return amounts
+}
+
+func sum(entries []*models.RevenueEntry) int64 {
+	var total int64
+	for _, entry := range entries {
+		total += entry.Amount
+	}
+	return total
+}
+
+// monthlySchedule returns a schedule recognizing amount in each month of 2026
+func monthlySchedule(invoice *models.Invoice, amount int64) []*models.RevenueEntry {
+	var schedule []*models.RevenueEntry
+	for month := time.January; month <= time.December; month++ {
+		schedule = append(schedule, &models.RevenueEntry{
+			InvoiceID:    invoice.ID,
+			BookedOn:     date(2026, time.January, 1),
+			RecognizedOn: date(2026, month, 1),
+			Amount:       amount,
+		})
+	}
+	return schedule
+}
+
+func TestScheduleYearly(t *testing.T) {
+	invoice := &models.Invoice{ID: 1, Tenant: "menuum", Currency: "eur", AmountPaid: 12100, Tax: 2100}
+	start, end := date(2026, time.January, 15), date(2027, time.January, 15)
+	lines := []Line{{Amount: 12100, Start: start, End: end}}
+
+	tests := []struct {
+		name    string
+		g       Granularity
+		buckets int
+	}{
+		{"monthly", Monthly, 13},
+		{"daily", Daily, 365},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries := Schedule(invoice, lines, start, tt.g)
+
+			if len(entries) != tt.buckets {
+				t.Fatalf("entries = %d, want %d", len(entries), tt.buckets)
+			}
+			if got := sum(entries); got != 10000 {
+				t.Errorf("recognized = %d, want the amount paid less tax, 10000", got)
+			}
+
+			// Every bucket gets its share of the year to the unit
+			seconds := float64(end.Sub(start))
+			for _, entry := range entries {
+				from, until := entry.RecognizedOn, tt.g.next(entry.RecognizedOn)
+				if from.Before(start) {
+					from = start
+				}
+				if until.After(end) {
+					until = end
+				}
+				want := 10000 * float64(until.Sub(from)) / seconds
+				if diff := float64(entry.Amount) - want; diff < -1 || diff > 1 {
+					t.Errorf("%s: amount = %d, want %.2f", entry.RecognizedOn.Format("2006-01-02"), entry.Amount, want)
+				}
+				if !entry.BookedOn.Equal(start) || entry.InvoiceID != 1 || entry.Tenant != "menuum" || entry.Currency != "eur" {
+					t.Errorf("entry = %+v", entry)
+				}
+			}
+		})
+	}
+}
+
+func TestScheduleProration(t *testing.T) {
+	paidAt := date(2026, time.March, 15)
+
+	tests := []struct {
+		name    string
+		invoice *models.Invoice
+		lines   []Line
+		want    map[time.Time]int64
+	}{
+		{
+			// An upgrade on March 15: the credit for the unused old plan
+			// cancels part of the new plan's charge for the same days
+			name:    "upgrade",
+			invoice: &models.Invoice{AmountPaid: 4000},
+			lines: []Line{
+				{Amount: -500, Start: paidAt, End: date(2026, time.April, 1)},
+				{Amount: 1500, Start: paidAt, End: date(2026, time.April, 1)},
+				{Amount: 3000, Start: date(2026, time.April, 1), End: date(2026, time.May, 1)},
+			},
+			want: map[time.Time]int64{
+				date(2026, time.March, 1): 1000,
+				date(2026, time.April, 1): 3000,
+			},
+		},
+		{
+			// Tax is left out in proportion to every line
+			name:    "upgrade with tax",
+			invoice: &models.Invoice{AmountPaid: 4840, Tax: 840},
+			lines: []Line{
+				{Amount: -605, Start: paidAt, End: date(2026, time.April, 1)},
+				{Amount: 1815, Start: paidAt, End: date(2026, time.April, 1)},
+				{Amount: 3630, Start: date(2026, time.April, 1), End: date(2026, time.May, 1)},
+			},
+			want: map[time.Time]int64{
+				date(2026, time.March, 1): 1000,
+				date(2026, time.April, 1): 3000,
+			},
+		},
+		{
+			// Credits larger than the charges leave nothing to weigh by, so
+			// everything is recognized on payment
+			name:    "net credit",
+			invoice: &models.Invoice{AmountPaid: 100},
+			lines: []Line{
+				{Amount: -3000, Start: paidAt, End: date(2026, time.April, 1)},
+				{Amount: 1000, Start: date(2026, time.April, 1), End: date(2026, time.May, 1)},
+			},
+			want: map[time.Time]int64{date(2026, time.March, 1): 100},
+		},
+		{
+			// Periods before the payment are recognized when it was paid
+			name:    "paid late",
+			invoice: &models.Invoice{AmountPaid: 3000},
+			lines:   []Line{{Amount: 3000, Start: date(2026, time.January, 1), End: date(2026, time.April, 1)}},
+			want:    map[time.Time]int64{date(2026, time.March, 1): 3000},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := byBucket(Schedule(tt.invoice, tt.lines, paidAt, Monthly))
+			if len(got) != len(tt.want) {
+				t.Fatalf("schedule = %v, want %v", got, tt.want)
+			}
+			for b, amount := range tt.want {
+				if got[b] != amount {
+					t.Errorf("%s: amount = %d, want %d", b.Format("2006-01"), got[b], amount)
+				}
+			}
+		})
+	}
+}
+
+func TestRefund(t *testing.T) {
+	// 12000 of revenue recognized at 1000 a month through 2026
+	invoice := &models.Invoice{ID: 1, AmountPaid: 14400, Tax: 2400}
+	schedule := monthlySchedule(invoice, 1000)
+
+	months := func(amount int64, from, to time.Month) map[time.Time]int64 {
+		amounts := make(map[time.Time]int64)
+		for month := from; month <= to; month++ {
+			amounts[date(2026, month, 1)] = amount
+		}
+		return amounts
+	}
+
+	tests := []struct {
+		name   string
+		refund *models.Refund
+		want   map[time.Time]int64
+	}{
+		{
+			// 3600 with 600 of tax, taken evenly out of August to December
+			name:   "partial",
+			refund: &models.Refund{ID: 7, Amount: 3600, Status: models.RefundStatusSucceeded, CreatedAt: date(2026, time.July, 10)},
+			want:   months(-600, time.August, time.December),
+		},
+		{
+			// 9600 with 1600 of tax: the 5000 still deferred is cancelled and
+			// the other 3000 reverses revenue already recognized
+			name:   "more than deferred",
+			refund: &models.Refund{ID: 7, Amount: 9600, Status: models.RefundStatusSucceeded, CreatedAt: date(2026, time.July, 10)},
+			want: func() map[time.Time]int64 {
+				amounts := months(-1000, time.August, time.December)
+				amounts[date(2026, time.July, 1)] = -3000
+				return amounts
+			}(),
+		},
+		{
+			name:   "after the service period",
+			refund: &models.Refund{ID: 7, Amount: 1200, Status: models.RefundStatusSucceeded, CreatedAt: date(2027, time.February, 3)},
+			want:   map[time.Time]int64{date(2027, time.February, 1): -1000},
+		},
+		{
+			name:   "not succeeded",
+			refund: &models.Refund{ID: 7, Amount: 1200, Status: models.RefundStatusPending, CreatedAt: date(2026, time.July, 10)},
+			want:   map[time.Time]int64{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries := Refund(tt.refund, invoice, schedule, Monthly)
+
+			got := byBucket(entries)
+			if len(got) != len(tt.want) {
+				t.Fatalf("entries = %v, want %v", got, tt.want)
+			}
+			for b, amount := range tt.want {
+				if got[b] != amount {
+					t.Errorf("%s: amount = %d, want %d", b.Format("2006-01"), got[b], amount)
+				}
+			}
+
+			booked := Daily.bucket(tt.refund.CreatedAt)
+			for _, entry := range entries {
+				if entry.RefundID == nil || *entry.RefundID != 7 || !entry.BookedOn.Equal(booked) {
+					t.Errorf("entry = %+v, want refund 7 booked on %s", entry, booked.Format("2006-01-02"))
+				}
+			}
+		})
+	}
+}
+
+func TestSpread(t *testing.T) {
+	start := time.Date(2026, time.January, 31, 12, 0, 0, 0, time.UTC)
+	end := date(2026, time.February, 2)
+
+	tests := []struct {
+		name   string
+		amount int64
+		start  time.Time
+		end    time.Time
+		g      Granularity
+		want   map[time.Time]int64
+	}{
+		{
+			name: "daily", amount: 100, start: start, end: end, g: Daily,
+			want: map[time.Time]int64{
+				date(2026, time.January, 31): 33,
+				date(2026, time.February, 1): 67,
+			},
+		},
+		{
+			name: "monthly", amount: 100, start: start, end: end, g: Monthly,
+			want: map[time.Time]int64{
+				date(2026, time.January, 1):  33,
+				date(2026, time.February, 1): 67,
+			},
+		},
+		{
+			name: "negative", amount: -100, start: start, end: end, g: Daily,
+			want: map[time.Time]int64{
+				date(2026, time.January, 31): -33,
+				date(2026, time.February, 1): -67,
+			},
+		},
+		{
+			name: "empty period", amount: 100, start: start, end: start, g: Daily,
+			want: map[time.Time]int64{date(2026, time.January, 31): 100},
+		},
+		{
+			name: "no period", amount: 100, g: Monthly,
+			want: map[time.Time]int64{Monthly.bucket(time.Time{}): 100},
+		},
+		{
+			name: "zero", amount: 0, start: start, end: end, g: Daily,
+			want: map[time.Time]int64{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := spread(tt.amount, tt.start, tt.end, tt.g)
+			if len(got) != len(tt.want) {
+				t.Fatalf("spread = %v, want %v", got, tt.want)
+			}
+			for b, amount := range tt.want {
+				if got[b] != amount {
+					t.Errorf("%s: amount = %d, want %d", b.Format("2006-01-02"), got[b], amount)
+				}
+			}
+		})
+	}
+}
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		amount, part, total int64
+		want                int64
+	}{
+		{100, 1, 3, 33},
+		{100, 2, 3, 67},
+		{100, 3, 3, 100},
+		{-100, 1, 3, -33},
+		{-100, 2, 3, -67},
+		{5, 1, 2, 3},
+		{-5, 1, 2, -3},
+		{4000, -500, 4000, -500},
+	}
+
+	for _, tt := range tests {
+		if got := split(tt.amount, tt.part, tt.total); got != tt.want {
+			t.Errorf("split(%d, %d, %d) = %d, want %d", tt.amount, tt.part, tt.total, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package reconcile
+
+import (
+	"github.com/naventro/payment-service/internal/models"
+	"github.com/naventro/payment-service/internal/stripe"
+	stripego "github.com/stripe/stripe-go/v84"
+)
+
+// Provider exposes the billing state held by the payment provider.
+// Records are returned as local models without local IDs.
+type Provider interface {
+	// ListSubscriptions calls fn for every subscription belonging to tenant
+	ListSubscriptions(tenant string, fn func(*models.Subscription) error) error
+	// GetSubscription returns a single subscription, or nil if it does not exist
+	GetSubscription(stripeSubscriptionID string) (*models.Subscription, error)
+	// ListInvoices calls fn for every invoice of a subscription
+	ListInvoices(stripeSubscriptionID string, fn func(*models.Invoice) error) error
+	// MonthlyRecurringAmount returns the MRR of a subscription in minor units
+	// of its currency
+	MonthlyRecurringAmount(stripeSubscriptionID string) (int64, error)
+}
+
+// StripeProvider implements Provider on top of the Stripe API
+type StripeProvider struct {
+	client      *stripe.Client
+	isPlanPrice func(priceID string) bool
+}
+
+// NewStripeProvider creates a provider. isPlanPrice identifies the plan item
+// of subscriptions, see stripe.PlanItem.
+func NewStripeProvider(client *stripe.Client, isPlanPrice func(priceID string) bool) *StripeProvider {
+	return &StripeProvider{client: client, isPlanPrice: isPlanPrice}
+}
+
+func (p *StripeProvider) ListSubscriptions(tenant string, fn func(*models.Subscription) error) error {
+	return p.client.SearchSubscriptions(tenant, func(sub *stripego.Subscription) error {
+		return fn(stripe.ToSubscription(sub, p.isPlanPrice))
+	})
+}
+
+func (p *StripeProvider) GetSubscription(stripeSubscriptionID string) (*models.Subscription, error) {
+	sub, err := p.client.GetSubscription(stripeSubscriptionID)
+	if stripe.IsNotFound(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return stripe.ToSubscription(sub, p.isPlanPrice), nil
+}
+
+func (p *StripeProvider) ListInvoices(stripeSubscriptionID string, fn func(*models.Invoice) error) error {
+	return p.client.ListInvoices(stripeSubscriptionID, func(inv *stripego.Invoice) error {
+		return fn(stripe.ToInvoice(inv))
+	})
+}
+
+func (p *StripeProvider) MonthlyRecurringAmount(stripeSubscriptionID string) (int64, error) {
+	sub, err := p.client.GetSubscription(stripeSubscriptionID)
+	if err != nil {
+		return 0, err
+	}
+
+	return stripe.MonthlyRecurringAmount(sub), nil
+}
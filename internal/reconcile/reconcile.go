@@ -0,0 +1,439 @@
+package reconcile
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/naventro/payment-service/internal/models"
+	"github.com/naventro/payment-service/internal/webhook"
+)
+
+type MismatchKind string
+
+const (
+	// MismatchMissingLocal means the provider has a record our database lacks
+	MismatchMissingLocal MismatchKind = "missing_local"
+	// MismatchMissingRemote means our database has a record the provider lacks
+	MismatchMissingRemote MismatchKind = "missing_remote"
+	// MismatchField means both sides have the record but a field differs
+	MismatchField MismatchKind = "field"
+)
+
+// Mismatch describes a single difference between the database and the provider
+type Mismatch struct {
+	Tenant   string       `json:"tenant"`
+	Kind     MismatchKind `json:"kind"`
+	Object   string       `json:"object"`
+	StripeID string       `json:"stripe_id"`
+	Field    string       `json:"field,omitempty"`
+	Local    string       `json:"local,omitempty"`
+	Remote   string       `json:"remote,omitempty"`
+	Repaired bool         `json:"repaired"`
+	Error    string       `json:"error,omitempty"`
+}
+
+// Report summarizes a reconciliation run
+type Report struct {
+	StartedAt     time.Time  `json:"started_at"`
+	FinishedAt    time.Time  `json:"finished_at"`
+	Tenants       []string   `json:"tenants"`
+	Subscriptions int        `json:"subscriptions_checked"`
+	Invoices      int        `json:"invoices_checked"`
+	Mismatches    []Mismatch `json:"mismatches"`
+	Errors        []string   `json:"errors,omitempty"`
+}
+
+// Unresolved returns the number of mismatches that were not repaired
+func (r *Report) Unresolved() int {
+	count := 0
+	for _, m := range r.Mismatches {
+		if !m.Repaired {
+			count++
+		}
+	}
+	return count
+}
+
+// Options controls a reconciliation run
+type Options struct {
+	// Tenants to reconcile; empty means every tenant with local subscriptions
+	Tenants []string
+	// Repair updates the database to match the provider
+	Repair bool
+	// Notify sends backend notifications for repaired subscriptions
+	Notify bool
+}
+
+// Reconciler diffs local subscriptions and invoices against the provider
+type Reconciler struct {
+	provider      Provider
+	subRepo       SubscriptionStore
+	invoiceRepo   InvoiceStore
+	customerRepo  CustomerStore
+	historyRepo   HistoryStore
+	webhookClient Notifier
+}
+
+// New creates a reconciler. webhookClient may be nil when repairs are never
+// notified.
+func New(
+	provider Provider,
+	subRepo SubscriptionStore,
+	invoiceRepo InvoiceStore,
+	customerRepo CustomerStore,
+	historyRepo HistoryStore,
+	webhookClient Notifier,
+) *Reconciler {
+	return &Reconciler{
+		provider:      provider,
+		subRepo:       subRepo,
+		invoiceRepo:   invoiceRepo,
+		customerRepo:  customerRepo,
+		historyRepo:   historyRepo,
+		webhookClient: webhookClient,
+	}
+}
+
+// Run reconciles every requested tenant. Failures for a tenant are recorded in
+// the report and do not stop the remaining tenants from being checked.
+func (r *Reconciler) Run(opts Options) (*Report, error) {
+	report := &Report{StartedAt: time.Now()}
+
+	tenants := opts.Tenants
+	if len(tenants) == 0 {
+		var err error
+		tenants, err = r.subRepo.ListTenants()
+		if err != nil {
+			return nil, err
+		}
+	}
+	report.Tenants = tenants
+
+	for _, tenant := range tenants {
+		if err := r.reconcileTenant(tenant, opts, report); err != nil {
+			log.Printf("Error reconciling tenant %s: %v", tenant, err)
+			report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", tenant, err))
+		}
+	}
+
+	report.FinishedAt = time.Now()
+	return report, nil
+}
+
+func (r *Reconciler) reconcileTenant(tenant string, opts Options, report *Report) error {
+	localSubs, err := r.subRepo.ListByTenant(tenant)
+	if err != nil {
+		return err
+	}
+
+	byStripeID := make(map[string]*models.Subscription, len(localSubs))
+	for _, sub := range localSubs {
+		byStripeID[sub.StripeSubscriptionID] = sub
+	}
+
+	seen := make(map[string]bool)
+	err = r.provider.ListSubscriptions(tenant, func(remote *models.Subscription) error {
+		seen[remote.StripeSubscriptionID] = true
+		return r.reconcileSubscription(tenant, byStripeID[remote.StripeSubscriptionID], remote, opts, report)
+	})
+	if err != nil {
+		return err
+	}
+
+	// Search results lag behind, so confirm unseen subscriptions individually
+	for _, local := range localSubs {
+		if seen[local.StripeSubscriptionID] {
+			continue
+		}
+
+		remote, err := r.provider.GetSubscription(local.StripeSubscriptionID)
+		if err != nil {
+			return err
+		}
+
+		if remote == nil {
+			report.Subscriptions++
+			report.Mismatches = append(report.Mismatches, Mismatch{
+				Tenant:   tenant,
+				Kind:     MismatchMissingRemote,
+				Object:   "subscription",
+				StripeID: local.StripeSubscriptionID,
+			})
+			continue
+		}
+
+		if err := r.reconcileSubscription(tenant, local, remote, opts, report); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *Reconciler) reconcileSubscription(tenant string, local, remote *models.Subscription, opts Options, report *Report) error {
+	report.Subscriptions++
+
+	if local == nil {
+		mismatch := Mismatch{
+			Tenant:   tenant,
+			Kind:     MismatchMissingLocal,
+			Object:   "subscription",
+			StripeID: remote.StripeSubscriptionID,
+		}
+
+		if opts.Repair {
+			if remote.UserID == "" || remote.Plan == "" {
+				mismatch.Error = "subscription metadata is missing user_id or plan"
+			} else if err := r.subRepo.Create(remote); err != nil {
+				mismatch.Error = err.Error()
+			} else {
+				mismatch.Repaired = true
+				local = remote
+				r.recordHistory(local, report)
+				r.notify(local, opts)
+			}
+		}
+
+		report.Mismatches = append(report.Mismatches, mismatch)
+
+		if local == nil {
+			return nil
+		}
+		return r.reconcileInvoices(tenant, local, opts, report)
+	}
+
+	diffs := diffSubscription(local, remote)
+	if len(diffs) > 0 {
+		var repairErr error
+		if opts.Repair {
+			local.Status = remote.Status
+			if remote.Plan != "" {
+				local.Plan = remote.Plan
+			}
+			local.CurrentPeriodStart = remote.CurrentPeriodStart
+			local.CurrentPeriodEnd = remote.CurrentPeriodEnd
+			local.CancelAtPeriodEnd = remote.CancelAtPeriodEnd
+			local.Quantity = remote.Quantity
+			local.Currency = remote.Currency
+			repairErr = r.subRepo.Update(local)
+		}
+
+		for _, diff := range diffs {
+			diff.Tenant = tenant
+			diff.StripeID = remote.StripeSubscriptionID
+			if opts.Repair {
+				diff.Repaired = repairErr == nil
+				if repairErr != nil {
+					diff.Error = repairErr.Error()
+				}
+			}
+			report.Mismatches = append(report.Mismatches, diff)
+		}
+
+		if opts.Repair && repairErr == nil {
+			r.recordHistory(local, report)
+			r.notify(local, opts)
+		}
+	}
+
+	return r.reconcileInvoices(tenant, local, opts, report)
+}
+
+// recordHistory stores the repaired state of sub so analytics see the change.
+// The repair already happened, so a failure is reported but not undone.
+func (r *Reconciler) recordHistory(sub *models.Subscription, report *Report) {
+	mrr, err := r.provider.MonthlyRecurringAmount(sub.StripeSubscriptionID)
+	if err == nil {
+		err = r.historyRepo.Record(&models.SubscriptionHistory{
+			SubscriptionID: sub.ID,
+			UserID:         sub.UserID,
+			Tenant:         sub.Tenant,
+			Plan:           sub.Plan,
+			Status:         sub.Status,
+			Quantity:       sub.Quantity,
+			Currency:       sub.Currency,
+			MRR:            mrr,
+			RecordedAt:     time.Now(),
+		})
+	}
+
+	if err != nil {
+		log.Printf("Error recording history of subscription %s: %v", sub.StripeSubscriptionID, err)
+		report.Errors = append(report.Errors, fmt.Sprintf("%s: recording history of %s: %v", sub.Tenant, sub.StripeSubscriptionID, err))
+	}
+}
+
+func (r *Reconciler) reconcileInvoices(tenant string, sub *models.Subscription, opts Options, report *Report) error {
+	localInvoices, err := r.invoiceRepo.GetBySubscriptionID(sub.ID)
+	if err != nil {
+		return err
+	}
+
+	byStripeID := make(map[string]*models.Invoice, len(localInvoices))
+	for _, inv := range localInvoices {
+		byStripeID[inv.StripeInvoiceID] = inv
+	}
+
+	return r.provider.ListInvoices(sub.StripeSubscriptionID, func(remote *models.Invoice) error {
+		local := byStripeID[remote.StripeInvoiceID]
+
+		// Only paid invoices are stored locally
+		if local == nil && remote.Status != models.InvoiceStatusPaid {
+			return nil
+		}
+		report.Invoices++
+
+		if local == nil {
+			mismatch := Mismatch{
+				Tenant:   tenant,
+				Kind:     MismatchMissingLocal,
+				Object:   "invoice",
+				StripeID: remote.StripeInvoiceID,
+			}
+
+			if opts.Repair {
+				remote.SubscriptionID = sub.ID
+				remote.UserID = sub.UserID
+				remote.Tenant = sub.Tenant
+				if err := r.invoiceRepo.Create(remote); err != nil {
+					mismatch.Error = err.Error()
+				} else {
+					mismatch.Repaired = true
+				}
+			}
+
+			report.Mismatches = append(report.Mismatches, mismatch)
+			return nil
+		}
+
+		diffs := diffInvoice(local, remote)
+		if len(diffs) == 0 {
+			return nil
+		}
+
+		var repairErr error
+		if opts.Repair {
+			local.Status = remote.Status
+			local.AmountPaid = remote.AmountPaid
+			local.InvoicePDF = remote.InvoicePDF
+			local.HostedInvoiceURL = remote.HostedInvoiceURL
+			// The remote breakdown lacks tax rate names, so it only replaces
+			// the local one when the tax differs
+			if local.Tax != remote.Tax {
+				local.TaxBreakdown = remote.TaxBreakdown
+			}
+			local.Subtotal = remote.Subtotal
+			local.Tax = remote.Tax
+			local.Total = remote.Total
+			local.CustomerCountry = remote.CustomerCountry
+			local.CustomerTaxIDs = remote.CustomerTaxIDs
+			repairErr = r.invoiceRepo.Update(local)
+		}
+
+		for _, diff := range diffs {
+			diff.Tenant = tenant
+			diff.StripeID = remote.StripeInvoiceID
+			if opts.Repair {
+				diff.Repaired = repairErr == nil
+				if repairErr != nil {
+					diff.Error = repairErr.Error()
+				}
+			}
+			report.Mismatches = append(report.Mismatches, diff)
+		}
+
+		return nil
+	})
+}
+
+// notify sends the repaired subscription state to the backend
+func (r *Reconciler) notify(sub *models.Subscription, opts Options) {
+	if !opts.Notify || r.webhookClient == nil {
+		return
+	}
+
+	var email string
+	cust, err := r.customerRepo.GetByStripeCustomerID(sub.StripeCustomerID)
+	if err != nil {
+		log.Printf("Error fetching customer email: %v", err)
+	} else if cust != nil {
+		email = cust.Email
+	}
+
+	payload := webhook.SubscriptionData{
+		UserID:             sub.UserID,
+		Email:              email,
+		Status:             string(sub.Status),
+		Plan:               string(sub.Plan),
+		SubscriptionID:     sub.StripeSubscriptionID,
+		CurrentPeriodStart: sub.CurrentPeriodStart,
+		CurrentPeriodEnd:   sub.CurrentPeriodEnd,
+		CancelAtPeriodEnd:  sub.CancelAtPeriodEnd,
+		Quantity:           sub.Quantity,
+		Currency:           sub.Currency,
+	}
+
+	if err := r.webhookClient.Notify(webhook.NewEvent("", webhook.EventSubscriptionUpdated, sub.Tenant, payload)); err != nil {
+		log.Printf("Error notifying backend: %v", err)
+	}
+}
+
+func diffSubscription(local, remote *models.Subscription) []Mismatch {
+	var diffs []Mismatch
+	add := func(field, localValue, remoteValue string) {
+		if localValue != remoteValue {
+			diffs = append(diffs, Mismatch{
+				Kind:   MismatchField,
+				Object: "subscription",
+				Field:  field,
+				Local:  localValue,
+				Remote: remoteValue,
+			})
+		}
+	}
+
+	add("status", string(local.Status), string(remote.Status))
+	if remote.Plan != "" {
+		add("plan", string(local.Plan), string(remote.Plan))
+	}
+	add("current_period_start", formatTime(local.CurrentPeriodStart), formatTime(remote.CurrentPeriodStart))
+	add("current_period_end", formatTime(local.CurrentPeriodEnd), formatTime(remote.CurrentPeriodEnd))
+	add("cancel_at_period_end", strconv.FormatBool(local.CancelAtPeriodEnd), strconv.FormatBool(remote.CancelAtPeriodEnd))
+	add("quantity", strconv.FormatInt(local.Quantity, 10), strconv.FormatInt(remote.Quantity, 10))
+	add("currency", local.Currency, remote.Currency)
+
+	return diffs
+}
+
+func diffInvoice(local, remote *models.Invoice) []Mismatch {
+	var diffs []Mismatch
+	add := func(field, localValue, remoteValue string) {
+		if localValue != remoteValue {
+			diffs = append(diffs, Mismatch{
+				Kind:   MismatchField,
+				Object: "invoice",
+				Field:  field,
+				Local:  localValue,
+				Remote: remoteValue,
+			})
+		}
+	}
+
+	add("status", string(local.Status), string(remote.Status))
+	add("amount_paid", strconv.FormatInt(local.AmountPaid, 10), strconv.FormatInt(remote.AmountPaid, 10))
+	add("tax", strconv.FormatInt(local.Tax, 10), strconv.FormatInt(remote.Tax, 10))
+	add("total", strconv.FormatInt(local.Total, 10), strconv.FormatInt(remote.Total, 10))
+
+	return diffs
+}
+
+// formatTime renders a timestamp in UTC with second precision, matching
+// the resolution Stripe reports
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Truncate(time.Second).Format(time.RFC3339)
+}
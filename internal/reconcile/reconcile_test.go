@@ -0,0 +1,408 @@
+package reconcile
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/naventro/payment-service/internal/models"
+	"github.com/naventro/payment-service/internal/webhook"
+)
+
+// fakeProvider serves subscriptions and invoices from memory. Subscriptions
+// listed in hidden are left out of ListSubscriptions, as lagging search
+// results are, but still found by GetSubscription.
+type fakeProvider struct {
+	subscriptions []*models.Subscription
+	invoices      map[string][]*models.Invoice
+	hidden        map[string]bool
+	mrr           map[string]int64
+}
+
+func (p *fakeProvider) ListSubscriptions(tenant string, fn func(*models.Subscription) error) error {
+	for _, sub := range p.subscriptions {
+		if sub.Tenant != tenant || p.hidden[sub.StripeSubscriptionID] {
+			continue
+		}
+		copied := *sub
+		if err := fn(&copied); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *fakeProvider) GetSubscription(stripeSubscriptionID string) (*models.Subscription, error) {
+	for _, sub := range p.subscriptions {
+		if sub.StripeSubscriptionID == stripeSubscriptionID {
+			copied := *sub
+			return &copied, nil
+		}
+	}
+	return nil, nil
+}
+
+func (p *fakeProvider) ListInvoices(stripeSubscriptionID string, fn func(*models.Invoice) error) error {
+	for _, inv := range p.invoices[stripeSubscriptionID] {
+		copied := *inv
+		if err := fn(&copied); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *fakeProvider) MonthlyRecurringAmount(stripeSubscriptionID string) (int64, error) {
+	return p.mrr[stripeSubscriptionID], nil
+}
+
+// fakeStore keeps local subscriptions and invoices in memory
+type fakeStore struct {
+	subscriptions []*models.Subscription
+	invoices      []*models.Invoice
+	updateErr     error
+}
+
+func (s *fakeStore) ListTenants() ([]string, error) {
+	seen := make(map[string]bool)
+	var tenants []string
+	for _, sub := range s.subscriptions {
+		if !seen[sub.Tenant] {
+			seen[sub.Tenant] = true
+			tenants = append(tenants, sub.Tenant)
+		}
+	}
+	return tenants, nil
+}
+
+func (s *fakeStore) ListByTenant(tenant string) ([]*models.Subscription, error) {
+	var subs []*models.Subscription
+	for _, sub := range s.subscriptions {
+		if sub.Tenant == tenant {
+			copied := *sub
+			subs = append(subs, &copied)
+		}
+	}
+	return subs, nil
+}
+
+func (s *fakeStore) Create(sub *models.Subscription) error {
+	sub.ID = len(s.subscriptions) + 1
+	copied := *sub
+	s.subscriptions = append(s.subscriptions, &copied)
+	return nil
+}
+
+func (s *fakeStore) Update(sub *models.Subscription) error {
+	if s.updateErr != nil {
+		return s.updateErr
+	}
+	for i, stored := range s.subscriptions {
+		if stored.ID == sub.ID {
+			copied := *sub
+			s.subscriptions[i] = &copied
+			return nil
+		}
+	}
+	return errors.New("subscription not found")
+}
+
+func (s *fakeStore) subscription(stripeID string) *models.Subscription {
+	for _, sub := range s.subscriptions {
+		if sub.StripeSubscriptionID == stripeID {
+			return sub
+		}
+	}
+	return nil
+}
+
+// fakeInvoices adapts fakeStore to InvoiceStore
+type fakeInvoices struct{ *fakeStore }
+
+func (s fakeInvoices) GetBySubscriptionID(subscriptionID int) ([]*models.Invoice, error) {
+	var invoices []*models.Invoice
+	for _, inv := range s.invoices {
+		if inv.SubscriptionID == subscriptionID {
+			copied := *inv
+			invoices = append(invoices, &copied)
+		}
+	}
+	return invoices, nil
+}
+
+func (s fakeInvoices) Create(invoice *models.Invoice) error {
+	invoice.ID = len(s.invoices) + 1
+	copied := *invoice
+	s.invoices = append(s.invoices, &copied)
+	return nil
+}
+
+func (s fakeInvoices) Update(invoice *models.Invoice) error {
+	for i, stored := range s.invoices {
+		if stored.ID == invoice.ID {
+			copied := *invoice
+			s.invoices[i] = &copied
+			return nil
+		}
+	}
+	return errors.New("invoice not found")
+}
+
+type fakeCustomers struct{}
+
+func (fakeCustomers) GetByStripeCustomerID(string) (*models.Customer, error) {
+	return &models.Customer{Email: "ana@example.com"}, nil
+}
+
+type fakeHistory struct {
+	entries []*models.SubscriptionHistory
+}
+
+func (h *fakeHistory) Record(entry *models.SubscriptionHistory) error {
+	h.entries = append(h.entries, entry)
+	return nil
+}
+
+type fakeNotifier struct {
+	events []*webhook.Event
+}
+
+func (n *fakeNotifier) Notify(event *webhook.Event) error {
+	n.events = append(n.events, event)
+	return nil
+}
+
+func timeAt(day int) *time.Time {
+	t := time.Date(2026, time.October, day, 0, 0, 0, 0, time.UTC)
+	return &t
+}
+
+func TestDiffSubscription(t *testing.T) {
+	local := &models.Subscription{
+		Status:           models.StatusActive,
+		Plan:             "premium_monthly",
+		CurrentPeriodEnd: timeAt(1),
+		Quantity:         1,
+		Currency:         "usd",
+	}
+
+	t.Run("equal", func(t *testing.T) {
+		remote := *local
+		// Sub-second differences are below Stripe's resolution
+		end := local.CurrentPeriodEnd.Add(300 * time.Millisecond)
+		remote.CurrentPeriodEnd = &end
+		if diffs := diffSubscription(local, &remote); len(diffs) != 0 {
+			t.Errorf("diffs = %+v, want none", diffs)
+		}
+	})
+
+	t.Run("status and period", func(t *testing.T) {
+		remote := *local
+		remote.Status = models.StatusPastDue
+		remote.CurrentPeriodEnd = timeAt(31)
+
+		diffs := diffSubscription(local, &remote)
+		if len(diffs) != 2 {
+			t.Fatalf("diffs = %+v, want status and current_period_end", diffs)
+		}
+		if diffs[0].Field != "status" || diffs[0].Local != "active" || diffs[0].Remote != "past_due" {
+			t.Errorf("status diff = %+v", diffs[0])
+		}
+		if diffs[1].Field != "current_period_end" || diffs[1].Remote != "2026-10-31T00:00:00Z" {
+			t.Errorf("period diff = %+v", diffs[1])
+		}
+	})
+
+	t.Run("plan unknown remotely", func(t *testing.T) {
+		remote := *local
+		remote.Plan = ""
+		if diffs := diffSubscription(local, &remote); len(diffs) != 0 {
+			t.Errorf("diffs = %+v, want none", diffs)
+		}
+	})
+}
+
+func TestDiffInvoice(t *testing.T) {
+	local := &models.Invoice{Status: models.InvoiceStatusPaid, AmountPaid: 999, Tax: 0, Total: 999}
+
+	remote := *local
+	if diffs := diffInvoice(local, &remote); len(diffs) != 0 {
+		t.Errorf("diffs = %+v, want none", diffs)
+	}
+
+	remote.Tax = 210
+	remote.Total = 1209
+	diffs := diffInvoice(local, &remote)
+	if len(diffs) != 2 || diffs[0].Field != "tax" || diffs[1].Field != "total" {
+		t.Errorf("diffs = %+v, want tax and total", diffs)
+	}
+}
+
+// newFixture returns a store and provider where sub_drift differs in status,
+// sub_new exists only in the provider, sub_gone only locally, and sub_lagging
+// matches but is missing from the provider's listing
+func newFixture() (*fakeStore, *fakeProvider) {
+	store := &fakeStore{
+		subscriptions: []*models.Subscription{
+			{ID: 1, UserID: "u1", Tenant: "menuum", StripeSubscriptionID: "sub_drift", Status: models.StatusActive, Plan: "premium_monthly", CurrentPeriodEnd: timeAt(1)},
+			{ID: 2, UserID: "u2", Tenant: "menuum", StripeSubscriptionID: "sub_gone", Status: models.StatusActive, Plan: "premium_monthly"},
+			{ID: 3, UserID: "u3", Tenant: "menuum", StripeSubscriptionID: "sub_lagging", Status: models.StatusActive, Plan: "premium_monthly"},
+		},
+	}
+
+	provider := &fakeProvider{
+		subscriptions: []*models.Subscription{
+			{UserID: "u1", Tenant: "menuum", StripeSubscriptionID: "sub_drift", Status: models.StatusCanceled, Plan: "premium_monthly", CurrentPeriodEnd: timeAt(1)},
+			{UserID: "u4", Tenant: "menuum", StripeSubscriptionID: "sub_new", Status: models.StatusActive, Plan: "premium_yearly"},
+			{UserID: "u3", Tenant: "menuum", StripeSubscriptionID: "sub_lagging", Status: models.StatusActive, Plan: "premium_monthly"},
+		},
+		invoices: map[string][]*models.Invoice{
+			"sub_drift": {
+				{StripeInvoiceID: "in_paid", Status: models.InvoiceStatusPaid, AmountPaid: 999, Total: 999},
+				{StripeInvoiceID: "in_open", Status: models.InvoiceStatusOpen, Total: 999},
+			},
+		},
+		hidden: map[string]bool{"sub_lagging": true},
+		mrr:    map[string]int64{"sub_drift": 999, "sub_new": 833},
+	}
+
+	return store, provider
+}
+
+func findMismatch(report *Report, object, stripeID string, kind MismatchKind) *Mismatch {
+	for i, m := range report.Mismatches {
+		if m.Object == object && m.StripeID == stripeID && m.Kind == kind {
+			return &report.Mismatches[i]
+		}
+	}
+	return nil
+}
+
+func TestRunReportOnly(t *testing.T) {
+	store, provider := newFixture()
+	notifier := &fakeNotifier{}
+	history := &fakeHistory{}
+	reconciler := New(provider, store, fakeInvoices{store}, fakeCustomers{}, history, notifier)
+
+	report, err := reconciler.Run(Options{Notify: true})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	if report.Subscriptions != 4 {
+		t.Errorf("subscriptions checked = %d, want 4", report.Subscriptions)
+	}
+	if report.Invoices != 1 {
+		t.Errorf("invoices checked = %d, want 1 (unpaid invoices are not stored locally)", report.Invoices)
+	}
+
+	drift := findMismatch(report, "subscription", "sub_drift", MismatchField)
+	if drift == nil || drift.Field != "status" || drift.Local != "active" || drift.Remote != "canceled" {
+		t.Errorf("status mismatch = %+v", drift)
+	}
+	if m := findMismatch(report, "subscription", "sub_new", MismatchMissingLocal); m == nil {
+		t.Error("subscription missing locally not reported")
+	}
+	if m := findMismatch(report, "subscription", "sub_gone", MismatchMissingRemote); m == nil {
+		t.Error("subscription missing in the provider not reported")
+	}
+	if m := findMismatch(report, "subscription", "sub_lagging", MismatchMissingRemote); m != nil {
+		t.Error("subscription missing from the listing reported although it exists")
+	}
+	if m := findMismatch(report, "invoice", "in_paid", MismatchMissingLocal); m == nil {
+		t.Error("invoice missing locally not reported")
+	}
+
+	if report.Unresolved() != len(report.Mismatches) {
+		t.Errorf("unresolved = %d, want all %d mismatches", report.Unresolved(), len(report.Mismatches))
+	}
+	if got := store.subscription("sub_drift").Status; got != models.StatusActive {
+		t.Errorf("local status changed to %s without repair", got)
+	}
+	if store.subscription("sub_new") != nil || len(store.invoices) != 0 {
+		t.Error("records created without repair")
+	}
+	if len(notifier.events) != 0 {
+		t.Errorf("sent %d notifications without repair", len(notifier.events))
+	}
+	if len(history.entries) != 0 {
+		t.Errorf("recorded %d history entries without repair", len(history.entries))
+	}
+}
+
+func TestRunRepair(t *testing.T) {
+	store, provider := newFixture()
+	notifier := &fakeNotifier{}
+	history := &fakeHistory{}
+	reconciler := New(provider, store, fakeInvoices{store}, fakeCustomers{}, history, notifier)
+
+	report, err := reconciler.Run(Options{Tenants: []string{"menuum"}, Repair: true, Notify: true})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	if got := store.subscription("sub_drift").Status; got != models.StatusCanceled {
+		t.Errorf("local status = %s, want canceled", got)
+	}
+	created := store.subscription("sub_new")
+	if created == nil || created.Plan != "premium_yearly" {
+		t.Errorf("missing subscription not created: %+v", created)
+	}
+	if len(store.invoices) != 1 || store.invoices[0].StripeInvoiceID != "in_paid" || store.invoices[0].SubscriptionID != 1 {
+		t.Errorf("invoices = %+v, want in_paid linked to subscription 1", store.invoices)
+	}
+
+	// Records missing in the provider cannot be repaired from it
+	if report.Unresolved() != 1 {
+		t.Errorf("unresolved = %d, want 1", report.Unresolved())
+	}
+	if m := findMismatch(report, "subscription", "sub_gone", MismatchMissingRemote); m == nil || m.Repaired {
+		t.Errorf("missing remote mismatch = %+v", m)
+	}
+
+	// Both repairs are visible to analytics, with the provider's MRR
+	if len(history.entries) != 2 {
+		t.Fatalf("history entries = %d, want 2", len(history.entries))
+	}
+	drift, added := history.entries[0], history.entries[1]
+	if drift.SubscriptionID != 1 || drift.Status != models.StatusCanceled || drift.MRR != 999 || drift.StripeEventID != nil {
+		t.Errorf("status repair history = %+v", drift)
+	}
+	if added.SubscriptionID != created.ID || added.Plan != "premium_yearly" || added.MRR != 833 || added.StripeEventID != nil {
+		t.Errorf("created subscription history = %+v", added)
+	}
+
+	if len(notifier.events) != 2 {
+		t.Fatalf("notifications = %d, want 2", len(notifier.events))
+	}
+	for _, event := range notifier.events {
+		if event.Type != webhook.EventSubscriptionUpdated || event.Tenant != "menuum" {
+			t.Errorf("notification = %+v", event)
+		}
+	}
+	if data := notifier.events[0].Data.(webhook.SubscriptionData); data.Status != "canceled" || data.Email != "ana@example.com" {
+		t.Errorf("notification data = %+v", data)
+	}
+}
+
+func TestRunRepairFailure(t *testing.T) {
+	store, provider := newFixture()
+	store.updateErr = errors.New("connection lost")
+	history := &fakeHistory{}
+	reconciler := New(provider, store, fakeInvoices{store}, fakeCustomers{}, history, nil)
+
+	report, err := reconciler.Run(Options{Repair: true})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	drift := findMismatch(report, "subscription", "sub_drift", MismatchField)
+	if drift == nil || drift.Repaired || drift.Error != "connection lost" {
+		t.Errorf("status mismatch = %+v, want unrepaired with the update error", drift)
+	}
+	for _, entry := range history.entries {
+		if entry.SubscriptionID == 1 {
+			t.Errorf("history recorded for the failed repair: %+v", entry)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package reconcile
+
+import (
+	"github.com/naventro/payment-service/internal/models"
+	"github.com/naventro/payment-service/internal/webhook"
+)
+
+// SubscriptionStore holds the local subscriptions, implemented by
+// repository.SubscriptionRepository
+type SubscriptionStore interface {
+	ListTenants() ([]string, error)
+	ListByTenant(tenant string) ([]*models.Subscription, error)
+	Create(sub *models.Subscription) error
+	Update(sub *models.Subscription) error
+}
+
+// InvoiceStore holds the local invoices, implemented by
+// repository.InvoiceRepository
+type InvoiceStore interface {
+	GetBySubscriptionID(subscriptionID int) ([]*models.Invoice, error)
+	Create(invoice *models.Invoice) error
+	Update(invoice *models.Invoice) error
+}
+
+// CustomerStore looks up cached customers, implemented by
+// repository.CustomerRepository
+type CustomerStore interface {
+	GetByStripeCustomerID(stripeCustomerID string) (*models.Customer, error)
+}
+
+// HistoryStore records subscription snapshots for analytics, implemented by
+// repository.SubscriptionHistoryRepository
+type HistoryStore interface {
+	Record(entry *models.SubscriptionHistory) error
+}
+
+// Notifier sends events to the backend, implemented by webhook.Client
+type Notifier interface {
+	Notify(event *webhook.Event) error
+}
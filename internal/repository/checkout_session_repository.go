@@ -0,0 +1,148 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/naventro/payment-service/internal/models"
+)
+
+type CheckoutSessionRepository struct {
+	db DBTX
+}
+
+func NewCheckoutSessionRepository(db *sql.DB) *CheckoutSessionRepository {
+	return &CheckoutSessionRepository{db: db}
+}
+
+// WithTx returns a copy of the repository that runs inside tx
+func (r *CheckoutSessionRepository) WithTx(tx *sql.Tx) *CheckoutSessionRepository {
+	return &CheckoutSessionRepository{db: tx}
+}
+
+func (r *CheckoutSessionRepository) Create(session *models.CheckoutSession) error {
+	query := `
+		INSERT INTO checkout_sessions (
+			stripe_session_id, user_id, tenant, plan, product, stripe_customer_id,
+			success_url, cancel_url, status, stripe_subscription_id,
+			subscription_id, completed_at, expired_at
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		RETURNING id, created_at, updated_at
+	`
+
+	err := r.db.QueryRow(
+		query,
+		session.StripeSessionID,
+		session.UserID,
+		session.Tenant,
+		session.Plan,
+		session.Product,
+		session.StripeCustomerID,
+		session.SuccessURL,
+		session.CancelURL,
+		session.Status,
+		session.StripeSubscriptionID,
+		session.SubscriptionID,
+		session.CompletedAt,
+		session.ExpiredAt,
+	).Scan(&session.ID, &session.CreatedAt, &session.UpdatedAt)
+
+	if err != nil {
+		return fmt.Errorf("error creating checkout session: %w", err)
+	}
+
+	return nil
+}
+
+func (r *CheckoutSessionRepository) GetByStripeSessionID(stripeSessionID string) (*models.CheckoutSession, error) {
+	query := `
+		SELECT
+			id, stripe_session_id, user_id, tenant, plan, product, stripe_customer_id,
+			success_url, cancel_url, status, stripe_subscription_id,
+			subscription_id, completed_at, expired_at, created_at, updated_at
+		FROM checkout_sessions
+		WHERE stripe_session_id = $1
+	`
+
+	session := &models.CheckoutSession{}
+	err := r.db.QueryRow(query, stripeSessionID).Scan(
+		&session.ID,
+		&session.StripeSessionID,
+		&session.UserID,
+		&session.Tenant,
+		&session.Plan,
+		&session.Product,
+		&session.StripeCustomerID,
+		&session.SuccessURL,
+		&session.CancelURL,
+		&session.Status,
+		&session.StripeSubscriptionID,
+		&session.SubscriptionID,
+		&session.CompletedAt,
+		&session.ExpiredAt,
+		&session.CreatedAt,
+		&session.UpdatedAt,
+	)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("error fetching checkout session: %w", err)
+	}
+
+	return session, nil
+}
+
+func (r *CheckoutSessionRepository) Update(session *models.CheckoutSession) error {
+	query := `
+		UPDATE checkout_sessions
+		SET status = $1, stripe_customer_id = $2, stripe_subscription_id = $3,
+		    subscription_id = $4, completed_at = $5, expired_at = $6,
+		    updated_at = CURRENT_TIMESTAMP
+		WHERE id = $7
+	`
+
+	result, err := r.db.Exec(
+		query,
+		session.Status,
+		session.StripeCustomerID,
+		session.StripeSubscriptionID,
+		session.SubscriptionID,
+		session.CompletedAt,
+		session.ExpiredAt,
+		session.ID,
+	)
+
+	if err != nil {
+		return fmt.Errorf("error updating checkout session: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error getting rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("checkout session not found")
+	}
+
+	return nil
+}
+
+// LinkSubscription attaches a local subscription to the completed checkout
+// sessions that produced the given Stripe subscription
+func (r *CheckoutSessionRepository) LinkSubscription(stripeSubscriptionID string, subscriptionID int) error {
+	query := `
+		UPDATE checkout_sessions
+		SET subscription_id = $1, updated_at = CURRENT_TIMESTAMP
+		WHERE stripe_subscription_id = $2 AND subscription_id IS NULL
+	`
+
+	if _, err := r.db.Exec(query, subscriptionID, stripeSubscriptionID); err != nil {
+		return fmt.Errorf("error linking checkout session: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,188 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/naventro/payment-service/internal/models"
+)
+
+const customerColumns = `
+	id, user_id, tenant, stripe_customer_id, email, name, created_at, updated_at
+`
+
+type CustomerRepository struct {
+	db DBTX
+}
+
+func NewCustomerRepository(db *sql.DB) *CustomerRepository {
+	return &CustomerRepository{db: db}
+}
+
+// WithTx returns a copy of the repository that runs inside tx
+func (r *CustomerRepository) WithTx(tx *sql.Tx) *CustomerRepository {
+	return &CustomerRepository{db: tx}
+}
+
+func scanCustomer(row *sql.Row) (*models.Customer, error) {
+	cust := &models.Customer{}
+	err := row.Scan(
+		&cust.ID,
+		&cust.UserID,
+		&cust.Tenant,
+		&cust.StripeCustomerID,
+		&cust.Email,
+		&cust.Name,
+		&cust.CreatedAt,
+		&cust.UpdatedAt,
+	)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("error fetching customer: %w", err)
+	}
+
+	return cust, nil
+}
+
+// Create stores a customer mapping unless the user is already mapped to a
+// Stripe customer in the tenant. The existing mapping is kept then and
+// created is false.
+func (r *CustomerRepository) Create(cust *models.Customer) (created bool, err error) {
+	query := `
+		INSERT INTO customers (user_id, tenant, stripe_customer_id, email, name)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (user_id, tenant) DO NOTHING
+		RETURNING id, created_at, updated_at
+	`
+
+	err = r.db.QueryRow(
+		query,
+		cust.UserID,
+		cust.Tenant,
+		cust.StripeCustomerID,
+		cust.Email,
+		cust.Name,
+	).Scan(&cust.ID, &cust.CreatedAt, &cust.UpdatedAt)
+
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, fmt.Errorf("error creating customer: %w", err)
+	}
+
+	return true, nil
+}
+
+func (r *CustomerRepository) GetByUserID(userID, tenant string) (*models.Customer, error) {
+	query := `SELECT ` + customerColumns + ` FROM customers WHERE user_id = $1 AND tenant = $2`
+	return scanCustomer(r.db.QueryRow(query, userID, tenant))
+}
+
+func (r *CustomerRepository) GetByStripeCustomerID(stripeCustomerID string) (*models.Customer, error) {
+	query := `SELECT ` + customerColumns + ` FROM customers WHERE stripe_customer_id = $1`
+	return scanCustomer(r.db.QueryRow(query, stripeCustomerID))
+}
+
+// GetOrCreate returns the customer mapped to userID and tenant, calling create
+// to provision one in Stripe when no mapping exists yet. The lookup and insert
+// run under a transaction-scoped advisory lock keyed on the user, so concurrent
+// checkouts for the same user cannot create duplicate Stripe customers.
+func (r *CustomerRepository) GetOrCreate(userID, tenant string, create func() (*models.Customer, error)) (*models.Customer, error) {
+	db, ok := r.db.(*sql.DB)
+	if !ok {
+		return nil, fmt.Errorf("GetOrCreate cannot run inside an existing transaction")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock(hashtext($1))`, tenant+":"+userID); err != nil {
+		return nil, fmt.Errorf("error acquiring customer lock: %w", err)
+	}
+
+	query := `SELECT ` + customerColumns + ` FROM customers WHERE user_id = $1 AND tenant = $2`
+	cust, err := scanCustomer(tx.QueryRow(query, userID, tenant))
+	if err != nil {
+		return nil, err
+	}
+
+	if cust != nil {
+		return cust, tx.Commit()
+	}
+
+	cust, err = create()
+	if err != nil {
+		return nil, err
+	}
+	cust.UserID = userID
+	cust.Tenant = tenant
+
+	// A customer.created webhook may have mapped the new customer already
+	insert := `
+		INSERT INTO customers (user_id, tenant, stripe_customer_id, email, name)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (user_id, tenant) DO UPDATE
+		SET stripe_customer_id = EXCLUDED.stripe_customer_id, updated_at = CURRENT_TIMESTAMP
+		RETURNING id, created_at, updated_at
+	`
+	err = tx.QueryRow(
+		insert,
+		cust.UserID,
+		cust.Tenant,
+		cust.StripeCustomerID,
+		cust.Email,
+		cust.Name,
+	).Scan(&cust.ID, &cust.CreatedAt, &cust.UpdatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("error creating customer: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing customer: %w", err)
+	}
+
+	return cust, nil
+}
+
+func (r *CustomerRepository) Update(cust *models.Customer) error {
+	query := `
+		UPDATE customers
+		SET email = $1, name = $2, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $3
+	`
+
+	result, err := r.db.Exec(query, cust.Email, cust.Name, cust.ID)
+	if err != nil {
+		return fmt.Errorf("error updating customer: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error getting rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("customer not found")
+	}
+
+	return nil
+}
+
+func (r *CustomerRepository) DeleteByStripeCustomerID(stripeCustomerID string) error {
+	query := `DELETE FROM customers WHERE stripe_customer_id = $1`
+
+	if _, err := r.db.Exec(query, stripeCustomerID); err != nil {
+		return fmt.Errorf("error deleting customer: %w", err)
+	}
+
+	return nil
+}
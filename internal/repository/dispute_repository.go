@@ -0,0 +1,210 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/naventro/payment-service/internal/models"
+)
+
+const disputeColumns = `
+	id, stripe_dispute_id, stripe_charge_id, stripe_payment_intent_id,
+	invoice_id, subscription_id, order_id, user_id, tenant, amount, currency,
+	reason, status, evidence_due_by, entitlements_revoked, closed_at, created_at,
+	updated_at
+`
+
+// DisputeFilter selects disputes; zero values are ignored
+type DisputeFilter struct {
+	Tenant string
+	UserID string
+	Status models.DisputeStatus
+	// Open limits the results to disputes that are not closed yet
+	Open  bool
+	Limit int
+}
+
+type DisputeRepository struct {
+	db DBTX
+}
+
+func NewDisputeRepository(db *sql.DB) *DisputeRepository {
+	return &DisputeRepository{db: db}
+}
+
+// WithTx returns a copy of the repository that runs inside tx
+func (r *DisputeRepository) WithTx(tx *sql.Tx) *DisputeRepository {
+	return &DisputeRepository{db: tx}
+}
+
+func scanDispute(row rowScanner) (*models.Dispute, error) {
+	dispute := &models.Dispute{}
+	err := row.Scan(
+		&dispute.ID,
+		&dispute.StripeDisputeID,
+		&dispute.StripeChargeID,
+		&dispute.StripePaymentIntentID,
+		&dispute.InvoiceID,
+		&dispute.SubscriptionID,
+		&dispute.OrderID,
+		&dispute.UserID,
+		&dispute.Tenant,
+		&dispute.Amount,
+		&dispute.Currency,
+		&dispute.Reason,
+		&dispute.Status,
+		&dispute.EvidenceDueBy,
+		&dispute.EntitlementsRevoked,
+		&dispute.ClosedAt,
+		&dispute.CreatedAt,
+		&dispute.UpdatedAt,
+	)
+	return dispute, err
+}
+
+func (r *DisputeRepository) Create(dispute *models.Dispute) error {
+	query := `
+		INSERT INTO disputes (
+			stripe_dispute_id, stripe_charge_id, stripe_payment_intent_id,
+			invoice_id, subscription_id, order_id, user_id, tenant, amount,
+			currency, reason, status, evidence_due_by, entitlements_revoked, closed_at
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
+		RETURNING id, created_at, updated_at
+	`
+
+	err := r.db.QueryRow(
+		query,
+		dispute.StripeDisputeID,
+		dispute.StripeChargeID,
+		dispute.StripePaymentIntentID,
+		dispute.InvoiceID,
+		dispute.SubscriptionID,
+		dispute.OrderID,
+		dispute.UserID,
+		dispute.Tenant,
+		dispute.Amount,
+		dispute.Currency,
+		dispute.Reason,
+		dispute.Status,
+		dispute.EvidenceDueBy,
+		dispute.EntitlementsRevoked,
+		dispute.ClosedAt,
+	).Scan(&dispute.ID, &dispute.CreatedAt, &dispute.UpdatedAt)
+
+	if err != nil {
+		return fmt.Errorf("error creating dispute: %w", err)
+	}
+
+	return nil
+}
+
+func (r *DisputeRepository) GetByStripeDisputeID(stripeDisputeID string) (*models.Dispute, error) {
+	query := `SELECT ` + disputeColumns + ` FROM disputes WHERE stripe_dispute_id = $1`
+	dispute, err := scanDispute(r.db.QueryRow(query, stripeDisputeID))
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("error fetching dispute: %w", err)
+	}
+
+	return dispute, nil
+}
+
+// List returns disputes matching filter, newest first
+func (r *DisputeRepository) List(filter DisputeFilter) ([]*models.Dispute, error) {
+	var conditions []string
+	var args []interface{}
+	where := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if filter.Tenant != "" {
+		where("tenant = $%d", filter.Tenant)
+	}
+	if filter.UserID != "" {
+		where("user_id = $%d", filter.UserID)
+	}
+	if filter.Status != "" {
+		where("status = $%d", filter.Status)
+	}
+	if filter.Open {
+		conditions = append(conditions, "closed_at IS NULL")
+	}
+
+	query := `SELECT ` + disputeColumns + ` FROM disputes`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY created_at DESC, id DESC"
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching disputes: %w", err)
+	}
+	defer rows.Close()
+
+	var disputes []*models.Dispute
+	for rows.Next() {
+		dispute, err := scanDispute(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning dispute: %w", err)
+		}
+		disputes = append(disputes, dispute)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating disputes: %w", err)
+	}
+
+	return disputes, nil
+}
+
+func (r *DisputeRepository) Update(dispute *models.Dispute) error {
+	query := `
+		UPDATE disputes
+		SET invoice_id = $1, subscription_id = $2, order_id = $3, user_id = $4,
+		    tenant = $5, amount = $6, reason = $7, status = $8, evidence_due_by = $9,
+		    entitlements_revoked = $10, closed_at = $11, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $12
+	`
+
+	result, err := r.db.Exec(
+		query,
+		dispute.InvoiceID,
+		dispute.SubscriptionID,
+		dispute.OrderID,
+		dispute.UserID,
+		dispute.Tenant,
+		dispute.Amount,
+		dispute.Reason,
+		dispute.Status,
+		dispute.EvidenceDueBy,
+		dispute.EntitlementsRevoked,
+		dispute.ClosedAt,
+		dispute.ID,
+	)
+
+	if err != nil {
+		return fmt.Errorf("error updating dispute: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error getting rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("dispute not found")
+	}
+
+	return nil
+}
@@ -0,0 +1,148 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// RowChange describes a row that a dry run inserted, updated or deleted
+type RowChange struct {
+	Table  string                 `json:"table"`
+	Op     string                 `json:"op"`
+	ID     interface{}            `json:"id"`
+	Fields []string               `json:"fields,omitempty"`
+	Before map[string]interface{} `json:"before,omitempty"`
+	After  map[string]interface{} `json:"after,omitempty"`
+}
+
+// DryRun runs fn inside a transaction that is always rolled back and returns
+// the rows it changed. Changes are found through MVCC system columns: rows
+// written by the transaction carry its ID in xmin, while the versions they
+// replaced, still visible to other connections, carry it in xmax.
+func DryRun(db *sql.DB, fn func(tx *sql.Tx) error) ([]RowChange, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var xid string
+	if err := tx.QueryRow(`SELECT xid(pg_current_xact_id())::text`).Scan(&xid); err != nil {
+		return nil, fmt.Errorf("error reading transaction id: %w", err)
+	}
+
+	if err := fn(tx); err != nil {
+		return nil, err
+	}
+
+	tables, err := trackedTables(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []RowChange
+	for _, table := range tables {
+		// Rows as the transaction left them
+		after, err := rowsByID(tx, fmt.Sprintf(`SELECT row_to_json(t) FROM %q t WHERE t.xmin = $1::xid`, table), xid)
+		if err != nil {
+			return nil, err
+		}
+
+		// Rows as everyone else still sees them
+		before, err := rowsByID(db, fmt.Sprintf(`SELECT row_to_json(t) FROM %q t WHERE t.xmax = $1::xid`, table), xid)
+		if err != nil {
+			return nil, err
+		}
+
+		changes = append(changes, diffRows(table, before, after)...)
+	}
+
+	return changes, nil
+}
+
+// trackedTables lists the application tables that have an id column
+func trackedTables(q DBTX) ([]string, error) {
+	rows, err := q.Query(`
+		SELECT table_name FROM information_schema.columns
+		WHERE table_schema = 'public' AND column_name = 'id'
+		ORDER BY table_name
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("error listing tables: %w", err)
+	}
+	defer rows.Close()
+
+	var tables []string
+	for rows.Next() {
+		var table string
+		if err := rows.Scan(&table); err != nil {
+			return nil, fmt.Errorf("error scanning table: %w", err)
+		}
+		tables = append(tables, table)
+	}
+
+	return tables, rows.Err()
+}
+
+func rowsByID(q DBTX, query, xid string) (map[string]map[string]interface{}, error) {
+	rows, err := q.Query(query, xid)
+	if err != nil {
+		return nil, fmt.Errorf("error reading changed rows: %w", err)
+	}
+	defer rows.Close()
+
+	result := make(map[string]map[string]interface{})
+	for rows.Next() {
+		var raw []byte
+		if err := rows.Scan(&raw); err != nil {
+			return nil, fmt.Errorf("error scanning changed row: %w", err)
+		}
+
+		var row map[string]interface{}
+		if err := json.Unmarshal(raw, &row); err != nil {
+			return nil, fmt.Errorf("error decoding changed row: %w", err)
+		}
+		result[fmt.Sprint(row["id"])] = row
+	}
+
+	return result, rows.Err()
+}
+
+func diffRows(table string, before, after map[string]map[string]interface{}) []RowChange {
+	var changes []RowChange
+
+	for id, row := range after {
+		old, existed := before[id]
+		if !existed {
+			changes = append(changes, RowChange{Table: table, Op: "insert", ID: row["id"], After: row})
+			continue
+		}
+
+		var fields []string
+		for field, value := range row {
+			if !reflect.DeepEqual(old[field], value) {
+				fields = append(fields, field)
+			}
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		sort.Strings(fields)
+		changes = append(changes, RowChange{Table: table, Op: "update", ID: row["id"], Fields: fields, Before: old, After: row})
+	}
+
+	for id, row := range before {
+		if _, exists := after[id]; !exists {
+			changes = append(changes, RowChange{Table: table, Op: "delete", ID: row["id"], Before: row})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return fmt.Sprint(changes[i].ID) < fmt.Sprint(changes[j].ID)
+	})
+
+	return changes
+}
@@ -0,0 +1,215 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/naventro/payment-service/internal/models"
+)
+
+// EventFilter selects stored events; zero values are ignored
+type EventFilter struct {
+	Type   string
+	Status models.EventStatus
+	From   *time.Time
+	To     *time.Time
+	Limit  int
+}
+
+type EventRepository struct {
+	db DBTX
+}
+
+func NewEventRepository(db *sql.DB) *EventRepository {
+	return &EventRepository{db: db}
+}
+
+// WithTx returns a copy of the repository that runs inside tx
+func (r *EventRepository) WithTx(tx *sql.Tx) *EventRepository {
+	return &EventRepository{db: tx}
+}
+
+// Record stores a received event, claimed for processing. If the event was
+// already stored the existing row is returned and inserted is false.
+func (r *EventRepository) Record(event *models.StripeEvent) (stored *models.StripeEvent, inserted bool, err error) {
+	query := `
+		INSERT INTO stripe_events (
+			stripe_event_id, type, status, payload, livemode, stripe_created_at, claimed_at
+		) VALUES ($1, $2, $3, $4, $5, $6, CURRENT_TIMESTAMP)
+		ON CONFLICT (stripe_event_id) DO NOTHING
+		RETURNING id, received_at
+	`
+
+	err = r.db.QueryRow(
+		query,
+		event.StripeEventID,
+		event.Type,
+		event.Status,
+		[]byte(event.Payload),
+		event.Livemode,
+		event.StripeCreatedAt,
+	).Scan(&event.ID, &event.ReceivedAt)
+
+	if err == sql.ErrNoRows {
+		stored, err = r.GetByStripeEventID(event.StripeEventID)
+		return stored, false, err
+	}
+
+	if err != nil {
+		return nil, false, fmt.Errorf("error recording event: %w", err)
+	}
+
+	return event, true, nil
+}
+
+func (r *EventRepository) GetByStripeEventID(stripeEventID string) (*models.StripeEvent, error) {
+	query := `
+		SELECT
+			id, stripe_event_id, type, status, payload, error, attempts,
+			livemode, stripe_created_at, received_at, processed_at
+		FROM stripe_events
+		WHERE stripe_event_id = $1
+	`
+
+	event := &models.StripeEvent{}
+	err := r.db.QueryRow(query, stripeEventID).Scan(
+		&event.ID,
+		&event.StripeEventID,
+		&event.Type,
+		&event.Status,
+		&event.Payload,
+		&event.Error,
+		&event.Attempts,
+		&event.Livemode,
+		&event.StripeCreatedAt,
+		&event.ReceivedAt,
+		&event.ProcessedAt,
+	)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("error fetching event: %w", err)
+	}
+
+	return event, nil
+}
+
+// List returns stored events matching filter, newest first. Payloads are not
+// loaded; fetch a single event to get its payload.
+func (r *EventRepository) List(filter EventFilter) ([]*models.StripeEvent, error) {
+	var conditions []string
+	var args []interface{}
+	where := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if filter.Type != "" {
+		where("type = $%d", filter.Type)
+	}
+	if filter.Status != "" {
+		where("status = $%d", filter.Status)
+	}
+	if filter.From != nil {
+		where("received_at >= $%d", *filter.From)
+	}
+	if filter.To != nil {
+		where("received_at < $%d", *filter.To)
+	}
+
+	query := `
+		SELECT
+			id, stripe_event_id, type, status, error, attempts,
+			livemode, stripe_created_at, received_at, processed_at
+		FROM stripe_events
+	`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY received_at DESC, id DESC"
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []*models.StripeEvent
+	for rows.Next() {
+		event := &models.StripeEvent{}
+		err := rows.Scan(
+			&event.ID,
+			&event.StripeEventID,
+			&event.Type,
+			&event.Status,
+			&event.Error,
+			&event.Attempts,
+			&event.Livemode,
+			&event.StripeCreatedAt,
+			&event.ReceivedAt,
+			&event.ProcessedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning event: %w", err)
+		}
+		events = append(events, event)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating events: %w", err)
+	}
+
+	return events, nil
+}
+
+// MarkResult records the outcome of a processing attempt
+func (r *EventRepository) MarkResult(id int, status models.EventStatus, errMessage *string) error {
+	query := `
+		UPDATE stripe_events
+		SET status = $1, error = $2, attempts = attempts + 1, processed_at = CURRENT_TIMESTAMP
+		WHERE id = $3
+	`
+
+	if _, err := r.db.Exec(query, status, errMessage, id); err != nil {
+		return fmt.Errorf("error updating event: %w", err)
+	}
+
+	return nil
+}
+
+// Claim takes a stored event for processing again. Events still being
+// processed are claimed once their last claim is older than lease, which
+// means the attempt was interrupted before its result was recorded; failed
+// events are claimed only when retryFailed is set. The row is updated in one
+// statement, so concurrent deliveries of an event claim it at most once.
+func (r *EventRepository) Claim(id int, retryFailed bool, lease time.Duration) (bool, error) {
+	query := `
+		UPDATE stripe_events
+		SET status = $1, claimed_at = CURRENT_TIMESTAMP
+		WHERE id = $2 AND (
+			(status = $1 AND (claimed_at IS NULL OR claimed_at < CURRENT_TIMESTAMP - make_interval(secs => $3)))
+			OR (status = $4 AND $5)
+		)
+	`
+
+	result, err := r.db.Exec(query, models.EventStatusReceived, id, lease.Seconds(), models.EventStatusFailed, retryFailed)
+	if err != nil {
+		return false, fmt.Errorf("error claiming event: %w", err)
+	}
+
+	claimed, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("error claiming event: %w", err)
+	}
+
+	return claimed > 0, nil
+}
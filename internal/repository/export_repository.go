@@ -0,0 +1,255 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/naventro/payment-service/internal/models"
+)
+
+// ExportFilter selects the records of an accounting export: those of Tenant,
+// or of every tenant if it is empty, dated in [From, To)
+type ExportFilter struct {
+	Tenant string
+	From   time.Time
+	To     time.Time
+}
+
+// ExportRepository streams invoices, orders, refunds and disputes for accounting
+// exports. Rows are handed to a callback as they are read, so exports of
+// long periods do not have to fit in memory.
+type ExportRepository struct {
+	db DBTX
+}
+
+func NewExportRepository(db *sql.DB) *ExportRepository {
+	return &ExportRepository{db: db}
+}
+
+// WithTx returns a copy of the repository that runs inside tx
+func (r *ExportRepository) WithTx(tx *sql.Tx) *ExportRepository {
+	return &ExportRepository{db: tx}
+}
+
+// extraColumns scans the columns selected after the ones a scan helper reads
+type extraColumns struct {
+	row  rowScanner
+	dest []interface{}
+}
+
+func (e extraColumns) Scan(dest ...interface{}) error {
+	return e.row.Scan(append(dest, e.dest...)...)
+}
+
+// stream runs query and calls scan for each row until it fails
+func (r *ExportRepository) stream(kind, query string, scan func(rowScanner) error, args ...interface{}) error {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return fmt.Errorf("error fetching %s: %w", kind, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return err
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("error iterating %s: %w", kind, err)
+	}
+
+	return nil
+}
+
+// StreamInvoices calls fn for each invoice created in the period, oldest
+// first, with the subscription it bills
+func (r *ExportRepository) StreamInvoices(filter ExportFilter, fn func(*models.InvoiceExport) error) error {
+	query := `
+		SELECT ` + invoiceColumns + `, stripe_subscription_id, plan, quantity
+		FROM (
+			SELECT i.*, s.stripe_subscription_id, s.plan, s.quantity
+			FROM invoices i
+			JOIN subscriptions s ON s.id = i.subscription_id
+			WHERE ($1 = '' OR i.tenant = $1)
+			  AND i.created_at >= $2::timestamptz AND i.created_at < $3::timestamptz
+		) i
+		ORDER BY created_at, id
+	`
+
+	return r.stream("invoices", query, func(row rowScanner) error {
+		export := &models.InvoiceExport{}
+		invoice, err := scanInvoice(extraColumns{row, []interface{}{
+			&export.StripeSubscriptionID,
+			&export.Plan,
+			&export.Quantity,
+		}})
+		if err != nil {
+			return fmt.Errorf("error scanning invoice: %w", err)
+		}
+		export.Invoice = *invoice
+		return fn(export)
+	}, filter.Tenant, filter.From, filter.To)
+}
+
+// StreamPaidInvoices calls fn for each invoice paid in the period, in the
+// order they were paid, with the subscription it bills. Invoices stored
+// without a payment time count as paid when they were created.
+func (r *ExportRepository) StreamPaidInvoices(filter ExportFilter, fn func(*models.InvoiceExport) error) error {
+	query := `
+		SELECT ` + invoiceColumns + `, stripe_subscription_id, plan, quantity
+		FROM (
+			SELECT i.*, s.stripe_subscription_id, s.plan, s.quantity, COALESCE(i.paid_at, i.created_at) AS export_date
+			FROM invoices i
+			JOIN subscriptions s ON s.id = i.subscription_id
+			WHERE ($1 = '' OR i.tenant = $1) AND i.status = $4
+			  AND COALESCE(i.paid_at, i.created_at) >= $2::timestamptz
+			  AND COALESCE(i.paid_at, i.created_at) < $3::timestamptz
+		) i
+		ORDER BY export_date, id
+	`
+
+	return r.stream("invoices", query, func(row rowScanner) error {
+		export := &models.InvoiceExport{}
+		invoice, err := scanInvoice(extraColumns{row, []interface{}{
+			&export.StripeSubscriptionID,
+			&export.Plan,
+			&export.Quantity,
+		}})
+		if err != nil {
+			return fmt.Errorf("error scanning invoice: %w", err)
+		}
+		export.Invoice = *invoice
+		return fn(export)
+	}, filter.Tenant, filter.From, filter.To, models.InvoiceStatusPaid)
+}
+
+// StreamPaidOrders calls fn for each one-time order paid in the period, in
+// the order they were paid, including the ones refunded or disputed since
+func (r *ExportRepository) StreamPaidOrders(filter ExportFilter, fn func(*models.Order) error) error {
+	query := `
+		SELECT ` + orderColumns + `
+		FROM orders
+		WHERE ($1 = '' OR tenant = $1)
+		  AND paid_at >= $2::timestamptz AND paid_at < $3::timestamptz
+		ORDER BY paid_at, id
+	`
+
+	return r.stream("orders", query, func(row rowScanner) error {
+		order, err := scanOrder(row)
+		if err != nil {
+			return fmt.Errorf("error scanning order: %w", err)
+		}
+		return fn(order)
+	}, filter.Tenant, filter.From, filter.To)
+}
+
+// StreamRefunds calls fn for each refund created in the period, oldest
+// first, with the invoice or order it was issued for
+func (r *ExportRepository) StreamRefunds(filter ExportFilter, fn func(*models.RefundExport) error) error {
+	query := `
+		SELECT ` + refundColumns + `, stripe_invoice_id, invoice_amount_paid, invoice_tax, order_amount, order_tax
+		FROM (
+			SELECT r.*, i.stripe_invoice_id, i.amount_paid AS invoice_amount_paid, i.tax AS invoice_tax,
+			       o.amount AS order_amount, o.tax AS order_tax
+			FROM refunds r
+			LEFT JOIN invoices i ON i.id = r.invoice_id
+			LEFT JOIN orders o ON o.id = r.order_id
+			WHERE ($1 = '' OR r.tenant = $1)
+			  AND r.created_at >= $2::timestamptz AND r.created_at < $3::timestamptz
+		) r
+		ORDER BY created_at, id
+	`
+
+	return r.stream("refunds", query, func(row rowScanner) error {
+		export := &models.RefundExport{}
+		refund, err := scanRefund(extraColumns{row, []interface{}{
+			&export.StripeInvoiceID,
+			&export.InvoiceAmountPaid,
+			&export.InvoiceTax,
+			&export.OrderAmount,
+			&export.OrderTax,
+		}})
+		if err != nil {
+			return fmt.Errorf("error scanning refund: %w", err)
+		}
+		export.Refund = *refund
+		return fn(export)
+	}, filter.Tenant, filter.From, filter.To)
+}
+
+// StreamDisputes calls fn for each dispute opened in the period, oldest
+// first, with the invoice it was filed against
+func (r *ExportRepository) StreamDisputes(filter ExportFilter, fn func(*models.DisputeExport) error) error {
+	return r.streamDisputes("d.created_at", "", filter, fn)
+}
+
+// StreamLostDisputes calls fn for each dispute lost in the period, in the
+// order they were closed. These are the disputes whose money is gone.
+func (r *ExportRepository) StreamLostDisputes(filter ExportFilter, fn func(*models.DisputeExport) error) error {
+	return r.streamDisputes("d.closed_at", models.DisputeStatusLost, filter, fn)
+}
+
+func (r *ExportRepository) streamDisputes(dateColumn string, status models.DisputeStatus, filter ExportFilter, fn func(*models.DisputeExport) error) error {
+	query := `
+		SELECT ` + disputeColumns + `, stripe_invoice_id
+		FROM (
+			SELECT d.*, i.stripe_invoice_id, ` + dateColumn + ` AS export_date
+			FROM disputes d
+			LEFT JOIN invoices i ON i.id = d.invoice_id
+			WHERE ($1 = '' OR d.tenant = $1) AND ($4 = '' OR d.status = $4)
+			  AND ` + dateColumn + ` >= $2::timestamptz AND ` + dateColumn + ` < $3::timestamptz
+		) d
+		ORDER BY export_date, id
+	`
+
+	return r.stream("disputes", query, func(row rowScanner) error {
+		export := &models.DisputeExport{}
+		dispute, err := scanDispute(extraColumns{row, []interface{}{&export.StripeInvoiceID}})
+		if err != nil {
+			return fmt.Errorf("error scanning dispute: %w", err)
+		}
+		export.Dispute = *dispute
+		return fn(export)
+	}, filter.Tenant, filter.From, filter.To, status)
+}
+
+// InvoicesMissingFee returns the paid invoices created in the period whose
+// charge is known but whose Stripe fee has not been stored yet, oldest first
+func (r *ExportRepository) InvoicesMissingFee(filter ExportFilter) ([]*models.Invoice, error) {
+	query := `
+		SELECT ` + invoiceColumns + `
+		FROM invoices
+		WHERE ($1 = '' OR tenant = $1)
+		  AND created_at >= $2::timestamptz AND created_at < $3::timestamptz
+		  AND status = $4 AND stripe_charge_id IS NOT NULL AND stripe_fee IS NULL
+		ORDER BY created_at, id
+	`
+
+	var invoices []*models.Invoice
+	err := r.stream("invoices", query, func(row rowScanner) error {
+		invoice, err := scanInvoice(row)
+		if err != nil {
+			return fmt.Errorf("error scanning invoice: %w", err)
+		}
+		invoices = append(invoices, invoice)
+		return nil
+	}, filter.Tenant, filter.From, filter.To, models.InvoiceStatusPaid)
+	if err != nil {
+		return nil, err
+	}
+
+	return invoices, nil
+}
+
+// SetInvoiceFee stores the Stripe fee of an invoice's payment
+func (r *ExportRepository) SetInvoiceFee(invoiceID int, fee int64, currency string) error {
+	query := `UPDATE invoices SET stripe_fee = $1, stripe_fee_currency = $2 WHERE id = $3`
+
+	if _, err := r.db.Exec(query, fee, currency, invoiceID); err != nil {
+		return fmt.Errorf("error updating invoice fee: %w", err)
+	}
+
+	return nil
+}
@@ -3,62 +3,36 @@ package repository
 import (
 	"database/sql"
 	"fmt"
+	"time"
 
 	"github.com/naventro/payment-service/internal/models"
 )
 
+const invoiceColumns = `
+	id, subscription_id, stripe_invoice_id, user_id, tenant,
+	amount_paid, currency, status, invoice_pdf, hosted_invoice_url,
+	stripe_payment_intent_id, stripe_charge_id, payment_action_required,
+	subtotal, tax, total, customer_country, customer_tax_ids, tax_breakdown,
+	stripe_fee, stripe_fee_currency, number, customer_name, customer_email,
+	customer_address, lines, period_start, period_end, paid_at, created_at
+`
+
 type InvoiceRepository struct {
-	db *sql.DB
+	db DBTX
 }
 
 func NewInvoiceRepository(db *sql.DB) *InvoiceRepository {
 	return &InvoiceRepository{db: db}
 }
 
-func (r *InvoiceRepository) Create(invoice *models.Invoice) error {
-	query := `
-		INSERT INTO invoices (
-			subscription_id, stripe_invoice_id, user_id, tenant,
-			amount_paid, currency, status, invoice_pdf, hosted_invoice_url,
-			period_start, period_end
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
-		RETURNING id, created_at
-	`
-
-	err := r.db.QueryRow(
-		query,
-		invoice.SubscriptionID,
-		invoice.StripeInvoiceID,
-		invoice.UserID,
-		invoice.Tenant,
-		invoice.AmountPaid,
-		invoice.Currency,
-		invoice.Status,
-		invoice.InvoicePDF,
-		invoice.HostedInvoiceURL,
-		invoice.PeriodStart,
-		invoice.PeriodEnd,
-	).Scan(&invoice.ID, &invoice.CreatedAt)
-
-	if err != nil {
-		return fmt.Errorf("error creating invoice: %w", err)
-	}
-
-	return nil
+// WithTx returns a copy of the repository that runs inside tx
+func (r *InvoiceRepository) WithTx(tx *sql.Tx) *InvoiceRepository {
+	return &InvoiceRepository{db: tx}
 }
 
-func (r *InvoiceRepository) GetByStripeInvoiceID(stripeInvoiceID string) (*models.Invoice, error) {
-	query := `
-		SELECT
-			id, subscription_id, stripe_invoice_id, user_id, tenant,
-			amount_paid, currency, status, invoice_pdf, hosted_invoice_url,
-			period_start, period_end, created_at
-		FROM invoices
-		WHERE stripe_invoice_id = $1
-	`
-
+func scanInvoice(row rowScanner) (*models.Invoice, error) {
 	invoice := &models.Invoice{}
-	err := r.db.QueryRow(query, stripeInvoiceID).Scan(
+	err := row.Scan(
 		&invoice.ID,
 		&invoice.SubscriptionID,
 		&invoice.StripeInvoiceID,
@@ -69,10 +43,32 @@ func (r *InvoiceRepository) GetByStripeInvoiceID(stripeInvoiceID string) (*model
 		&invoice.Status,
 		&invoice.InvoicePDF,
 		&invoice.HostedInvoiceURL,
+		&invoice.StripePaymentIntentID,
+		&invoice.StripeChargeID,
+		&invoice.PaymentActionRequired,
+		&invoice.Subtotal,
+		&invoice.Tax,
+		&invoice.Total,
+		&invoice.CustomerCountry,
+		&invoice.CustomerTaxIDs,
+		&invoice.TaxBreakdown,
+		&invoice.StripeFee,
+		&invoice.StripeFeeCurrency,
+		&invoice.Number,
+		&invoice.CustomerName,
+		&invoice.CustomerEmail,
+		&invoice.CustomerAddress,
+		&invoice.Lines,
 		&invoice.PeriodStart,
 		&invoice.PeriodEnd,
+		&invoice.PaidAt,
 		&invoice.CreatedAt,
 	)
+	return invoice, err
+}
+
+func (r *InvoiceRepository) getOne(query string, args ...interface{}) (*models.Invoice, error) {
+	invoice, err := scanInvoice(r.db.QueryRow(query, args...))
 
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -85,18 +81,8 @@ func (r *InvoiceRepository) GetByStripeInvoiceID(stripeInvoiceID string) (*model
 	return invoice, nil
 }
 
-func (r *InvoiceRepository) GetByUserID(userID, tenant string) ([]*models.Invoice, error) {
-	query := `
-		SELECT
-			id, subscription_id, stripe_invoice_id, user_id, tenant,
-			amount_paid, currency, status, invoice_pdf, hosted_invoice_url,
-			period_start, period_end, created_at
-		FROM invoices
-		WHERE user_id = $1 AND tenant = $2
-		ORDER BY created_at DESC
-	`
-
-	rows, err := r.db.Query(query, userID, tenant)
+func (r *InvoiceRepository) list(query string, args ...interface{}) ([]*models.Invoice, error) {
+	rows, err := r.db.Query(query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("error fetching invoices: %w", err)
 	}
@@ -104,22 +90,7 @@ func (r *InvoiceRepository) GetByUserID(userID, tenant string) ([]*models.Invoic
 
 	var invoices []*models.Invoice
 	for rows.Next() {
-		invoice := &models.Invoice{}
-		err := rows.Scan(
-			&invoice.ID,
-			&invoice.SubscriptionID,
-			&invoice.StripeInvoiceID,
-			&invoice.UserID,
-			&invoice.Tenant,
-			&invoice.AmountPaid,
-			&invoice.Currency,
-			&invoice.Status,
-			&invoice.InvoicePDF,
-			&invoice.HostedInvoiceURL,
-			&invoice.PeriodStart,
-			&invoice.PeriodEnd,
-			&invoice.CreatedAt,
-		)
+		invoice, err := scanInvoice(rows)
 		if err != nil {
 			return nil, fmt.Errorf("error scanning invoice: %w", err)
 		}
@@ -133,11 +104,107 @@ func (r *InvoiceRepository) GetByUserID(userID, tenant string) ([]*models.Invoic
 	return invoices, nil
 }
 
+func (r *InvoiceRepository) Create(invoice *models.Invoice) error {
+	query := `
+		INSERT INTO invoices (
+			subscription_id, stripe_invoice_id, user_id, tenant,
+			amount_paid, currency, status, invoice_pdf, hosted_invoice_url,
+			stripe_payment_intent_id, stripe_charge_id, payment_action_required,
+			subtotal, tax, total, customer_country, customer_tax_ids, tax_breakdown,
+			stripe_fee, stripe_fee_currency, number, customer_name, customer_email,
+			customer_address, lines, period_start, period_end, paid_at
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24, $25, $26, $27, $28)
+		RETURNING id, created_at
+	`
+
+	err := r.db.QueryRow(
+		query,
+		invoice.SubscriptionID,
+		invoice.StripeInvoiceID,
+		invoice.UserID,
+		invoice.Tenant,
+		invoice.AmountPaid,
+		invoice.Currency,
+		invoice.Status,
+		invoice.InvoicePDF,
+		invoice.HostedInvoiceURL,
+		invoice.StripePaymentIntentID,
+		invoice.StripeChargeID,
+		invoice.PaymentActionRequired,
+		invoice.Subtotal,
+		invoice.Tax,
+		invoice.Total,
+		invoice.CustomerCountry,
+		invoice.CustomerTaxIDs,
+		invoice.TaxBreakdown,
+		invoice.StripeFee,
+		invoice.StripeFeeCurrency,
+		invoice.Number,
+		invoice.CustomerName,
+		invoice.CustomerEmail,
+		invoice.CustomerAddress,
+		invoice.Lines,
+		invoice.PeriodStart,
+		invoice.PeriodEnd,
+		invoice.PaidAt,
+	).Scan(&invoice.ID, &invoice.CreatedAt)
+
+	if err != nil {
+		return fmt.Errorf("error creating invoice: %w", err)
+	}
+
+	return nil
+}
+
+func (r *InvoiceRepository) GetByID(id int) (*models.Invoice, error) {
+	return r.getOne(`SELECT `+invoiceColumns+` FROM invoices WHERE id = $1`, id)
+}
+
+func (r *InvoiceRepository) GetByStripeInvoiceID(stripeInvoiceID string) (*models.Invoice, error) {
+	return r.getOne(`SELECT `+invoiceColumns+` FROM invoices WHERE stripe_invoice_id = $1`, stripeInvoiceID)
+}
+
+// GetByPayment returns the invoice paid by a PaymentIntent or, for payments
+// made without one, a charge
+func (r *InvoiceRepository) GetByPayment(paymentIntentID, chargeID string) (*models.Invoice, error) {
+	query := `
+		SELECT ` + invoiceColumns + ` FROM invoices
+		WHERE (stripe_payment_intent_id = $1 AND $1 <> '') OR (stripe_charge_id = $2 AND $2 <> '')
+		ORDER BY created_at DESC
+		LIMIT 1
+	`
+	return r.getOne(query, paymentIntentID, chargeID)
+}
+
+func (r *InvoiceRepository) GetByUserID(userID, tenant string) ([]*models.Invoice, error) {
+	query := `
+		SELECT ` + invoiceColumns + ` FROM invoices
+		WHERE user_id = $1 AND tenant = $2
+		ORDER BY created_at DESC
+	`
+	return r.list(query, userID, tenant)
+}
+
+func (r *InvoiceRepository) GetBySubscriptionID(subscriptionID int) ([]*models.Invoice, error) {
+	query := `
+		SELECT ` + invoiceColumns + ` FROM invoices
+		WHERE subscription_id = $1
+		ORDER BY created_at DESC
+	`
+	return r.list(query, subscriptionID)
+}
+
 func (r *InvoiceRepository) Update(invoice *models.Invoice) error {
 	query := `
 		UPDATE invoices
-		SET status = $1, amount_paid = $2, invoice_pdf = $3, hosted_invoice_url = $4
-		WHERE id = $5
+		SET status = $1, amount_paid = $2, invoice_pdf = $3, hosted_invoice_url = $4,
+		    stripe_payment_intent_id = $5, stripe_charge_id = $6,
+		    payment_action_required = $7, subtotal = $8, tax = $9, total = $10,
+		    customer_country = $11, customer_tax_ids = $12, tax_breakdown = $13,
+		    stripe_fee = $14, stripe_fee_currency = $15, number = COALESCE(number, $16),
+		    customer_name = $17, customer_email = $18, customer_address = $19,
+		    lines = $20, paid_at = COALESCE(paid_at, $21)
+		WHERE id = $22
 	`
 
 	result, err := r.db.Exec(
@@ -146,6 +213,23 @@ func (r *InvoiceRepository) Update(invoice *models.Invoice) error {
 		invoice.AmountPaid,
 		invoice.InvoicePDF,
 		invoice.HostedInvoiceURL,
+		invoice.StripePaymentIntentID,
+		invoice.StripeChargeID,
+		invoice.PaymentActionRequired,
+		invoice.Subtotal,
+		invoice.Tax,
+		invoice.Total,
+		invoice.CustomerCountry,
+		invoice.CustomerTaxIDs,
+		invoice.TaxBreakdown,
+		invoice.StripeFee,
+		invoice.StripeFeeCurrency,
+		invoice.Number,
+		invoice.CustomerName,
+		invoice.CustomerEmail,
+		invoice.CustomerAddress,
+		invoice.Lines,
+		invoice.PaidAt,
 		invoice.ID,
 	)
 
@@ -164,3 +248,102 @@ func (r *InvoiceRepository) Update(invoice *models.Invoice) error {
 
 	return nil
 }
+
+// NextNumber returns the next number of one of a tenant's invoice numbering
+// series, starting at 1
+func (r *InvoiceRepository) NextNumber(tenant, series string) (int64, error) {
+	query := `
+		INSERT INTO invoice_number_sequences (tenant, series, last_number)
+		VALUES ($1, $2, 1)
+		ON CONFLICT (tenant, series) DO UPDATE
+		SET last_number = invoice_number_sequences.last_number + 1,
+		    updated_at = CURRENT_TIMESTAMP
+		RETURNING last_number
+	`
+
+	var number int64
+	if err := r.db.QueryRow(query, tenant, series).Scan(&number); err != nil {
+		return 0, fmt.Errorf("error incrementing invoice number: %w", err)
+	}
+
+	return number, nil
+}
+
+// AssignNumber gives an invoice the next number of one of its tenant's
+// numbering series, formatted by format, unless it already has a number, and
+// returns the invoice's number. The invoice is locked while the number is
+// taken and stored in the same transaction, so concurrent calls number an
+// invoice once and a failure does not leave a gap in the series.
+func (r *InvoiceRepository) AssignNumber(invoiceID int, tenant, series string, format func(int64) string) (string, error) {
+	if db, ok := r.db.(*sql.DB); ok {
+		tx, err := db.Begin()
+		if err != nil {
+			return "", fmt.Errorf("error starting transaction: %w", err)
+		}
+		defer tx.Rollback()
+
+		number, err := r.WithTx(tx).AssignNumber(invoiceID, tenant, series, format)
+		if err != nil {
+			return "", err
+		}
+
+		return number, tx.Commit()
+	}
+
+	var current sql.NullString
+	err := r.db.QueryRow(`SELECT number FROM invoices WHERE id = $1 FOR UPDATE`, invoiceID).Scan(&current)
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("invoice not found")
+	}
+	if err != nil {
+		return "", fmt.Errorf("error locking invoice: %w", err)
+	}
+
+	if current.Valid {
+		return current.String, nil
+	}
+
+	n, err := r.NextNumber(tenant, series)
+	if err != nil {
+		return "", err
+	}
+
+	number := format(n)
+	if _, err := r.db.Exec(`UPDATE invoices SET number = $1 WHERE id = $2 AND number IS NULL`, number, invoiceID); err != nil {
+		return "", fmt.Errorf("error numbering invoice: %w", err)
+	}
+
+	return number, nil
+}
+
+// ListUnnumberedPaid returns the paid invoices of tenant, or of every tenant
+// if it is empty, that have no number yet, in the order they were paid
+func (r *InvoiceRepository) ListUnnumberedPaid(tenant string) ([]*models.Invoice, error) {
+	query := `
+		SELECT ` + invoiceColumns + `
+		FROM invoices
+		WHERE ($1 = '' OR tenant = $1) AND status = $2 AND number IS NULL
+		ORDER BY COALESCE(paid_at, created_at), id
+	`
+
+	return r.list(query, tenant, models.InvoiceStatusPaid)
+}
+
+// HasUnnumberedPaidBefore reports whether tenant has paid invoices other than
+// invoiceID that were paid before paidAt and have no number yet
+func (r *InvoiceRepository) HasUnnumberedPaidBefore(tenant string, invoiceID int, paidAt time.Time) (bool, error) {
+	query := `
+		SELECT EXISTS(
+			SELECT 1 FROM invoices
+			WHERE tenant = $1 AND status = $2 AND number IS NULL AND id <> $3
+			  AND COALESCE(paid_at, created_at) < $4
+		)
+	`
+
+	var exists bool
+	if err := r.db.QueryRow(query, tenant, models.InvoiceStatusPaid, invoiceID, paidAt).Scan(&exists); err != nil {
+		return false, fmt.Errorf("error checking unnumbered invoices: %w", err)
+	}
+
+	return exists, nil
+}
@@ -0,0 +1,190 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/naventro/payment-service/internal/models"
+)
+
+const orderColumns = `
+	id, stripe_payment_intent_id, stripe_checkout_session_id, stripe_customer_id,
+	user_id, tenant, product, amount, tax, currency, status, entitlement,
+	paid_at, created_at, updated_at
+`
+
+type OrderRepository struct {
+	db DBTX
+}
+
+func NewOrderRepository(db *sql.DB) *OrderRepository {
+	return &OrderRepository{db: db}
+}
+
+// WithTx returns a copy of the repository that runs inside tx
+func (r *OrderRepository) WithTx(tx *sql.Tx) *OrderRepository {
+	return &OrderRepository{db: tx}
+}
+
+func scanOrder(row rowScanner) (*models.Order, error) {
+	order := &models.Order{}
+	err := row.Scan(
+		&order.ID,
+		&order.StripePaymentIntentID,
+		&order.StripeCheckoutSessionID,
+		&order.StripeCustomerID,
+		&order.UserID,
+		&order.Tenant,
+		&order.Product,
+		&order.Amount,
+		&order.Tax,
+		&order.Currency,
+		&order.Status,
+		&order.Entitlement,
+		&order.PaidAt,
+		&order.CreatedAt,
+		&order.UpdatedAt,
+	)
+	return order, err
+}
+
+func (r *OrderRepository) getOne(query string, args ...interface{}) (*models.Order, error) {
+	order, err := scanOrder(r.db.QueryRow(query, args...))
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("error fetching order: %w", err)
+	}
+
+	return order, nil
+}
+
+func (r *OrderRepository) list(query string, args ...interface{}) ([]*models.Order, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching orders: %w", err)
+	}
+	defer rows.Close()
+
+	var orders []*models.Order
+	for rows.Next() {
+		order, err := scanOrder(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning order: %w", err)
+		}
+		orders = append(orders, order)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating orders: %w", err)
+	}
+
+	return orders, nil
+}
+
+// Save inserts an order or merges it into the stored one in a single
+// statement, since the checkout and payment events of an order usually arrive
+// together. A stored order keeps its session, customer and entitlement, takes
+// the amount when one is given and the tax from the checkout session, and
+// only moves from pending to paid, so a late payment event does not undo a
+// refund or a lost dispute. order is filled with the stored row; becamePaid
+// reports whether this call made the order paid.
+func (r *OrderRepository) Save(order *models.Order) (becamePaid bool, err error) {
+	query := `
+		INSERT INTO orders (
+			stripe_payment_intent_id, stripe_checkout_session_id, stripe_customer_id,
+			user_id, tenant, product, amount, currency, status, entitlement, paid_at, tax
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $14)
+		ON CONFLICT (stripe_payment_intent_id) DO UPDATE
+		SET stripe_checkout_session_id = COALESCE(orders.stripe_checkout_session_id, EXCLUDED.stripe_checkout_session_id),
+		    stripe_customer_id = COALESCE(orders.stripe_customer_id, EXCLUDED.stripe_customer_id),
+		    amount = CASE WHEN EXCLUDED.amount > 0 THEN EXCLUDED.amount ELSE orders.amount END,
+		    currency = CASE WHEN EXCLUDED.amount > 0 THEN EXCLUDED.currency ELSE orders.currency END,
+		    tax = CASE WHEN EXCLUDED.stripe_checkout_session_id IS NOT NULL THEN EXCLUDED.tax ELSE orders.tax END,
+		    status = CASE WHEN orders.status = $13 AND EXCLUDED.status = $12 THEN EXCLUDED.status ELSE orders.status END,
+		    paid_at = COALESCE(orders.paid_at, EXCLUDED.paid_at),
+		    updated_at = CURRENT_TIMESTAMP
+		RETURNING ` + orderColumns + `, COALESCE(status = $12 AND paid_at = $11, FALSE)
+	`
+
+	row := r.db.QueryRow(
+		query,
+		order.StripePaymentIntentID,
+		order.StripeCheckoutSessionID,
+		order.StripeCustomerID,
+		order.UserID,
+		order.Tenant,
+		order.Product,
+		order.Amount,
+		order.Currency,
+		order.Status,
+		order.Entitlement,
+		order.PaidAt,
+		models.OrderStatusPaid,
+		models.OrderStatusPending,
+		order.Tax,
+	)
+
+	// paid_at is only set by the statement that makes the order paid, so the
+	// row carries this call's timestamp exactly when it made the transition
+	stored, err := scanOrder(extraColumns{row, []interface{}{&becamePaid}})
+	if err != nil {
+		return false, fmt.Errorf("error saving order: %w", err)
+	}
+
+	*order = *stored
+	return becamePaid, nil
+}
+
+func (r *OrderRepository) GetByPaymentIntentID(paymentIntentID string) (*models.Order, error) {
+	return r.getOne(`SELECT `+orderColumns+` FROM orders WHERE stripe_payment_intent_id = $1`, paymentIntentID)
+}
+
+func (r *OrderRepository) GetByCheckoutSessionID(checkoutSessionID string) (*models.Order, error) {
+	return r.getOne(`SELECT `+orderColumns+` FROM orders WHERE stripe_checkout_session_id = $1`, checkoutSessionID)
+}
+
+// GetByUserID returns a user's orders, newest first
+func (r *OrderRepository) GetByUserID(userID, tenant string) ([]*models.Order, error) {
+	query := `
+		SELECT ` + orderColumns + ` FROM orders
+		WHERE user_id = $1 AND tenant = $2
+		ORDER BY created_at DESC, id DESC
+	`
+	return r.list(query, userID, tenant)
+}
+
+// UpdateStatus moves a paid order to status, e.g. refunded. It reports
+// whether the order was paid and has been updated.
+func (r *OrderRepository) UpdateStatus(id int, status models.OrderStatus) (bool, error) {
+	query := `
+		UPDATE orders SET status = $1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2 AND status = $3
+	`
+
+	result, err := r.db.Exec(query, status, id, models.OrderStatusPaid)
+	if err != nil {
+		return false, fmt.Errorf("error updating order: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("error getting rows affected: %w", err)
+	}
+
+	return rowsAffected > 0, nil
+}
+
+// ListEntitlements returns a user's paid orders that grant an entitlement.
+// Refunded and disputed orders are left out.
+func (r *OrderRepository) ListEntitlements(userID, tenant string) ([]*models.Order, error) {
+	query := `
+		SELECT ` + orderColumns + ` FROM orders
+		WHERE user_id = $1 AND tenant = $2 AND status = $3 AND entitlement IS NOT NULL
+		ORDER BY paid_at ASC, id ASC
+	`
+	return r.list(query, userID, tenant, models.OrderStatusPaid)
+}
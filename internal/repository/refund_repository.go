@@ -0,0 +1,188 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/naventro/payment-service/internal/models"
+)
+
+const refundColumns = `
+	id, stripe_refund_id, stripe_charge_id, stripe_payment_intent_id,
+	invoice_id, order_id, user_id, tenant, amount, currency, reason, status,
+	failure_reason, idempotency_key, created_at, updated_at
+`
+
+type RefundRepository struct {
+	db DBTX
+}
+
+func NewRefundRepository(db *sql.DB) *RefundRepository {
+	return &RefundRepository{db: db}
+}
+
+// WithTx returns a copy of the repository that runs inside tx
+func (r *RefundRepository) WithTx(tx *sql.Tx) *RefundRepository {
+	return &RefundRepository{db: tx}
+}
+
+// WithInvoiceLock runs fn under a transaction-scoped advisory lock keyed on
+// the invoice, so refunds of an invoice are checked against its balance and
+// issued one at a time. fn writes through the repositories as usual; the lock
+// is released once it returns.
+func (r *RefundRepository) WithInvoiceLock(invoiceID int, fn func() error) error {
+	db, ok := r.db.(*sql.DB)
+	if !ok {
+		return fmt.Errorf("WithInvoiceLock cannot run inside an existing transaction")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock(hashtext($1))`, fmt.Sprintf("refund:invoice:%d", invoiceID)); err != nil {
+		return fmt.Errorf("error acquiring invoice lock: %w", err)
+	}
+
+	if err := fn(); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func scanRefund(row rowScanner) (*models.Refund, error) {
+	refund := &models.Refund{}
+	err := row.Scan(
+		&refund.ID,
+		&refund.StripeRefundID,
+		&refund.StripeChargeID,
+		&refund.StripePaymentIntentID,
+		&refund.InvoiceID,
+		&refund.OrderID,
+		&refund.UserID,
+		&refund.Tenant,
+		&refund.Amount,
+		&refund.Currency,
+		&refund.Reason,
+		&refund.Status,
+		&refund.FailureReason,
+		&refund.IdempotencyKey,
+		&refund.CreatedAt,
+		&refund.UpdatedAt,
+	)
+	return refund, err
+}
+
+func (r *RefundRepository) list(query string, args ...interface{}) ([]*models.Refund, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching refunds: %w", err)
+	}
+	defer rows.Close()
+
+	var refunds []*models.Refund
+	for rows.Next() {
+		refund, err := scanRefund(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning refund: %w", err)
+		}
+		refunds = append(refunds, refund)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating refunds: %w", err)
+	}
+
+	return refunds, nil
+}
+
+// Save inserts a refund or, if it is already stored, updates it. Refunds
+// issued through the API and their webhooks can arrive in either order.
+func (r *RefundRepository) Save(refund *models.Refund) error {
+	query := `
+		INSERT INTO refunds (
+			stripe_refund_id, stripe_charge_id, stripe_payment_intent_id,
+			invoice_id, order_id, user_id, tenant, amount, currency, reason, status,
+			failure_reason, idempotency_key
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		ON CONFLICT (stripe_refund_id) DO UPDATE
+		SET stripe_charge_id = EXCLUDED.stripe_charge_id,
+		    stripe_payment_intent_id = EXCLUDED.stripe_payment_intent_id,
+		    invoice_id = EXCLUDED.invoice_id, order_id = EXCLUDED.order_id,
+		    user_id = EXCLUDED.user_id,
+		    tenant = EXCLUDED.tenant, amount = EXCLUDED.amount,
+		    reason = EXCLUDED.reason, status = EXCLUDED.status,
+		    failure_reason = EXCLUDED.failure_reason,
+		    idempotency_key = COALESCE(EXCLUDED.idempotency_key, refunds.idempotency_key),
+		    updated_at = CURRENT_TIMESTAMP
+		RETURNING id, created_at, updated_at
+	`
+
+	err := r.db.QueryRow(
+		query,
+		refund.StripeRefundID,
+		refund.StripeChargeID,
+		refund.StripePaymentIntentID,
+		refund.InvoiceID,
+		refund.OrderID,
+		refund.UserID,
+		refund.Tenant,
+		refund.Amount,
+		refund.Currency,
+		refund.Reason,
+		refund.Status,
+		refund.FailureReason,
+		refund.IdempotencyKey,
+	).Scan(&refund.ID, &refund.CreatedAt, &refund.UpdatedAt)
+
+	if err != nil {
+		return fmt.Errorf("error saving refund: %w", err)
+	}
+
+	return nil
+}
+
+func (r *RefundRepository) GetByStripeRefundID(stripeRefundID string) (*models.Refund, error) {
+	query := `SELECT ` + refundColumns + ` FROM refunds WHERE stripe_refund_id = $1`
+	refund, err := scanRefund(r.db.QueryRow(query, stripeRefundID))
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("error fetching refund: %w", err)
+	}
+
+	return refund, nil
+}
+
+func (r *RefundRepository) GetByInvoiceID(invoiceID int) ([]*models.Refund, error) {
+	query := `
+		SELECT ` + refundColumns + ` FROM refunds
+		WHERE invoice_id = $1
+		ORDER BY created_at ASC, id ASC
+	`
+	return r.list(query, invoiceID)
+}
+
+func (r *RefundRepository) GetByOrderID(orderID int) ([]*models.Refund, error) {
+	query := `
+		SELECT ` + refundColumns + ` FROM refunds
+		WHERE order_id = $1
+		ORDER BY created_at ASC, id ASC
+	`
+	return r.list(query, orderID)
+}
+
+func (r *RefundRepository) GetByUserID(userID, tenant string) ([]*models.Refund, error) {
+	query := `
+		SELECT ` + refundColumns + ` FROM refunds
+		WHERE user_id = $1 AND tenant = $2
+		ORDER BY created_at ASC, id ASC
+	`
+	return r.list(query, userID, tenant)
+}
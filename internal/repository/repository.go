@@ -0,0 +1,16 @@
+package repository
+
+import "database/sql"
+
+// DBTX is implemented by both *sql.DB and *sql.Tx, letting repositories run
+// either directly against the pool or inside a transaction
+type DBTX interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
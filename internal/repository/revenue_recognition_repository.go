@@ -0,0 +1,191 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/naventro/payment-service/internal/models"
+)
+
+const revenueEntryColumns = `
+	id, invoice_id, refund_id, tenant, currency, booked_on, recognized_on,
+	amount, created_at
+`
+
+// RecognitionFilter selects the months of a recognition report: the calendar
+// months that overlap [From, To), for Tenant or every tenant if it is empty
+type RecognitionFilter struct {
+	Tenant string
+	From   time.Time
+	To     time.Time
+}
+
+type RevenueRecognitionRepository struct {
+	db DBTX
+}
+
+func NewRevenueRecognitionRepository(db *sql.DB) *RevenueRecognitionRepository {
+	return &RevenueRecognitionRepository{db: db}
+}
+
+// WithTx returns a copy of the repository that runs inside tx
+func (r *RevenueRecognitionRepository) WithTx(tx *sql.Tx) *RevenueRecognitionRepository {
+	return &RevenueRecognitionRepository{db: tx}
+}
+
+func scanRevenueEntry(row rowScanner) (*models.RevenueEntry, error) {
+	entry := &models.RevenueEntry{}
+	err := row.Scan(
+		&entry.ID,
+		&entry.InvoiceID,
+		&entry.RefundID,
+		&entry.Tenant,
+		&entry.Currency,
+		&entry.BookedOn,
+		&entry.RecognizedOn,
+		&entry.Amount,
+		&entry.CreatedAt,
+	)
+	return entry, err
+}
+
+// ReplaceInvoiceSchedule replaces the schedule of an invoice, keeping the
+// entries of its refunds
+func (r *RevenueRecognitionRepository) ReplaceInvoiceSchedule(invoiceID int, entries []*models.RevenueEntry) error {
+	query := `DELETE FROM revenue_recognition WHERE invoice_id = $1 AND refund_id IS NULL`
+
+	if _, err := r.db.Exec(query, invoiceID); err != nil {
+		return fmt.Errorf("error deleting revenue schedule: %w", err)
+	}
+
+	return r.insert(entries)
+}
+
+// ReplaceRefundEntries replaces the entries of a refund; no entries removes them
+func (r *RevenueRecognitionRepository) ReplaceRefundEntries(refundID int, entries []*models.RevenueEntry) error {
+	query := `DELETE FROM revenue_recognition WHERE refund_id = $1`
+
+	if _, err := r.db.Exec(query, refundID); err != nil {
+		return fmt.Errorf("error deleting refund revenue entries: %w", err)
+	}
+
+	return r.insert(entries)
+}
+
+func (r *RevenueRecognitionRepository) insert(entries []*models.RevenueEntry) error {
+	query := `
+		INSERT INTO revenue_recognition (
+			invoice_id, refund_id, tenant, currency, booked_on, recognized_on, amount
+		) VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, created_at
+	`
+
+	for _, entry := range entries {
+		err := r.db.QueryRow(
+			query,
+			entry.InvoiceID,
+			entry.RefundID,
+			entry.Tenant,
+			entry.Currency,
+			entry.BookedOn,
+			entry.RecognizedOn,
+			entry.Amount,
+		).Scan(&entry.ID, &entry.CreatedAt)
+
+		if err != nil {
+			return fmt.Errorf("error creating revenue entry: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// GetByInvoiceID returns the schedule and refund entries of an invoice,
+// sorted by recognition date
+func (r *RevenueRecognitionRepository) GetByInvoiceID(invoiceID int) ([]*models.RevenueEntry, error) {
+	query := `SELECT ` + revenueEntryColumns + ` FROM revenue_recognition WHERE invoice_id = $1 ORDER BY recognized_on, id`
+
+	rows, err := r.db.Query(query, invoiceID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching revenue entries: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []*models.RevenueEntry
+	for rows.Next() {
+		entry, err := scanRevenueEntry(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning revenue entry: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating revenue entries: %w", err)
+	}
+
+	return entries, nil
+}
+
+// Monthly calls fn with the billed, recognized and deferred revenue of each
+// tenant, currency and month of the filter, sorted by tenant, currency and
+// month. Months without revenue are included while a balance is deferred.
+func (r *RevenueRecognitionRepository) Monthly(filter RecognitionFilter, fn func(*models.RecognitionMonth) error) error {
+	query := `
+		WITH months AS (
+			SELECT generate_series(
+				date_trunc('month', $2::timestamp),
+				$3::timestamp - interval '1 microsecond',
+				interval '1 month'
+			)::date AS month
+		), keys AS (
+			SELECT DISTINCT tenant, currency
+			FROM revenue_recognition
+			WHERE ($1 = '' OR tenant = $1) AND booked_on < $3::timestamp
+		)
+		SELECT to_char(m.month, 'YYYY-MM'), k.tenant, k.currency,
+		       COALESCE(SUM(e.amount) FILTER (WHERE e.booked_on >= m.month), 0),
+		       COALESCE(SUM(e.amount) FILTER (WHERE e.recognized_on >= m.month AND e.recognized_on < m.month + interval '1 month'), 0),
+		       COALESCE(SUM(e.amount) FILTER (WHERE e.recognized_on >= m.month + interval '1 month'), 0)
+		FROM keys k
+		CROSS JOIN months m
+		LEFT JOIN revenue_recognition e
+		       ON e.tenant = k.tenant AND e.currency = k.currency
+		      AND e.booked_on < m.month + interval '1 month'
+		GROUP BY m.month, k.tenant, k.currency
+		HAVING COALESCE(SUM(e.amount) FILTER (WHERE e.booked_on >= m.month), 0) <> 0
+		    OR COALESCE(SUM(e.amount) FILTER (WHERE e.recognized_on >= m.month), 0) <> 0
+		ORDER BY k.tenant, k.currency, m.month
+	`
+
+	rows, err := r.db.Query(query, filter.Tenant, filter.From, filter.To)
+	if err != nil {
+		return fmt.Errorf("error fetching revenue recognition: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		month := &models.RecognitionMonth{}
+		err := rows.Scan(
+			&month.Month,
+			&month.Tenant,
+			&month.Currency,
+			&month.Billed,
+			&month.Recognized,
+			&month.Deferred,
+		)
+		if err != nil {
+			return fmt.Errorf("error scanning revenue recognition: %w", err)
+		}
+		if err := fn(month); err != nil {
+			return err
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("error iterating revenue recognition: %w", err)
+	}
+
+	return nil
+}
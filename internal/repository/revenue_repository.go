@@ -0,0 +1,122 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/naventro/payment-service/internal/models"
+)
+
+// RevenueFilter selects the payments counted in a revenue report; zero values are ignored
+type RevenueFilter struct {
+	Tenant string
+	From   *time.Time
+	To     *time.Time
+}
+
+// RevenueRepository aggregates paid invoices, orders and refunds
+type RevenueRepository struct {
+	db DBTX
+}
+
+func NewRevenueRepository(db *sql.DB) *RevenueRepository {
+	return &RevenueRepository{db: db}
+}
+
+// WithTx returns a copy of the repository that runs inside tx
+func (r *RevenueRepository) WithTx(tx *sql.Tx) *RevenueRepository {
+	return &RevenueRepository{db: tx}
+}
+
+// ByCurrency returns the revenue in each currency, sorted by currency.
+// Invoices count by creation, orders by payment and refunds by creation time.
+func (r *RevenueRepository) ByCurrency(filter RevenueFilter) ([]*models.CurrencyRevenue, error) {
+	query := `
+		WITH subs AS (
+			SELECT currency, COUNT(*) AS active
+			FROM subscriptions
+			WHERE status IN ($4, $5) AND ($1 = '' OR tenant = $1)
+			GROUP BY currency
+		), inv AS (
+			SELECT currency, COUNT(*) AS count, SUM(amount_paid) AS amount, SUM(tax) AS tax
+			FROM invoices
+			WHERE status = $6 AND ($1 = '' OR tenant = $1)
+			  AND ($2::timestamptz IS NULL OR created_at >= $2)
+			  AND ($3::timestamptz IS NULL OR created_at < $3)
+			GROUP BY currency
+		), ord AS (
+			SELECT currency, COUNT(*) AS count, SUM(amount) AS amount
+			FROM orders
+			WHERE status = $7 AND ($1 = '' OR tenant = $1)
+			  AND ($2::timestamptz IS NULL OR paid_at >= $2)
+			  AND ($3::timestamptz IS NULL OR paid_at < $3)
+			GROUP BY currency
+		), ref AS (
+			SELECT currency, SUM(amount) AS amount
+			FROM refunds
+			WHERE status = $8 AND ($1 = '' OR tenant = $1)
+			  AND ($2::timestamptz IS NULL OR created_at >= $2)
+			  AND ($3::timestamptz IS NULL OR created_at < $3)
+			GROUP BY currency
+		), currencies AS (
+			SELECT currency FROM subs
+			UNION SELECT currency FROM inv
+			UNION SELECT currency FROM ord
+			UNION SELECT currency FROM ref
+		)
+		SELECT c.currency,
+		       COALESCE(subs.active, 0),
+		       COALESCE(inv.count, 0), COALESCE(inv.amount, 0), COALESCE(inv.tax, 0),
+		       COALESCE(ord.count, 0), COALESCE(ord.amount, 0),
+		       COALESCE(ref.amount, 0)
+		FROM currencies c
+		LEFT JOIN subs ON subs.currency = c.currency
+		LEFT JOIN inv ON inv.currency = c.currency
+		LEFT JOIN ord ON ord.currency = c.currency
+		LEFT JOIN ref ON ref.currency = c.currency
+		ORDER BY c.currency
+	`
+
+	rows, err := r.db.Query(
+		query,
+		filter.Tenant,
+		filter.From,
+		filter.To,
+		models.StatusActive,
+		models.StatusTrialing,
+		models.InvoiceStatusPaid,
+		models.OrderStatusPaid,
+		models.RefundStatusSucceeded,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching revenue: %w", err)
+	}
+	defer rows.Close()
+
+	var revenue []*models.CurrencyRevenue
+	for rows.Next() {
+		rev := &models.CurrencyRevenue{}
+		err := rows.Scan(
+			&rev.Currency,
+			&rev.ActiveSubscriptions,
+			&rev.Invoices,
+			&rev.InvoiceAmount,
+			&rev.Tax,
+			&rev.Orders,
+			&rev.OrderAmount,
+			&rev.Refunded,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning revenue: %w", err)
+		}
+		rev.Net = rev.InvoiceAmount + rev.OrderAmount - rev.Refunded
+		revenue = append(revenue, rev)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating revenue: %w", err)
+	}
+
+	return revenue, nil
+}
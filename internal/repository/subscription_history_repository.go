@@ -0,0 +1,109 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/naventro/payment-service/internal/models"
+)
+
+const subscriptionHistoryColumns = `
+	id, subscription_id, stripe_event_id, user_id, tenant, plan, status,
+	quantity, currency, mrr, recorded_at, created_at
+`
+
+type SubscriptionHistoryRepository struct {
+	db DBTX
+}
+
+func NewSubscriptionHistoryRepository(db *sql.DB) *SubscriptionHistoryRepository {
+	return &SubscriptionHistoryRepository{db: db}
+}
+
+// WithTx returns a copy of the repository that runs inside tx
+func (r *SubscriptionHistoryRepository) WithTx(tx *sql.Tx) *SubscriptionHistoryRepository {
+	return &SubscriptionHistoryRepository{db: tx}
+}
+
+func scanSubscriptionHistory(row rowScanner) (*models.SubscriptionHistory, error) {
+	entry := &models.SubscriptionHistory{}
+	err := row.Scan(
+		&entry.ID,
+		&entry.SubscriptionID,
+		&entry.StripeEventID,
+		&entry.UserID,
+		&entry.Tenant,
+		&entry.Plan,
+		&entry.Status,
+		&entry.Quantity,
+		&entry.Currency,
+		&entry.MRR,
+		&entry.RecordedAt,
+		&entry.CreatedAt,
+	)
+	return entry, err
+}
+
+// Record stores a snapshot of a subscription. Snapshots of an event that was
+// already recorded, e.g. on redelivery, are ignored.
+func (r *SubscriptionHistoryRepository) Record(entry *models.SubscriptionHistory) error {
+	query := `
+		INSERT INTO subscription_history (
+			subscription_id, stripe_event_id, user_id, tenant, plan, status,
+			quantity, currency, mrr, recorded_at
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		ON CONFLICT (stripe_event_id) DO NOTHING
+	`
+
+	_, err := r.db.Exec(
+		query,
+		entry.SubscriptionID,
+		entry.StripeEventID,
+		entry.UserID,
+		entry.Tenant,
+		entry.Plan,
+		entry.Status,
+		entry.Quantity,
+		entry.Currency,
+		entry.MRR,
+		entry.RecordedAt,
+	)
+
+	if err != nil {
+		return fmt.Errorf("error recording subscription history: %w", err)
+	}
+
+	return nil
+}
+
+// List returns the snapshots recorded before the given time, grouped by
+// subscription and oldest first. An empty tenant returns every tenant.
+func (r *SubscriptionHistoryRepository) List(tenant string, before time.Time) ([]*models.SubscriptionHistory, error) {
+	query := `
+		SELECT ` + subscriptionHistoryColumns + ` FROM subscription_history
+		WHERE ($1 = '' OR tenant = $1) AND recorded_at < $2
+		ORDER BY subscription_id, recorded_at, id
+	`
+
+	rows, err := r.db.Query(query, tenant, before)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching subscription history: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []*models.SubscriptionHistory
+	for rows.Next() {
+		entry, err := scanSubscriptionHistory(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning subscription history: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating subscription history: %w", err)
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,118 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/lib/pq"
+	"github.com/naventro/payment-service/internal/models"
+)
+
+const subscriptionItemColumns = `
+	id, subscription_id, stripe_subscription_item_id, stripe_price_id, kind,
+	addon, quantity, created_at, updated_at
+`
+
+type SubscriptionItemRepository struct {
+	db DBTX
+}
+
+func NewSubscriptionItemRepository(db *sql.DB) *SubscriptionItemRepository {
+	return &SubscriptionItemRepository{db: db}
+}
+
+// WithTx returns a copy of the repository that runs inside tx
+func (r *SubscriptionItemRepository) WithTx(tx *sql.Tx) *SubscriptionItemRepository {
+	return &SubscriptionItemRepository{db: tx}
+}
+
+func scanSubscriptionItem(row rowScanner) (*models.SubscriptionItem, error) {
+	item := &models.SubscriptionItem{}
+	err := row.Scan(
+		&item.ID,
+		&item.SubscriptionID,
+		&item.StripeSubscriptionItemID,
+		&item.StripePriceID,
+		&item.Kind,
+		&item.Addon,
+		&item.Quantity,
+		&item.CreatedAt,
+		&item.UpdatedAt,
+	)
+	return item, err
+}
+
+// Sync makes the stored items of a subscription match items: new items are
+// inserted, existing ones updated and the ones no longer present deleted
+func (r *SubscriptionItemRepository) Sync(subscriptionID int, items []*models.SubscriptionItem) error {
+	query := `
+		INSERT INTO subscription_items (
+			subscription_id, stripe_subscription_item_id, stripe_price_id, kind,
+			addon, quantity
+		) VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (stripe_subscription_item_id) DO UPDATE
+		SET stripe_price_id = EXCLUDED.stripe_price_id, kind = EXCLUDED.kind,
+		    addon = EXCLUDED.addon, quantity = EXCLUDED.quantity,
+		    updated_at = CURRENT_TIMESTAMP
+		RETURNING id, created_at, updated_at
+	`
+
+	ids := make([]string, 0, len(items))
+	for _, item := range items {
+		item.SubscriptionID = subscriptionID
+		err := r.db.QueryRow(
+			query,
+			item.SubscriptionID,
+			item.StripeSubscriptionItemID,
+			item.StripePriceID,
+			item.Kind,
+			item.Addon,
+			item.Quantity,
+		).Scan(&item.ID, &item.CreatedAt, &item.UpdatedAt)
+
+		if err != nil {
+			return fmt.Errorf("error saving subscription item: %w", err)
+		}
+		ids = append(ids, item.StripeSubscriptionItemID)
+	}
+
+	_, err := r.db.Exec(
+		`DELETE FROM subscription_items WHERE subscription_id = $1 AND NOT (stripe_subscription_item_id = ANY($2))`,
+		subscriptionID,
+		pq.Array(ids),
+	)
+	if err != nil {
+		return fmt.Errorf("error deleting subscription items: %w", err)
+	}
+
+	return nil
+}
+
+func (r *SubscriptionItemRepository) GetBySubscriptionID(subscriptionID int) ([]*models.SubscriptionItem, error) {
+	query := `
+		SELECT ` + subscriptionItemColumns + ` FROM subscription_items
+		WHERE subscription_id = $1
+		ORDER BY id ASC
+	`
+
+	rows, err := r.db.Query(query, subscriptionID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching subscription items: %w", err)
+	}
+	defer rows.Close()
+
+	var items []*models.SubscriptionItem
+	for rows.Next() {
+		item, err := scanSubscriptionItem(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning subscription item: %w", err)
+		}
+		items = append(items, item)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating subscription items: %w", err)
+	}
+
+	return items, nil
+}
@@ -7,20 +7,94 @@ import (
 	"github.com/naventro/payment-service/internal/models"
 )
 
+const subscriptionColumns = `
+	id, user_id, tenant, stripe_customer_id, stripe_subscription_id,
+	status, plan, current_period_start, current_period_end,
+	cancel_at_period_end, quantity, currency, payment_action_required, payment_action_invoice_id,
+	payment_action_url, created_at, updated_at
+`
+
 type SubscriptionRepository struct {
-	db *sql.DB
+	db DBTX
 }
 
 func NewSubscriptionRepository(db *sql.DB) *SubscriptionRepository {
 	return &SubscriptionRepository{db: db}
 }
 
+// WithTx returns a copy of the repository that runs inside tx
+func (r *SubscriptionRepository) WithTx(tx *sql.Tx) *SubscriptionRepository {
+	return &SubscriptionRepository{db: tx}
+}
+
+func scanSubscription(row rowScanner) (*models.Subscription, error) {
+	sub := &models.Subscription{}
+	err := row.Scan(
+		&sub.ID,
+		&sub.UserID,
+		&sub.Tenant,
+		&sub.StripeCustomerID,
+		&sub.StripeSubscriptionID,
+		&sub.Status,
+		&sub.Plan,
+		&sub.CurrentPeriodStart,
+		&sub.CurrentPeriodEnd,
+		&sub.CancelAtPeriodEnd,
+		&sub.Quantity,
+		&sub.Currency,
+		&sub.PaymentActionRequired,
+		&sub.PaymentActionInvoiceID,
+		&sub.PaymentActionURL,
+		&sub.CreatedAt,
+		&sub.UpdatedAt,
+	)
+	return sub, err
+}
+
+func (r *SubscriptionRepository) getOne(query string, args ...interface{}) (*models.Subscription, error) {
+	sub, err := scanSubscription(r.db.QueryRow(query, args...))
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("error fetching subscription: %w", err)
+	}
+
+	return sub, nil
+}
+
+func (r *SubscriptionRepository) list(query string, args ...interface{}) ([]*models.Subscription, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching subscriptions: %w", err)
+	}
+	defer rows.Close()
+
+	var subs []*models.Subscription
+	for rows.Next() {
+		sub, err := scanSubscription(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning subscription: %w", err)
+		}
+		subs = append(subs, sub)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating subscriptions: %w", err)
+	}
+
+	return subs, nil
+}
+
 func (r *SubscriptionRepository) Create(sub *models.Subscription) error {
 	query := `
 		INSERT INTO subscriptions (
 			user_id, tenant, stripe_customer_id, stripe_subscription_id,
-			status, plan, current_period_start, current_period_end, cancel_at_period_end
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+			status, plan, current_period_start, current_period_end, cancel_at_period_end,
+			quantity, currency
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
 		RETURNING id, created_at, updated_at
 	`
 
@@ -35,6 +109,8 @@ func (r *SubscriptionRepository) Create(sub *models.Subscription) error {
 		sub.CurrentPeriodStart,
 		sub.CurrentPeriodEnd,
 		sub.CancelAtPeriodEnd,
+		sub.Quantity,
+		sub.Currency,
 	).Scan(&sub.ID, &sub.CreatedAt, &sub.UpdatedAt)
 
 	if err != nil {
@@ -44,86 +120,65 @@ func (r *SubscriptionRepository) Create(sub *models.Subscription) error {
 	return nil
 }
 
-func (r *SubscriptionRepository) GetByUserID(userID, tenant string) (*models.Subscription, error) {
-	query := `
-		SELECT
-			id, user_id, tenant, stripe_customer_id, stripe_subscription_id,
-			status, plan, current_period_start, current_period_end,
-			cancel_at_period_end, created_at, updated_at
-		FROM subscriptions
-		WHERE user_id = $1 AND tenant = $2
-	`
-
-	sub := &models.Subscription{}
-	err := r.db.QueryRow(query, userID, tenant).Scan(
-		&sub.ID,
-		&sub.UserID,
-		&sub.Tenant,
-		&sub.StripeCustomerID,
-		&sub.StripeSubscriptionID,
-		&sub.Status,
-		&sub.Plan,
-		&sub.CurrentPeriodStart,
-		&sub.CurrentPeriodEnd,
-		&sub.CancelAtPeriodEnd,
-		&sub.CreatedAt,
-		&sub.UpdatedAt,
-	)
-
-	if err == sql.ErrNoRows {
-		return nil, nil
-	}
-
-	if err != nil {
-		return nil, fmt.Errorf("error fetching subscription: %w", err)
-	}
+func (r *SubscriptionRepository) GetByID(id int) (*models.Subscription, error) {
+	return r.getOne(`SELECT `+subscriptionColumns+` FROM subscriptions WHERE id = $1`, id)
+}
 
-	return sub, nil
+func (r *SubscriptionRepository) GetByUserID(userID, tenant string) (*models.Subscription, error) {
+	return r.getOne(`SELECT `+subscriptionColumns+` FROM subscriptions WHERE user_id = $1 AND tenant = $2`, userID, tenant)
 }
 
 func (r *SubscriptionRepository) GetByStripeSubscriptionID(stripeSubID string) (*models.Subscription, error) {
+	return r.getOne(`SELECT `+subscriptionColumns+` FROM subscriptions WHERE stripe_subscription_id = $1`, stripeSubID)
+}
+
+// GetByStripeCustomerID returns the most recent subscription of a Stripe customer
+func (r *SubscriptionRepository) GetByStripeCustomerID(stripeCustomerID string) (*models.Subscription, error) {
 	query := `
-		SELECT
-			id, user_id, tenant, stripe_customer_id, stripe_subscription_id,
-			status, plan, current_period_start, current_period_end,
-			cancel_at_period_end, created_at, updated_at
-		FROM subscriptions
-		WHERE stripe_subscription_id = $1
+		SELECT ` + subscriptionColumns + ` FROM subscriptions
+		WHERE stripe_customer_id = $1
+		ORDER BY created_at DESC
+		LIMIT 1
 	`
+	return r.getOne(query, stripeCustomerID)
+}
 
-	sub := &models.Subscription{}
-	err := r.db.QueryRow(query, stripeSubID).Scan(
-		&sub.ID,
-		&sub.UserID,
-		&sub.Tenant,
-		&sub.StripeCustomerID,
-		&sub.StripeSubscriptionID,
-		&sub.Status,
-		&sub.Plan,
-		&sub.CurrentPeriodStart,
-		&sub.CurrentPeriodEnd,
-		&sub.CancelAtPeriodEnd,
-		&sub.CreatedAt,
-		&sub.UpdatedAt,
-	)
+func (r *SubscriptionRepository) ListByTenant(tenant string) ([]*models.Subscription, error) {
+	return r.list(`SELECT `+subscriptionColumns+` FROM subscriptions WHERE tenant = $1 ORDER BY id`, tenant)
+}
 
-	if err == sql.ErrNoRows {
-		return nil, nil
+// ListTenants returns every tenant that has at least one subscription
+func (r *SubscriptionRepository) ListTenants() ([]string, error) {
+	rows, err := r.db.Query(`SELECT DISTINCT tenant FROM subscriptions ORDER BY tenant`)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching tenants: %w", err)
+	}
+	defer rows.Close()
+
+	var tenants []string
+	for rows.Next() {
+		var tenant string
+		if err := rows.Scan(&tenant); err != nil {
+			return nil, fmt.Errorf("error scanning tenant: %w", err)
+		}
+		tenants = append(tenants, tenant)
 	}
 
-	if err != nil {
-		return nil, fmt.Errorf("error fetching subscription: %w", err)
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating tenants: %w", err)
 	}
 
-	return sub, nil
+	return tenants, nil
 }
 
 func (r *SubscriptionRepository) Update(sub *models.Subscription) error {
 	query := `
 		UPDATE subscriptions
 		SET status = $1, plan = $2, current_period_start = $3,
-		    current_period_end = $4, cancel_at_period_end = $5, updated_at = CURRENT_TIMESTAMP
-		WHERE id = $6
+		    current_period_end = $4, cancel_at_period_end = $5, quantity = $6,
+		    currency = $7, payment_action_required = $8, payment_action_invoice_id = $9,
+		    payment_action_url = $10, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $11
 	`
 
 	result, err := r.db.Exec(
@@ -133,6 +188,11 @@ func (r *SubscriptionRepository) Update(sub *models.Subscription) error {
 		sub.CurrentPeriodStart,
 		sub.CurrentPeriodEnd,
 		sub.CancelAtPeriodEnd,
+		sub.Quantity,
+		sub.Currency,
+		sub.PaymentActionRequired,
+		sub.PaymentActionInvoiceID,
+		sub.PaymentActionURL,
 		sub.ID,
 	)
 
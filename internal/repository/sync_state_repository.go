@@ -0,0 +1,49 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// SyncStateRepository stores named cursors for background sync jobs
+type SyncStateRepository struct {
+	db DBTX
+}
+
+func NewSyncStateRepository(db *sql.DB) *SyncStateRepository {
+	return &SyncStateRepository{db: db}
+}
+
+// WithTx returns a copy of the repository that runs inside tx
+func (r *SyncStateRepository) WithTx(tx *sql.Tx) *SyncStateRepository {
+	return &SyncStateRepository{db: tx}
+}
+
+// Get returns the value stored under name, or an empty string if none
+func (r *SyncStateRepository) Get(name string) (string, error) {
+	var value string
+	err := r.db.QueryRow(`SELECT value FROM sync_state WHERE name = $1`, name).Scan(&value)
+
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("error fetching sync state: %w", err)
+	}
+
+	return value, nil
+}
+
+func (r *SyncStateRepository) Set(name, value string) error {
+	query := `
+		INSERT INTO sync_state (name, value) VALUES ($1, $2)
+		ON CONFLICT (name) DO UPDATE SET value = EXCLUDED.value, updated_at = CURRENT_TIMESTAMP
+	`
+
+	if _, err := r.db.Exec(query, name, value); err != nil {
+		return fmt.Errorf("error updating sync state: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,222 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/naventro/payment-service/internal/models"
+)
+
+const usageEventColumns = `
+	id, idempotency_key, user_id, tenant, meter, quantity, stripe_customer_id,
+	occurred_at, status, attempts, next_attempt_at, last_error, reported_at,
+	created_at, updated_at
+`
+
+type UsageRepository struct {
+	db DBTX
+}
+
+func NewUsageRepository(db *sql.DB) *UsageRepository {
+	return &UsageRepository{db: db}
+}
+
+// WithTx returns a copy of the repository that runs inside tx
+func (r *UsageRepository) WithTx(tx *sql.Tx) *UsageRepository {
+	return &UsageRepository{db: tx}
+}
+
+func scanUsageEvent(row rowScanner) (*models.UsageEvent, error) {
+	event := &models.UsageEvent{}
+	err := row.Scan(
+		&event.ID,
+		&event.IdempotencyKey,
+		&event.UserID,
+		&event.Tenant,
+		&event.Meter,
+		&event.Quantity,
+		&event.StripeCustomerID,
+		&event.OccurredAt,
+		&event.Status,
+		&event.Attempts,
+		&event.NextAttemptAt,
+		&event.LastError,
+		&event.ReportedAt,
+		&event.CreatedAt,
+		&event.UpdatedAt,
+	)
+	return event, err
+}
+
+// Record stores a usage event unless one with the same idempotency key was
+// already recorded for the tenant. It returns the stored event and whether it
+// was created by this call.
+func (r *UsageRepository) Record(event *models.UsageEvent) (*models.UsageEvent, bool, error) {
+	query := `
+		INSERT INTO usage_events (
+			idempotency_key, user_id, tenant, meter, quantity, stripe_customer_id,
+			occurred_at, status
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (tenant, idempotency_key) DO NOTHING
+		RETURNING ` + usageEventColumns
+
+	created, err := scanUsageEvent(r.db.QueryRow(
+		query,
+		event.IdempotencyKey,
+		event.UserID,
+		event.Tenant,
+		event.Meter,
+		event.Quantity,
+		event.StripeCustomerID,
+		event.OccurredAt,
+		models.UsageEventStatusPending,
+	))
+
+	if err == nil {
+		return created, true, nil
+	}
+
+	if err != sql.ErrNoRows {
+		return nil, false, fmt.Errorf("error recording usage event: %w", err)
+	}
+
+	existing, err := r.GetByIdempotencyKey(event.Tenant, event.IdempotencyKey)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if existing == nil {
+		return nil, false, fmt.Errorf("usage event %s conflicted but was not found", event.IdempotencyKey)
+	}
+
+	return existing, false, nil
+}
+
+func (r *UsageRepository) GetByIdempotencyKey(tenant, idempotencyKey string) (*models.UsageEvent, error) {
+	query := `SELECT ` + usageEventColumns + ` FROM usage_events WHERE tenant = $1 AND idempotency_key = $2`
+	event, err := scanUsageEvent(r.db.QueryRow(query, tenant, idempotencyKey))
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("error fetching usage event: %w", err)
+	}
+
+	return event, nil
+}
+
+// ClaimDue claims up to limit pending events whose next report attempt is
+// due, oldest first, by moving their next attempt to now plus lease. Other
+// runs skip the claimed rows until the lease expires, so an event is reported
+// by one replica at a time; one whose outcome is never stored is retried
+// after the lease.
+func (r *UsageRepository) ClaimDue(now time.Time, lease time.Duration, limit int) ([]*models.UsageEvent, error) {
+	query := `
+		UPDATE usage_events
+		SET next_attempt_at = $3, updated_at = CURRENT_TIMESTAMP
+		WHERE id IN (
+			SELECT id FROM usage_events
+			WHERE status = $1 AND (next_attempt_at IS NULL OR next_attempt_at <= $2)
+			ORDER BY id ASC
+			LIMIT $4
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING ` + usageEventColumns
+
+	rows, err := r.db.Query(query, models.UsageEventStatusPending, now, now.Add(lease), limit)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching usage events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []*models.UsageEvent
+	for rows.Next() {
+		event, err := scanUsageEvent(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning usage event: %w", err)
+		}
+		events = append(events, event)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating usage events: %w", err)
+	}
+
+	// RETURNING does not keep the order of the subquery
+	sort.Slice(events, func(i, j int) bool { return events[i].ID < events[j].ID })
+
+	return events, nil
+}
+
+// Totals sums a user's usage per meter for events that occurred in [from, to).
+// Failed events are left out since they are not billed.
+func (r *UsageRepository) Totals(userID, tenant string, from, to time.Time) ([]models.UsageTotal, error) {
+	query := `
+		SELECT meter, COALESCE(SUM(quantity), 0),
+		       COALESCE(SUM(quantity) FILTER (WHERE status = $5), 0)
+		FROM usage_events
+		WHERE user_id = $1 AND tenant = $2 AND occurred_at >= $3 AND occurred_at < $4
+		  AND status <> $6
+		GROUP BY meter
+		ORDER BY meter
+	`
+
+	rows, err := r.db.Query(query, userID, tenant, from, to, models.UsageEventStatusReported, models.UsageEventStatusFailed)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching usage totals: %w", err)
+	}
+	defer rows.Close()
+
+	var totals []models.UsageTotal
+	for rows.Next() {
+		var total models.UsageTotal
+		if err := rows.Scan(&total.Meter, &total.Quantity, &total.Reported); err != nil {
+			return nil, fmt.Errorf("error scanning usage total: %w", err)
+		}
+		totals = append(totals, total)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating usage totals: %w", err)
+	}
+
+	return totals, nil
+}
+
+// UpdateReport stores the outcome of a report attempt. Events that are no
+// longer pending are left alone, so a late attempt cannot overwrite the
+// outcome another run stored; stored is false then.
+func (r *UsageRepository) UpdateReport(event *models.UsageEvent) (stored bool, err error) {
+	query := `
+		UPDATE usage_events
+		SET status = $1, attempts = $2, next_attempt_at = $3, last_error = $4,
+		    reported_at = $5, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $6 AND status = $7
+	`
+
+	result, err := r.db.Exec(
+		query,
+		event.Status,
+		event.Attempts,
+		event.NextAttemptAt,
+		event.LastError,
+		event.ReportedAt,
+		event.ID,
+		models.UsageEventStatusPending,
+	)
+
+	if err != nil {
+		return false, fmt.Errorf("error updating usage event: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("error getting rows affected: %w", err)
+	}
+
+	return rowsAffected > 0, nil
+}
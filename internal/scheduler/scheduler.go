@@ -0,0 +1,28 @@
+package scheduler
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Every runs job on a fixed interval until ctx is canceled. Errors are logged
+// and do not stop subsequent runs.
+func Every(ctx context.Context, name string, interval time.Duration, job func() error) {
+	log.Printf("Scheduling %s every %s", name, interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Printf("Stopping scheduled %s", name)
+			return
+		case <-ticker.C:
+			if err := job(); err != nil {
+				log.Printf("Error running scheduled %s: %v", name, err)
+			}
+		}
+	}
+}
@@ -2,16 +2,71 @@ package stripe
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/naventro/payment-service/internal/models"
 	"github.com/stripe/stripe-go/v84"
+	"github.com/stripe/stripe-go/v84/billing/meterevent"
+	"github.com/stripe/stripe-go/v84/charge"
 	"github.com/stripe/stripe-go/v84/checkout/session"
 	"github.com/stripe/stripe-go/v84/customer"
+	"github.com/stripe/stripe-go/v84/event"
+	"github.com/stripe/stripe-go/v84/invoice"
+	"github.com/stripe/stripe-go/v84/invoicepayment"
+	"github.com/stripe/stripe-go/v84/paymentmethod"
+	"github.com/stripe/stripe-go/v84/refund"
+	"github.com/stripe/stripe-go/v84/setupintent"
 	"github.com/stripe/stripe-go/v84/subscription"
+	"github.com/stripe/stripe-go/v84/subscriptionitem"
+	"github.com/stripe/stripe-go/v84/taxrate"
 )
 
+// TaxSettings control tax calculation and the billing details collected at checkout
+type TaxSettings struct {
+	AutomaticTax bool
+	// BillingAddressCollection is "auto", "required" or empty for Stripe's default
+	BillingAddressCollection string
+	TaxIDCollection          bool
+}
+
+// apply sets the tax options of a checkout session for an existing customer.
+// The collected address and name are saved on the customer, which Stripe
+// requires for automatic tax and tax ID collection.
+func (t TaxSettings) apply(params *stripe.CheckoutSessionParams) {
+	addressCollection := t.BillingAddressCollection
+	if t.AutomaticTax && addressCollection == "" {
+		addressCollection = string(stripe.CheckoutSessionBillingAddressCollectionAuto)
+	}
+
+	if addressCollection != "" {
+		params.BillingAddressCollection = stripe.String(addressCollection)
+	}
+
+	if t.AutomaticTax {
+		params.AutomaticTax = &stripe.CheckoutSessionAutomaticTaxParams{Enabled: stripe.Bool(true)}
+	}
+
+	if t.TaxIDCollection {
+		params.TaxIDCollection = &stripe.CheckoutSessionTaxIDCollectionParams{Enabled: stripe.Bool(true)}
+	}
+
+	if addressCollection != "" || t.TaxIDCollection {
+		params.CustomerUpdate = &stripe.CheckoutSessionCustomerUpdateParams{
+			Address: stripe.String("auto"),
+		}
+		if t.TaxIDCollection {
+			params.CustomerUpdate.Name = stripe.String("auto")
+		}
+	}
+}
+
 type Client struct {
 	secretKey string
+	// taxRates caches tax rates by ID for DescribeTaxes
+	taxRates sync.Map
 }
 
 func NewClient(secretKey string) *Client {
@@ -19,61 +74,123 @@ func NewClient(secretKey string) *Client {
 	return &Client{secretKey: secretKey}
 }
 
-// GetPriceID returns the Stripe Price ID for a given plan
-func (c *Client) GetPriceID(plan models.Plan) (string, error) {
-	// These should be set in environment variables or configuration
-	// For now, we'll return placeholder IDs that need to be replaced
-	switch plan {
-	case models.PlanPremiumMonthly:
-		// Replace with actual Stripe Price ID for monthly plan
-		return "price_1SqQiMEOzQkrhqSSgh3KVRps", nil
-	case models.PlanPremiumYearly:
-		// Replace with actual Stripe Price ID for yearly plan
-		return "price_1SqQjcEOzQkrhqSSLqO5rDdb", nil
-	default:
-		return "", fmt.Errorf("invalid plan: %s", plan)
-	}
+// CheckoutParams describes a Checkout Session for an existing customer.
+// Plan, PriceID, Quantity and MeteredPriceIDs apply to subscriptions;
+// Product and PriceID to one-time payments.
+type CheckoutParams struct {
+	CustomerID string
+	UserID     string
+	Tenant     string
+
+	Plan     models.Plan
+	Product  string
+	PriceID  string
+	Quantity int64
+	// MeteredPriceIDs are added as usage-based items billed on top of the
+	// plan and must share its currency
+	MeteredPriceIDs []string
+
+	// Locale sets the language of the Checkout page, empty for Stripe's
+	// default; see IsCheckoutLocale
+	Locale     string
+	SuccessURL string
+	CancelURL  string
+	Tax        TaxSettings
 }
 
-// CreateCheckoutSession creates a Stripe Checkout Session
-func (c *Client) CreateCheckoutSession(userID, tenant string, plan models.Plan, successURL, cancelURL string) (*stripe.CheckoutSession, error) {
-	priceID, err := c.GetPriceID(plan)
-	if err != nil {
-		return nil, err
-	}
+// checkoutLocales are the languages of the Checkout page supported by Stripe
+var checkoutLocales = map[string]bool{
+	"auto": true, "bg": true, "cs": true, "da": true, "de": true, "el": true,
+	"en": true, "en-GB": true, "es": true, "es-419": true, "et": true, "fi": true,
+	"fil": true, "fr": true, "fr-CA": true, "hr": true, "hu": true, "id": true,
+	"it": true, "ja": true, "ko": true, "lt": true, "lv": true, "ms": true,
+	"mt": true, "nb": true, "nl": true, "pl": true, "pt": true, "pt-BR": true,
+	"ro": true, "ru": true, "sk": true, "sl": true, "sv": true, "th": true,
+	"tr": true, "vi": true, "zh": true, "zh-HK": true, "zh-TW": true,
+}
 
-	// Create or retrieve customer
-	customerID, err := c.getOrCreateCustomer(userID, tenant)
-	if err != nil {
-		return nil, fmt.Errorf("error getting/creating customer: %w", err)
+// IsCheckoutLocale reports whether Stripe Checkout supports a locale
+func IsCheckoutLocale(locale string) bool {
+	return checkoutLocales[locale]
+}
+
+// CreateCheckoutSession creates a Stripe Checkout Session for p.Quantity
+// seats of p.Plan at p.PriceID, plus its metered prices
+func (c *Client) CreateCheckoutSession(p CheckoutParams) (*stripe.CheckoutSession, error) {
+	metadata := map[string]string{
+		"user_id": p.UserID,
+		"tenant":  p.Tenant,
+		"plan":    string(p.Plan),
 	}
 
 	params := &stripe.CheckoutSessionParams{
-		Customer: stripe.String(customerID),
+		Customer: stripe.String(p.CustomerID),
 		Mode:     stripe.String(string(stripe.CheckoutSessionModeSubscription)),
 		LineItems: []*stripe.CheckoutSessionLineItemParams{
 			{
-				Price:    stripe.String(priceID),
-				Quantity: stripe.Int64(1),
+				Price:    stripe.String(p.PriceID),
+				Quantity: stripe.Int64(p.Quantity),
 			},
 		},
-		SuccessURL: stripe.String(successURL),
-		CancelURL:  stripe.String(cancelURL),
+		SuccessURL: stripe.String(p.SuccessURL),
+		CancelURL:  stripe.String(p.CancelURL),
 		// Metadata for the checkout session
-		Metadata: map[string]string{
-			"user_id": userID,
-			"tenant":  tenant,
-			"plan":    string(plan),
-		},
+		Metadata: metadata,
 		// IMPORTANT: Pass metadata to the subscription that will be created
 		SubscriptionData: &stripe.CheckoutSessionSubscriptionDataParams{
-			Metadata: map[string]string{
-				"user_id": userID,
-				"tenant":  tenant,
-				"plan":    string(plan),
+			Metadata: metadata,
+		},
+	}
+
+	// Metered prices are billed by reported usage and take no quantity
+	for _, priceID := range p.MeteredPriceIDs {
+		params.LineItems = append(params.LineItems, &stripe.CheckoutSessionLineItemParams{
+			Price: stripe.String(priceID),
+		})
+	}
+	if p.Locale != "" {
+		params.Locale = stripe.String(p.Locale)
+	}
+	p.Tax.apply(params)
+
+	sess, err := session.New(params)
+	if err != nil {
+		return nil, fmt.Errorf("error creating checkout session: %w", err)
+	}
+
+	return sess, nil
+}
+
+// CreatePaymentCheckoutSession creates a one-time payment Checkout Session for
+// the catalog product p.Product. The metadata is copied to the PaymentIntent
+// so the order can be recorded from payment_intent.succeeded as well.
+func (c *Client) CreatePaymentCheckoutSession(p CheckoutParams) (*stripe.CheckoutSession, error) {
+	metadata := map[string]string{
+		"user_id": p.UserID,
+		"tenant":  p.Tenant,
+		"product": p.Product,
+	}
+
+	params := &stripe.CheckoutSessionParams{
+		Customer: stripe.String(p.CustomerID),
+		Mode:     stripe.String(string(stripe.CheckoutSessionModePayment)),
+		LineItems: []*stripe.CheckoutSessionLineItemParams{
+			{
+				Price:    stripe.String(p.PriceID),
+				Quantity: stripe.Int64(1),
 			},
 		},
+		SuccessURL: stripe.String(p.SuccessURL),
+		CancelURL:  stripe.String(p.CancelURL),
+		Metadata:   metadata,
+		PaymentIntentData: &stripe.CheckoutSessionPaymentIntentDataParams{
+			Metadata: metadata,
+		},
+	}
+	if p.Locale != "" {
+		params.Locale = stripe.String(p.Locale)
 	}
+	p.Tax.apply(params)
 
 	sess, err := session.New(params)
 	if err != nil {
@@ -83,22 +200,33 @@ func (c *Client) CreateCheckoutSession(userID, tenant string, plan models.Plan,
 	return sess, nil
 }
 
-// getOrCreateCustomer gets or creates a Stripe customer
-func (c *Client) getOrCreateCustomer(userID, tenant string) (string, error) {
+// searchEscaper escapes characters with special meaning inside a quoted
+// Stripe search query value
+var searchEscaper = strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+
+// FindOrCreateCustomer gets or creates a Stripe customer for a user, prefilling
+// email and name when a new customer is created.
+// Search is eventually consistent, so callers should check the local customers
+// table first and only fall back to this for users created before it existed.
+func (c *Client) FindOrCreateCustomer(userID, tenant, email, name string) (*stripe.Customer, error) {
 	// Search for existing customer with this user_id
 	params := &stripe.CustomerSearchParams{
 		SearchParams: stripe.SearchParams{
-			Query: fmt.Sprintf("metadata['user_id']:'%s' AND metadata['tenant']:'%s'", userID, tenant),
+			Query: fmt.Sprintf(
+				"metadata['user_id']:'%s' AND metadata['tenant']:'%s'",
+				searchEscaper.Replace(userID),
+				searchEscaper.Replace(tenant),
+			),
 		},
 	}
 
 	iter := customer.Search(params)
 	if iter.Next() {
-		return iter.Customer().ID, nil
+		return iter.Customer(), nil
 	}
 
 	if err := iter.Err(); err != nil {
-		return "", fmt.Errorf("error searching for customer: %w", err)
+		return nil, fmt.Errorf("error searching for customer: %w", err)
 	}
 
 	// Customer doesn't exist, create new one
@@ -108,13 +236,40 @@ func (c *Client) getOrCreateCustomer(userID, tenant string) (string, error) {
 			"tenant":  tenant,
 		},
 	}
+	if email != "" {
+		customerParams.Email = stripe.String(email)
+	}
+	if name != "" {
+		customerParams.Name = stripe.String(name)
+	}
 
 	cust, err := customer.New(customerParams)
 	if err != nil {
-		return "", fmt.Errorf("error creating customer: %w", err)
+		return nil, fmt.Errorf("error creating customer: %w", err)
+	}
+
+	return cust, nil
+}
+
+// GetCustomer retrieves a Stripe customer
+func (c *Client) GetCustomer(customerID string) (*stripe.Customer, error) {
+	cust, err := customer.Get(customerID, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error getting customer: %w", err)
+	}
+
+	return cust, nil
+}
+
+// UpdateCustomerMetadata sets metadata keys on a Stripe customer
+func (c *Client) UpdateCustomerMetadata(customerID string, metadata map[string]string) (*stripe.Customer, error) {
+	params := &stripe.CustomerParams{Metadata: metadata}
+	cust, err := customer.Update(customerID, params)
+	if err != nil {
+		return nil, fmt.Errorf("error updating customer metadata: %w", err)
 	}
 
-	return cust.ID, nil
+	return cust, nil
 }
 
 // CancelSubscription schedules a Stripe subscription to cancel at period end
@@ -153,3 +308,487 @@ func (c *Client) GetSubscription(subscriptionID string) (*stripe.Subscription, e
 
 	return sub, nil
 }
+
+// SearchSubscriptions calls fn for every subscription tagged with the given tenant,
+// paging through the results
+func (c *Client) SearchSubscriptions(tenant string, fn func(*stripe.Subscription) error) error {
+	params := &stripe.SubscriptionSearchParams{
+		SearchParams: stripe.SearchParams{
+			Query: fmt.Sprintf("metadata['tenant']:'%s'", searchEscaper.Replace(tenant)),
+		},
+	}
+
+	iter := subscription.Search(params)
+	for iter.Next() {
+		if err := fn(iter.Subscription()); err != nil {
+			return err
+		}
+	}
+
+	if err := iter.Err(); err != nil {
+		return fmt.Errorf("error searching subscriptions: %w", err)
+	}
+
+	return nil
+}
+
+// ListInvoices calls fn for every invoice of a subscription, paging through the results
+func (c *Client) ListInvoices(subscriptionID string, fn func(*stripe.Invoice) error) error {
+	params := &stripe.InvoiceListParams{
+		Subscription: stripe.String(subscriptionID),
+	}
+
+	iter := invoice.List(params)
+	for iter.Next() {
+		if err := fn(iter.Invoice()); err != nil {
+			return err
+		}
+	}
+
+	if err := iter.Err(); err != nil {
+		return fmt.Errorf("error listing invoices: %w", err)
+	}
+
+	return nil
+}
+
+// UpdateSubscriptionMetadata sets metadata keys on a Stripe subscription
+func (c *Client) UpdateSubscriptionMetadata(subscriptionID string, metadata map[string]string) (*stripe.Subscription, error) {
+	params := &stripe.SubscriptionParams{Metadata: metadata}
+	sub, err := subscription.Update(subscriptionID, params)
+	if err != nil {
+		return nil, fmt.Errorf("error updating subscription metadata: %w", err)
+	}
+
+	return sub, nil
+}
+
+// ListCustomerSubscriptions calls fn for every subscription of a customer,
+// including canceled ones
+func (c *Client) ListCustomerSubscriptions(customerID string, fn func(*stripe.Subscription) error) error {
+	params := &stripe.SubscriptionListParams{
+		Customer: stripe.String(customerID),
+		Status:   stripe.String("all"),
+	}
+
+	iter := subscription.List(params)
+	for iter.Next() {
+		if err := fn(iter.Subscription()); err != nil {
+			return err
+		}
+	}
+
+	if err := iter.Err(); err != nil {
+		return fmt.Errorf("error listing subscriptions: %w", err)
+	}
+
+	return nil
+}
+
+// eventWindow is the span of events listed at a time. The API lists newest
+// first, so each window is buffered and walked backwards.
+const eventWindow = time.Hour
+
+// maxEventTypes is the most event types the list API filters by
+const maxEventTypes = 20
+
+// ListEvents calls fn for every event of types created in [from, to], oldest
+// first. An empty types, or more than the API accepts, lists every type.
+// Stripe keeps events for 30 days.
+func (c *Client) ListEvents(from, to time.Time, types []string, fn func(*stripe.Event) error) error {
+	for start := from; !start.After(to); start = start.Add(eventWindow) {
+		end := start.Add(eventWindow - time.Second)
+		if end.After(to) {
+			end = to
+		}
+
+		params := &stripe.EventListParams{
+			CreatedRange: &stripe.RangeQueryParams{
+				GreaterThanOrEqual: start.Unix(),
+				LesserThanOrEqual:  end.Unix(),
+			},
+		}
+		if len(types) > 0 && len(types) <= maxEventTypes {
+			params.Types = stripe.StringSlice(types)
+		}
+
+		var events []*stripe.Event
+		iter := event.List(params)
+		for iter.Next() {
+			events = append(events, iter.Event())
+		}
+
+		if err := iter.Err(); err != nil {
+			return fmt.Errorf("error listing events: %w", err)
+		}
+
+		for i := len(events) - 1; i >= 0; i-- {
+			if err := fn(events[i]); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// GetInvoice fetches an invoice from Stripe
+func (c *Client) GetInvoice(invoiceID string) (*stripe.Invoice, error) {
+	inv, err := invoice.Get(invoiceID, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching invoice: %w", err)
+	}
+
+	return inv, nil
+}
+
+// InvoiceLines returns every line of an invoice. Invoice objects embed the
+// first page of lines; the rest are listed from Stripe.
+func (c *Client) InvoiceLines(inv *stripe.Invoice) ([]*stripe.InvoiceLineItem, error) {
+	if inv.Lines != nil && !inv.Lines.HasMore {
+		return inv.Lines.Data, nil
+	}
+
+	params := &stripe.InvoiceListLinesParams{
+		Invoice: stripe.String(inv.ID),
+	}
+
+	var lines []*stripe.InvoiceLineItem
+	iter := invoice.ListLines(params)
+	for iter.Next() {
+		lines = append(lines, iter.InvoiceLineItem())
+	}
+
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("error listing invoice lines: %w", err)
+	}
+
+	return lines, nil
+}
+
+// GetInvoicePayment returns the PaymentIntent and charge that paid an invoice.
+// Either may be empty: payments made without a PaymentIntent only have a charge.
+func (c *Client) GetInvoicePayment(invoiceID string) (paymentIntentID, chargeID string, err error) {
+	params := &stripe.InvoicePaymentListParams{
+		Invoice: stripe.String(invoiceID),
+		Status:  stripe.String("paid"),
+	}
+	params.AddExpand("data.payment.payment_intent")
+
+	iter := invoicepayment.List(params)
+	for iter.Next() {
+		payment := iter.InvoicePayment().Payment
+		if payment == nil {
+			continue
+		}
+		if payment.PaymentIntent != nil {
+			paymentIntentID = payment.PaymentIntent.ID
+			if payment.PaymentIntent.LatestCharge != nil {
+				chargeID = payment.PaymentIntent.LatestCharge.ID
+			}
+		}
+		if payment.Charge != nil {
+			chargeID = payment.Charge.ID
+		}
+		break
+	}
+
+	if err := iter.Err(); err != nil {
+		return "", "", fmt.Errorf("error listing invoice payments: %w", err)
+	}
+
+	return paymentIntentID, chargeID, nil
+}
+
+// GetChargeFee returns the Stripe fee of a charge and the currency of the
+// balance it settled in. ok is false while the charge has no balance
+// transaction, e.g. for payments that are still pending.
+func (c *Client) GetChargeFee(chargeID string) (fee int64, currency string, ok bool, err error) {
+	params := &stripe.ChargeParams{}
+	params.AddExpand("balance_transaction")
+
+	ch, err := charge.Get(chargeID, params)
+	if err != nil {
+		return 0, "", false, fmt.Errorf("error fetching charge: %w", err)
+	}
+
+	if ch.BalanceTransaction == nil {
+		return 0, "", false, nil
+	}
+
+	return ch.BalanceTransaction.Fee, string(ch.BalanceTransaction.Currency), true, nil
+}
+
+// GetInvoiceIDForPaymentIntent returns the invoice paid by a PaymentIntent, or
+// an empty string if it did not pay an invoice
+func (c *Client) GetInvoiceIDForPaymentIntent(paymentIntentID string) (string, error) {
+	params := &stripe.InvoicePaymentListParams{
+		Payment: &stripe.InvoicePaymentListPaymentParams{
+			Type:          stripe.String("payment_intent"),
+			PaymentIntent: stripe.String(paymentIntentID),
+		},
+	}
+	params.Limit = stripe.Int64(1)
+	params.Single = true
+
+	iter := invoicepayment.List(params)
+	for iter.Next() {
+		if inv := iter.InvoicePayment().Invoice; inv != nil {
+			return inv.ID, nil
+		}
+	}
+
+	if err := iter.Err(); err != nil {
+		return "", fmt.Errorf("error listing invoice payments: %w", err)
+	}
+
+	return "", nil
+}
+
+// CreateRefund refunds a payment, identified by its PaymentIntent or, for
+// payments made without one, its charge. A zero amount refunds the remainder
+// of the payment. Stripe returns the first refund again when a request is
+// retried with the same idempotency key.
+func (c *Client) CreateRefund(paymentIntentID, chargeID string, amount int64, reason string, metadata map[string]string, idempotencyKey string) (*stripe.Refund, error) {
+	params := &stripe.RefundParams{Metadata: metadata}
+	params.SetIdempotencyKey(idempotencyKey)
+	if paymentIntentID != "" {
+		params.PaymentIntent = stripe.String(paymentIntentID)
+	} else {
+		params.Charge = stripe.String(chargeID)
+	}
+	if amount > 0 {
+		params.Amount = stripe.Int64(amount)
+	}
+	if reason != "" {
+		params.Reason = stripe.String(reason)
+	}
+
+	r, err := refund.New(params)
+	if err != nil {
+		return nil, fmt.Errorf("error creating refund: %w", err)
+	}
+
+	return r, nil
+}
+
+// ListChargeRefunds returns the refunds of a charge. Charges in webhook
+// payloads no longer include them.
+func (c *Client) ListChargeRefunds(chargeID string) ([]*stripe.Refund, error) {
+	params := &stripe.RefundListParams{
+		Charge: stripe.String(chargeID),
+	}
+
+	var refunds []*stripe.Refund
+	iter := refund.List(params)
+	for iter.Next() {
+		refunds = append(refunds, iter.Refund())
+	}
+
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("error listing refunds: %w", err)
+	}
+
+	return refunds, nil
+}
+
+// ListPaymentMethods returns the cards saved on a customer
+func (c *Client) ListPaymentMethods(customerID string) ([]*stripe.PaymentMethod, error) {
+	params := &stripe.PaymentMethodListParams{
+		Customer: stripe.String(customerID),
+		Type:     stripe.String(string(stripe.PaymentMethodTypeCard)),
+	}
+
+	var methods []*stripe.PaymentMethod
+	iter := paymentmethod.List(params)
+	for iter.Next() {
+		methods = append(methods, iter.PaymentMethod())
+	}
+
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("error listing payment methods: %w", err)
+	}
+
+	return methods, nil
+}
+
+// GetPaymentMethod retrieves a Stripe payment method
+func (c *Client) GetPaymentMethod(paymentMethodID string) (*stripe.PaymentMethod, error) {
+	pm, err := paymentmethod.Get(paymentMethodID, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error getting payment method: %w", err)
+	}
+
+	return pm, nil
+}
+
+// CreateSetupIntent creates a SetupIntent that saves a new card on the
+// customer for future off-session payments such as renewals
+func (c *Client) CreateSetupIntent(customerID, userID, tenant string) (*stripe.SetupIntent, error) {
+	params := &stripe.SetupIntentParams{
+		Customer:           stripe.String(customerID),
+		PaymentMethodTypes: stripe.StringSlice([]string{string(stripe.PaymentMethodTypeCard)}),
+		Usage:              stripe.String(string(stripe.SetupIntentUsageOffSession)),
+		Metadata: map[string]string{
+			"user_id": userID,
+			"tenant":  tenant,
+		},
+	}
+
+	si, err := setupintent.New(params)
+	if err != nil {
+		return nil, fmt.Errorf("error creating setup intent: %w", err)
+	}
+
+	return si, nil
+}
+
+// SetDefaultPaymentMethod makes a payment method the customer's default for invoices
+func (c *Client) SetDefaultPaymentMethod(customerID, paymentMethodID string) (*stripe.Customer, error) {
+	params := &stripe.CustomerParams{
+		InvoiceSettings: &stripe.CustomerInvoiceSettingsParams{
+			DefaultPaymentMethod: stripe.String(paymentMethodID),
+		},
+	}
+	cust, err := customer.Update(customerID, params)
+	if err != nil {
+		return nil, fmt.Errorf("error setting default payment method: %w", err)
+	}
+
+	return cust, nil
+}
+
+// SetSubscriptionPaymentMethod sets the payment method a subscription renews with.
+// It takes precedence over the customer's default.
+func (c *Client) SetSubscriptionPaymentMethod(subscriptionID, paymentMethodID string) (*stripe.Subscription, error) {
+	params := &stripe.SubscriptionParams{
+		DefaultPaymentMethod: stripe.String(paymentMethodID),
+	}
+	sub, err := subscription.Update(subscriptionID, params)
+	if err != nil {
+		return nil, fmt.Errorf("error setting subscription payment method: %w", err)
+	}
+
+	return sub, nil
+}
+
+// UpdateSubscriptionQuantity changes the number of seats of a subscription.
+// prorationBehavior is one of Stripe's proration behaviors: create_prorations
+// charges or credits the difference on the next invoice, always_invoice bills
+// it immediately and none leaves the current period untouched. isPlanPrice
+// identifies the plan item, see PlanItem.
+func (c *Client) UpdateSubscriptionQuantity(subscriptionID string, quantity int64, prorationBehavior string, isPlanPrice func(priceID string) bool) (*stripe.Subscription, error) {
+	sub, err := c.GetSubscription(subscriptionID)
+	if err != nil {
+		return nil, err
+	}
+
+	item := PlanItem(sub, isPlanPrice)
+	if item == nil {
+		return nil, fmt.Errorf("subscription %s has no plan item", subscriptionID)
+	}
+
+	params := &stripe.SubscriptionParams{
+		Items: []*stripe.SubscriptionItemsParams{
+			{
+				ID:       stripe.String(item.ID),
+				Quantity: stripe.Int64(quantity),
+			},
+		},
+		ProrationBehavior: stripe.String(prorationBehavior),
+	}
+	sub, err = subscription.Update(subscriptionID, params)
+	if err != nil {
+		return nil, fmt.Errorf("error updating subscription quantity: %w", err)
+	}
+
+	return sub, nil
+}
+
+// AddSubscriptionItem adds a price as an extra item of a subscription, billed
+// on the same invoices as the plan
+func (c *Client) AddSubscriptionItem(subscriptionID, priceID string, quantity int64, prorationBehavior string) (*stripe.SubscriptionItem, error) {
+	params := &stripe.SubscriptionItemParams{
+		Subscription:      stripe.String(subscriptionID),
+		Price:             stripe.String(priceID),
+		Quantity:          stripe.Int64(quantity),
+		ProrationBehavior: stripe.String(prorationBehavior),
+	}
+	item, err := subscriptionitem.New(params)
+	if err != nil {
+		return nil, fmt.Errorf("error adding subscription item: %w", err)
+	}
+
+	return item, nil
+}
+
+// DeleteSubscriptionItem removes an item from its subscription
+func (c *Client) DeleteSubscriptionItem(itemID, prorationBehavior string) error {
+	params := &stripe.SubscriptionItemParams{
+		ProrationBehavior: stripe.String(prorationBehavior),
+	}
+	if _, err := subscriptionitem.Del(itemID, params); err != nil {
+		return fmt.Errorf("error deleting subscription item: %w", err)
+	}
+
+	return nil
+}
+
+// DescribeTaxes fills in the name, percentage and jurisdiction of the tax
+// rates in an invoice's tax breakdown
+func (c *Client) DescribeTaxes(taxes models.InvoiceTaxes) error {
+	for i := range taxes {
+		if taxes[i].TaxRateID == "" {
+			continue
+		}
+
+		var rate *stripe.TaxRate
+		if cached, ok := c.taxRates.Load(taxes[i].TaxRateID); ok {
+			rate = cached.(*stripe.TaxRate)
+		} else {
+			fetched, err := taxrate.Get(taxes[i].TaxRateID, nil)
+			if err != nil {
+				return fmt.Errorf("error getting tax rate: %w", err)
+			}
+			c.taxRates.Store(fetched.ID, fetched)
+			rate = fetched
+		}
+
+		taxes[i].DisplayName = rate.DisplayName
+		taxes[i].Percentage = rate.Percentage
+		taxes[i].Country = rate.Country
+		taxes[i].Jurisdiction = rate.Jurisdiction
+	}
+
+	return nil
+}
+
+// ReportMeterEvent reports usage to the Stripe billing meter with the given
+// event name. Stripe drops events whose identifier it has already seen, so
+// reporting the same event again is safe.
+func (c *Client) ReportMeterEvent(eventName, customerID, identifier string, value int64, timestamp time.Time) error {
+	params := &stripe.BillingMeterEventParams{
+		EventName:  stripe.String(eventName),
+		Identifier: stripe.String(identifier),
+		Payload: map[string]string{
+			"stripe_customer_id": customerID,
+			"value":              strconv.FormatInt(value, 10),
+		},
+		Timestamp: stripe.Int64(timestamp.Unix()),
+	}
+	if _, err := meterevent.New(params); err != nil {
+		return fmt.Errorf("error reporting meter event: %w", err)
+	}
+
+	return nil
+}
+
+// DetachPaymentMethod removes a payment method from its customer
+func (c *Client) DetachPaymentMethod(paymentMethodID string) (*stripe.PaymentMethod, error) {
+	pm, err := paymentmethod.Detach(paymentMethodID, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error detaching payment method: %w", err)
+	}
+
+	return pm, nil
+}
@@ -0,0 +1,263 @@
+package stripe
+
+import (
+	"errors"
+	"math"
+	"time"
+
+	"github.com/naventro/payment-service/internal/models"
+	"github.com/stripe/stripe-go/v84"
+)
+
+// IsNotFound reports whether err is a Stripe "resource missing" error
+func IsNotFound(err error) bool {
+	var stripeErr *stripe.Error
+	return errors.As(err, &stripeErr) && stripeErr.Code == stripe.ErrorCodeResourceMissing
+}
+
+// SubscriptionPeriod returns the current billing period of a subscription.
+// In API v84+, period dates are at subscription item level; they are read
+// from the plan item, or from the first item of subscriptions that only
+// have metered ones.
+func SubscriptionPeriod(sub *stripe.Subscription, isPlanPrice func(priceID string) bool) (start, end *time.Time) {
+	item := PlanItem(sub, isPlanPrice)
+	if item == nil && sub.Items != nil && len(sub.Items.Data) > 0 {
+		item = sub.Items.Data[0]
+	}
+	if item == nil {
+		return nil, nil
+	}
+
+	periodStart := time.Unix(item.CurrentPeriodStart, 0)
+	periodEnd := time.Unix(item.CurrentPeriodEnd, 0)
+	return &periodStart, &periodEnd
+}
+
+// PlanItem returns the item of a subscription that bills the plan, or nil if
+// it has none. It is the item whose price isPlanPrice reports as a plan
+// price, such as Catalog.IsPlanPrice; subscriptions without one, like those
+// on prices created outside the catalog, fall back to the first item that is
+// not metered.
+func PlanItem(sub *stripe.Subscription, isPlanPrice func(priceID string) bool) *stripe.SubscriptionItem {
+	if sub.Items == nil {
+		return nil
+	}
+
+	if isPlanPrice != nil {
+		for _, item := range sub.Items.Data {
+			if item.Price != nil && isPlanPrice(item.Price.ID) {
+				return item
+			}
+		}
+	}
+
+	for _, item := range sub.Items.Data {
+		if item.Price != nil && item.Price.Recurring != nil && item.Price.Recurring.UsageType == stripe.PriceRecurringUsageTypeMetered {
+			continue
+		}
+		return item
+	}
+
+	return nil
+}
+
+// SubscriptionQuantity returns the number of seats of a subscription, the
+// quantity of its plan item
+func SubscriptionQuantity(sub *stripe.Subscription, isPlanPrice func(priceID string) bool) int64 {
+	item := PlanItem(sub, isPlanPrice)
+	if item == nil || item.Quantity == 0 {
+		return 1
+	}
+
+	return item.Quantity
+}
+
+// MonthlyRecurringAmount returns the recurring amount of a subscription's
+// licensed items normalized to a month, in the smallest currency unit.
+// Metered items, tiered prices and discounts are not included.
+func MonthlyRecurringAmount(sub *stripe.Subscription) int64 {
+	if sub.Items == nil {
+		return 0
+	}
+
+	var total float64
+	for _, item := range sub.Items.Data {
+		price := item.Price
+		if price == nil || price.Recurring == nil || price.Recurring.UsageType == stripe.PriceRecurringUsageTypeMetered {
+			continue
+		}
+
+		quantity := item.Quantity
+		if quantity == 0 {
+			quantity = 1
+		}
+
+		intervalCount := price.Recurring.IntervalCount
+		if intervalCount == 0 {
+			intervalCount = 1
+		}
+
+		var perMonth float64
+		switch price.Recurring.Interval {
+		case stripe.PriceRecurringIntervalDay:
+			perMonth = 365.0 / 12
+		case stripe.PriceRecurringIntervalWeek:
+			perMonth = 52.0 / 12
+		case stripe.PriceRecurringIntervalMonth:
+			perMonth = 1
+		case stripe.PriceRecurringIntervalYear:
+			perMonth = 1.0 / 12
+		}
+
+		total += float64(price.UnitAmount*quantity) * perMonth / float64(intervalCount)
+	}
+
+	return int64(math.Round(total))
+}
+
+// ToSubscription converts a Stripe subscription into the local model,
+// taking the user, tenant and plan from its metadata. isPlanPrice identifies
+// the plan item, see PlanItem.
+func ToSubscription(sub *stripe.Subscription, isPlanPrice func(priceID string) bool) *models.Subscription {
+	periodStart, periodEnd := SubscriptionPeriod(sub, isPlanPrice)
+
+	subscription := &models.Subscription{
+		UserID:               sub.Metadata["user_id"],
+		Tenant:               sub.Metadata["tenant"],
+		StripeSubscriptionID: sub.ID,
+		Status:               models.SubscriptionStatus(sub.Status),
+		Plan:                 models.Plan(sub.Metadata["plan"]),
+		CurrentPeriodStart:   periodStart,
+		CurrentPeriodEnd:     periodEnd,
+		CancelAtPeriodEnd:    sub.CancelAtPeriodEnd,
+		Quantity:             SubscriptionQuantity(sub, isPlanPrice),
+		Currency:             string(sub.Currency),
+	}
+	if sub.Customer != nil {
+		subscription.StripeCustomerID = sub.Customer.ID
+	}
+
+	return subscription
+}
+
+// ToInvoice converts a Stripe invoice into the local model. The local
+// subscription, user and tenant are left for the caller to fill in.
+func ToInvoice(inv *stripe.Invoice) *models.Invoice {
+	var periodStart, periodEnd *time.Time
+	if inv.PeriodStart > 0 {
+		t := time.Unix(inv.PeriodStart, 0)
+		periodStart = &t
+	}
+	if inv.PeriodEnd > 0 {
+		t := time.Unix(inv.PeriodEnd, 0)
+		periodEnd = &t
+	}
+
+	invoice := &models.Invoice{
+		StripeInvoiceID:  inv.ID,
+		AmountPaid:       inv.AmountPaid,
+		Currency:         string(inv.Currency),
+		Status:           models.InvoiceStatus(inv.Status),
+		InvoicePDF:       &inv.InvoicePDF,
+		HostedInvoiceURL: &inv.HostedInvoiceURL,
+		PeriodStart:      periodStart,
+		PeriodEnd:        periodEnd,
+	}
+	if inv.StatusTransitions != nil && inv.StatusTransitions.PaidAt > 0 {
+		paidAt := time.Unix(inv.StatusTransitions.PaidAt, 0)
+		invoice.PaidAt = &paidAt
+	}
+	SetInvoiceTax(invoice, inv)
+	SetInvoiceCustomer(invoice, inv)
+	if inv.Lines != nil && !inv.Lines.HasMore {
+		invoice.Lines = ToInvoiceLines(inv.Lines.Data)
+	}
+	return invoice
+}
+
+// SetInvoiceCustomer copies the name, email and billing address the
+// customer had when the invoice was finalized
+func SetInvoiceCustomer(invoice *models.Invoice, inv *stripe.Invoice) {
+	invoice.CustomerName = nil
+	if inv.CustomerName != "" {
+		name := inv.CustomerName
+		invoice.CustomerName = &name
+	}
+
+	invoice.CustomerEmail = nil
+	if inv.CustomerEmail != "" {
+		email := inv.CustomerEmail
+		invoice.CustomerEmail = &email
+	}
+
+	invoice.CustomerAddress = nil
+	if address := inv.CustomerAddress; address != nil && *address != (stripe.Address{}) {
+		invoice.CustomerAddress = &models.InvoiceAddress{
+			Line1:      address.Line1,
+			Line2:      address.Line2,
+			City:       address.City,
+			State:      address.State,
+			PostalCode: address.PostalCode,
+			Country:    address.Country,
+		}
+	}
+}
+
+// ToInvoiceLines converts the lines of a Stripe invoice
+func ToInvoiceLines(items []*stripe.InvoiceLineItem) models.InvoiceLines {
+	lines := make(models.InvoiceLines, 0, len(items))
+	for _, item := range items {
+		line := models.InvoiceLine{
+			Description: item.Description,
+			Quantity:    item.Quantity,
+			Amount:      item.Amount,
+		}
+		if item.Period != nil && item.Period.End > 0 {
+			start := time.Unix(item.Period.Start, 0)
+			end := time.Unix(item.Period.End, 0)
+			line.PeriodStart = &start
+			line.PeriodEnd = &end
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// SetInvoiceTax copies the totals, tax and customer tax details of a Stripe
+// invoice. Tax rates are only referenced by ID; Client.DescribeTaxes fills in
+// their names and percentages.
+func SetInvoiceTax(invoice *models.Invoice, inv *stripe.Invoice) {
+	invoice.Subtotal = inv.Subtotal
+	invoice.Total = inv.Total
+	invoice.Tax = 0
+	invoice.TaxBreakdown = nil
+	for _, tax := range inv.TotalTaxes {
+		invoice.Tax += tax.Amount
+
+		entry := models.InvoiceTax{
+			Amount:           tax.Amount,
+			TaxableAmount:    tax.TaxableAmount,
+			TaxBehavior:      string(tax.TaxBehavior),
+			TaxabilityReason: string(tax.TaxabilityReason),
+		}
+		if tax.TaxRateDetails != nil {
+			entry.TaxRateID = tax.TaxRateDetails.TaxRate
+		}
+		invoice.TaxBreakdown = append(invoice.TaxBreakdown, entry)
+	}
+
+	invoice.CustomerTaxIDs = nil
+	for _, taxID := range inv.CustomerTaxIDs {
+		entry := models.InvoiceTaxID{Value: taxID.Value}
+		if taxID.Type != nil {
+			entry.Type = string(*taxID.Type)
+		}
+		invoice.CustomerTaxIDs = append(invoice.CustomerTaxIDs, entry)
+	}
+
+	invoice.CustomerCountry = nil
+	if inv.CustomerAddress != nil && inv.CustomerAddress.Country != "" {
+		country := inv.CustomerAddress.Country
+		invoice.CustomerCountry = &country
+	}
+}
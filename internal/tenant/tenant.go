@@ -0,0 +1,270 @@
+package tenant
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/mail"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var hexColor = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// Settings are the policies that can differ between tenants
+type Settings struct {
+	// RevokeOnDispute revokes the user's entitlements as soon as a dispute
+	// is opened against one of their payments, until it is won
+	RevokeOnDispute bool `json:"revoke_on_dispute"`
+
+	// AutomaticTax calculates VAT and sales tax with Stripe Tax at checkout
+	// and on subscription renewals
+	AutomaticTax bool `json:"automatic_tax"`
+	// BillingAddressCollection is "auto" or "required". Stripe Tax needs the
+	// customer's location, so automatic tax collects it at least as "auto".
+	BillingAddressCollection string `json:"billing_address_collection"`
+	// TaxIDCollection lets business customers enter a VAT or other tax ID at checkout
+	TaxIDCollection bool `json:"tax_id_collection"`
+
+	// Invoice sets the branding, legal details and numbering of the
+	// tenant's invoice PDFs
+	Invoice InvoiceSettings `json:"invoice"`
+
+	// Emails sets the sender, language and templates of the lifecycle
+	// emails sent to the tenant's customers
+	Emails EmailSettings `json:"emails"`
+}
+
+// emailKinds are the lifecycle emails sent to customers
+var emailKinds = []string{"payment_failed", "trial_ending", "canceled", "reactivated", "receipt"}
+
+// EmailSettings are the sender, language and templates of customer emails
+type EmailSettings struct {
+	// OptOut sends no emails to the tenant's customers, e.g. when its
+	// backend sends its own; Skip leaves out single kinds such as "receipt"
+	OptOut bool     `json:"opt_out"`
+	Skip   []string `json:"skip"`
+	// From and ReplyTo are addresses such as "Menuum <billing@menuum.com>";
+	// From defaults to MAIL_FROM
+	From    string `json:"from"`
+	ReplyTo string `json:"reply_to"`
+	// SenderName is the product name used in the texts, the tenant ID if empty
+	SenderName string `json:"sender_name"`
+	// Locale of the built-in templates, "en" or "es"
+	Locale string `json:"locale"`
+	// TemplateDir holds templates that replace the built-in ones, laid out
+	// as <locale>/<kind>.tmpl
+	TemplateDir string `json:"template_dir"`
+}
+
+// Sends reports whether the tenant's customers get emails of kind
+func (s EmailSettings) Sends(kind string) bool {
+	if s.OptOut {
+		return false
+	}
+	for _, skipped := range s.Skip {
+		if skipped == kind {
+			return false
+		}
+	}
+	return true
+}
+
+// InvoiceSettings are the seller details, look and numbering of invoice PDFs
+type InvoiceSettings struct {
+	// Seller details printed on every invoice
+	LegalName string   `json:"legal_name"`
+	FiscalID  string   `json:"fiscal_id"`
+	Address   []string `json:"address"`
+	Email     string   `json:"email"`
+	// LogoFile is a PNG or JPEG printed in the header
+	LogoFile string `json:"logo_file"`
+	// Template is the layout, "standard" or "compact"
+	Template string `json:"template"`
+	// Locale of the labels, "en" or "es"
+	Locale string `json:"locale"`
+	// AccentColor is a hex color such as #1a73e8 for headings and rules
+	AccentColor string `json:"accent_color"`
+	// Footer holds legal notes printed at the bottom of each invoice
+	Footer string `json:"footer"`
+
+	// Invoice numbers are NumberPrefix and a sequence padded to NumberDigits.
+	// YearlySequence adds the year to the prefix and restarts the sequence
+	// every year.
+	NumberPrefix   string `json:"number_prefix"`
+	NumberDigits   int    `json:"number_digits"`
+	YearlySequence bool   `json:"yearly_sequence"`
+}
+
+// Series returns the numbering series of invoices paid at t: the prefix of
+// their numbers
+func (s InvoiceSettings) Series(t time.Time) string {
+	if s.YearlySequence {
+		return fmt.Sprintf("%s%d-", s.NumberPrefix, t.Year())
+	}
+	return s.NumberPrefix
+}
+
+// FormatNumber returns the invoice number with sequence n in series
+func (s InvoiceSettings) FormatNumber(series string, n int64) string {
+	digits := s.NumberDigits
+	if digits == 0 {
+		digits = 6
+	}
+	return fmt.Sprintf("%s%0*d", series, digits, n)
+}
+
+func (s Settings) validate() error {
+	switch s.BillingAddressCollection {
+	case "", "auto", "required":
+	default:
+		return fmt.Errorf("billing_address_collection must be auto or required, got %q", s.BillingAddressCollection)
+	}
+	if err := s.Invoice.validate(); err != nil {
+		return err
+	}
+	return s.Emails.validate()
+}
+
+func (s EmailSettings) validate() error {
+	for _, kind := range s.Skip {
+		if !contains(emailKinds, kind) {
+			return fmt.Errorf("emails.skip must only list %s, got %q", strings.Join(emailKinds, ", "), kind)
+		}
+	}
+
+	switch s.Locale {
+	case "", "en", "es":
+	default:
+		return fmt.Errorf("emails.locale must be en or es, got %q", s.Locale)
+	}
+
+	for _, address := range []string{s.From, s.ReplyTo} {
+		if address == "" {
+			continue
+		}
+		if _, err := mail.ParseAddress(address); err != nil {
+			return fmt.Errorf("emails: invalid address %q: %w", address, err)
+		}
+	}
+
+	if s.TemplateDir != "" {
+		if info, err := os.Stat(s.TemplateDir); err != nil {
+			return fmt.Errorf("emails.template_dir: %w", err)
+		} else if !info.IsDir() {
+			return fmt.Errorf("emails.template_dir: %s is not a directory", s.TemplateDir)
+		}
+	}
+
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (s InvoiceSettings) validate() error {
+	switch s.Template {
+	case "", "standard", "compact":
+	default:
+		return fmt.Errorf("invoice.template must be standard or compact, got %q", s.Template)
+	}
+
+	switch s.Locale {
+	case "", "en", "es":
+	default:
+		return fmt.Errorf("invoice.locale must be en or es, got %q", s.Locale)
+	}
+
+	if s.AccentColor != "" && !hexColor.MatchString(s.AccentColor) {
+		return fmt.Errorf("invoice.accent_color must be a hex color such as #1a73e8, got %q", s.AccentColor)
+	}
+
+	if s.NumberDigits < 0 || s.NumberDigits > 12 {
+		return fmt.Errorf("invoice.number_digits must be between 1 and 12, got %d", s.NumberDigits)
+	}
+
+	if len(s.NumberPrefix) > 30 {
+		return fmt.Errorf("invoice.number_prefix must be at most 30 characters")
+	}
+
+	if s.LogoFile != "" {
+		if _, err := os.Stat(s.LogoFile); err != nil {
+			return fmt.Errorf("invoice.logo_file: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Registry resolves the settings of each tenant. Tenants without their own
+// entry use the defaults.
+type Registry struct {
+	defaults Settings
+	tenants  map[string]Settings
+}
+
+// configFile is the layout of TENANT_CONFIG_FILE. Each tenant entry is
+// applied on top of the defaults, so it only needs the keys it overrides.
+type configFile struct {
+	Defaults json.RawMessage            `json:"defaults"`
+	Tenants  map[string]json.RawMessage `json:"tenants"`
+}
+
+// Load reads the tenant settings from a JSON file. An empty path returns a
+// registry where every tenant has the zero settings.
+func Load(path string) (*Registry, error) {
+	registry := &Registry{tenants: make(map[string]Settings)}
+	if path == "" {
+		return registry, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading tenant config: %w", err)
+	}
+
+	var file configFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("error parsing tenant config: %w", err)
+	}
+
+	if len(file.Defaults) > 0 {
+		if err := json.Unmarshal(file.Defaults, &registry.defaults); err != nil {
+			return nil, fmt.Errorf("error parsing tenant config defaults: %w", err)
+		}
+		if err := registry.defaults.validate(); err != nil {
+			return nil, fmt.Errorf("invalid tenant config defaults: %w", err)
+		}
+	}
+
+	for name, raw := range file.Tenants {
+		settings := registry.defaults
+		// Decoding reuses the backing array of slices, which is shared with the defaults
+		settings.Invoice.Address = append([]string(nil), settings.Invoice.Address...)
+		settings.Emails.Skip = append([]string(nil), settings.Emails.Skip...)
+		if err := json.Unmarshal(raw, &settings); err != nil {
+			return nil, fmt.Errorf("error parsing tenant config for %q: %w", name, err)
+		}
+		if err := settings.validate(); err != nil {
+			return nil, fmt.Errorf("invalid tenant config for %q: %w", name, err)
+		}
+		registry.tenants[name] = settings
+	}
+
+	return registry, nil
+}
+
+// Get returns the settings of a tenant
+func (r *Registry) Get(tenant string) Settings {
+	if settings, ok := r.tenants[tenant]; ok {
+		return settings
+	}
+	return r.defaults
+}
@@ -0,0 +1,189 @@
+package usage
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/naventro/payment-service/internal/catalog"
+	"github.com/naventro/payment-service/internal/models"
+	"github.com/naventro/payment-service/internal/repository"
+	"github.com/naventro/payment-service/internal/stripe"
+	stripego "github.com/stripe/stripe-go/v84"
+)
+
+// MaxEventAge is how old a usage event can be; Stripe rejects meter events
+// older than 35 days, so older usage could never be billed
+const MaxEventAge = 35 * 24 * time.Hour
+
+const (
+	// batchSize is how many events are loaded at a time
+	batchSize = 100
+	// maxBackoff caps the delay between report attempts of an event
+	maxBackoff = 6 * time.Hour
+	// claimLease is how long a run has to report the events it claimed
+	// before another run may claim them again
+	claimLease = 5 * time.Minute
+)
+
+// Result summarizes a reporting run
+type Result struct {
+	Reported int `json:"reported"`
+	Retrying int `json:"retrying"`
+	Failed   int `json:"failed"`
+}
+
+// Reporter sends recorded usage events to Stripe billing meters. Failed
+// reports are retried with exponential backoff until maxAttempts.
+type Reporter struct {
+	usageRepo    *repository.UsageRepository
+	stripeClient *stripe.Client
+	catalog      *catalog.Catalog
+	maxAttempts  int
+}
+
+// New creates a reporter
+func New(
+	usageRepo *repository.UsageRepository,
+	stripeClient *stripe.Client,
+	catalog *catalog.Catalog,
+	maxAttempts int,
+) *Reporter {
+	if maxAttempts <= 0 {
+		maxAttempts = 1
+	}
+
+	return &Reporter{
+		usageRepo:    usageRepo,
+		stripeClient: stripeClient,
+		catalog:      catalog,
+		maxAttempts:  maxAttempts,
+	}
+}
+
+// Run reports every pending event whose next attempt is due
+func (r *Reporter) Run() (*Result, error) {
+	result := &Result{}
+	now := time.Now()
+
+	for {
+		events, err := r.usageRepo.ClaimDue(now, claimLease, batchSize)
+		if err != nil {
+			return result, err
+		}
+
+		for _, event := range events {
+			if err := r.report(event, result); err != nil {
+				return result, err
+			}
+		}
+
+		// Claimed and failed events are scheduled after now, so they are not
+		// claimed again
+		if len(events) < batchSize {
+			return result, nil
+		}
+	}
+}
+
+// report sends one event and stores the outcome. Only failing to store the
+// outcome is returned as an error.
+func (r *Reporter) report(event *models.UsageEvent, result *Result) error {
+	event.Attempts++
+
+	err := r.send(event)
+	if err == nil {
+		now := time.Now()
+		event.Status = models.UsageEventStatusReported
+		event.ReportedAt = &now
+		event.NextAttemptAt = nil
+		event.LastError = nil
+		result.Reported++
+		return r.store(event)
+	}
+
+	message := err.Error()
+	event.LastError = &message
+
+	if !retryable(err) || event.Attempts >= r.maxAttempts {
+		event.Status = models.UsageEventStatusFailed
+		event.NextAttemptAt = nil
+		result.Failed++
+		log.Printf("Usage event %d failed after %d attempts: %v", event.ID, event.Attempts, err)
+	} else {
+		next := time.Now().Add(backoff(event.Attempts))
+		event.NextAttemptAt = &next
+		result.Retrying++
+		log.Printf("Error reporting usage event %d, retrying at %s: %v", event.ID, next.Format(time.RFC3339), err)
+	}
+
+	return r.store(event)
+}
+
+// store records the outcome of a report attempt
+func (r *Reporter) store(event *models.UsageEvent) error {
+	stored, err := r.usageRepo.UpdateReport(event)
+	if err != nil {
+		return err
+	}
+	if !stored {
+		log.Printf("Usage event %d was already completed by another run, keeping its outcome", event.ID)
+	}
+	return nil
+}
+
+func (r *Reporter) send(event *models.UsageEvent) error {
+	meter, ok := r.catalog.Meter(event.Meter)
+	if !ok {
+		return &permanentError{fmt.Errorf("meter %q is not in the catalog", event.Meter)}
+	}
+
+	if time.Since(event.OccurredAt) > MaxEventAge {
+		return &permanentError{fmt.Errorf("usage event is older than %s", MaxEventAge)}
+	}
+
+	// The identifier lets Stripe drop a report that succeeded but could not
+	// be marked as reported here
+	identifier := fmt.Sprintf("%s-usage-%d", event.Tenant, event.ID)
+	return r.stripeClient.ReportMeterEvent(meter.EventName, event.StripeCustomerID, identifier, event.Quantity, event.OccurredAt)
+}
+
+// backoff returns the delay before the next attempt: one minute doubled for
+// each attempt made, up to maxBackoff
+func backoff(attempts int) time.Duration {
+	delay := time.Minute
+	for i := 1; i < attempts && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxBackoff {
+		delay = maxBackoff
+	}
+	return delay
+}
+
+// permanentError marks a report that retrying cannot fix
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+// retryable reports whether a report may succeed later: Stripe client errors
+// other than rate limiting are permanent, everything else is retried
+func retryable(err error) bool {
+	var permanent *permanentError
+	if errors.As(err, &permanent) {
+		return false
+	}
+
+	var stripeErr *stripego.Error
+	if errors.As(err, &stripeErr) {
+		status := stripeErr.HTTPStatusCode
+		return status == 0 || status == 429 || status >= 500
+	}
+
+	return true
+}
@@ -3,45 +3,180 @@ package webhook
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"time"
 )
 
+// Formats of the notifications sent to the backend
+const (
+	// FormatLegacy sends the unversioned payloads to one path per object
+	FormatLegacy = "legacy"
+	// FormatEnvelope sends every event in the versioned envelope to /webhooks/events
+	FormatEnvelope = "envelope"
+	// FormatBoth sends both, for backends migrating to the envelope
+	FormatBoth = "both"
+)
+
 type Client struct {
 	baseURL    string
+	format     string
 	httpClient *http.Client
+	dryRun     bool
 }
 
+// SubscriptionWebhookPayload and the payloads below are the legacy format,
+// which has no event type or version. Events without a legacy payload, such
+// as invoice.paid, are only sent in the envelope.
 type SubscriptionWebhookPayload struct {
+	UserID             string     `json:"user_id"`
+	Email              string     `json:"email"`
+	Status             string     `json:"status"`
+	Plan               string     `json:"plan"`
+	SubscriptionID     string     `json:"subscription_id"`
+	CurrentPeriodStart *time.Time `json:"current_period_start"`
+	CurrentPeriodEnd   *time.Time `json:"current_period_end"`
+	CancelAtPeriodEnd  bool       `json:"cancel_at_period_end"`
+	Quantity           int64      `json:"quantity,omitempty"`
+	Currency           string     `json:"currency,omitempty"`
+	Addons             []string   `json:"addons,omitempty"` // catalog keys of attached add-ons
+	// PaymentActionURL is where the user authenticates a pending renewal payment
+	PaymentActionRequired bool   `json:"payment_action_required"`
+	PaymentActionURL      string `json:"payment_action_url,omitempty"`
+}
+
+// DisputeWebhookPayload describes a chargeback against one of the user's payments
+type DisputeWebhookPayload struct {
+	Event               string     `json:"event"`
 	UserID              string     `json:"user_id"`
-	Email               string     `json:"email"`
+	Tenant              string     `json:"tenant"`
+	DisputeID           string     `json:"dispute_id"`
+	InvoiceID           string     `json:"invoice_id,omitempty"`
+	SubscriptionID      string     `json:"subscription_id,omitempty"`
+	Amount              int64      `json:"amount"`
+	Currency            string     `json:"currency"`
+	Reason              string     `json:"reason"`
 	Status              string     `json:"status"`
-	Plan                string     `json:"plan"`
-	SubscriptionID      string     `json:"subscription_id"`
-	CurrentPeriodStart  *time.Time `json:"current_period_start"`
-	CurrentPeriodEnd    *time.Time `json:"current_period_end"`
-	CancelAtPeriodEnd   bool       `json:"cancel_at_period_end"`
+	EvidenceDueBy       *time.Time `json:"evidence_due_by,omitempty"`
+	EntitlementsRevoked bool       `json:"entitlements_revoked"`
+}
+
+// RefundWebhookPayload describes money returned to the user for one of their payments
+type RefundWebhookPayload struct {
+	UserID         string `json:"user_id"`
+	Tenant         string `json:"tenant"`
+	RefundID       string `json:"refund_id"`
+	InvoiceID      string `json:"invoice_id,omitempty"`
+	SubscriptionID string `json:"subscription_id,omitempty"`
+	Amount         int64  `json:"amount"`
+	Currency       string `json:"currency"`
+	Reason         string `json:"reason,omitempty"`
+	Status         string `json:"status"`
+	FailureReason  string `json:"failure_reason,omitempty"`
+	// AmountRefunded is the total refunded on the invoice so far
+	AmountRefunded int64 `json:"amount_refunded"`
+	FullyRefunded  bool  `json:"fully_refunded"`
+}
+
+// PaymentMethodWebhookPayload describes a card saved on the user's customer
+type PaymentMethodWebhookPayload struct {
+	Event           string `json:"event"`
+	UserID          string `json:"user_id"`
+	Tenant          string `json:"tenant"`
+	PaymentMethodID string `json:"payment_method_id"`
+	Brand           string `json:"brand"`
+	Last4           string `json:"last4"`
+	ExpMonth        int64  `json:"exp_month"`
+	ExpYear         int64  `json:"exp_year"`
 }
 
-func NewClient(baseURL string) *Client {
+// OrderWebhookPayload describes a one-time purchase
+type OrderWebhookPayload struct {
+	UserID      string `json:"user_id"`
+	Tenant      string `json:"tenant"`
+	OrderID     string `json:"order_id"`
+	Product     string `json:"product"`
+	Amount      int64  `json:"amount"`
+	Currency    string `json:"currency"`
+	Status      string `json:"status"`
+	Entitlement string `json:"entitlement,omitempty"`
+}
+
+// NewClient returns a client sending notifications in format to the backend at baseURL
+func NewClient(baseURL, format string) *Client {
 	return &Client{
 		baseURL: baseURL,
+		format:  format,
 		httpClient: &http.Client{
 			Timeout: 10 * time.Second,
 		},
 	}
 }
 
+// DryRun returns a copy of the client that logs notifications instead of sending them
+func (c *Client) DryRun() *Client {
+	dryRun := *c
+	dryRun.dryRun = true
+	return &dryRun
+}
+
+// Notify sends an event to the backend in the client's format. Legacy
+// notifications are sent for the events that have one.
+func (c *Client) Notify(event *Event) error {
+	var errs []error
+
+	if c.format != FormatLegacy {
+		if err := c.post("/webhooks/events", event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if c.format != FormatEnvelope {
+		if path, payload, ok := event.legacy(); ok {
+			if err := c.post(path, payload); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+
+	log.Printf("Successfully sent %s notification %s for tenant %s", event.Type, event.ID, event.Tenant)
+	return nil
+}
+
+// NotifySubscriptionChange sends the legacy subscription payload to
+// /webhooks/subscription, whatever the client's format. Its callers do not
+// know the tenant or the type of the change, so it sends no envelope.
+//
+// Deprecated: use Notify with an event of the type of the change.
 func (c *Client) NotifySubscriptionChange(payload SubscriptionWebhookPayload) error {
-	url := c.baseURL + "/webhooks/subscription"
+	if err := c.post("/webhooks/subscription", payload); err != nil {
+		return err
+	}
+
+	log.Printf("Successfully sent webhook notification for user %s", payload.UserID)
+	return nil
+}
+
+// post sends payload as JSON to path on the backend
+func (c *Client) post(path string, payload interface{}) error {
+	url := c.baseURL + path
 
 	jsonData, err := json.Marshal(payload)
 	if err != nil {
 		return fmt.Errorf("error marshaling payload: %w", err)
 	}
 
+	if c.dryRun {
+		log.Printf("Dry run, not sending webhook to %s with payload: %+v", url, payload)
+		return nil
+	}
+
 	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
 	if err != nil {
 		return fmt.Errorf("error creating request: %w", err)
@@ -61,6 +196,5 @@ func (c *Client) NotifySubscriptionChange(payload SubscriptionWebhookPayload) er
 		return fmt.Errorf("webhook returned non-success status code: %d", resp.StatusCode)
 	}
 
-	log.Printf("Successfully sent webhook notification for user %s", payload.UserID)
 	return nil
 }
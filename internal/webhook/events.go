@@ -0,0 +1,270 @@
+package webhook
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	_ "embed"
+	"encoding/hex"
+	"strings"
+	"time"
+)
+
+// EventVersion is the version of the event envelope and its data objects.
+// Fields may be added within a version; removing or changing one requires a
+// new version.
+const EventVersion = "1"
+
+// EventSchema is the JSON Schema of the event envelope and its data objects
+//
+//go:embed schema/event.v1.json
+var EventSchema []byte
+
+// EventType names what happened in an event and which data object it carries
+type EventType string
+
+const (
+	// Subscription events carry SubscriptionData with the state after the change
+	EventSubscriptionCreated               EventType = "subscription.created"
+	EventSubscriptionUpdated               EventType = "subscription.updated"
+	EventSubscriptionCancellationScheduled EventType = "subscription.cancellation_scheduled"
+	EventSubscriptionReactivated           EventType = "subscription.reactivated"
+	EventSubscriptionCanceled              EventType = "subscription.canceled"
+	EventSubscriptionPaymentActionRequired EventType = "subscription.payment_action_required"
+
+	// EventInvoicePaid carries InvoiceData
+	EventInvoicePaid EventType = "invoice.paid"
+	// EventPaymentFailed carries PaymentFailedData
+	EventPaymentFailed EventType = "payment.failed"
+
+	// Refund events carry RefundData
+	EventRefundCreated EventType = "refund.created"
+	EventRefundUpdated EventType = "refund.updated"
+
+	// Dispute events carry DisputeData
+	EventDisputeCreated EventType = "dispute.created"
+	EventDisputeUpdated EventType = "dispute.updated"
+	EventDisputeClosed  EventType = "dispute.closed"
+
+	// Payment method events carry PaymentMethodData
+	EventPaymentMethodAttached EventType = "payment_method.attached"
+	EventPaymentMethodExpiring EventType = "payment_method.expiring"
+
+	// EventOrderPaid carries OrderData
+	EventOrderPaid EventType = "order.paid"
+)
+
+// Event is the versioned envelope of every notification sent to the backend.
+// Data is the object of Type, e.g. SubscriptionData for subscription events.
+type Event struct {
+	ID      string    `json:"id"`
+	Version string    `json:"version"`
+	Type    EventType `json:"type"`
+	// OccurredAt is when the service recorded the change
+	OccurredAt time.Time   `json:"occurred_at"`
+	Tenant     string      `json:"tenant"`
+	Data       interface{} `json:"data"`
+}
+
+// NewEvent returns an event of eventType for tenant. source identifies what
+// caused the change, usually the ID of the Stripe event being processed: the
+// event ID is derived from it and eventType, so redeliveries and replays of
+// that Stripe event send the same ID and the backend can deduplicate them.
+// Changes requested through the API have no source and get a random ID.
+func NewEvent(source string, eventType EventType, tenant string, data interface{}) *Event {
+	return &Event{
+		ID:         newEventID(source, eventType),
+		Version:    EventVersion,
+		Type:       eventType,
+		OccurredAt: time.Now().UTC(),
+		Tenant:     tenant,
+		Data:       data,
+	}
+}
+
+func newEventID(source string, eventType EventType) string {
+	if source != "" {
+		sum := sha256.Sum256([]byte(source + ":" + string(eventType)))
+		return "evt_" + hex.EncodeToString(sum[:16])
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		// crypto/rand does not fail on supported platforms
+		panic(err)
+	}
+	return "evt_" + hex.EncodeToString(b)
+}
+
+// SubscriptionData is the state of a subscription. Its fields match the
+// legacy SubscriptionWebhookPayload.
+type SubscriptionData struct {
+	UserID             string     `json:"user_id"`
+	Email              string     `json:"email"`
+	Status             string     `json:"status"`
+	Plan               string     `json:"plan"`
+	SubscriptionID     string     `json:"subscription_id"`
+	CurrentPeriodStart *time.Time `json:"current_period_start"`
+	CurrentPeriodEnd   *time.Time `json:"current_period_end"`
+	CancelAtPeriodEnd  bool       `json:"cancel_at_period_end"`
+	Quantity           int64      `json:"quantity,omitempty"`
+	Currency           string     `json:"currency,omitempty"`
+	Addons             []string   `json:"addons,omitempty"`
+	// PaymentActionURL is where the user authenticates a pending renewal payment
+	PaymentActionRequired bool   `json:"payment_action_required"`
+	PaymentActionURL      string `json:"payment_action_url,omitempty"`
+}
+
+// InvoiceData describes a paid subscription invoice. Amounts are in the
+// smallest unit of Currency.
+type InvoiceData struct {
+	UserID           string     `json:"user_id"`
+	InvoiceID        string     `json:"invoice_id"`
+	SubscriptionID   string     `json:"subscription_id"`
+	Number           string     `json:"number,omitempty"`
+	AmountPaid       int64      `json:"amount_paid"`
+	Subtotal         int64      `json:"subtotal"`
+	Tax              int64      `json:"tax"`
+	Total            int64      `json:"total"`
+	Currency         string     `json:"currency"`
+	HostedInvoiceURL string     `json:"hosted_invoice_url,omitempty"`
+	PeriodStart      *time.Time `json:"period_start,omitempty"`
+	PeriodEnd        *time.Time `json:"period_end,omitempty"`
+}
+
+// PaymentFailedData describes a failed subscription payment. Stripe retries
+// it at NextPaymentAttempt, if any; meanwhile the customer can pay on the
+// hosted invoice page.
+type PaymentFailedData struct {
+	UserID             string     `json:"user_id"`
+	InvoiceID          string     `json:"invoice_id"`
+	SubscriptionID     string     `json:"subscription_id"`
+	AmountDue          int64      `json:"amount_due"`
+	Currency           string     `json:"currency"`
+	AttemptCount       int64      `json:"attempt_count"`
+	NextPaymentAttempt *time.Time `json:"next_payment_attempt,omitempty"`
+	HostedInvoiceURL   string     `json:"hosted_invoice_url,omitempty"`
+}
+
+// RefundData describes money returned to the user for one of their payments
+type RefundData struct {
+	UserID         string `json:"user_id"`
+	RefundID       string `json:"refund_id"`
+	InvoiceID      string `json:"invoice_id,omitempty"`
+	SubscriptionID string `json:"subscription_id,omitempty"`
+	// OrderID is set instead of InvoiceID for refunds of one-time purchases
+	OrderID       string `json:"order_id,omitempty"`
+	Amount        int64  `json:"amount"`
+	Currency      string `json:"currency"`
+	Reason        string `json:"reason,omitempty"`
+	Status        string `json:"status"`
+	FailureReason string `json:"failure_reason,omitempty"`
+	// AmountRefunded is the total refunded on the invoice or order so far
+	AmountRefunded int64 `json:"amount_refunded"`
+	FullyRefunded  bool  `json:"fully_refunded"`
+}
+
+// DisputeData describes a chargeback against one of the user's payments
+type DisputeData struct {
+	UserID         string `json:"user_id"`
+	DisputeID      string `json:"dispute_id"`
+	InvoiceID      string `json:"invoice_id,omitempty"`
+	SubscriptionID string `json:"subscription_id,omitempty"`
+	// OrderID is set instead of InvoiceID for disputes of one-time purchases
+	OrderID             string     `json:"order_id,omitempty"`
+	Amount              int64      `json:"amount"`
+	Currency            string     `json:"currency"`
+	Reason              string     `json:"reason"`
+	Status              string     `json:"status"`
+	EvidenceDueBy       *time.Time `json:"evidence_due_by,omitempty"`
+	EntitlementsRevoked bool       `json:"entitlements_revoked"`
+}
+
+// PaymentMethodData describes a card saved on the user's customer
+type PaymentMethodData struct {
+	UserID          string `json:"user_id"`
+	PaymentMethodID string `json:"payment_method_id"`
+	Brand           string `json:"brand"`
+	Last4           string `json:"last4"`
+	ExpMonth        int64  `json:"exp_month"`
+	ExpYear         int64  `json:"exp_year"`
+}
+
+// OrderData describes a one-time purchase
+type OrderData struct {
+	UserID      string `json:"user_id"`
+	OrderID     string `json:"order_id"`
+	Product     string `json:"product"`
+	Amount      int64  `json:"amount"`
+	Currency    string `json:"currency"`
+	Status      string `json:"status"`
+	Entitlement string `json:"entitlement,omitempty"`
+}
+
+// legacy returns the path and payload the event was sent as before the
+// envelope, or false for events that had no legacy notification
+func (e *Event) legacy() (string, interface{}, bool) {
+	// Legacy dispute and payment method payloads name the change without the prefix
+	change := string(e.Type)
+	if i := strings.IndexByte(change, '.'); i >= 0 {
+		change = change[i+1:]
+	}
+
+	switch data := e.Data.(type) {
+	case SubscriptionData:
+		return "/webhooks/subscription", SubscriptionWebhookPayload(data), true
+	case RefundData:
+		return "/webhooks/refund", RefundWebhookPayload{
+			UserID:         data.UserID,
+			Tenant:         e.Tenant,
+			RefundID:       data.RefundID,
+			InvoiceID:      data.InvoiceID,
+			SubscriptionID: data.SubscriptionID,
+			Amount:         data.Amount,
+			Currency:       data.Currency,
+			Reason:         data.Reason,
+			Status:         data.Status,
+			FailureReason:  data.FailureReason,
+			AmountRefunded: data.AmountRefunded,
+			FullyRefunded:  data.FullyRefunded,
+		}, true
+	case DisputeData:
+		return "/webhooks/dispute", DisputeWebhookPayload{
+			Event:               change,
+			UserID:              data.UserID,
+			Tenant:              e.Tenant,
+			DisputeID:           data.DisputeID,
+			InvoiceID:           data.InvoiceID,
+			SubscriptionID:      data.SubscriptionID,
+			Amount:              data.Amount,
+			Currency:            data.Currency,
+			Reason:              data.Reason,
+			Status:              data.Status,
+			EvidenceDueBy:       data.EvidenceDueBy,
+			EntitlementsRevoked: data.EntitlementsRevoked,
+		}, true
+	case PaymentMethodData:
+		return "/webhooks/payment-method", PaymentMethodWebhookPayload{
+			Event:           change,
+			UserID:          data.UserID,
+			Tenant:          e.Tenant,
+			PaymentMethodID: data.PaymentMethodID,
+			Brand:           data.Brand,
+			Last4:           data.Last4,
+			ExpMonth:        data.ExpMonth,
+			ExpYear:         data.ExpYear,
+		}, true
+	case OrderData:
+		return "/webhooks/order", OrderWebhookPayload{
+			UserID:      data.UserID,
+			Tenant:      e.Tenant,
+			OrderID:     data.OrderID,
+			Product:     data.Product,
+			Amount:      data.Amount,
+			Currency:    data.Currency,
+			Status:      data.Status,
+			Entitlement: data.Entitlement,
+		}, true
+	}
+
+	return "", nil, false
+}